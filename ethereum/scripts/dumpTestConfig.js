@@ -39,20 +39,29 @@ const dump = (tmpDir, channels, bridge) => {
             endpoint: "ws://localhost:8545/",
             startblock: 1,
             "descendants-until-final": 3,
-            channels: {
-                basic: {
+            channels: [
+                {
+                    name: "basic",
                     inbound: channels.basic.inbound.address,
                     outbound: channels.basic.outbound.address,
+                    event: "Message(address source,uint64 nonce,bytes payload)",
+                    call: "BasicInboundChannel.submit",
+                    "nonce-storage": "BasicInboundChannel.Nonce",
                 },
-                incentivized: {
+                {
+                    name: "incentivized",
                     inbound: channels.incentivized.inbound.address,
                     outbound: channels.incentivized.outbound.address,
+                    event: "Message(address source,uint64 nonce,uint256 fee,bytes payload)",
+                    call: "IncentivizedInboundChannel.submit",
+                    "nonce-storage": "IncentivizedInboundChannel.Nonce",
                 },
-            },
+            ],
             beefylightclient: bridge.beefylightclient.address
         },
         parachain: {
-            endpoint: "ws://127.0.0.1:11144/"
+            endpoint: "ws://127.0.0.1:11144/",
+            "parachain-id": 200,
         },
         relaychain: {
             endpoint: "ws://127.0.0.1:9944/"
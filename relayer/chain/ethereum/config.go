@@ -1,5 +1,16 @@
 package ethereum
 
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
 type Config struct {
 	Endpoint                       string         `mapstructure:"endpoint"`
 	BeefyPrivateKey                string         `mapstructure:"beefy-private-key"`
@@ -8,14 +19,246 @@ type Config struct {
 	Channels                       ChannelsConfig `mapstructure:"channels"`
 	BeefyLightClient               string         `mapstructure:"beefylightclient"`
 	StartBlock                     uint64         `mapstructure:"startblock"`
+	// Number of parallel jobs used to catch up with the chain. Zero disables backfilling.
+	BackfillConcurrency uint `mapstructure:"backfill-concurrency"`
+	// Minimum number of finalized blocks the parachain must lag behind before backfilling
+	BackfillThreshold uint64 `mapstructure:"backfill-threshold"`
 }
 
-type ChannelsConfig struct {
-	Basic        ChannelConfig `mapstructure:"basic"`
-	Incentivized ChannelConfig `mapstructure:"incentivized"`
-}
+// Names of the channels that the parachain commitment relayer delivers to
+const (
+	BasicChannel        = "basic"
+	IncentivizedChannel = "incentivized"
+)
+
+type ChannelsConfig []ChannelConfig
 
 type ChannelConfig struct {
+	Name     string `mapstructure:"name"`
 	Inbound  string `mapstructure:"inbound"`
 	Outbound string `mapstructure:"outbound"`
+	// Signature of the event emitted by the outbound contract, with the names of its arguments,
+	// e.g. "Message(address source,uint64 nonce,bytes payload)". The argument named nonce holds
+	// the nonce of the message.
+	Event string `mapstructure:"event"`
+	// Parachain call which receives messages from this channel, e.g. "BasicInboundChannel.submit"
+	Call string `mapstructure:"call"`
+	// Parachain storage item holding the nonce of the last message received from this channel,
+	// e.g. "BasicInboundChannel.Nonce"
+	NonceStorage string `mapstructure:"nonce-storage"`
+}
+
+// NonceArgument is the name of the event argument holding the nonce of a message
+const NonceArgument = "nonce"
+
+// builtinChannels are the defaults of the channels that the parachain commitment relayer
+// delivers to, for configurations which name neither the arguments of their events nor the
+// storage items of their nonces
+var builtinChannels = map[string]ChannelConfig{
+	BasicChannel: {
+		Event:        "Message(address source,uint64 nonce,bytes payload)",
+		NonceStorage: "BasicInboundChannel.Nonce",
+	},
+	IncentivizedChannel: {
+		Event:        "Message(address source,uint64 nonce,uint256 fee,bytes payload)",
+		NonceStorage: "IncentivizedInboundChannel.Nonce",
+	},
+}
+
+// Get returns the channel with the given name
+func (c ChannelsConfig) Get(name string) (*ChannelConfig, error) {
+	for i := range c {
+		if c[i].Name == name {
+			return &c[i], nil
+		}
+	}
+	return nil, fmt.Errorf("channel %q is not configured", name)
+}
+
+func (c *ChannelConfig) Validate() error {
+	if !common.IsHexAddress(c.Outbound) {
+		return fmt.Errorf("channel %q: invalid outbound address %q", c.Name, c.Outbound)
+	}
+	if c.Event == "" {
+		return fmt.Errorf("channel %q: event signature is not set", c.Name)
+	}
+	if c.Call == "" {
+		return fmt.Errorf("channel %q: parachain call is not set", c.Name)
+	}
+
+	event, err := c.EventABI()
+	if err != nil {
+		return fmt.Errorf("channel %q: %w", c.Name, err)
+	}
+	nonce, ok := findArgument(event.Inputs, NonceArgument)
+	if !ok || nonce.Type.T != abi.UintTy {
+		return fmt.Errorf("channel %q: event %q has no unsigned integer argument named %s", c.Name, c.Event, NonceArgument)
+	}
+
+	if _, _, err := c.NonceStorageItem(); err != nil {
+		return fmt.Errorf("channel %q: %w", c.Name, err)
+	}
+	return nil
+}
+
+func (c *ChannelConfig) OutboundAddress() common.Address {
+	return common.HexToAddress(c.Outbound)
+}
+
+func (c *ChannelConfig) InboundAddress() common.Address {
+	return common.HexToAddress(c.Inbound)
+}
+
+// EventTopic returns the topic identifying the channel's outbound event in logs
+func (c *ChannelConfig) EventTopic() common.Hash {
+	event, err := c.EventABI()
+	if err != nil {
+		return crypto.Keccak256Hash([]byte(c.Event))
+	}
+	return event.ID
+}
+
+// EventABI returns the channel's outbound event. Built-in channels may be configured with
+// signatures which don't name their arguments.
+func (c *ChannelConfig) EventABI() (abi.Event, error) {
+	event, err := parseEvent(c.Event)
+	if err != nil {
+		return abi.Event{}, err
+	}
+
+	builtin, ok := builtinChannels[c.Name]
+	if _, named := findArgument(event.Inputs, NonceArgument); ok && !named {
+		builtinEvent, err := parseEvent(builtin.Event)
+		if err == nil && builtinEvent.ID == event.ID {
+			return builtinEvent, nil
+		}
+	}
+	return event, nil
+}
+
+// NonceStorageItem returns the module and name of the parachain storage item holding the nonce
+// of the last message received from the channel
+func (c *ChannelConfig) NonceStorageItem() (string, string, error) {
+	item := c.NonceStorage
+	if item == "" {
+		item = builtinChannels[c.Name].NonceStorage
+	}
+
+	parts := strings.Split(item, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid nonce storage item %q", item)
+	}
+	return parts[0], parts[1], nil
+}
+
+// MessageNonce decodes the nonce of a message from the channel's outbound event
+func (c *ChannelConfig) MessageNonce(log *types.Log) (uint64, error) {
+	event, err := c.EventABI()
+	if err != nil {
+		return 0, err
+	}
+	if len(log.Topics) == 0 || log.Topics[0] != event.ID {
+		return 0, fmt.Errorf("log is not a %s event", event.Sig)
+	}
+
+	var value interface{}
+	nonce, ok := findArgument(event.Inputs, NonceArgument)
+	switch {
+	case !ok:
+		return 0, fmt.Errorf("event %s has no argument named %s", event.Sig, NonceArgument)
+	case nonce.Indexed:
+		// Indexed arguments follow the event's topic in order
+		topic := 1
+		for _, input := range event.Inputs {
+			if input.Name == NonceArgument {
+				break
+			}
+			if input.Indexed {
+				topic++
+			}
+		}
+		if topic >= len(log.Topics) {
+			return 0, fmt.Errorf("log has no topic for indexed argument %s", NonceArgument)
+		}
+		value = new(big.Int).SetBytes(log.Topics[topic].Bytes())
+	default:
+		values, err := event.Inputs.NonIndexed().UnpackValues(log.Data)
+		if err != nil {
+			return 0, fmt.Errorf("unpack event data: %w", err)
+		}
+		for i, input := range event.Inputs.NonIndexed() {
+			if input.Name == NonceArgument {
+				value = values[i]
+			}
+		}
+	}
+
+	switch nonce := value.(type) {
+	case uint64:
+		return nonce, nil
+	case uint32:
+		return uint64(nonce), nil
+	case uint16:
+		return uint64(nonce), nil
+	case uint8:
+		return uint64(nonce), nil
+	case *big.Int:
+		if !nonce.IsUint64() {
+			return 0, fmt.Errorf("nonce %s exceeds 64 bits", nonce)
+		}
+		return nonce.Uint64(), nil
+	default:
+		return 0, fmt.Errorf("nonce of unsupported type %T", value)
+	}
+}
+
+// parseEvent parses an event signature whose arguments may be named and indexed, such as
+// "Message(address indexed source,uint64 nonce,bytes payload)"
+func parseEvent(signature string) (abi.Event, error) {
+	open := strings.Index(signature, "(")
+	if open <= 0 || !strings.HasSuffix(signature, ")") {
+		return abi.Event{}, fmt.Errorf("invalid event signature %q", signature)
+	}
+	name := strings.TrimSpace(signature[:open])
+	params := strings.TrimSpace(signature[open+1 : len(signature)-1])
+
+	var inputs abi.Arguments
+	if params != "" {
+		for _, param := range strings.Split(params, ",") {
+			fields := strings.Fields(param)
+			if len(fields) == 0 || len(fields) > 3 || strings.ContainsAny(param, "()") {
+				return abi.Event{}, fmt.Errorf("invalid argument %q of event signature %q", param, signature)
+			}
+
+			typ, err := abi.NewType(fields[0], "", nil)
+			if err != nil {
+				return abi.Event{}, fmt.Errorf("argument %q of event signature %q: %w", param, signature, err)
+			}
+			argument := abi.Argument{Type: typ}
+			rest := fields[1:]
+			if len(rest) > 0 && rest[0] == "indexed" {
+				argument.Indexed = true
+				rest = rest[1:]
+			}
+			switch len(rest) {
+			case 0:
+			case 1:
+				argument.Name = rest[0]
+			default:
+				return abi.Event{}, fmt.Errorf("invalid argument %q of event signature %q", param, signature)
+			}
+			inputs = append(inputs, argument)
+		}
+	}
+
+	return abi.NewEvent(name, name, false, inputs), nil
+}
+
+func findArgument(arguments abi.Arguments, name string) (abi.Argument, bool) {
+	for _, argument := range arguments {
+		if argument.Name == name {
+			return argument, true
+		}
+	}
+	return abi.Argument{}, false
 }
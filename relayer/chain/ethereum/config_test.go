@@ -0,0 +1,124 @@
+package ethereum_test
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	gethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/contracts/basic"
+	"github.com/snowfork/polkadot-ethereum/relayer/contracts/incentivized"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelConfig_EventTopic(t *testing.T) {
+	basicABI, err := abi.JSON(strings.NewReader(basic.BasicOutboundChannelABI))
+	if err != nil {
+		panic(err)
+	}
+	incentivizedABI, err := abi.JSON(strings.NewReader(incentivized.IncentivizedOutboundChannelABI))
+	if err != nil {
+		panic(err)
+	}
+
+	basicChannel := ethereum.ChannelConfig{Event: "Message(address,uint64,bytes)"}
+	assert.Equal(t, basicABI.Events["Message"].ID, basicChannel.EventTopic())
+
+	incentivizedChannel := ethereum.ChannelConfig{Event: "Message(address,uint64,uint256,bytes)"}
+	assert.Equal(t, incentivizedABI.Events["Message"].ID, incentivizedChannel.EventTopic())
+}
+
+func TestChannelsConfig_Get(t *testing.T) {
+	channels := ethereum.ChannelsConfig{
+		{Name: ethereum.BasicChannel, Inbound: "0x992B9df075935E522EC7950F37eC8557e86f6fdb"},
+		{Name: ethereum.IncentivizedChannel, Inbound: "0xFc97A6197dc90bef6bbEFD672742Ed75E9768553"},
+	}
+
+	channel, err := channels.Get(ethereum.IncentivizedChannel)
+	assert.Nil(t, err)
+	assert.Equal(t, "0xFc97A6197dc90bef6bbEFD672742Ed75E9768553", channel.InboundAddress().Hex())
+
+	_, err = channels.Get("unknown")
+	assert.Error(t, err)
+}
+
+func TestChannelConfig_MessageNonce(t *testing.T) {
+	basicABI, err := abi.JSON(strings.NewReader(basic.BasicOutboundChannelABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := basicABI.Events["Message"].Inputs.NonIndexed().Pack(common.HexToAddress("0x01"), uint64(42), []byte{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := gethTypes.Log{Topics: []common.Hash{basicABI.Events["Message"].ID}, Data: data}
+
+	// Built-in channels may leave their arguments unnamed
+	channel := ethereum.ChannelConfig{Name: ethereum.BasicChannel, Event: "Message(address,uint64,bytes)"}
+	nonce, err := channel.MessageNonce(&log)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), nonce)
+
+	channel = ethereum.ChannelConfig{Name: "custom", Event: "Message(address source,uint64 nonce,bytes payload)"}
+	nonce, err = channel.MessageNonce(&log)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), nonce)
+
+	channel = ethereum.ChannelConfig{Name: "custom", Event: "Message(address,uint64,bytes)"}
+	_, err = channel.MessageNonce(&log)
+	assert.Error(t, err)
+
+	// Indexed nonces are read from the log's topics
+	channel = ethereum.ChannelConfig{Name: "custom", Event: "Sent(address indexed source,uint256 indexed nonce,bytes payload)"}
+	event, err := channel.EventABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, crypto.Keccak256Hash([]byte("Sent(address,uint256,bytes)")), channel.EventTopic())
+	data, err = event.Inputs.NonIndexed().Pack([]byte{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log = gethTypes.Log{
+		Topics: []common.Hash{event.ID, common.HexToHash("0x01"), common.BigToHash(big.NewInt(7))},
+		Data:   data,
+	}
+	nonce, err = channel.MessageNonce(&log)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(7), nonce)
+}
+
+func TestChannelConfig_Validate(t *testing.T) {
+	channel := ethereum.ChannelConfig{
+		Name:     ethereum.IncentivizedChannel,
+		Outbound: "0xFc97A6197dc90bef6bbEFD672742Ed75E9768553",
+		Event:    "Message(address,uint64,uint256,bytes)",
+		Call:     "IncentivizedInboundChannel.submit",
+	}
+	assert.Nil(t, channel.Validate())
+	module, item, err := channel.NonceStorageItem()
+	assert.Nil(t, err)
+	assert.Equal(t, "IncentivizedInboundChannel", module)
+	assert.Equal(t, "Nonce", item)
+
+	// Other channels name their nonce argument and storage item
+	channel.Name = "custom"
+	assert.Error(t, channel.Validate())
+	channel.Event = "Message(address source,uint64 nonce,uint256 fee,bytes payload)"
+	assert.Error(t, channel.Validate())
+	channel.NonceStorage = "CustomInboundChannel.LastNonce"
+	assert.Nil(t, channel.Validate())
+	module, item, err = channel.NonceStorageItem()
+	assert.Nil(t, err)
+	assert.Equal(t, "CustomInboundChannel", module)
+	assert.Equal(t, "LastNonce", item)
+
+	channel.Event = "Message(address source,bytes32 nonce)"
+	assert.Error(t, channel.Validate())
+	channel.Event = "Message(address source,uint64 nonce"
+	assert.Error(t, channel.Validate())
+}
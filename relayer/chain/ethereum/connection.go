@@ -5,18 +5,28 @@ package ethereum
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/sirupsen/logrus"
 
+	"github.com/snowfork/polkadot-ethereum/relayer/chain"
 	"github.com/snowfork/polkadot-ethereum/relayer/crypto/secp256k1"
 )
 
+// clients holds the clients shared by the workers of the process
+var clients = chain.NewRegistry()
+
 type Connection struct {
 	endpoint string
 	kp       *secp256k1.Keypair
-	client   *ethclient.Client
-	log      *logrus.Entry
+	// Shared with the other workers connected to the same endpoint
+	client *ethclient.Client
+	// Guards client and closed
+	mutex  sync.Mutex
+	closed bool
+	log    *logrus.Entry
 }
 
 func NewConnection(endpoint string, kp *secp256k1.Keypair, log *logrus.Entry) *Connection {
@@ -27,13 +37,25 @@ func NewConnection(endpoint string, kp *secp256k1.Keypair, log *logrus.Entry) *C
 	}
 }
 
+// Connect acquires the shared client. Closed connections can't be connected again.
 func (co *Connection) Connect(ctx context.Context) error {
-	client, err := ethclient.Dial(co.endpoint)
-	if err != nil {
-		return err
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+
+	if co.closed {
+		return fmt.Errorf("connection to %s is closed", co.endpoint)
+	}
+	if co.client == nil {
+		client, err := clients.Acquire(co.endpoint, func() (chain.Closer, error) {
+			return ethclient.Dial(co.endpoint)
+		})
+		if err != nil {
+			return err
+		}
+		co.client = client.(*ethclient.Client)
 	}
 
-	chainID, err := client.NetworkID(ctx)
+	chainID, err := co.client.NetworkID(ctx)
 	if err != nil {
 		return err
 	}
@@ -43,14 +65,20 @@ func (co *Connection) Connect(ctx context.Context) error {
 		"chainID":  chainID,
 	}).Info("Connected to chain")
 
-	co.client = client
-
 	return nil
 }
 
+// Close gives up the shared client, which is closed once no other worker uses it
 func (co *Connection) Close() {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+
+	if co.closed {
+		return
+	}
+	co.closed = true
 	if co.client != nil {
-		co.client.Close()
+		clients.Release(co.endpoint)
 	}
 }
 
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
 	"github.com/snowfork/polkadot-ethereum/relayer/crypto/secp256k1"
@@ -23,3 +24,14 @@ func TestConnect(t *testing.T) {
 	}
 	defer conn.Close()
 }
+
+func TestConnect_AfterClose(t *testing.T) {
+	log := logrus.NewEntry(logrus.New())
+
+	conn := ethereum.NewConnection("ws://localhost:8545", secp256k1.Alice(), log)
+	conn.Close()
+	// Closed connections don't acquire the shared client again
+	err := conn.Connect(context.Background())
+	assert.Error(t, err)
+	conn.Close()
+}
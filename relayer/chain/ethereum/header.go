@@ -44,6 +44,15 @@ type Header struct {
 	header *etypes.Header
 }
 
+// StoredHeader is the header representation kept in the VerifierLightclient pallet's
+// `Headers` storage map
+type StoredHeader struct {
+	Submitter       types.OptionBytes32
+	Header          Header
+	TotalDifficulty types.U256
+	Finalized       bool
+}
+
 func (h *Header) Decode(decoder scale.Decoder) error {
 	var fields headerSCALE
 	err := decoder.Decode(&fields)
@@ -34,8 +34,9 @@ func (d *DefaultBlockLoader) GetAllReceipts(ctx context.Context, block *gethType
 }
 
 // Keeps the blocks and receipts for the latest block heights / numbers
-// in memory (up to `capacity` block numbers)
+// in memory (up to `capacity` block numbers). Safe for concurrent use.
 type BlockCache struct {
+	sync.Mutex
 	capacity       int
 	hashesByNumber map[uint64][]string
 	blocks         map[string]*gethTypes.Block
@@ -52,6 +53,9 @@ func NewBlockCache(capacity int) *BlockCache {
 }
 
 func (bc *BlockCache) Insert(block *gethTypes.Block, receiptTrie *gethTrie.Trie) {
+	bc.Lock()
+	defer bc.Unlock()
+
 	hash := block.Hash().Hex()
 	_, exists := bc.blocks[hash]
 	if exists {
@@ -88,6 +92,9 @@ func (bc *BlockCache) Insert(block *gethTypes.Block, receiptTrie *gethTrie.Trie)
 }
 
 func (bc *BlockCache) Get(hash gethCommon.Hash) (*gethTypes.Block, *gethTrie.Trie, bool) {
+	bc.Lock()
+	defer bc.Unlock()
+
 	hashHex := hash.Hex()
 	block, exists := bc.blocks[hashHex]
 	if exists {
@@ -0,0 +1,271 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethereum
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	geth "github.com/ethereum/go-ethereum"
+	gethCommon "github.com/ethereum/go-ethereum/common"
+	gethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Upper bound for the number of blocks covered by a single log query
+const MaxLogQueryRange uint64 = 1024
+
+// Error messages used by popular providers when a log query matches too many logs. Other errors,
+// such as those of rate limits, are returned unchanged rather than shrinking the range.
+var tooManyResultsErrors = []string{
+	"query returned more than 10000 results",
+	"log response size exceeded",
+	"block range is too wide",
+	"block range is too large",
+}
+
+type LogFilterer interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	FilterLogs(ctx context.Context, query geth.FilterQuery) ([]gethTypes.Log, error)
+}
+
+// LogFetcher retrieves the logs emitted by a set of contracts, block by block. It
+// uses a single ranged query to prefetch logs for a span of upcoming finalized blocks
+// and consults header blooms to skip blocks that can't contain any matches.
+type LogFetcher struct {
+	client                LogFilterer
+	events                map[gethCommon.Address]map[gethCommon.Hash]bool
+	addresses             []gethCommon.Address
+	topics                []gethCommon.Hash
+	descendantsUntilFinal uint64
+	queryRange            uint64
+	// Inclusive range of block numbers with prefetched logs
+	cachedFrom uint64
+	cachedTo   uint64
+	cached     map[uint64][]*gethTypes.Log
+	log        *logrus.Entry
+}
+
+func NewLogFetcher(client LogFilterer, channels ChannelsConfig, descendantsUntilFinal uint64, log *logrus.Entry) *LogFetcher {
+	events := make(map[gethCommon.Address]map[gethCommon.Hash]bool)
+	var addresses []gethCommon.Address
+	var topics []gethCommon.Hash
+
+	for i := range channels {
+		address := channels[i].OutboundAddress()
+		topic := channels[i].EventTopic()
+
+		if _, exists := events[address]; !exists {
+			events[address] = make(map[gethCommon.Hash]bool)
+			addresses = append(addresses, address)
+		}
+		events[address][topic] = true
+
+		if !containsHash(topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+
+	return &LogFetcher{
+		client:                client,
+		events:                events,
+		addresses:             addresses,
+		topics:                topics,
+		descendantsUntilFinal: descendantsUntilFinal,
+		queryRange:            MaxLogQueryRange,
+		cached:                make(map[uint64][]*gethTypes.Log),
+		log:                   log,
+	}
+}
+
+// FetchLogs returns the matching logs in the finalized block `number`. If `header` is
+// non-nil, it must be the header of that block and is used to avoid unnecessary queries.
+func (f *LogFetcher) FetchLogs(ctx context.Context, number uint64, header *gethTypes.Header) ([]*gethTypes.Log, error) {
+	if header != nil && !f.BloomMatches(header.Bloom) {
+		return nil, nil
+	}
+
+	if number >= f.cachedFrom && number <= f.cachedTo && f.cached != nil {
+		logs := f.cached[number]
+		// The header's bloom matched, so prefetched blocks without logs may have been replaced since
+		if header == nil || len(logs) > 0 && inBlock(logs, header.Hash()) {
+			f.pruneCache(number)
+			return logs, nil
+		}
+		f.log.WithField("blockNumber", number).Debug("Discarding prefetched logs which can't be verified against the block")
+	}
+
+	err := f.prefetch(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := f.cached[number]
+	f.pruneCache(number)
+	if header != nil && !inBlock(logs, header.Hash()) {
+		return nil, fmt.Errorf("logs fetched for block %d don't belong to block %s", number, header.Hash().Hex())
+	}
+	return logs, nil
+}
+
+// inBlock reports whether all logs were emitted in the block with the given hash
+func inBlock(logs []*gethTypes.Log, blockHash gethCommon.Hash) bool {
+	for _, log := range logs {
+		if log.BlockHash != blockHash {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *LogFetcher) prefetch(ctx context.Context, from uint64) error {
+	latest, err := f.client.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	latestFinalized := saturatingSub(latest, f.descendantsUntilFinal)
+
+	for {
+		to := from + f.queryRange - 1
+		if to > latestFinalized {
+			to = latestFinalized
+		}
+		if to < from {
+			to = from
+		}
+
+		logs, err := f.filterRange(ctx, from, to)
+		if err != nil {
+			if isTooManyResultsError(err) && f.queryRange > 1 {
+				f.queryRange = f.queryRange / 2
+				f.log.WithError(err).WithField("queryRange", f.queryRange).Debug("Shrinking log query range")
+				continue
+			}
+			return err
+		}
+
+		f.cached = logs
+		f.cachedFrom = from
+		f.cachedTo = to
+
+		// Gradually grow the range back after a successful query
+		if f.queryRange < MaxLogQueryRange {
+			f.queryRange = f.queryRange * 2
+		}
+
+		return nil
+	}
+}
+
+// FetchLogRange returns the matching logs in the inclusive range of finalized blocks,
+// grouped by block number. Ranges rejected by the provider are split in half until
+// they succeed. Unlike FetchLogs, it is safe for concurrent use.
+func (f *LogFetcher) FetchLogRange(ctx context.Context, from uint64, to uint64) (map[uint64][]*gethTypes.Log, error) {
+	logs, err := f.filterRange(ctx, from, to)
+	if err == nil || !isTooManyResultsError(err) || from == to {
+		return logs, err
+	}
+
+	mid := from + (to-from)/2
+	logs, err = f.FetchLogRange(ctx, from, mid)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := f.FetchLogRange(ctx, mid+1, to)
+	if err != nil {
+		return nil, err
+	}
+	for number, blockLogs := range upper {
+		logs[number] = blockLogs
+	}
+	return logs, nil
+}
+
+func (f *LogFetcher) filterRange(ctx context.Context, from uint64, to uint64) (map[uint64][]*gethTypes.Log, error) {
+	logs, err := f.client.FilterLogs(ctx, geth.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: f.addresses,
+		Topics:    [][]gethCommon.Hash{f.topics},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f.log.WithFields(logrus.Fields{
+		"fromBlock": from,
+		"toBlock":   to,
+		"logCount":  len(logs),
+	}).Debug("Fetched logs for block range")
+
+	result := make(map[uint64][]*gethTypes.Log)
+	for i := range logs {
+		if logs[i].Removed || !f.isMatch(&logs[i]) {
+			continue
+		}
+		result[logs[i].BlockNumber] = append(result[logs[i].BlockNumber], &logs[i])
+	}
+	return result, nil
+}
+
+func (f *LogFetcher) pruneCache(number uint64) {
+	for n := range f.cached {
+		if n <= number {
+			delete(f.cached, n)
+		}
+	}
+	f.cachedFrom = number + 1
+}
+
+func (f *LogFetcher) isMatch(log *gethTypes.Log) bool {
+	if len(log.Topics) == 0 {
+		return false
+	}
+	topics, exists := f.events[log.Address]
+	return exists && topics[log.Topics[0]]
+}
+
+// BloomMatches reports whether a block with the given logs bloom may contain matching logs
+func (f *LogFetcher) BloomMatches(bloom gethTypes.Bloom) bool {
+	for address, topics := range f.events {
+		if !gethTypes.BloomLookup(bloom, address) {
+			continue
+		}
+		for topic := range topics {
+			if gethTypes.BloomLookup(bloom, topic) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isTooManyResultsError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range tooManyResultsErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsHash(hashes []gethCommon.Hash, hash gethCommon.Hash) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Subtraction but returns 0 when r > l
+func saturatingSub(l uint64, r uint64) uint64 {
+	if r > l {
+		return 0
+	}
+	return l - r
+}
@@ -0,0 +1,218 @@
+package ethereum_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	geth "github.com/ethereum/go-ethereum"
+	gethCommon "github.com/ethereum/go-ethereum/common"
+	gethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestLogFilterer struct {
+	latest uint64
+	logs   []gethTypes.Log
+	// Queries spanning more blocks than this are rejected
+	maxRange uint64
+	// Returned for every query, if set
+	err     error
+	queries []geth.FilterQuery
+}
+
+func (f *TestLogFilterer) BlockNumber(_ context.Context) (uint64, error) {
+	return f.latest, nil
+}
+
+func (f *TestLogFilterer) FilterLogs(_ context.Context, query geth.FilterQuery) ([]gethTypes.Log, error) {
+	f.queries = append(f.queries, query)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	from, to := query.FromBlock.Uint64(), query.ToBlock.Uint64()
+	if f.maxRange > 0 && to-from+1 > f.maxRange {
+		return nil, errors.New("query returned more than 10000 results")
+	}
+
+	var result []gethTypes.Log
+	for _, log := range f.logs {
+		if log.BlockNumber >= from && log.BlockNumber <= to {
+			result = append(result, log)
+		}
+	}
+	return result, nil
+}
+
+var testChannels = ethereum.ChannelsConfig{
+	{
+		Name:     ethereum.BasicChannel,
+		Outbound: "0x992B9df075935E522EC7950F37eC8557e86f6fdb",
+		Event:    "Message(address,uint64,bytes)",
+	},
+	{
+		Name:     ethereum.IncentivizedChannel,
+		Outbound: "0xFc97A6197dc90bef6bbEFD672742Ed75E9768553",
+		Event:    "Message(address,uint64,uint256,bytes)",
+	},
+}
+
+func makeTestLog(channel *ethereum.ChannelConfig, blockNumber uint64) gethTypes.Log {
+	return gethTypes.Log{
+		Address:     channel.OutboundAddress(),
+		Topics:      []gethCommon.Hash{channel.EventTopic()},
+		BlockNumber: blockNumber,
+	}
+}
+
+func TestLogFetcher_SingleQueryPerRange(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	client := TestLogFilterer{
+		latest: 110,
+		logs: []gethTypes.Log{
+			makeTestLog(&testChannels[0], 3),
+			makeTestLog(&testChannels[1], 3),
+			makeTestLog(&testChannels[1], 7),
+			// Incentivized event signature emitted by the basic channel's contract
+			{
+				Address:     testChannels[0].OutboundAddress(),
+				Topics:      []gethCommon.Hash{testChannels[1].EventTopic()},
+				BlockNumber: 7,
+			},
+		},
+	}
+	fetcher := ethereum.NewLogFetcher(&client, testChannels, 10, logger.WithField("test", "ing"))
+
+	ctx := context.Background()
+	for number := uint64(1); number <= 100; number++ {
+		logs, err := fetcher.FetchLogs(ctx, number, nil)
+		assert.Nil(t, err)
+
+		switch number {
+		case 3:
+			assert.Equal(t, 2, len(logs))
+		case 7:
+			assert.Equal(t, 1, len(logs))
+			assert.Equal(t, testChannels[1].OutboundAddress(), logs[0].Address)
+		default:
+			assert.Empty(t, logs)
+		}
+	}
+
+	assert.Equal(t, 1, len(client.queries))
+	assert.Equal(t, uint64(1), client.queries[0].FromBlock.Uint64())
+	assert.Equal(t, uint64(100), client.queries[0].ToBlock.Uint64())
+	assert.Equal(t, 2, len(client.queries[0].Addresses))
+}
+
+func TestLogFetcher_ShrinksRange(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	client := TestLogFilterer{
+		latest:   10000,
+		logs:     []gethTypes.Log{makeTestLog(&testChannels[0], 500)},
+		maxRange: 100,
+	}
+	fetcher := ethereum.NewLogFetcher(&client, testChannels, 0, logger.WithField("test", "ing"))
+
+	logs, err := fetcher.FetchLogs(context.Background(), 450, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, logs)
+
+	last := client.queries[len(client.queries)-1]
+	assert.True(t, last.ToBlock.Uint64()-last.FromBlock.Uint64()+1 <= 100)
+
+	// Prefetched logs are served without further queries
+	queryCount := len(client.queries)
+	logs, err = fetcher.FetchLogs(context.Background(), 500, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, queryCount, len(client.queries))
+}
+
+func TestLogFetcher_SkipsBlocksByBloom(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	client := TestLogFilterer{latest: 100}
+	fetcher := ethereum.NewLogFetcher(&client, testChannels, 0, logger.WithField("test", "ing"))
+
+	header := gethTypes.Header{Number: big.NewInt(5)}
+	logs, err := fetcher.FetchLogs(context.Background(), 5, &header)
+	assert.Nil(t, err)
+	assert.Empty(t, logs)
+	assert.Empty(t, client.queries)
+
+	log := makeTestLog(&testChannels[0], 5)
+	header.Bloom = gethTypes.CreateBloom(gethTypes.Receipts{{Logs: []*gethTypes.Log{&log}}})
+	log.BlockHash = header.Hash()
+	client.logs = []gethTypes.Log{log}
+
+	logs, err = fetcher.FetchLogs(context.Background(), 5, &header)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, 1, len(client.queries))
+}
+
+func TestLogFetcher_RefetchesReplacedBlocks(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	client := TestLogFilterer{latest: 100}
+	fetcher := ethereum.NewLogFetcher(&client, testChannels, 0, logger.WithField("test", "ing"))
+
+	// Blocks 5 and 6 are prefetched before they're replaced
+	stale := makeTestLog(&testChannels[0], 6)
+	stale.BlockHash = gethCommon.Hash{6}
+	client.logs = []gethTypes.Log{stale}
+	_, err := fetcher.FetchLogs(context.Background(), 4, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(client.queries))
+
+	makeHeader := func(number int64, log *gethTypes.Log) gethTypes.Header {
+		header := gethTypes.Header{Number: big.NewInt(number)}
+		header.Bloom = gethTypes.CreateBloom(gethTypes.Receipts{{Logs: []*gethTypes.Log{log}}})
+		log.BlockHash = header.Hash()
+		return header
+	}
+	first := makeTestLog(&testChannels[0], 5)
+	firstHeader := makeHeader(5, &first)
+	second := makeTestLog(&testChannels[1], 6)
+	secondHeader := makeHeader(6, &second)
+	client.logs = []gethTypes.Log{first, second}
+
+	// The bloom of block 5 matches although no logs were prefetched for it
+	logs, err := fetcher.FetchLogs(context.Background(), 5, &firstHeader)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(logs)) {
+		assert.Equal(t, firstHeader.Hash(), logs[0].BlockHash)
+	}
+	assert.Equal(t, 2, len(client.queries))
+
+	// Logs are served from the new prefetch
+	logs, err = fetcher.FetchLogs(context.Background(), 6, &secondHeader)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(logs)) {
+		assert.Equal(t, secondHeader.Hash(), logs[0].BlockHash)
+	}
+	assert.Equal(t, 2, len(client.queries))
+
+	// The provider still serves logs of another block
+	third := makeTestLog(&testChannels[0], 7)
+	thirdHeader := makeHeader(7, &third)
+	third.BlockHash = gethCommon.Hash{7}
+	client.logs = []gethTypes.Log{third}
+	_, err = fetcher.FetchLogs(context.Background(), 7, &thirdHeader)
+	assert.Error(t, err)
+}
+
+func TestLogFetcher_KeepsRangeOnOtherErrors(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	client := TestLogFilterer{latest: 100, err: errors.New("too many requests, request rate limit exceeded")}
+	fetcher := ethereum.NewLogFetcher(&client, testChannels, 0, logger.WithField("test", "ing"))
+
+	_, err := fetcher.FetchLogs(context.Background(), 1, nil)
+	assert.EqualError(t, err, "too many requests, request rate limit exceeded")
+	_, err = fetcher.FetchLogRange(context.Background(), 1, 100)
+	assert.Error(t, err)
+	assert.Equal(t, 2, len(client.queries))
+}
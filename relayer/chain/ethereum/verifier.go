@@ -0,0 +1,163 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethereum
+
+import (
+	"bytes"
+	"fmt"
+
+	gethCommon "github.com/ethereum/go-ethereum/common"
+	etypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// receiptRLP is the legacy receipt encoding accepted by the parachain
+type receiptRLP struct {
+	PostStateOrStatus []byte
+	CumulativeGasUsed uint64
+	Bloom             etypes.Bloom
+	Logs              []*etypes.Log
+}
+
+// VerifyMessage performs the same checks as the parachain's VerifierLightclient
+// pallet: the receipt proof in `message` must resolve to `receiptsRoot`, and the
+// proven receipt must contain the log encoded in the message data.
+func VerifyMessage(receiptsRoot gethCommon.Hash, message *parachain.Message) error {
+	if message.Proof.Data == nil {
+		return fmt.Errorf("message has no proof data")
+	}
+
+	root, receiptData, err := applyMerkleProof(message.Proof.Data.Values)
+	if err != nil {
+		return err
+	}
+	if root != receiptsRoot {
+		return fmt.Errorf("proof root %s does not match receipts root %s", root.Hex(), receiptsRoot.Hex())
+	}
+
+	var receipt receiptRLP
+	err = rlp.DecodeBytes(receiptData, &receipt)
+	if err != nil {
+		return fmt.Errorf("decode receipt: %w", err)
+	}
+
+	var log etypes.Log
+	err = rlp.DecodeBytes(message.Data, &log)
+	if err != nil {
+		return fmt.Errorf("decode log: %w", err)
+	}
+
+	for _, l := range receipt.Logs {
+		if logsEqual(l, &log) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("log not found in receipt")
+}
+
+// applyMerkleProof walks the proof from the leaf up to the root, checking that
+// each node references the hash of the node below it. It returns the root hash
+// and the value stored in the leaf.
+func applyMerkleProof(proof []types.Bytes) (gethCommon.Hash, []byte, error) {
+	if len(proof) == 0 {
+		return gethCommon.Hash{}, nil, fmt.Errorf("proof is empty")
+	}
+
+	leaf := proof[len(proof)-1]
+	_, value, err := decodeShortNode(leaf)
+	if err != nil {
+		return gethCommon.Hash{}, nil, fmt.Errorf("decode leaf node: %w", err)
+	}
+
+	hash := crypto.Keccak256Hash(leaf)
+	for i := len(proof) - 2; i >= 0; i-- {
+		found, err := nodeContainsHash(proof[i], hash)
+		if err != nil {
+			return gethCommon.Hash{}, nil, fmt.Errorf("decode node %d: %w", i, err)
+		}
+		if !found {
+			return gethCommon.Hash{}, nil, fmt.Errorf("node %d does not reference its child", i)
+		}
+		hash = crypto.Keccak256Hash(proof[i])
+	}
+
+	return hash, value, nil
+}
+
+func decodeShortNode(node []byte) ([]byte, []byte, error) {
+	content, _, err := rlp.SplitList(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, rest, err := rlp.SplitString(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value, _, err := rlp.SplitString(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, value, nil
+}
+
+func nodeContainsHash(node []byte, hash gethCommon.Hash) (bool, error) {
+	content, _, err := rlp.SplitList(node)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := rlp.CountValues(content)
+	if err != nil {
+		return false, err
+	}
+
+	switch count {
+	case 2:
+		_, value, err := decodeShortNode(node)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(value, hash[:]), nil
+	case 17:
+		found := false
+		for i := 0; i < count; i++ {
+			var child []byte
+			child, content, err = rlp.SplitString(content)
+			if err != nil {
+				return false, err
+			}
+			switch len(child) {
+			case 0:
+			case 32:
+				if bytes.Equal(child, hash[:]) {
+					found = true
+				}
+			default:
+				return false, fmt.Errorf("expected 32-byte hash or empty child")
+			}
+		}
+		return found, nil
+	default:
+		return false, fmt.Errorf("invalid number of list elements")
+	}
+}
+
+func logsEqual(a *etypes.Log, b *etypes.Log) bool {
+	if a.Address != b.Address || len(a.Topics) != len(b.Topics) {
+		return false
+	}
+	for i := range a.Topics {
+		if a.Topics[i] != b.Topics[i] {
+			return false
+		}
+	}
+	return bytes.Equal(a.Data, b.Data)
+}
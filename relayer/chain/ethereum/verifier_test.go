@@ -0,0 +1,70 @@
+package ethereum_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestMessage(t *testing.T, receiptIndex int, logIndex int) parachain.Message {
+	receipts := receipts11408438()
+	event := receipts[receiptIndex].Logs[logIndex]
+
+	receiptTrie, err := ethereum.MakeTrie(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, _ := test.NewNullLogger()
+	mapping := make(map[common.Address]string)
+	mapping[event.Address] = "InboundChannel.submit"
+
+	msg, err := ethereum.MakeMessageFromEvent(mapping, event, receiptTrie, logger.WithField("test", "ing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg.Args[0].(parachain.Message)
+}
+
+func TestVerifyMessage(t *testing.T) {
+	block := block11408438()
+	message := makeTestMessage(t, 5, 5)
+
+	err := ethereum.VerifyMessage(block.ReceiptHash(), &message)
+	assert.Nil(t, err)
+}
+
+func TestVerifyMessage_WrongRoot(t *testing.T) {
+	message := makeTestMessage(t, 5, 5)
+
+	err := ethereum.VerifyMessage(common.HexToHash("0x01"), &message)
+	assert.Error(t, err)
+}
+
+func TestVerifyMessage_LogNotInReceipt(t *testing.T) {
+	block := block11408438()
+	message := makeTestMessage(t, 5, 5)
+	other := makeTestMessage(t, 3, 0)
+
+	// Valid proof for receipt 5, but log data taken from receipt 3
+	message.Data = other.Data
+
+	err := ethereum.VerifyMessage(block.ReceiptHash(), &message)
+	assert.Error(t, err)
+}
+
+func TestVerifyMessage_TamperedProof(t *testing.T) {
+	block := block11408438()
+	message := makeTestMessage(t, 5, 5)
+
+	leaf := message.Proof.Data.Values[len(message.Proof.Data.Values)-1]
+	leaf[len(leaf)-1] ^= 0xff
+
+	err := ethereum.VerifyMessage(block.ReceiptHash(), &message)
+	assert.Error(t, err)
+}
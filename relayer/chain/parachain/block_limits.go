@@ -0,0 +1,81 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package parachain
+
+import (
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+// Mirrors frame_system::limits::BlockLength
+type blockLength struct {
+	Max perDispatchClassU32
+}
+
+type perDispatchClassU32 struct {
+	Normal      types.U32
+	Operational types.U32
+	Mandatory   types.U32
+}
+
+// Mirrors frame_system::limits::BlockWeights
+type blockWeights struct {
+	BaseBlock types.U64
+	MaxBlock  types.U64
+	PerClass  perDispatchClassWeights
+}
+
+type perDispatchClassWeights struct {
+	Normal      weightsPerClass
+	Operational weightsPerClass
+	Mandatory   weightsPerClass
+}
+
+type weightsPerClass struct {
+	BaseExtrinsic types.U64
+	MaxExtrinsic  types.OptionU64
+	MaxTotal      types.OptionU64
+	Reserved      types.OptionU64
+}
+
+// BlockLimits are the upper bounds for the length and weight of a single
+// normal extrinsic
+type BlockLimits struct {
+	MaxExtrinsicLength uint32
+	MaxExtrinsicWeight uint64
+}
+
+// ReadBlockLimits decodes the limits from the System pallet's metadata constants
+func ReadBlockLimits(meta *types.Metadata) (*BlockLimits, error) {
+	value, err := meta.FindConstantValue("System", "BlockLength")
+	if err != nil {
+		return nil, err
+	}
+
+	var length blockLength
+	err = types.DecodeFromBytes(value, &length)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err = meta.FindConstantValue("System", "BlockWeights")
+	if err != nil {
+		return nil, err
+	}
+
+	var weights blockWeights
+	err = types.DecodeFromBytes(value, &weights)
+	if err != nil {
+		return nil, err
+	}
+
+	maxWeight := uint64(weights.MaxBlock)
+	if ok, value := weights.PerClass.Normal.MaxExtrinsic.Unwrap(); ok {
+		maxWeight = uint64(value)
+	}
+
+	return &BlockLimits{
+		MaxExtrinsicLength: uint32(length.Max.Normal),
+		MaxExtrinsicWeight: maxWeight,
+	}, nil
+}
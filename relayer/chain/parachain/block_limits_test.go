@@ -0,0 +1,72 @@
+package parachain_test
+
+import (
+	"testing"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+	"github.com/stretchr/testify/assert"
+)
+
+type testWeightsPerClass struct {
+	BaseExtrinsic types.U64
+	MaxExtrinsic  types.OptionU64
+	MaxTotal      types.OptionU64
+	Reserved      types.OptionU64
+}
+
+func makeTestMetadata(t *testing.T, maxExtrinsic types.OptionU64) *types.Metadata {
+	length, err := types.EncodeToBytes([3]types.U32{3932160, 5242880, 5242880})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	class := testWeightsPerClass{
+		BaseExtrinsic: 125000000,
+		MaxExtrinsic:  maxExtrinsic,
+		MaxTotal:      types.NewOptionU64(375000000000),
+		Reserved:      types.NewOptionU64(0),
+	}
+	weights, err := types.EncodeToBytes(struct {
+		BaseBlock types.U64
+		MaxBlock  types.U64
+		PerClass  [3]testWeightsPerClass
+	}{5000000000, 500000000000, [3]testWeightsPerClass{class, class, class}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var system types.ModuleMetadataV12
+	system.Name = "System"
+	system.Constants = []types.ModuleConstantMetadataV6{
+		{Name: "BlockLength", Type: "BlockLength", Value: length},
+		{Name: "BlockWeights", Type: "BlockWeights", Value: weights},
+	}
+
+	return &types.Metadata{
+		IsMetadataV12: true,
+		AsMetadataV12: types.MetadataV12{Modules: []types.ModuleMetadataV12{system}},
+	}
+}
+
+func TestReadBlockLimits(t *testing.T) {
+	meta := makeTestMetadata(t, types.NewOptionU64(374875000000))
+
+	limits, err := parachain.ReadBlockLimits(meta)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3932160), limits.MaxExtrinsicLength)
+	assert.Equal(t, uint64(374875000000), limits.MaxExtrinsicWeight)
+}
+
+func TestReadBlockLimits_NoMaxExtrinsic(t *testing.T) {
+	meta := makeTestMetadata(t, types.NewOptionU64Empty())
+
+	limits, err := parachain.ReadBlockLimits(meta)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(500000000000), limits.MaxExtrinsicWeight)
+}
+
+func TestReadBlockLimits_Missing(t *testing.T) {
+	_, err := parachain.ReadBlockLimits(&types.Metadata{IsMetadataV12: true})
+	assert.Error(t, err)
+}
@@ -0,0 +1,298 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package parachain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/rpc/offchain"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+// CommitmentNotFoundError is returned when the messages committed to by a digest item
+// can't be found
+type CommitmentNotFoundError struct {
+	Commitment Commitment
+}
+
+func (e *CommitmentNotFoundError) Error() string {
+	return fmt.Sprintf("messages of %s channel commitment %s not found", e.Commitment.ChannelID.Name(),
+		e.Commitment.Hash.Hex())
+}
+
+// IsCommitmentNotFound reports whether err is, or wraps, a CommitmentNotFoundError
+func IsCommitmentNotFound(err error) bool {
+	var notFound *CommitmentNotFoundError
+	return errors.As(err, &notFound)
+}
+
+// CommitmentSource retrieves the SCALE-encoded messages committed to in the digest of a block
+type CommitmentSource interface {
+	// GetCommitment returns a CommitmentNotFoundError if the source doesn't have the messages
+	GetCommitment(commitment *Commitment, blockHash types.Hash) (types.StorageDataRaw, error)
+}
+
+// OffchainStorageSource reads commitments from the offchain storage of a node, where the
+// outbound channels persist them when offchain indexing is enabled
+type OffchainStorageSource struct {
+	conn *Connection
+}
+
+func NewOffchainStorageSource(conn *Connection) *OffchainStorageSource {
+	return &OffchainStorageSource{conn: conn}
+}
+
+func (s *OffchainStorageSource) GetCommitment(commitment *Commitment, _ types.Hash) (types.StorageDataRaw, error) {
+	storageKey, err := MakeStorageKey(commitment.ChannelID, commitment.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.conn.GetAPI().RPC.Offchain.LocalStorageGet(offchain.Persistent, storageKey)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || len(*data) == 0 {
+		return nil, &CommitmentNotFoundError{*commitment}
+	}
+	return *data, nil
+}
+
+// Delay before connecting to an unavailable offchain node again
+const OffchainReconnectDelay = time.Minute
+
+// RemoteOffchainStorageSource reads commitments from the offchain storage of a node other than
+// the parachain node the relayer follows. The node is connected to on first use, and skipped
+// while it's unavailable, so that it doesn't hold up relaying.
+type RemoteOffchainStorageSource struct {
+	conn *Connection
+	// Guards connecting and closing the connection
+	mu             sync.Mutex
+	connected      bool
+	closed         bool
+	reconnectDelay time.Duration
+	nextAttempt    time.Time
+}
+
+func NewRemoteOffchainStorageSource(conn *Connection) *RemoteOffchainStorageSource {
+	return &RemoteOffchainStorageSource{conn: conn, reconnectDelay: OffchainReconnectDelay}
+}
+
+func (s *RemoteOffchainStorageSource) GetCommitment(commitment *Commitment, blockHash types.Hash) (
+	types.StorageDataRaw, error) {
+	err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	return NewOffchainStorageSource(s.conn).GetCommitment(commitment, blockHash)
+}
+
+func (s *RemoteOffchainStorageSource) connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("offchain storage source is closed")
+	}
+	if s.connected {
+		return nil
+	}
+	if now := time.Now(); now.Before(s.nextAttempt) {
+		return fmt.Errorf("offchain node is unavailable, reconnecting in %s",
+			s.nextAttempt.Sub(now).Round(time.Second))
+	}
+
+	err := s.conn.Connect(context.Background())
+	if err != nil {
+		s.nextAttempt = time.Now().Add(s.reconnectDelay)
+		s.conn.log.WithError(err).Warn("Failed to connect to offchain node. Skipping it meanwhile")
+		return err
+	}
+	s.connected = true
+	return nil
+}
+
+// Close closes the connection to the node, if any
+func (s *RemoteOffchainStorageSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.conn.Close()
+}
+
+// MessageQueueSource rebuilds commitments from the message queues of the outbound channels. A
+// channel commits to its queue at the start of a block, so the committed messages are those
+// queued at the end of the parent block.
+//
+// The outbound channels' events can't be used instead: they only carry the nonce of each
+// accepted message, not its target or payload. Reading the queue requires a node keeping the
+// state of the parent block, which a pruning node discards after 256 blocks by default, so
+// older commitments need an archive node or one run with a larger --pruning.
+type MessageQueueSource struct {
+	conn *Connection
+}
+
+// Error returned by substrate nodes for the state of a pruned block
+const stateDiscardedError = "State already discarded"
+
+func NewMessageQueueSource(conn *Connection) *MessageQueueSource {
+	return &MessageQueueSource{conn: conn}
+}
+
+func (s *MessageQueueSource) GetCommitment(commitment *Commitment, blockHash types.Hash) (types.StorageDataRaw, error) {
+	channel, err := commitment.ChannelID.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := s.conn.GetAPI().RPC.Chain.GetHeader(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := types.CreateStorageKey(s.conn.GetMetadata(), channel.OutboundModule, "MessageQueue", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.conn.GetAPI().RPC.State.GetStorageRaw(key, header.ParentHash)
+	if err != nil && strings.Contains(err.Error(), stateDiscardedError) {
+		return nil, fmt.Errorf("state of block %s was pruned, reading the message queue requires an archive node: %w",
+			header.ParentHash.Hex(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || len(*data) == 0 {
+		return nil, &CommitmentNotFoundError{*commitment}
+	}
+	return *data, nil
+}
+
+// GetCommitment tries each of the sources in turn, returning the first messages matching the
+// commitment hash. When none of them has the messages, a CommitmentNotFoundError is returned,
+// unless a source failed otherwise.
+func GetCommitment(sources []CommitmentSource, commitment *Commitment, blockHash types.Hash,
+	log *logrus.Entry) (types.StorageDataRaw, error) {
+	var lastErr error = &CommitmentNotFoundError{*commitment}
+	for i, source := range sources {
+		sourceLog := log.WithFields(logrus.Fields{
+			"source":         fmt.Sprintf("%T", source),
+			"sourceIndex":    i,
+			"commitmentHash": commitment.Hash.Hex(),
+			"blockHash":      blockHash.Hex(),
+		})
+
+		data, err := source.GetCommitment(commitment, blockHash)
+		if IsCommitmentNotFound(err) {
+			sourceLog.Debug("Commitment not found")
+			continue
+		}
+		if err != nil {
+			sourceLog.WithError(err).Warn("Failed to read commitment")
+			lastErr = err
+			continue
+		}
+
+		hash, err := CommitmentHash(commitment.ChannelID, data)
+		if err != nil {
+			sourceLog.WithError(err).Warn("Failed to decode commitment")
+			lastErr = err
+			continue
+		}
+		if hash != commitment.Hash {
+			sourceLog.WithField("actualHash", hash.Hex()).Warn("Messages don't match commitment")
+			continue
+		}
+
+		sourceLog.WithField("commitmentSizeBytes", len(data)).Debug("Retrieved commitment")
+		return data, nil
+	}
+	return nil, lastErr
+}
+
+type basicMessage struct {
+	Target  common.Address
+	Nonce   uint64
+	Payload []byte
+}
+
+type incentivizedMessage struct {
+	Target  common.Address
+	Nonce   uint64
+	Fee     *big.Int
+	Payload []byte
+}
+
+var (
+	basicMessagesArgs = mustArguments("tuple[]", []abi.ArgumentMarshaling{
+		{Name: "target", Type: "address"},
+		{Name: "nonce", Type: "uint64"},
+		{Name: "payload", Type: "bytes"},
+	})
+	incentivizedMessagesArgs = mustArguments("tuple[]", []abi.ArgumentMarshaling{
+		{Name: "target", Type: "address"},
+		{Name: "nonce", Type: "uint64"},
+		{Name: "fee", Type: "uint256"},
+		{Name: "payload", Type: "bytes"},
+	})
+)
+
+func mustArguments(t string, components []abi.ArgumentMarshaling) abi.Arguments {
+	typ, err := abi.NewType(t, "", components)
+	if err != nil {
+		panic(err)
+	}
+	return abi.Arguments{{Type: typ}}
+}
+
+// CommitmentHash returns the hash of the SCALE-encoded messages of a channel, as committed to
+// by the outbound channel and verified by the inbound channel contract: the Keccak256 hash of
+// the ABI-encoded messages.
+func CommitmentHash(channelID ChannelID, data types.StorageDataRaw) (types.H256, error) {
+	var encoded []byte
+	switch {
+	case channelID.IsBasic:
+		var messages []BasicOutboundChannelMessage
+		err := types.DecodeFromBytes(data, &messages)
+		if err != nil {
+			return types.H256{}, err
+		}
+		abiMessages := make([]basicMessage, len(messages))
+		for i, m := range messages {
+			abiMessages[i] = basicMessage{m.Target, m.Nonce, m.Payload}
+		}
+		encoded, err = basicMessagesArgs.Pack(abiMessages)
+		if err != nil {
+			return types.H256{}, err
+		}
+	case channelID.IsIncentivized:
+		var messages []IncentivizedOutboundChannelMessage
+		err := types.DecodeFromBytes(data, &messages)
+		if err != nil {
+			return types.H256{}, err
+		}
+		abiMessages := make([]incentivizedMessage, len(messages))
+		for i, m := range messages {
+			abiMessages[i] = incentivizedMessage{m.Target, m.Nonce, m.Fee.Int, m.Payload}
+		}
+		encoded, err = incentivizedMessagesArgs.Pack(abiMessages)
+		if err != nil {
+			return types.H256{}, err
+		}
+	default:
+		return types.H256{}, fmt.Errorf("messages of %s channel aren't supported", channelID.Name())
+	}
+	return types.NewH256(crypto.Keccak256(encoded)), nil
+}
@@ -0,0 +1,299 @@
+package parachain_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+	gethrpc "github.com/snowfork/go-substrate-rpc-client/v3/gethrpc"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+var testMessages = []parachain.BasicOutboundChannelMessage{
+	{
+		Target:  common.HexToAddress("0xdaf13fa1997b9649b2bcc553732c67887a68022c"),
+		Nonce:   1,
+		Payload: []byte{1, 2, 3},
+	},
+}
+
+func encodeTestMessages(t *testing.T) types.StorageDataRaw {
+	data, err := types.EncodeToBytes(testMessages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// ABI encoding of testMessages, as in abi.encode(_messages) of BasicInboundChannel.submit
+func testCommitmentHash() types.H256 {
+	word := func(b []byte) []byte {
+		return common.LeftPadBytes(b, 32)
+	}
+	var encoded []byte
+	for _, w := range [][]byte{
+		word([]byte{0x20}), // offset of the array
+		word([]byte{1}),    // length of the array
+		word([]byte{0x20}), // offset of the first message
+		word(testMessages[0].Target[:]),
+		word([]byte{1}),
+		word([]byte{0x60}), // offset of the payload within the message
+		word([]byte{3}),
+		common.RightPadBytes([]byte{1, 2, 3}, 32),
+	} {
+		encoded = append(encoded, w...)
+	}
+	return types.NewH256(crypto.Keccak256(encoded))
+}
+
+func TestCommitmentHash(t *testing.T) {
+	hash, err := parachain.CommitmentHash(parachain.ChannelID{IsBasic: true}, encodeTestMessages(t))
+	assert.Nil(t, err)
+	assert.Equal(t, testCommitmentHash(), hash)
+
+	incentivized, err := types.EncodeToBytes([]parachain.IncentivizedOutboundChannelMessage{
+		{Target: testMessages[0].Target, Nonce: 1, Fee: types.NewU256(*common.Big1), Payload: []byte{1, 2, 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err = parachain.CommitmentHash(parachain.ChannelID{IsIncentivized: true}, incentivized)
+	assert.Nil(t, err)
+	assert.NotEqual(t, testCommitmentHash(), hash)
+
+	_, err = parachain.CommitmentHash(parachain.ChannelID{IsIncentivized: true}, encodeTestMessages(t))
+	assert.Error(t, err)
+}
+
+type testSource struct {
+	data  types.StorageDataRaw
+	err   error
+	calls int
+}
+
+func (s *testSource) GetCommitment(commitment *parachain.Commitment, _ types.Hash) (types.StorageDataRaw, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.data == nil {
+		return nil, &parachain.CommitmentNotFoundError{Commitment: *commitment}
+	}
+	return s.data, nil
+}
+
+func TestGetCommitment(t *testing.T) {
+	log := logrus.WithField("test", "commitment")
+	commitment := parachain.Commitment{ChannelID: parachain.ChannelID{IsBasic: true}, Hash: testCommitmentHash()}
+	data := encodeTestMessages(t)
+
+	notFound := &testSource{}
+	// Messages of another commitment
+	otherMessages, err := types.EncodeToBytes([]parachain.BasicOutboundChannelMessage{{Nonce: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatch := &testSource{data: otherMessages}
+	found := &testSource{data: data}
+	unused := &testSource{data: data}
+
+	result, err := parachain.GetCommitment([]parachain.CommitmentSource{notFound, mismatch, found, unused},
+		&commitment, types.Hash{}, log)
+	assert.Nil(t, err)
+	assert.Equal(t, data, result)
+	assert.Equal(t, []int{1, 1, 1, 0}, []int{notFound.calls, mismatch.calls, found.calls, unused.calls})
+
+	_, err = parachain.GetCommitment([]parachain.CommitmentSource{notFound, mismatch}, &commitment, types.Hash{}, log)
+	assert.True(t, parachain.IsCommitmentNotFound(err))
+	assert.Contains(t, err.Error(), commitment.Hash.Hex())
+
+	failing := &testSource{err: errors.New("connection refused")}
+	_, err = parachain.GetCommitment([]parachain.CommitmentSource{failing, notFound}, &commitment, types.Hash{}, log)
+	assert.EqualError(t, err, "connection refused")
+	assert.False(t, parachain.IsCommitmentNotFound(err))
+}
+
+// Metadata of a parachain with the basic outbound channel
+func newTestMetadata() *types.Metadata {
+	return &types.Metadata{
+		MagicNumber:   types.MagicNumber,
+		Version:       13,
+		IsMetadataV13: true,
+		AsMetadataV13: types.MetadataV13{
+			Modules: []types.ModuleMetadataV13{
+				{
+					Name:       "BasicOutboundModule",
+					HasStorage: true,
+					Storage: types.StorageMetadataV13{
+						Prefix: "BasicOutboundModule",
+						Items: []types.StorageFunctionMetadataV13{
+							{
+								Name:     "MessageQueue",
+								Modifier: types.StorageFunctionModifierV0{IsDefault: true},
+								Type:     types.StorageFunctionTypeV13{IsType: true, AsType: "Vec<Message>"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// testParachain serves the state of a parachain without offchain indexing
+type testParachain struct {
+	metadata string
+	// Storage at the parent of the block with the commitment
+	storage map[string]string
+	// Whether the state of that block was discarded
+	pruned bool
+}
+
+func (p *testParachain) GetMetadata() string {
+	return p.metadata
+}
+
+func (p *testParachain) GetStorage(key string, blockHash string) (*string, error) {
+	if blockHash != parentHash.Hex() {
+		return nil, nil
+	}
+	if p.pruned {
+		return nil, fmt.Errorf("State already discarded for BlockId::Hash(%s)", blockHash)
+	}
+	value, ok := p.storage[key]
+	if !ok {
+		return nil, nil
+	}
+	return &value, nil
+}
+
+func (p *testParachain) LocalStorageGet(_ string, _ string) *string {
+	return nil
+}
+
+func (p *testParachain) Health() map[string]interface{} {
+	return map[string]interface{}{"peers": 1, "isSyncing": false, "shouldHavePeers": true}
+}
+
+func (p *testParachain) GetBlockHash(_ uint64) string {
+	return types.NewHash([]byte{1}).Hex()
+}
+
+func (p *testParachain) GetHeader(_ string) map[string]interface{} {
+	return map[string]interface{}{
+		"parentHash":     parentHash.Hex(),
+		"number":         "0x6",
+		"stateRoot":      types.Hash{}.Hex(),
+		"extrinsicsRoot": types.Hash{}.Hex(),
+		"digest":         map[string]interface{}{"logs": []string{}},
+	}
+}
+
+var (
+	parentHash = types.NewHash([]byte{5})
+	blockHash  = types.NewHash([]byte{6})
+)
+
+func newTestParachain(t *testing.T, p *testParachain) string {
+	server := gethrpc.NewServer()
+	for _, name := range []string{"state", "offchain", "system", "chain"} {
+		err := server.RegisterName(name, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	http := httptest.NewServer(server.WebsocketHandler([]string{"*"}))
+	t.Cleanup(func() {
+		server.Stop()
+		http.Close()
+	})
+	return "ws" + strings.TrimPrefix(http.URL, "http")
+}
+
+func TestGetBasicOutboundMessages_MessageQueue(t *testing.T) {
+	metadata := newTestMetadata()
+	encodedMetadata, err := types.EncodeToHexString(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := types.CreateStorageKey(metadata, "BasicOutboundModule", "MessageQueue", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &testParachain{
+		metadata: encodedMetadata,
+		storage:  map[string]string{key.Hex(): types.HexEncodeToString(encodeTestMessages(t))},
+	}
+
+	conn := parachain.NewConnection(newTestParachain(t, node), nil, logrus.WithField("test", "parachain"))
+	err = conn.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	digestItem := parachain.AuxiliaryDigestItem{
+		IsCommitment: true,
+		AsCommitment: parachain.Commitment{ChannelID: parachain.ChannelID{IsBasic: true}, Hash: testCommitmentHash()},
+	}
+	messages, data, err := conn.GetBasicOutboundMessages(digestItem, blockHash)
+	assert.Nil(t, err)
+	assert.Equal(t, testMessages, messages)
+	assert.Equal(t, encodeTestMessages(t), data)
+
+	// The queue was emptied by an earlier commitment
+	delete(node.storage, key.Hex())
+	_, _, err = conn.GetBasicOutboundMessages(digestItem, blockHash)
+	assert.True(t, parachain.IsCommitmentNotFound(err))
+
+	// The node no longer has the state of the parent block
+	node.pruned = true
+	_, _, err = conn.GetBasicOutboundMessages(digestItem, blockHash)
+	if assert.Error(t, err) {
+		assert.False(t, parachain.IsCommitmentNotFound(err))
+		assert.Contains(t, err.Error(), "archive node")
+	}
+}
+
+func TestRemoteOffchainStorageSource(t *testing.T) {
+	log := logrus.WithField("test", "commitment")
+	commitment := parachain.Commitment{ChannelID: parachain.ChannelID{IsBasic: true}, Hash: testCommitmentHash()}
+	data := encodeTestMessages(t)
+
+	// Nothing listens on the endpoint
+	down := parachain.NewRemoteOffchainStorageSource(parachain.NewConnection("ws://127.0.0.1:1", nil, log))
+	defer down.Close()
+	found := &testSource{data: data}
+
+	for i := 0; i < 2; i++ {
+		result, err := parachain.GetCommitment([]parachain.CommitmentSource{down, found}, &commitment, blockHash, log)
+		assert.Nil(t, err)
+		assert.Equal(t, data, result)
+	}
+
+	_, err := down.GetCommitment(&commitment, blockHash)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "reconnecting in")
+	assert.False(t, parachain.IsCommitmentNotFound(err))
+
+	encodedMetadata, err := types.EncodeToHexString(newTestMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := parachain.NewRemoteOffchainStorageSource(parachain.NewConnection(
+		newTestParachain(t, &testParachain{metadata: encodedMetadata}), nil, log))
+	defer up.Close()
+
+	_, err = up.GetCommitment(&commitment, blockHash)
+	assert.True(t, parachain.IsCommitmentNotFound(err))
+}
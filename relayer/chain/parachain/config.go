@@ -1,6 +1,31 @@
 package parachain
 
+// Ways of handling extrinsics which fail to dispatch when dry-run
+const (
+	DryRunDisabled = ""
+	DryRunWarn     = "warn"
+	DryRunRefuse   = "refuse"
+)
+
 type Config struct {
-	Endpoint   string `mapstructure:"endpoint"`
-	PrivateKey string `mapstructure:"private-key"`
+	Endpoint string `mapstructure:"endpoint"`
+	// Endpoints to fail over to when Endpoint isn't reachable. Optional.
+	Endpoints  []string `mapstructure:"endpoints"`
+	PrivateKey string   `mapstructure:"private-key"`
+	// Nodes with offchain indexing enabled, from which committed messages are read before falling
+	// back to rebuilding them from the state of Endpoint. Optional.
+	OffchainEndpoints []string `mapstructure:"offchain-endpoints"`
+	// ID of the parachain on the relay chain. Required by the parachain commitment relayer.
+	ParachainID uint32 `mapstructure:"parachain-id"`
+	// Maximum number of Ethereum headers submitted in a single extrinsic
+	MaxHeadersPerBatch uint `mapstructure:"max-headers-per-batch"`
+	// File to which messages failing to dispatch are appended. Optional.
+	DeadLetterFile string `mapstructure:"dead-letter-file"`
+	// Dry-run extrinsics before submitting them, and either warn about or refuse to
+	// submit those which fail to dispatch. Requires a node exposing unsafe RPCs.
+	DryRun string `mapstructure:"dry-run"`
+	// Tip added to the fee of each extrinsic
+	Tip uint64 `mapstructure:"tip"`
+	// Number of blocks for which an extrinsic is valid. Defaults to MortalEraPeriod.
+	MortalEraPeriod uint64 `mapstructure:"mortal-era-period"`
 }
@@ -5,97 +5,122 @@ package parachain
 
 import (
 	"context"
+	"encoding/json"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 
 	gsrpc "github.com/snowfork/go-substrate-rpc-client/v3"
-	"github.com/snowfork/go-substrate-rpc-client/v3/rpc/offchain"
 	"github.com/snowfork/go-substrate-rpc-client/v3/signature"
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/substrate"
 )
 
 type Connection struct {
-	endpoint    string
-	kp          *signature.KeyringPair
-	api         *gsrpc.SubstrateAPI
-	metadata    types.Metadata
-	genesisHash types.Hash
-	log         *logrus.Entry
+	endpoints []string
+	kp        *signature.KeyringPair
+	// Shared with the other workers connected to the same endpoints
+	client    *substrate.Client
+	closeOnce sync.Once
+	// Sources of the messages committed to in block digests, tried in order
+	commitmentSources []CommitmentSource
+	log               *logrus.Entry
 }
 
+// GetAPI returns the API of the current connection, which is replaced after reconnecting
 func (co *Connection) GetAPI() *gsrpc.SubstrateAPI {
-	return co.api
+	return co.client.API()
 }
 
+// GetMetadata returns the latest known metadata. The returned metadata isn't modified
+// when the runtime is upgraded, so it stays valid for callers holding on to it.
 func (co *Connection) GetMetadata() *types.Metadata {
-	return &co.metadata
+	return co.client.Metadata()
 }
 
 func (co *Connection) GetKeypair() *signature.KeyringPair {
 	return co.kp
 }
 
-func NewConnection(endpoint string, kp *signature.KeyringPair, log *logrus.Entry) *Connection {
-	return &Connection{
-		endpoint: endpoint,
-		kp:       kp,
-		log:      log,
+// NewConnection creates a connection to the node at endpoint. The connection fails over
+// to the fallback endpoints when the node isn't reachable. Connections to the same
+// endpoints share a websocket, while keeping their own keypair.
+//
+// Committed messages are read from the offchain storage of the node, or else rebuilt
+// from the message queues of the outbound channels.
+func NewConnection(endpoint string, kp *signature.KeyringPair, log *logrus.Entry, fallbacks ...string) *Connection {
+	co := &Connection{
+		endpoints: append([]string{endpoint}, fallbacks...),
+		kp:        kp,
+		log:       log,
 	}
+	co.commitmentSources = []CommitmentSource{NewOffchainStorageSource(co), NewMessageQueueSource(co)}
+	return co
 }
 
-func (co *Connection) Connect(_ context.Context) error {
-	// Initialize API
-	api, err := gsrpc.NewSubstrateAPI(co.endpoint)
-	if err != nil {
-		return err
-	}
-	co.api = api
+// SetCommitmentSources replaces the sources of the messages committed to in block digests
+func (co *Connection) SetCommitmentSources(sources ...CommitmentSource) {
+	co.commitmentSources = sources
+}
 
-	// Fetch metadata
-	meta, err := api.RPC.State.GetMetadataLatest()
-	if err != nil {
-		return err
+func (co *Connection) Connect(ctx context.Context) error {
+	if co.client == nil {
+		co.client = substrate.AcquireClient(co.endpoints)
 	}
-	co.metadata = *meta
 
-	// Fetch genesis hash
-	genesisHash, err := api.RPC.Chain.GetBlockHash(0)
+	err := co.client.Connect(ctx)
 	if err != nil {
 		return err
 	}
-	co.genesisHash = genesisHash
 
 	co.log.WithFields(logrus.Fields{
-		"endpoint":    co.endpoint,
-		"metaVersion": meta.Version,
+		"endpoint":    co.client.Endpoint(),
+		"metaVersion": co.client.Metadata().Version,
 	}).Info("Connected to chain")
 
 	return nil
 }
 
+// Close gives up the shared connection, which is closed once no other worker uses it
 func (co *Connection) Close() {
-	// TODO: Fix design issue in GSRPC preventing on-demand closing of connections
+	if co.client == nil {
+		return
+	}
+	co.closeOnce.Do(co.client.Release)
+}
+
+// Subscribe creates a subscription which is restored after reconnecting
+func (co *Connection) Subscribe(namespace, subscribeMethod, unsubscribeMethod, notificationMethod string,
+	channel interface{}, args ...interface{}) (*substrate.Subscription, error) {
+	return co.client.Subscribe(namespace, subscribeMethod, unsubscribeMethod, notificationMethod, channel, args...)
 }
 
 func (co *Connection) Api() *gsrpc.SubstrateAPI {
-	return co.api
+	return co.GetAPI()
 }
 
 func (co *Connection) GenesisHash() types.Hash {
-	return co.genesisHash
+	return co.client.GenesisHash()
 }
 
 func (co *Connection) Metadata() *types.Metadata {
-	return &co.metadata
+	return co.GetMetadata()
+}
+
+// RefreshMetadata fetches the metadata of the latest runtime. The metadata is shared
+// with the other workers connected to the same endpoints.
+func (co *Connection) RefreshMetadata() error {
+	return co.client.RefreshMetadata()
 }
 
 func (co *Connection) GetFinalizedHeader() (*types.Header, error) {
-	finalizedHash, err := co.api.RPC.Chain.GetFinalizedHead()
+	finalizedHash, err := co.GetAPI().RPC.Chain.GetFinalizedHead()
 	if err != nil {
 		return nil, err
 	}
 
-	finalizedHeader, err := co.api.RPC.Chain.GetHeader(finalizedHash)
+	finalizedHeader, err := co.GetAPI().RPC.Chain.GetHeader(finalizedHash)
 	if err != nil {
 		return nil, err
 	}
@@ -104,7 +129,7 @@ func (co *Connection) GetFinalizedHeader() (*types.Header, error) {
 }
 
 func (co *Connection) GetLatestBlockNumber() (*types.BlockNumber, error) {
-	latestBlock, err := co.api.RPC.Chain.GetBlockLatest()
+	latestBlock, err := co.GetAPI().RPC.Chain.GetBlockLatest()
 	if err != nil {
 		return nil, err
 	}
@@ -112,33 +137,43 @@ func (co *Connection) GetLatestBlockNumber() (*types.BlockNumber, error) {
 	return &latestBlock.Block.Header.Number, nil
 }
 
-func (co *Connection) GetDataForDigestItem(digestItem *AuxiliaryDigestItem) (types.StorageDataRaw, error) {
-	storageKey, err := MakeStorageKey(digestItem.AsCommitment.ChannelID, digestItem.AsCommitment.Hash)
+// RuntimeDispatchInfo is returned by the payment_queryInfo RPC
+type RuntimeDispatchInfo struct {
+	Weight     uint64      `json:"weight"`
+	Class      string      `json:"class"`
+	PartialFee json.Number `json:"partialFee"`
+}
+
+// QueryInfo returns the weight and fee of an extrinsic as estimated by the runtime
+func (co *Connection) QueryInfo(ext *types.Extrinsic) (*RuntimeDispatchInfo, error) {
+	encoded, err := types.EncodeToHexString(ext)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := co.GetAPI().RPC.Offchain.LocalStorageGet(offchain.Persistent, storageKey)
+	var info RuntimeDispatchInfo
+	err = co.GetAPI().Client.Call(&info, "payment_queryInfo", encoded)
 	if err != nil {
-		co.log.WithError(err).Error("Failed to read commitment from offchain storage")
 		return nil, err
 	}
 
-	if data != nil {
-		co.log.WithFields(logrus.Fields{
-			"commitmentSizeBytes": len(*data),
-		}).Debug("Retrieved commitment from offchain storage")
-	} else {
-		co.log.WithError(err).Error("Commitment not found in offchain storage")
+	return &info, nil
+}
+
+// GetDataForDigestItem returns the SCALE-encoded messages committed to by a digest item of
+// the block at blockHash. A CommitmentNotFoundError is returned if no source has them.
+func (co *Connection) GetDataForDigestItem(digestItem *AuxiliaryDigestItem, blockHash types.Hash) (types.StorageDataRaw, error) {
+	data, err := GetCommitment(co.commitmentSources, &digestItem.AsCommitment, blockHash, co.log)
+	if err != nil {
+		co.log.WithError(err).Error("Failed to read commitment")
 		return nil, err
 	}
-
-	return *data, nil
+	return data, nil
 }
 
-func (co *Connection) GetBasicOutboundMessages(digestItem AuxiliaryDigestItem) (
+func (co *Connection) GetBasicOutboundMessages(digestItem AuxiliaryDigestItem, blockHash types.Hash) (
 	[]BasicOutboundChannelMessage, types.StorageDataRaw, error) {
-	data, err := co.GetDataForDigestItem(&digestItem)
+	data, err := co.GetDataForDigestItem(&digestItem, blockHash)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -154,9 +189,9 @@ func (co *Connection) GetBasicOutboundMessages(digestItem AuxiliaryDigestItem) (
 	return messages, data, nil
 }
 
-func (co *Connection) GetIncentivizedOutboundMessages(digestItem AuxiliaryDigestItem) (
+func (co *Connection) GetIncentivizedOutboundMessages(digestItem AuxiliaryDigestItem, blockHash types.Hash) (
 	[]IncentivizedOutboundChannelMessage, types.StorageDataRaw, error) {
-	data, err := co.GetDataForDigestItem(&digestItem)
+	data, err := co.GetDataForDigestItem(&digestItem, blockHash)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -1,12 +1,26 @@
 package parachain
 
 import (
+	"bytes"
 	"fmt"
+	"sync"
 
+	"github.com/sirupsen/logrus"
 	"github.com/snowfork/go-substrate-rpc-client/v3/scale"
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
 )
 
+// UnknownVariantError is returned when decoding an enum variant the relayer doesn't know about,
+// such as one added by a later version of the parachain runtime
+type UnknownVariantError struct {
+	Type  string
+	Index byte
+}
+
+func (e *UnknownVariantError) Error() string {
+	return fmt.Sprintf("No such variant for %s: %d", e.Type, e.Index)
+}
+
 type AuxiliaryDigestItem struct {
 	IsCommitment bool
 	AsCommitment Commitment
@@ -26,9 +40,34 @@ func (a *AuxiliaryDigestItem) Decode(decoder scale.Decoder) error {
 	switch tag {
 	case 0:
 		a.IsCommitment = true
-		err = decoder.Decode(&a.AsCommitment)
+		// Fields are decoded one by one, as errors of struct fields lose their type
+		err = decoder.Decode(&a.AsCommitment.ChannelID)
+		if err != nil {
+			return err
+		}
+		err = decoder.Decode(&a.AsCommitment.Hash)
+	default:
+		return &UnknownVariantError{"AuxiliaryDigestItem", tag}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (a AuxiliaryDigestItem) Encode(encoder scale.Encoder) error {
+	var err error
+	switch {
+	case a.IsCommitment:
+		err = encoder.PushByte(0)
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(a.AsCommitment)
 	default:
-		return fmt.Errorf("No such variant for DigestItem")
+		return fmt.Errorf("No such variant for AuxiliaryDigestItem")
 	}
 
 	if err != nil {
@@ -38,58 +77,181 @@ func (a *AuxiliaryDigestItem) Decode(decoder scale.Decoder) error {
 	return nil
 }
 
+// ChannelInfo describes a variant of ChannelID
+type ChannelInfo struct {
+	// Name of the channel in the channels configuration
+	Name string
+	// Pallet of the outbound channel on the parachain
+	OutboundModule string
+}
+
+var (
+	channelsMu sync.RWMutex
+	// Known channels by ChannelID variant index
+	channels = map[byte]ChannelInfo{
+		0: {Name: "basic", OutboundModule: "BasicOutboundModule"},
+		1: {Name: "incentivized", OutboundModule: "IncentivizedOutboundModule"},
+	}
+)
+
+// RegisterChannelID adds a variant of ChannelID, so that commitments of a channel added to the
+// parachain are decoded. Commitments of channels the relayer can't deliver are skipped.
+func RegisterChannelID(index byte, info ChannelInfo) error {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
+	if info.Name == "" {
+		return fmt.Errorf("ChannelID variant %d has no name", index)
+	}
+	if registered, ok := channels[index]; ok {
+		return fmt.Errorf("ChannelID variant %d is already registered as %s", index, registered.Name)
+	}
+	for i, registered := range channels {
+		if registered.Name == info.Name {
+			return fmt.Errorf("channel %s is already registered as ChannelID variant %d", info.Name, i)
+		}
+	}
+	channels[index] = info
+	return nil
+}
+
 type ChannelID struct {
 	IsBasic        bool
 	IsIncentivized bool
+	// Channels registered with RegisterChannelID, by variant index
+	IsOther bool
+	AsOther byte
 }
 
-func (c *ChannelID) Decode(decoder scale.Decoder) error {
-	tag, err := decoder.ReadOneByte()
-	if err != nil {
-		return err
+// NewChannelID returns the ChannelID with the given variant index, which must be registered
+func NewChannelID(index byte) (ChannelID, error) {
+	channelsMu.RLock()
+	_, ok := channels[index]
+	channelsMu.RUnlock()
+	if !ok {
+		return ChannelID{}, &UnknownVariantError{"ChannelID", index}
 	}
 
-	switch tag {
+	switch index {
 	case 0:
-		c.IsBasic = true
+		return ChannelID{IsBasic: true}, nil
 	case 1:
-		c.IsIncentivized = true
+		return ChannelID{IsIncentivized: true}, nil
 	default:
-		return fmt.Errorf("No such variant for ChannelID")
+		return ChannelID{IsOther: true, AsOther: index}, nil
 	}
+}
 
-	return nil
+// ChannelIDByName returns the ChannelID of a registered channel
+func ChannelIDByName(name string) (ChannelID, error) {
+	channelsMu.RLock()
+	for index, info := range channels {
+		if info.Name == name {
+			channelsMu.RUnlock()
+			return NewChannelID(index)
+		}
+	}
+	channelsMu.RUnlock()
+	return ChannelID{}, fmt.Errorf("unknown channel %s", name)
 }
 
-func (c ChannelID) Encode(encoder scale.Encoder) error {
-	var err error
+// Index returns the variant index of the ChannelID
+func (c ChannelID) Index() (byte, error) {
 	switch {
 	case c.IsBasic:
-		err = encoder.PushByte(0)
+		return 0, nil
 	case c.IsIncentivized:
-		err = encoder.PushByte(1)
+		return 1, nil
+	case c.IsOther:
+		return c.AsOther, nil
 	default:
-		return fmt.Errorf("No such variant for ChannelID")
+		return 0, fmt.Errorf("No such variant for ChannelID")
 	}
+}
 
+// Info returns the registered description of the channel
+func (c ChannelID) Info() (ChannelInfo, error) {
+	index, err := c.Index()
+	if err != nil {
+		return ChannelInfo{}, err
+	}
+
+	channelsMu.RLock()
+	defer channelsMu.RUnlock()
+	info, ok := channels[index]
+	if !ok {
+		return ChannelInfo{}, &UnknownVariantError{"ChannelID", index}
+	}
+	return info, nil
+}
+
+// Name returns the name of the channel, or a placeholder for unregistered channels
+func (c ChannelID) Name() string {
+	info, err := c.Info()
+	if err != nil {
+		index, _ := c.Index()
+		return fmt.Sprintf("unknown(%d)", index)
+	}
+	return info.Name
+}
+
+func (c *ChannelID) Decode(decoder scale.Decoder) error {
+	tag, err := decoder.ReadOneByte()
+	if err != nil {
+		return err
+	}
+
+	channelID, err := NewChannelID(tag)
 	if err != nil {
 		return err
 	}
+	*c = channelID
 
 	return nil
 }
 
-func ExtractAuxiliaryDigestItems(digest types.Digest) ([]AuxiliaryDigestItem, error) {
+func (c ChannelID) Encode(encoder scale.Encoder) error {
+	index, err := c.Index()
+	if err != nil {
+		return err
+	}
+
+	return encoder.PushByte(index)
+}
+
+// DecodeAuxiliaryDigestItem decodes an auxiliary digest item, which must take up all of data
+func DecodeAuxiliaryDigestItem(data []byte) (AuxiliaryDigestItem, error) {
+	reader := bytes.NewReader(data)
+	var item AuxiliaryDigestItem
+	err := scale.NewDecoder(reader).Decode(&item)
+	if err != nil {
+		return AuxiliaryDigestItem{}, err
+	}
+	if reader.Len() != 0 {
+		return AuxiliaryDigestItem{}, fmt.Errorf("%d bytes left after decoding AuxiliaryDigestItem", reader.Len())
+	}
+	return item, nil
+}
+
+// ExtractAuxiliaryDigestItems decodes the auxiliary digest items of a block. Other digest items
+// may also come from unrelated pallets, and later runtimes may add variants of AuxiliaryDigestItem
+// or ChannelID. Items which don't decode as a known variant are skipped with a warning, rather than
+// failing the whole block.
+func ExtractAuxiliaryDigestItems(digest types.Digest, log *logrus.Entry) []AuxiliaryDigestItem {
 	var auxDigestItems []AuxiliaryDigestItem
-	for _, digestItem := range digest {
-		if digestItem.IsOther {
-			var auxDigestItem AuxiliaryDigestItem
-			err := types.DecodeFromBytes(digestItem.AsOther, &auxDigestItem)
-			if err != nil {
-				return nil, err
-			}
-			auxDigestItems = append(auxDigestItems, auxDigestItem)
+	for i, digestItem := range digest {
+		if !digestItem.IsOther {
+			continue
+		}
+		auxDigestItem, err := DecodeAuxiliaryDigestItem(digestItem.AsOther)
+		if err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				"digestItemIndex": i,
+				"digestItem":      fmt.Sprintf("%#x", []byte(digestItem.AsOther)),
+			}).Warn("Skipping unknown digest item")
+			continue
 		}
+		auxDigestItems = append(auxDigestItems, auxDigestItem)
 	}
-	return auxDigestItems, nil
+	return auxDigestItems
 }
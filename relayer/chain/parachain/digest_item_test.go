@@ -0,0 +1,141 @@
+package parachain_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+func commitmentItem(channelID parachain.ChannelID) parachain.AuxiliaryDigestItem {
+	return parachain.AuxiliaryDigestItem{
+		IsCommitment: true,
+		AsCommitment: parachain.Commitment{ChannelID: channelID, Hash: types.NewH256([]byte{7, 7, 7})},
+	}
+}
+
+func TestAuxiliaryDigestItem_RoundTrip(t *testing.T) {
+	for _, channelID := range []parachain.ChannelID{{IsBasic: true}, {IsIncentivized: true}} {
+		item := commitmentItem(channelID)
+		encoded, err := types.EncodeToBytes(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index, _ := channelID.Index()
+		assert.Equal(t, append([]byte{0, index}, item.AsCommitment.Hash[:]...), encoded)
+
+		decoded, err := parachain.DecodeAuxiliaryDigestItem(encoded)
+		assert.Nil(t, err)
+		assert.Equal(t, item, decoded)
+	}
+
+	_, err := types.EncodeToBytes(parachain.AuxiliaryDigestItem{})
+	assert.Error(t, err)
+	_, err = types.EncodeToBytes(commitmentItem(parachain.ChannelID{}))
+	assert.Error(t, err)
+}
+
+func TestDecodeAuxiliaryDigestItem(t *testing.T) {
+	encoded, err := types.EncodeToBytes(commitmentItem(parachain.ChannelID{IsBasic: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A variant added by a later runtime
+	_, err = parachain.DecodeAuxiliaryDigestItem(append([]byte{1}, encoded[1:]...))
+	var unknown *parachain.UnknownVariantError
+	assert.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "AuxiliaryDigestItem", unknown.Type)
+
+	// A channel unknown to the relayer
+	_, err = parachain.DecodeAuxiliaryDigestItem(append([]byte{0, 200}, encoded[2:]...))
+	assert.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "ChannelID", unknown.Type)
+	assert.Equal(t, byte(200), unknown.Index)
+
+	// Digest items of other pallets may start like ours
+	_, err = parachain.DecodeAuxiliaryDigestItem(append(encoded, 1))
+	assert.Error(t, err)
+	_, err = parachain.DecodeAuxiliaryDigestItem(encoded[:10])
+	assert.Error(t, err)
+}
+
+func TestExtractAuxiliaryDigestItems(t *testing.T) {
+	basic := commitmentItem(parachain.ChannelID{IsBasic: true})
+	incentivized := commitmentItem(parachain.ChannelID{IsIncentivized: true})
+	other := func(item parachain.AuxiliaryDigestItem) types.DigestItem {
+		encoded, err := types.EncodeToBytes(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return types.DigestItem{IsOther: true, AsOther: encoded}
+	}
+	unknownVariant := other(basic)
+	unknownVariant.AsOther = append([]byte{5}, unknownVariant.AsOther[1:]...)
+
+	digest := types.Digest{
+		{IsPreRuntime: true, AsPreRuntime: types.PreRuntime{ConsensusEngineID: 1, Bytes: []byte{1}}},
+		other(basic),
+		unknownVariant,
+		{IsOther: true, AsOther: []byte("not a commitment")},
+		other(incentivized),
+	}
+
+	logger, hook := test.NewNullLogger()
+	items := parachain.ExtractAuxiliaryDigestItems(digest, logrus.NewEntry(logger))
+	assert.Equal(t, []parachain.AuxiliaryDigestItem{basic, incentivized}, items)
+	if assert.Len(t, hook.Entries, 2) {
+		assert.Equal(t, logrus.WarnLevel, hook.Entries[0].Level)
+		assert.Equal(t, 2, hook.Entries[0].Data["digestItemIndex"])
+		assert.Equal(t, 3, hook.Entries[1].Data["digestItemIndex"])
+	}
+}
+
+func TestRegisterChannelID(t *testing.T) {
+	_, err := parachain.NewChannelID(9)
+	assert.Error(t, err)
+
+	err = parachain.RegisterChannelID(9, parachain.ChannelInfo{Name: "test", OutboundModule: "TestOutboundModule"})
+	assert.Nil(t, err)
+
+	channelID, err := parachain.NewChannelID(9)
+	assert.Nil(t, err)
+	assert.Equal(t, parachain.ChannelID{IsOther: true, AsOther: 9}, channelID)
+	assert.Equal(t, "test", channelID.Name())
+	info, err := channelID.Info()
+	assert.Nil(t, err)
+	assert.Equal(t, "TestOutboundModule", info.OutboundModule)
+
+	byName, err := parachain.ChannelIDByName("test")
+	assert.Nil(t, err)
+	assert.Equal(t, channelID, byName)
+
+	// Commitments of the registered channel are decoded
+	item := commitmentItem(channelID)
+	encoded, err := types.EncodeToBytes(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := parachain.DecodeAuxiliaryDigestItem(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, item, decoded)
+
+	// But their messages can't be relayed
+	_, err = parachain.CommitmentHash(channelID, []byte{0})
+	assert.Error(t, err)
+
+	assert.Error(t, parachain.RegisterChannelID(9, parachain.ChannelInfo{Name: "other"}))
+	assert.Error(t, parachain.RegisterChannelID(0, parachain.ChannelInfo{Name: "other"}))
+	assert.Error(t, parachain.RegisterChannelID(10, parachain.ChannelInfo{Name: "basic"}))
+	assert.Error(t, parachain.RegisterChannelID(10, parachain.ChannelInfo{}))
+
+	byName, err = parachain.ChannelIDByName("incentivized")
+	assert.Nil(t, err)
+	assert.Equal(t, parachain.ChannelID{IsIncentivized: true}, byName)
+	assert.Equal(t, "unknown(10)", parachain.ChannelID{IsOther: true, AsOther: 10}.Name())
+}
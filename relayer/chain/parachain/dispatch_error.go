@@ -0,0 +1,167 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package parachain
+
+import (
+	"fmt"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/scale"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+// DispatchError mirrors sp_runtime::DispatchError
+type DispatchError struct {
+	IsOther             bool
+	IsCannotLookup      bool
+	IsBadOrigin         bool
+	IsModule            bool
+	AsModule            ModuleError
+	IsConsumerRemaining bool
+	IsNoProviders       bool
+	IsToken             bool
+	AsToken             types.U8
+	IsArithmetic        bool
+	AsArithmetic        types.U8
+}
+
+type ModuleError struct {
+	Index types.U8
+	Error types.U8
+	// Names of the pallet and error, if resolved from the metadata
+	ModuleName string
+	ErrorName  string
+}
+
+func (m *ModuleError) Decode(decoder scale.Decoder) error {
+	err := decoder.Decode(&m.Index)
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(&m.Error)
+}
+
+func (m ModuleError) Encode(encoder scale.Encoder) error {
+	err := encoder.Encode(m.Index)
+	if err != nil {
+		return err
+	}
+	return encoder.Encode(m.Error)
+}
+
+// ResolveNames looks up the names of a module error in the runtime metadata
+func (m *ModuleError) ResolveNames(meta *types.Metadata) error {
+	find := func(name types.Text, index uint8, errors []types.ErrorMetadataV8) (bool, error) {
+		if index != uint8(m.Index) {
+			return false, nil
+		}
+		if int(m.Error) >= len(errors) {
+			return true, fmt.Errorf("error index %d for module %s out of range", m.Error, name)
+		}
+		m.ModuleName = string(name)
+		m.ErrorName = string(errors[m.Error].Name)
+		return true, nil
+	}
+
+	switch {
+	case meta.IsMetadataV12:
+		for _, mod := range meta.AsMetadataV12.Modules {
+			if ok, err := find(mod.Name, mod.Index, mod.Errors); ok {
+				return err
+			}
+		}
+	case meta.IsMetadataV13:
+		for _, mod := range meta.AsMetadataV13.Modules {
+			if ok, err := find(mod.Name, mod.Index, mod.Errors); ok {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported metadata version %d", meta.Version)
+	}
+
+	return fmt.Errorf("module index %d out of range", m.Index)
+}
+
+func (d *DispatchError) Decode(decoder scale.Decoder) error {
+	b, err := decoder.ReadOneByte()
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case 0:
+		// The message of DispatchError::Other isn't encoded
+		d.IsOther = true
+	case 1:
+		d.IsCannotLookup = true
+	case 2:
+		d.IsBadOrigin = true
+	case 3:
+		d.IsModule = true
+		return decoder.Decode(&d.AsModule)
+	case 4:
+		d.IsConsumerRemaining = true
+	case 5:
+		d.IsNoProviders = true
+	case 6:
+		d.IsToken = true
+		return decoder.Decode(&d.AsToken)
+	case 7:
+		d.IsArithmetic = true
+		return decoder.Decode(&d.AsArithmetic)
+	default:
+		return fmt.Errorf("unknown DispatchError variant %d", b)
+	}
+
+	return nil
+}
+
+func (d DispatchError) Error() string {
+	switch {
+	case d.IsOther:
+		return "Other"
+	case d.IsCannotLookup:
+		return "CannotLookup"
+	case d.IsBadOrigin:
+		return "BadOrigin"
+	case d.IsModule:
+		if d.AsModule.ErrorName != "" {
+			return fmt.Sprintf("%s.%s", d.AsModule.ModuleName, d.AsModule.ErrorName)
+		}
+		return fmt.Sprintf("Module { index: %d, error: %d }", d.AsModule.Index, d.AsModule.Error)
+	case d.IsConsumerRemaining:
+		return "ConsumerRemaining"
+	case d.IsNoProviders:
+		return "NoProviders"
+	case d.IsToken:
+		return fmt.Sprintf("Token(%d)", d.AsToken)
+	case d.IsArithmetic:
+		return fmt.Sprintf("Arithmetic(%d)", d.AsArithmetic)
+	default:
+		return "Unknown"
+	}
+}
+
+// DispatchResult mirrors sp_runtime::DispatchResult
+type DispatchResult struct {
+	Ok  bool
+	Err DispatchError
+}
+
+func (d *DispatchResult) Decode(decoder scale.Decoder) error {
+	b, err := decoder.ReadOneByte()
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case 0:
+		d.Ok = true
+		return nil
+	case 1:
+		return decoder.Decode(&d.Err)
+	default:
+		return fmt.Errorf("unknown DispatchResult variant %d", b)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package parachain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/snowfork/go-substrate-rpc-client/v3/scale"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+var invalidTransactionNames = []string{
+	"Call", "Payment", "Future", "Stale", "BadProof", "AncientBirthBlock",
+	"ExhaustsResources", "Custom", "BadMandatory", "MandatoryDispatch",
+}
+
+var unknownTransactionNames = []string{"CannotLookup", "NoUnsignedValidator", "Custom"}
+
+// TransactionValidityError mirrors sp_runtime::transaction_validity::TransactionValidityError
+type TransactionValidityError struct {
+	IsInvalid bool
+	IsUnknown bool
+	// Variant of InvalidTransaction or UnknownTransaction
+	Variant types.U8
+	// Code of the Custom variants
+	Custom types.U8
+}
+
+func (t *TransactionValidityError) Decode(decoder scale.Decoder) error {
+	b, err := decoder.ReadOneByte()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	switch b {
+	case 0:
+		t.IsInvalid = true
+		names = invalidTransactionNames
+	case 1:
+		t.IsUnknown = true
+		names = unknownTransactionNames
+	default:
+		return fmt.Errorf("unknown TransactionValidityError variant %d", b)
+	}
+
+	err = decoder.Decode(&t.Variant)
+	if err != nil {
+		return err
+	}
+	if int(t.Variant) >= len(names) {
+		return fmt.Errorf("unknown transaction validity variant %d", t.Variant)
+	}
+	if names[t.Variant] == "Custom" {
+		return decoder.Decode(&t.Custom)
+	}
+
+	return nil
+}
+
+func (t TransactionValidityError) Error() string {
+	kind, names := "Invalid", invalidTransactionNames
+	if t.IsUnknown {
+		kind, names = "Unknown", unknownTransactionNames
+	}
+
+	if int(t.Variant) >= len(names) {
+		return fmt.Sprintf("%s(%d)", kind, t.Variant)
+	}
+	if names[t.Variant] == "Custom" {
+		return fmt.Sprintf("%s(Custom(%d))", kind, t.Custom)
+	}
+	return fmt.Sprintf("%s(%s)", kind, names[t.Variant])
+}
+
+// ApplyExtrinsicResult mirrors sp_runtime::ApplyExtrinsicResult
+type ApplyExtrinsicResult struct {
+	// Set if the extrinsic is invalid and wouldn't be included in a block
+	ValidityError *TransactionValidityError
+	// Set if the extrinsic is valid but fails to dispatch
+	DispatchError *DispatchError
+}
+
+func (a *ApplyExtrinsicResult) Decode(decoder scale.Decoder) error {
+	b, err := decoder.ReadOneByte()
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case 0:
+		var result DispatchResult
+		err = decoder.Decode(&result)
+		if err != nil {
+			return err
+		}
+		if !result.Ok {
+			a.DispatchError = &result.Err
+		}
+		return nil
+	case 1:
+		a.ValidityError = &TransactionValidityError{}
+		return decoder.Decode(a.ValidityError)
+	default:
+		return fmt.Errorf("unknown ApplyExtrinsicResult variant %d", b)
+	}
+}
+
+// DryRun applies a signed extrinsic on top of the best block without submitting it. The
+// system_dryRun RPC is unsafe and only available on nodes which expose unsafe RPCs.
+func (co *Connection) DryRun(ext *types.Extrinsic) (*ApplyExtrinsicResult, error) {
+	encoded, err := types.EncodeToHexString(ext)
+	if err != nil {
+		return nil, err
+	}
+
+	var res string
+	err = co.GetAPI().Client.Call(&res, "system_dryRun", encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := types.HexDecodeString(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var result ApplyExtrinsicResult
+	err = types.DecodeFromBytes(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.DispatchError != nil && result.DispatchError.IsModule {
+		// Unresolved errors are still reported by index
+		_ = result.DispatchError.AsModule.ResolveNames(co.GetMetadata())
+	}
+
+	return &result, nil
+}
+
+// JSON-RPC error code for calls to methods the node doesn't expose
+const methodNotFoundCode = -32601
+
+// IsMethodNotFound reports whether an RPC call failed because the node doesn't expose the
+// method, e.g. system_dryRun on nodes without unsafe RPCs
+func IsMethodNotFound(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.ErrorCode() == methodNotFoundCode
+	}
+	return strings.Contains(err.Error(), "Method not found")
+}
@@ -0,0 +1,62 @@
+package parachain_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyExtrinsicResult_Decode(t *testing.T) {
+	var result parachain.ApplyExtrinsicResult
+
+	// Ok(Ok(()))
+	err := types.DecodeFromBytes([]byte{0, 0}, &result)
+	assert.Nil(t, err)
+	assert.Nil(t, result.ValidityError)
+	assert.Nil(t, result.DispatchError)
+
+	// Ok(Err(DispatchError::Module { index: 10, error: 2 }))
+	result = parachain.ApplyExtrinsicResult{}
+	err = types.DecodeFromBytes([]byte{0, 1, 3, 10, 2}, &result)
+	assert.Nil(t, err)
+	assert.Nil(t, result.ValidityError)
+	assert.True(t, result.DispatchError.IsModule)
+	assert.Equal(t, types.U8(10), result.DispatchError.AsModule.Index)
+	assert.Equal(t, types.U8(2), result.DispatchError.AsModule.Error)
+
+	// Err(TransactionValidityError::Invalid(InvalidTransaction::Stale))
+	result = parachain.ApplyExtrinsicResult{}
+	err = types.DecodeFromBytes([]byte{1, 0, 3}, &result)
+	assert.Nil(t, err)
+	assert.Nil(t, result.DispatchError)
+	assert.Equal(t, "Invalid(Stale)", result.ValidityError.Error())
+
+	// Err(TransactionValidityError::Unknown(UnknownTransaction::Custom(5)))
+	result = parachain.ApplyExtrinsicResult{}
+	err = types.DecodeFromBytes([]byte{1, 1, 2, 5}, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, "Unknown(Custom(5))", result.ValidityError.Error())
+}
+
+func TestApplyExtrinsicResult_DecodeInvalid(t *testing.T) {
+	var result parachain.ApplyExtrinsicResult
+	err := types.DecodeFromBytes([]byte{1, 0, 42}, &result)
+	assert.Error(t, err)
+}
+
+type rpcError struct{ code int }
+
+func (e rpcError) Error() string  { return "rpc error" }
+func (e rpcError) ErrorCode() int { return e.code }
+
+func TestIsMethodNotFound(t *testing.T) {
+	assert.True(t, parachain.IsMethodNotFound(rpcError{code: -32601}))
+	assert.True(t, parachain.IsMethodNotFound(fmt.Errorf("dry run: %w", rpcError{code: -32601})))
+	assert.True(t, parachain.IsMethodNotFound(errors.New("Method not found")))
+	assert.False(t, parachain.IsMethodNotFound(rpcError{code: -32603}))
+	assert.False(t, parachain.IsMethodNotFound(errors.New("connection reset by peer")))
+}
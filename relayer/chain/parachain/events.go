@@ -0,0 +1,372 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package parachain
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/scale"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+// EventRecord is a single entry of the System.Events storage item, with its
+// arguments decoded according to the type names in the runtime metadata
+type EventRecord struct {
+	Phase  types.Phase
+	Module string
+	Name   string
+	Args   []interface{}
+	Topics []types.Hash
+}
+
+// AssetId mirrors artemis_core::AssetId
+type AssetId struct {
+	IsETH   bool
+	IsToken bool
+	AsToken types.H160
+}
+
+func (a *AssetId) Decode(decoder scale.Decoder) error {
+	b, err := decoder.ReadOneByte()
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case 0:
+		a.IsETH = true
+		return nil
+	case 1:
+		a.IsToken = true
+		return decoder.Decode(&a.AsToken)
+	default:
+		return fmt.Errorf("unknown AssetId variant %d", b)
+	}
+}
+
+// NetworkId mirrors xcm::v0::NetworkId
+type NetworkId struct {
+	IsAny      bool
+	IsNamed    bool
+	AsNamed    types.Bytes
+	IsPolkadot bool
+	IsKusama   bool
+}
+
+func (n *NetworkId) Decode(decoder scale.Decoder) error {
+	b, err := decoder.ReadOneByte()
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case 0:
+		n.IsAny = true
+	case 1:
+		n.IsNamed = true
+		return decoder.Decode(&n.AsNamed)
+	case 2:
+		n.IsPolkadot = true
+	case 3:
+		n.IsKusama = true
+	default:
+		return fmt.Errorf("unknown NetworkId variant %d", b)
+	}
+
+	return nil
+}
+
+// MessageId identifies a message delivered by one of the inbound channels
+type MessageId struct {
+	ChannelID types.U8
+	Nonce     types.U64
+}
+
+// Types of event arguments, keyed by the names used in the runtime metadata.
+// Names are looked up qualified with the module name first, for types whose
+// name is ambiguous across pallets.
+var eventArgTypes = map[string]reflect.Type{
+	"bool":                  reflect.TypeOf(types.Bool(false)),
+	"u8":                    reflect.TypeOf(types.U8(0)),
+	"u16":                   reflect.TypeOf(types.U16(0)),
+	"u32":                   reflect.TypeOf(types.U32(0)),
+	"u64":                   reflect.TypeOf(types.U64(0)),
+	"u128":                  reflect.TypeOf(types.U128{}),
+	"U256":                  reflect.TypeOf(types.U256{}),
+	"H160":                  reflect.TypeOf(types.H160{}),
+	"H256":                  reflect.TypeOf(types.H256{}),
+	"Hash":                  reflect.TypeOf(types.Hash{}),
+	"AccountId":             reflect.TypeOf(types.AccountID{}),
+	"Balance":               reflect.TypeOf(types.U128{}),
+	"BalanceOf":             reflect.TypeOf(types.U128{}),
+	"BalanceStatus":         reflect.TypeOf(types.U8(0)),
+	"Status":                reflect.TypeOf(types.U8(0)),
+	"BlockNumber":           reflect.TypeOf(types.U32(0)),
+	"RelayChainBlockNumber": reflect.TypeOf(types.U32(0)),
+	"Weight":                reflect.TypeOf(types.U64(0)),
+	"ProposalIndex":         reflect.TypeOf(types.U32(0)),
+	"MemberCount":           reflect.TypeOf(types.U32(0)),
+	"ParaId":                reflect.TypeOf(types.U32(0)),
+	"MessageNonce":          reflect.TypeOf(types.U64(0)),
+	"OverweightIndex":       reflect.TypeOf(types.U64(0)),
+	"DispatchInfo":          reflect.TypeOf(types.DispatchInfo{}),
+	"DispatchError":         reflect.TypeOf(DispatchError{}),
+	"DispatchResult":        reflect.TypeOf(DispatchResult{}),
+	"AssetId":               reflect.TypeOf(AssetId{}),
+	"NetworkId":             reflect.TypeOf(NetworkId{}),
+	"MessageId":             reflect.TypeOf(MessageId{}),
+	"DmpQueue.MessageId":    reflect.TypeOf([32]byte{}),
+}
+
+// resolveEventArgType maps a type name from the metadata to a Go type
+func resolveEventArgType(module string, name string) (reflect.Type, error) {
+	name = normalizeTypeName(name)
+
+	if typ, ok := eventArgTypes[module+"."+name]; ok {
+		return typ, nil
+	}
+	if typ, ok := eventArgTypes[name]; ok {
+		return typ, nil
+	}
+
+	switch {
+	case strings.HasPrefix(name, "Vec<") && strings.HasSuffix(name, ">"):
+		elem, err := resolveEventArgType(module, name[4:len(name)-1])
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	case strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]"):
+		parts := strings.Split(name[1:len(name)-1], ";")
+		if len(parts) != 2 {
+			break
+		}
+		length, err := strconv.Atoi(parts[1])
+		if err != nil {
+			break
+		}
+		elem, err := resolveEventArgType(module, parts[0])
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ArrayOf(length, elem), nil
+	case strings.HasPrefix(name, "(") && strings.HasSuffix(name, ")"):
+		var fields []reflect.StructField
+		for i, part := range splitTypeList(name[1 : len(name)-1]) {
+			elem, err := resolveEventArgType(module, part)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, reflect.StructField{Name: fmt.Sprintf("F%d", i), Type: elem})
+		}
+		return reflect.StructOf(fields), nil
+	}
+
+	return nil, fmt.Errorf("unsupported event argument type %s in module %s", name, module)
+}
+
+// normalizeTypeName removes whitespace and generic trait qualifiers, e.g.
+// "T::AccountId" or "BalanceOf<T>" become "AccountId" and "BalanceOf"
+func normalizeTypeName(name string) string {
+	name = strings.Join(strings.Fields(name), "")
+	name = strings.ReplaceAll(name, "<T>", "")
+	name = strings.ReplaceAll(name, "<T,I>", "")
+	name = strings.ReplaceAll(name, "T::", "")
+	return name
+}
+
+// splitTypeList splits a comma separated list of types, ignoring commas in nested types
+func splitTypeList(list string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '<', '(', '[':
+			depth++
+		case '>', ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if start < len(list) {
+		parts = append(parts, list[start:])
+	}
+	return parts
+}
+
+// findEventMetadata returns the module name and metadata of the event with the given ID
+func findEventMetadata(meta *types.Metadata, id types.EventID) (string, *types.EventMetadataV4, error) {
+	find := func(name types.Text, index uint8, events []types.EventMetadataV4) (string, *types.EventMetadataV4, bool, error) {
+		if index != id[0] {
+			return "", nil, false, nil
+		}
+		if int(id[1]) >= len(events) {
+			return "", nil, true, fmt.Errorf("event index %d for module %s out of range", id[1], name)
+		}
+		return string(name), &events[id[1]], true, nil
+	}
+
+	switch {
+	case meta.IsMetadataV12:
+		for _, mod := range meta.AsMetadataV12.Modules {
+			if !mod.HasEvents {
+				continue
+			}
+			if name, event, ok, err := find(mod.Name, mod.Index, mod.Events); ok {
+				return name, event, err
+			}
+		}
+	case meta.IsMetadataV13:
+		for _, mod := range meta.AsMetadataV13.Modules {
+			if !mod.HasEvents {
+				continue
+			}
+			if name, event, ok, err := find(mod.Name, mod.Index, mod.Events); ok {
+				return name, event, err
+			}
+		}
+	default:
+		return "", nil, fmt.Errorf("unsupported metadata version %d", meta.Version)
+	}
+
+	return "", nil, fmt.Errorf("module index %d out of range", id[0])
+}
+
+// EventDecoder decodes the System.Events storage item one record at a time, so that
+// callers can stop before reaching events with argument types that aren't supported
+type EventDecoder struct {
+	meta      *types.Metadata
+	decoder   *scale.Decoder
+	remaining uint64
+}
+
+func NewEventDecoder(meta *types.Metadata, data []byte) (*EventDecoder, error) {
+	decoder := scale.NewDecoder(bytes.NewReader(data))
+
+	count, err := decoder.DecodeUintCompact()
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventDecoder{
+		meta:      meta,
+		decoder:   decoder,
+		remaining: count.Uint64(),
+	}, nil
+}
+
+// Next decodes the next event record. It returns nil once all records are decoded.
+func (ed *EventDecoder) Next() (*EventRecord, error) {
+	if ed.remaining == 0 {
+		return nil, nil
+	}
+	ed.remaining--
+
+	var record EventRecord
+	err := ed.decoder.Decode(&record.Phase)
+	if err != nil {
+		return nil, err
+	}
+
+	var id types.EventID
+	err = ed.decoder.Decode(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	module, event, err := findEventMetadata(ed.meta, id)
+	if err != nil {
+		return nil, err
+	}
+	record.Module = module
+	record.Name = string(event.Name)
+
+	for _, arg := range event.Args {
+		value, err := ed.decodeArg(module, normalizeTypeName(string(arg)))
+		if err != nil {
+			return nil, fmt.Errorf("decode %s.%s: %w", module, record.Name, err)
+		}
+		record.Args = append(record.Args, value)
+	}
+
+	err = ed.decoder.Decode(&record.Topics)
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// decodeArg decodes an event argument. Optional arguments are decoded to nil if
+// they have no value.
+func (ed *EventDecoder) decodeArg(module string, name string) (interface{}, error) {
+	if strings.HasPrefix(name, "Option<") && strings.HasSuffix(name, ">") {
+		b, err := ed.decoder.ReadOneByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return nil, nil
+		}
+		return ed.decodeArg(module, name[7:len(name)-1])
+	}
+
+	typ, err := resolveEventArgType(module, name)
+	if err != nil {
+		return nil, err
+	}
+
+	value := reflect.New(typ)
+	err = ed.decoder.Decode(value.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	// Module errors are only meaningful with the names of the pallet and error
+	switch v := value.Interface().(type) {
+	case *DispatchError:
+		ed.resolveNames(v)
+	case *DispatchResult:
+		if !v.Ok {
+			ed.resolveNames(&v.Err)
+		}
+	}
+
+	return value.Elem().Interface(), nil
+}
+
+func (ed *EventDecoder) resolveNames(dispatchErr *DispatchError) {
+	if dispatchErr.IsModule {
+		// Unresolved errors are still reported by index
+		_ = dispatchErr.AsModule.ResolveNames(ed.meta)
+	}
+}
+
+// DecodeEvents decodes all records of the System.Events storage item
+func DecodeEvents(meta *types.Metadata, data []byte) ([]EventRecord, error) {
+	decoder, err := NewEventDecoder(meta, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []EventRecord
+	for {
+		record, err := decoder.Next()
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			return records, nil
+		}
+		records = append(records, *record)
+	}
+}
@@ -0,0 +1,138 @@
+package parachain_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestEventModule(name string, index uint8, events ...types.EventMetadataV4) types.ModuleMetadataV12 {
+	var module types.ModuleMetadataV12
+	module.Name = types.Text(name)
+	module.HasEvents = true
+	module.Events = events
+	module.Index = index
+	return module
+}
+
+func makeTestEventMetadata() *types.Metadata {
+	system := makeTestEventModule("System", 0,
+		types.EventMetadataV4{Name: "ExtrinsicSuccess", Args: []types.Type{"DispatchInfo"}},
+		types.EventMetadataV4{Name: "ExtrinsicFailed", Args: []types.Type{"DispatchError", "DispatchInfo"}},
+	)
+	system.Errors = []types.ErrorMetadataV8{{Name: "InvalidSpecName"}, {Name: "SpecVersionNeedsToIncrease"}}
+	assets := makeTestEventModule("Assets", 16,
+		types.EventMetadataV4{Name: "Transferred", Args: []types.Type{"AssetId", "T::AccountId", "T::AccountId", "U256"}},
+	)
+	xcmp := makeTestEventModule("XcmpQueue", 17,
+		types.EventMetadataV4{Name: "Success", Args: []types.Type{"Option<Hash>"}},
+		types.EventMetadataV4{Name: "Fail", Args: []types.Type{"Option<Hash>", "XcmError"}},
+	)
+
+	return &types.Metadata{
+		IsMetadataV12: true,
+		AsMetadataV12: types.MetadataV12{Modules: []types.ModuleMetadataV12{system, assets, xcmp}},
+	}
+}
+
+// encodeTestEvent encodes an event record emitted while applying the given extrinsic
+func encodeTestEvent(t *testing.T, extrinsic uint32, id types.EventID, args ...interface{}) []byte {
+	phase, err := types.EncodeToBytes(types.Phase{IsApplyExtrinsic: true, AsApplyExtrinsic: extrinsic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := append(phase, id[:]...)
+	for _, arg := range args {
+		encoded, err := types.EncodeToBytes(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, encoded...)
+	}
+
+	// No topics
+	return append(data, 0)
+}
+
+func encodeTestEvents(records ...[]byte) []byte {
+	data := []byte{byte(len(records) << 2)}
+	for _, record := range records {
+		data = append(data, record...)
+	}
+	return data
+}
+
+func TestDecodeEvents(t *testing.T) {
+	info := types.DispatchInfo{Weight: 1000, Class: types.DispatchClass{IsNormal: true}, PaysFee: types.Pays{IsYes: true}}
+	account := types.NewAccountID(make([]byte, 32))
+
+	data := encodeTestEvents(
+		// Assets.Transferred(AssetId::ETH, ...)
+		encodeTestEvent(t, 0, types.EventID{16, 0}, types.U8(0), account, account, types.NewU256(*big.NewInt(500))),
+		encodeTestEvent(t, 0, types.EventID{0, 0}, info),
+		// System.ExtrinsicFailed(DispatchError::Module { index: 12, error: 3 }, ...)
+		encodeTestEvent(t, 1, types.EventID{0, 1}, [3]types.U8{3, 12, 3}, info),
+		// XcmpQueue.Success(None)
+		encodeTestEvent(t, 2, types.EventID{17, 0}, types.U8(0)),
+		// System.ExtrinsicFailed(DispatchError::Module { index: 0, error: 1 }, ...)
+		encodeTestEvent(t, 3, types.EventID{0, 1}, [3]types.U8{3, 0, 1}, info),
+	)
+
+	records, err := parachain.DecodeEvents(makeTestEventMetadata(), data)
+	assert.Nil(t, err)
+	assert.Len(t, records, 5)
+
+	assert.Equal(t, "Assets", records[0].Module)
+	assert.Equal(t, "Transferred", records[0].Name)
+	assert.True(t, records[0].Args[0].(parachain.AssetId).IsETH)
+
+	assert.Equal(t, "ExtrinsicSuccess", records[1].Name)
+	assert.Equal(t, types.Weight(1000), records[1].Args[0].(types.DispatchInfo).Weight)
+
+	assert.Equal(t, "ExtrinsicFailed", records[2].Name)
+	assert.Equal(t, uint32(1), records[2].Phase.AsApplyExtrinsic)
+	dispatchErr := records[2].Args[0].(parachain.DispatchError)
+	assert.True(t, dispatchErr.IsModule)
+	assert.Equal(t, types.U8(12), dispatchErr.AsModule.Index)
+	assert.Equal(t, types.U8(3), dispatchErr.AsModule.Error)
+	// Not in the metadata
+	assert.Equal(t, "", dispatchErr.AsModule.ErrorName)
+
+	assert.Equal(t, "Success", records[3].Name)
+	assert.Nil(t, records[3].Args[0])
+
+	dispatchErr = records[4].Args[0].(parachain.DispatchError)
+	assert.Equal(t, "System.SpecVersionNeedsToIncrease", dispatchErr.Error())
+}
+
+func TestDecodeEvents_UnsupportedType(t *testing.T) {
+	data := encodeTestEvents(
+		encodeTestEvent(t, 0, types.EventID{17, 1}, types.U8(0), types.U8(0)),
+	)
+
+	_, err := parachain.DecodeEvents(makeTestEventMetadata(), data)
+	assert.Error(t, err)
+}
+
+func TestEventDecoder_StopsBeforeUnsupportedType(t *testing.T) {
+	info := types.DispatchInfo{Weight: 1000, Class: types.DispatchClass{IsNormal: true}, PaysFee: types.Pays{IsYes: true}}
+
+	data := encodeTestEvents(
+		encodeTestEvent(t, 0, types.EventID{0, 0}, info),
+		encodeTestEvent(t, 1, types.EventID{17, 1}, types.U8(0), types.U8(0)),
+	)
+
+	decoder, err := parachain.NewEventDecoder(makeTestEventMetadata(), data)
+	assert.Nil(t, err)
+
+	record, err := decoder.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "ExtrinsicSuccess", record.Name)
+
+	_, err = decoder.Next()
+	assert.Error(t, err)
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package parachain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// ExtrinsicBuilder signs extrinsics with the connection's keypair. It follows runtime
+// upgrades and finalized blocks using subscriptions, so that signing doesn't require
+// any RPC calls. The connection's metadata is refreshed after runtime upgrades.
+type ExtrinsicBuilder struct {
+	conn      *Connection
+	tip       uint64
+	eraPeriod uint64
+	log       *logrus.Entry
+
+	// Fixed for the chain, and cached to sign without a connection
+	genesisHash types.Hash
+
+	sync.RWMutex
+	specVersion        types.U32
+	transactionVersion types.U32
+	// Block from which signed extrinsics are valid
+	eraBlockNumber uint64
+	eraBlockHash   types.Hash
+}
+
+func NewExtrinsicBuilder(conn *Connection, config *Config, log *logrus.Entry) (*ExtrinsicBuilder, error) {
+	period := config.MortalEraPeriod
+	if period == 0 {
+		period = MortalEraPeriod
+	}
+	if !IsValidMortalEraPeriod(period) {
+		return nil, fmt.Errorf("mortal era period %d is not a power of two between 4 and 65536", period)
+	}
+
+	return &ExtrinsicBuilder{
+		conn:      conn,
+		tip:       config.Tip,
+		eraPeriod: period,
+		log:       log,
+	}, nil
+}
+
+func (eb *ExtrinsicBuilder) Start(ctx context.Context, eg *errgroup.Group) error {
+	eb.genesisHash = eb.conn.GenesisHash()
+
+	rv, err := eb.conn.GetAPI().RPC.State.GetRuntimeVersionLatest()
+	if err != nil {
+		return err
+	}
+	eb.setRuntimeVersion(rv)
+
+	header, err := eb.conn.GetFinalizedHeader()
+	if err != nil {
+		return err
+	}
+	err = eb.refreshEra(uint64(header.Number))
+	if err != nil {
+		return err
+	}
+
+	// Subscriptions made through the connection are restored after reconnecting
+	versions := make(chan types.RuntimeVersion)
+	versionsSub, err := eb.conn.Subscribe("state", "subscribeRuntimeVersion", "unsubscribeRuntimeVersion",
+		"runtimeVersion", versions)
+	if err != nil {
+		return err
+	}
+
+	heads := make(chan types.Header)
+	headsSub, err := eb.conn.Subscribe("chain", "subscribeFinalizedHeads", "unsubscribeFinalizedHeads",
+		"finalizedHead", heads)
+	if err != nil {
+		versionsSub.Unsubscribe()
+		return err
+	}
+
+	eg.Go(func() error {
+		defer versionsSub.Unsubscribe()
+		defer headsSub.Unsubscribe()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-versionsSub.Err():
+				return err
+			case err := <-headsSub.Err():
+				return err
+			case rv := <-versions:
+				err := eb.onRuntimeVersion(&rv)
+				if err != nil {
+					return err
+				}
+			case header := <-heads:
+				if !eb.isEraStale(uint64(header.Number)) {
+					continue
+				}
+				err := eb.refreshEra(uint64(header.Number))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+func (eb *ExtrinsicBuilder) setRuntimeVersion(rv *types.RuntimeVersion) {
+	eb.Lock()
+	defer eb.Unlock()
+	eb.specVersion = rv.SpecVersion
+	eb.transactionVersion = rv.TransactionVersion
+}
+
+func (eb *ExtrinsicBuilder) onRuntimeVersion(rv *types.RuntimeVersion) error {
+	eb.RLock()
+	upgraded := rv.SpecVersion != eb.specVersion || rv.TransactionVersion != eb.transactionVersion
+	eb.RUnlock()
+
+	if !upgraded {
+		return nil
+	}
+
+	// Calls must be built with the new metadata before signing for the new version
+	err := eb.conn.RefreshMetadata()
+	if err != nil {
+		return err
+	}
+	eb.setRuntimeVersion(rv)
+
+	eb.log.WithFields(logrus.Fields{
+		"specVersion":        rv.SpecVersion,
+		"transactionVersion": rv.TransactionVersion,
+	}).Info("Runtime was upgraded")
+
+	return nil
+}
+
+// isEraStale returns true once a quarter of the era period has passed since the era block
+func (eb *ExtrinsicBuilder) isEraStale(finalizedBlockNumber uint64) bool {
+	eb.RLock()
+	defer eb.RUnlock()
+	return finalizedBlockNumber >= eb.eraBlockNumber+eb.eraPeriod/4
+}
+
+func (eb *ExtrinsicBuilder) refreshEra(finalizedBlockNumber uint64) error {
+	hash, err := eb.conn.GetAPI().RPC.Chain.GetBlockHash(finalizedBlockNumber)
+	if err != nil {
+		return err
+	}
+
+	eb.Lock()
+	defer eb.Unlock()
+	eb.eraBlockNumber = finalizedBlockNumber
+	eb.eraBlockHash = hash
+	return nil
+}
+
+// Sign creates an extrinsic for the call, signed with the given nonce
+func (eb *ExtrinsicBuilder) Sign(call types.Call, nonce uint32) (*types.Extrinsic, error) {
+	eb.RLock()
+	options := types.SignatureOptions{
+		BlockHash:          eb.eraBlockHash,
+		Era:                NewMortalEraWithPeriod(eb.eraBlockNumber, eb.eraPeriod),
+		GenesisHash:        eb.genesisHash,
+		Nonce:              types.NewUCompactFromUInt(uint64(nonce)),
+		SpecVersion:        eb.specVersion,
+		Tip:                types.NewUCompactFromUInt(eb.tip),
+		TransactionVersion: eb.transactionVersion,
+	}
+	eb.RUnlock()
+
+	ext := types.NewExtrinsic(call)
+	err := ext.Sign(*eb.conn.GetKeypair(), options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ext, nil
+}
@@ -0,0 +1,49 @@
+package parachain
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/signature"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtrinsicBuilder_Sign(t *testing.T) {
+	conn := NewConnection("", &signature.TestKeyringPairAlice, logrus.NewEntry(logrus.New()))
+
+	builder, err := NewExtrinsicBuilder(conn, &Config{Tip: 100, MortalEraPeriod: 256}, conn.log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.specVersion = 3
+	builder.transactionVersion = 1
+	builder.eraBlockNumber = 1000
+	builder.eraBlockHash = types.NewHash([]byte{1, 2, 3})
+
+	ext, err := builder.Sign(types.Call{CallIndex: types.CallIndex{SectionIndex: 1, MethodIndex: 2}}, 7)
+	assert.Nil(t, err)
+	assert.True(t, ext.IsSigned())
+	assert.Equal(t, types.NewUCompactFromUInt(7), ext.Signature.Nonce)
+	assert.Equal(t, types.NewUCompactFromUInt(100), ext.Signature.Tip)
+	assert.Equal(t, NewMortalEraWithPeriod(1000, 256), ext.Signature.Era)
+}
+
+func TestExtrinsicBuilder_IsEraStale(t *testing.T) {
+	conn := NewConnection("", &signature.TestKeyringPairAlice, logrus.NewEntry(logrus.New()))
+
+	builder, err := NewExtrinsicBuilder(conn, &Config{}, conn.log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.eraBlockNumber = 1000
+
+	assert.False(t, builder.isEraStale(1000))
+	assert.False(t, builder.isEraStale(1015))
+	assert.True(t, builder.isEraStale(1016))
+}
+
+func TestNewExtrinsicBuilder_InvalidPeriod(t *testing.T) {
+	_, err := NewExtrinsicBuilder(nil, &Config{MortalEraPeriod: 100}, nil)
+	assert.Error(t, err)
+}
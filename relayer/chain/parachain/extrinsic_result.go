@@ -0,0 +1,225 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package parachain
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+// ExtrinsicResult is the outcome of dispatching an extrinsic which was included in a block
+type ExtrinsicResult struct {
+	BlockHash types.Hash
+	// Index of the extrinsic in the block
+	Index uint32
+	// Nil if the extrinsic was dispatched successfully
+	Error *DispatchError
+	// Events emitted while applying the extrinsic
+	Events []EventRecord
+	// Set if the result is from a dry run and the extrinsic wasn't submitted
+	DryRun bool
+}
+
+func (r *ExtrinsicResult) Success() bool {
+	return r.Error == nil
+}
+
+// Only the extrinsics of a block are needed, which are kept encoded to avoid
+// depending on the runtime's signature and signed extension types
+type rawBlock struct {
+	Block struct {
+		Extrinsics []string `json:"extrinsics"`
+	} `json:"block"`
+}
+
+// FetchExtrinsicResult looks up the extrinsic in the given block and decodes the events
+// emitted while applying it
+func (co *Connection) FetchExtrinsicResult(blockHash types.Hash, ext *types.Extrinsic) (*ExtrinsicResult, error) {
+	encoded, err := types.EncodeToHexString(ext)
+	if err != nil {
+		return nil, err
+	}
+
+	var block rawBlock
+	err = co.GetAPI().Client.Call(&block, "chain_getBlock", blockHash.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i, e := range block.Block.Extrinsics {
+		if strings.EqualFold(e, encoded) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("extrinsic not found in block %s", blockHash.Hex())
+	}
+
+	key, err := types.CreateStorageKey(co.GetMetadata(), "System", "Events", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := co.GetAPI().RPC.State.GetStorageRaw(key, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || len(*data) == 0 {
+		return nil, fmt.Errorf("no events found in block %s", blockHash.Hex())
+	}
+
+	decoder, err := NewEventDecoder(co.GetMetadata(), *data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := ExtrinsicResult{
+		BlockHash: blockHash,
+		Index:     uint32(index),
+	}
+
+	for {
+		record, err := decoder.Next()
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			break
+		}
+
+		if !record.Phase.IsApplyExtrinsic || record.Phase.AsApplyExtrinsic < result.Index {
+			if record.Phase.IsFinalization {
+				break
+			}
+			continue
+		}
+		if record.Phase.AsApplyExtrinsic > result.Index {
+			// Events are ordered by phase, so the remaining ones don't need to be decoded
+			break
+		}
+
+		result.Events = append(result.Events, *record)
+
+		if record.Module == "System" && record.Name == "ExtrinsicFailed" {
+			if len(record.Args) == 0 {
+				return nil, fmt.Errorf("unexpected arguments for System.ExtrinsicFailed")
+			}
+			dispatchErr, ok := record.Args[0].(DispatchError)
+			if !ok {
+				return nil, fmt.Errorf("unexpected arguments for System.ExtrinsicFailed")
+			}
+			result.Error = &dispatchErr
+		}
+	}
+
+	return &result, nil
+}
+
+// CallResult is the outcome of a single call in a Utility.batch or Utility.batch_all extrinsic
+type CallResult struct {
+	// Whether the call was executed and its changes were kept
+	Success bool
+	// Set if the call itself failed. Calls which were reverted or skipped because of
+	// another call's failure have no error.
+	Error *DispatchError
+}
+
+// CallResults maps the outcome of a batch extrinsic with the given number of calls back to
+// each call. Utility.batch stops at the first failing call and emits BatchInterrupted.
+// Utility.batch_all reverts all calls instead, and the index of the failing call is only
+// known if the batch consists of a single call.
+func (r *ExtrinsicResult) CallResults(count int) []CallResult {
+	results := make([]CallResult, count)
+
+	if !r.Success() {
+		if count == 1 {
+			results[0].Error = r.Error
+		}
+		return results
+	}
+
+	for _, event := range r.Events {
+		if event.Module != "Utility" || event.Name != "BatchInterrupted" || len(event.Args) != 2 {
+			continue
+		}
+
+		index, ok := event.Args[0].(types.U32)
+		if !ok {
+			continue
+		}
+		dispatchErr, ok := event.Args[1].(DispatchError)
+		if !ok {
+			continue
+		}
+
+		for i := 0; i < count && i < int(index); i++ {
+			results[i].Success = true
+		}
+		if int(index) < count {
+			results[index].Error = &dispatchErr
+		}
+		return results
+	}
+
+	for i := range results {
+		results[i].Success = true
+	}
+	return results
+}
+
+// MessageDispatch is the outcome of dispatching a message delivered by an inbound channel
+type MessageDispatch struct {
+	ID MessageId
+	// Whether the message was dispatched, regardless of the result of its call
+	Dispatched bool
+	// Set if the message's call failed
+	Error *DispatchError
+	// The message's origin isn't allowed to dispatch its call
+	Rejected bool
+	// The message's payload couldn't be decoded into a call
+	DecodeFailed bool
+}
+
+// MessageDispatches returns the outcomes of the messages dispatched by the extrinsic, in
+// the order of the calls which delivered them
+func (r *ExtrinsicResult) MessageDispatches() []MessageDispatch {
+	var dispatches []MessageDispatch
+
+	for _, event := range r.Events {
+		if event.Module != "Dispatch" || len(event.Args) == 0 {
+			continue
+		}
+
+		id, ok := event.Args[0].(MessageId)
+		if !ok {
+			continue
+		}
+		dispatch := MessageDispatch{ID: id}
+
+		switch event.Name {
+		case "MessageDispatched":
+			dispatch.Dispatched = true
+			if len(event.Args) < 2 {
+				continue
+			}
+			if result, ok := event.Args[1].(DispatchResult); ok && !result.Ok {
+				dispatch.Error = &result.Err
+			}
+		case "MessageRejected":
+			dispatch.Rejected = true
+		case "MessageDecodeFailed":
+			dispatch.DecodeFailed = true
+		default:
+			continue
+		}
+
+		dispatches = append(dispatches, dispatch)
+	}
+
+	return dispatches
+}
@@ -0,0 +1,114 @@
+package parachain_test
+
+import (
+	"testing"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeModuleError(index uint8, err uint8) *parachain.DispatchError {
+	return &parachain.DispatchError{
+		IsModule: true,
+		AsModule: parachain.ModuleError{Index: types.U8(index), Error: types.U8(err)},
+	}
+}
+
+func TestCallResults_Completed(t *testing.T) {
+	result := parachain.ExtrinsicResult{
+		Events: []parachain.EventRecord{
+			{Module: "Utility", Name: "BatchCompleted"},
+			{Module: "System", Name: "ExtrinsicSuccess"},
+		},
+	}
+
+	for _, call := range result.CallResults(3) {
+		assert.True(t, call.Success)
+		assert.Nil(t, call.Error)
+	}
+}
+
+func TestCallResults_Interrupted(t *testing.T) {
+	dispatchErr := makeModuleError(10, 1)
+	result := parachain.ExtrinsicResult{
+		Events: []parachain.EventRecord{
+			{Module: "Utility", Name: "BatchInterrupted", Args: []interface{}{types.U32(1), *dispatchErr}},
+			{Module: "System", Name: "ExtrinsicSuccess"},
+		},
+	}
+
+	calls := result.CallResults(3)
+	assert.True(t, calls[0].Success)
+	assert.False(t, calls[1].Success)
+	assert.Equal(t, dispatchErr, calls[1].Error)
+	assert.False(t, calls[2].Success)
+	assert.Nil(t, calls[2].Error)
+}
+
+func TestCallResults_Failed(t *testing.T) {
+	dispatchErr := makeModuleError(15, 7)
+	result := parachain.ExtrinsicResult{Error: dispatchErr}
+
+	calls := result.CallResults(2)
+	for _, call := range calls {
+		assert.False(t, call.Success)
+		assert.Nil(t, call.Error)
+	}
+
+	// The failing call is known if there's only one
+	calls = result.CallResults(1)
+	assert.Equal(t, dispatchErr, calls[0].Error)
+}
+
+func TestMessageDispatches(t *testing.T) {
+	dispatchErr := makeModuleError(64, 0)
+	result := parachain.ExtrinsicResult{
+		Events: []parachain.EventRecord{
+			{Module: "Dispatch", Name: "MessageDispatched", Args: []interface{}{
+				parachain.MessageId{ChannelID: 0, Nonce: 1}, parachain.DispatchResult{Ok: true},
+			}},
+			{Module: "Dispatch", Name: "MessageDispatched", Args: []interface{}{
+				parachain.MessageId{ChannelID: 1, Nonce: 7}, parachain.DispatchResult{Err: *dispatchErr},
+			}},
+			{Module: "ETH", Name: "Minted"},
+			{Module: "Dispatch", Name: "MessageRejected", Args: []interface{}{
+				parachain.MessageId{ChannelID: 0, Nonce: 2},
+			}},
+		},
+	}
+
+	dispatches := result.MessageDispatches()
+	assert.Len(t, dispatches, 3)
+
+	assert.True(t, dispatches[0].Dispatched)
+	assert.Nil(t, dispatches[0].Error)
+	assert.Equal(t, types.U64(1), dispatches[0].ID.Nonce)
+
+	assert.True(t, dispatches[1].Dispatched)
+	assert.Equal(t, dispatchErr, dispatches[1].Error)
+	assert.Equal(t, types.U8(1), dispatches[1].ID.ChannelID)
+
+	assert.True(t, dispatches[2].Rejected)
+}
+
+func TestModuleError_ResolveNames(t *testing.T) {
+	verifier := makeTestEventModule("VerifierLightclient", 15)
+	verifier.Errors = []types.ErrorMetadataV8{{Name: "AncientHeader"}, {Name: "MissingHeader"}}
+	meta := &types.Metadata{
+		IsMetadataV12: true,
+		AsMetadataV12: types.MetadataV12{Modules: []types.ModuleMetadataV12{verifier}},
+	}
+
+	dispatchErr := makeModuleError(15, 1)
+	assert.Equal(t, "Module { index: 15, error: 1 }", dispatchErr.Error())
+
+	err := dispatchErr.AsModule.ResolveNames(meta)
+	assert.Nil(t, err)
+	assert.Equal(t, "VerifierLightclient.MissingHeader", dispatchErr.Error())
+
+	err = makeModuleError(15, 2).AsModule.ResolveNames(meta)
+	assert.Error(t, err)
+	err = makeModuleError(16, 0).AsModule.ResolveNames(meta)
+	assert.Error(t, err)
+}
@@ -3,72 +3,57 @@ package parachain
 import (
 	"fmt"
 
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
-	"github.com/wealdtech/go-merkletree"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
+	"github.com/snowfork/polkadot-ethereum/relayer/crypto/merkle"
 )
 
-func CreateParachainHeaderProof(allParaHeads []types.Bytes, ourParaHead types.Header, expectedRoot types.H256) (
-	[]byte, [][32]byte, error) {
-	ourParaHeadBytes, err := types.EncodeToBytes(ourParaHead)
-	if err != nil {
-		return nil, [][32]byte{}, err
-	}
+// ParachainHeaderProof proves the inclusion of a parachain's head in the parachain heads
+// root of an MMR leaf
+type ParachainHeaderProof struct {
+	Root  types.H256
+	Proof [][32]byte
+	Pos   int
+	Width int
+}
 
-	paraTreeData := make([][]byte, len(allParaHeads))
-	for i, paraHead := range allParaHeads {
-		paraTreeData[i] = paraHead
+// CreateParachainHeaderProof builds the proof for the head of ourParaID among allParaHeads, which
+// must be sorted by parachain ID. It fails unless the heads hash to expectedRoot, which is taken
+// from the MMR leaf the proof is relayed with.
+func CreateParachainHeaderProof(allParaHeads []relaychain.ParaHead, ourParaID uint32, expectedRoot types.H256) (
+	ParachainHeaderProof, error) {
+	pos := -1
+	for i, head := range allParaHeads {
+		if head.ParaID == ourParaID {
+			pos = i
+			break
+		}
 	}
-
-	// Create the tree
-	paraMerkleTree, err := merkletree.NewUsing(paraTreeData, &Keccak256{}, nil)
-	if err != nil {
-		return nil, [][32]byte{}, err
+	if pos == -1 {
+		return ParachainHeaderProof{}, fmt.Errorf("no head for parachain %d", ourParaID)
 	}
 
-	// Generate Merkle Proof for our parachain's head
-	proof, err := paraMerkleTree.GenerateProof(ourParaHeadBytes)
+	leaves, err := relaychain.ParaHeadsLeaves(allParaHeads)
 	if err != nil {
-		return nil, [][32]byte{}, err
+		return ParachainHeaderProof{}, err
 	}
-	root := paraMerkleTree.Root()
 
-	// Check the root matches our expected root  - TODO, uncomment this once beefy is fixed
-	// for i, v := range expectedRoot[0:32] {
-	// 	if v != root[i] {
-	// 		return [][32]byte{}, fmt.Errorf("Merkle tree created does not match the expected root")
-	// 	}
-	// }
-
-	// Verify the proof
-	verified, err := merkletree.VerifyProofUsing(ourParaHeadBytes, proof, root, &Keccak256{}, nil)
-	if err != nil {
-		return nil, [][32]byte{}, err
-	}
-	if !verified {
-		return nil, [][32]byte{}, fmt.Errorf("failed to verify proof")
+	root := types.H256(merkle.Root(leaves))
+	if root != expectedRoot {
+		return ParachainHeaderProof{}, fmt.Errorf(
+			"parachain heads root %s does not match root %s in MMR leaf", root.Hex(), expectedRoot.Hex())
 	}
 
-	proofContents := make([][32]byte, len(proof.Hashes))
-	for i, hash := range proof.Hashes {
-		var hash32Byte [32]byte
-		copy(hash32Byte[:], hash)
-		proofContents[i] = hash32Byte
+	proof, err := merkle.Proof(leaves, pos)
+	if err != nil {
+		return ParachainHeaderProof{}, err
 	}
 
-	return root, proofContents, nil
-}
-
-// Keccak256 is the Keccak256 hashing method
-type Keccak256 struct{}
-
-// New creates a new Keccak256 hashing method
-func New() *Keccak256 {
-	return &Keccak256{}
-}
-
-// Hash generates a Keccak256 hash from a byte array
-func (h *Keccak256) Hash(data []byte) []byte {
-	hash := crypto.Keccak256(data)
-	return hash[:]
+	return ParachainHeaderProof{
+		Root:  root,
+		Proof: proof,
+		Pos:   pos,
+		Width: len(leaves),
+	}, nil
 }
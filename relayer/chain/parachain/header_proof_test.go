@@ -0,0 +1,152 @@
+package parachain_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
+	"github.com/snowfork/polkadot-ethereum/relayer/crypto/merkle"
+)
+
+func TestParaHeadsLeaves(t *testing.T) {
+	leaves, err := relaychain.ParaHeadsLeaves([]relaychain.ParaHead{{ParaID: 200, Data: types.Bytes{1, 2}}})
+	assert.Nil(t, err)
+	// (ParaId, HeadData) as SCALE: u32 little endian, then compact length prefixed bytes
+	assert.Equal(t, [][]byte{common.FromHex("0xc8000000080102")}, leaves)
+}
+
+func TestCreateParachainHeaderProof(t *testing.T) {
+	heads := []relaychain.ParaHead{
+		{ParaID: 100, Data: types.Bytes{1}},
+		{ParaID: 200, Data: types.Bytes{2, 2}},
+		{ParaID: 201, Data: types.Bytes{3, 3, 3}},
+	}
+	leafHashes := [][]byte{
+		crypto.Keccak256(common.FromHex("0x640000000401")),
+		crypto.Keccak256(common.FromHex("0xc8000000080202")),
+		crypto.Keccak256(common.FromHex("0xc90000000c030303")),
+	}
+	// The third leaf is promoted to the second level
+	expectedRoot := types.NewH256(crypto.Keccak256(crypto.Keccak256(leafHashes[0], leafHashes[1]), leafHashes[2]))
+
+	root, err := relaychain.ParaHeadsRoot(heads)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedRoot, root)
+
+	proof, err := parachain.CreateParachainHeaderProof(heads, 200, expectedRoot)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedRoot, proof.Root)
+	assert.Equal(t, 1, proof.Pos)
+	assert.Equal(t, 3, proof.Width)
+
+	var leafHash [32]byte
+	copy(leafHash[:], leafHashes[1])
+	computed, err := merkle.ComputeRoot(leafHash, proof.Pos, proof.Width, proof.Proof)
+	assert.Nil(t, err)
+	assert.Equal(t, [32]byte(expectedRoot), computed)
+
+	// Heads out of order, or a root from another leaf, are rejected before anything is relayed
+	_, err = parachain.CreateParachainHeaderProof([]relaychain.ParaHead{heads[1], heads[0], heads[2]}, 200, expectedRoot)
+	assert.Error(t, err)
+	_, err = parachain.CreateParachainHeaderProof(heads, 200, types.NewH256([]byte{1}))
+	assert.Error(t, err)
+
+	_, err = parachain.CreateParachainHeaderProof(heads, 300, expectedRoot)
+	assert.Error(t, err)
+}
+
+// Relay chain state shared with the relaychain tests, see paraHeadsFixture there
+type paraHeadsFixture struct {
+	Storage            map[string]string `json:"storage"`
+	OwnParaID          uint32            `json:"ownParaId"`
+	OwnHeadPos         int               `json:"ownHeadPos"`
+	OwnHeadProof       []common.Hash     `json:"ownHeadProof"`
+	ParachainHeadsRoot common.Hash       `json:"parachainHeadsRoot"`
+}
+
+const (
+	// twox128("Paras") ++ twox128("Heads")
+	parasHeadsPrefix = "0xcd710b30bd2eab0352ddcc26417aa1941b3c252fcb29d88eff4f3de5de4476c3"
+	// twox128("Paras") ++ twox128("Parachains")
+	parasParachainsKey = "0xcd710b30bd2eab0352ddcc26417aa1940b76934f4cc08dee01012d059e1b83ee"
+)
+
+// readParaHeadsFixture returns the fixture with the heads of its parachains, sorted by
+// parachain ID. Paras.Heads is a Twox64Concat map, so IDs end its keys.
+func readParaHeadsFixture(t *testing.T) (paraHeadsFixture, []relaychain.ParaHead) {
+	rawData, err := ioutil.ReadFile(filepath.Join("..", "relaychain", "testdata", "paras-heads-fixture.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture paraHeadsFixture
+	err = json.Unmarshal(rawData, &fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parachains []types.U32
+	err = types.DecodeFromHexString(fixture.Storage[parasParachainsKey], &parachains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isParachain := make(map[uint32]bool)
+	for _, id := range parachains {
+		isParachain[uint32(id)] = true
+	}
+
+	var heads []relaychain.ParaHead
+	for key, value := range fixture.Storage {
+		if !strings.HasPrefix(key, parasHeadsPrefix) {
+			continue
+		}
+		encodedKey := common.FromHex(key)
+		id := binary.LittleEndian.Uint32(encodedKey[len(encodedKey)-4:])
+		if !isParachain[id] {
+			continue
+		}
+
+		var data types.Bytes
+		err := types.DecodeFromHexString(value, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		heads = append(heads, relaychain.ParaHead{ParaID: id, Data: data})
+	}
+	relaychain.SortParaHeads(heads)
+	return fixture, heads
+}
+
+func TestCreateParachainHeaderProof_Fixture(t *testing.T) {
+	fixture, heads := readParaHeadsFixture(t)
+	assert.Len(t, heads, 5)
+	expectedRoot := types.H256(fixture.ParachainHeadsRoot)
+
+	proof, err := parachain.CreateParachainHeaderProof(heads, fixture.OwnParaID, expectedRoot)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedRoot, proof.Root)
+	assert.Equal(t, fixture.OwnHeadPos, proof.Pos)
+	assert.Equal(t, len(heads), proof.Width)
+	if assert.Len(t, proof.Proof, len(fixture.OwnHeadProof)) {
+		for i, item := range fixture.OwnHeadProof {
+			assert.Equal(t, [32]byte(item), proof.Proof[i])
+		}
+	}
+
+	leaves, err := relaychain.ParaHeadsLeaves(heads)
+	assert.Nil(t, err)
+	var leafHash [32]byte
+	copy(leafHash[:], crypto.Keccak256(leaves[proof.Pos]))
+	computed, err := merkle.ComputeRoot(leafHash, proof.Pos, proof.Width, proof.Proof)
+	assert.Nil(t, err)
+	assert.Equal(t, [32]byte(expectedRoot), computed)
+}
@@ -9,20 +9,28 @@ import (
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
 )
 
-// Must be a power of two between 4 and 65536 (inclusive)
+// Default period. Must be a power of two between 4 and 65536 (inclusive)
 const MortalEraPeriod = uint64(64)
 
 func NewMortalEra(currentBlockNumber uint64) types.ExtrinsicEra {
+	return NewMortalEraWithPeriod(currentBlockNumber, MortalEraPeriod)
+}
+
+func IsValidMortalEraPeriod(period uint64) bool {
+	return period >= 4 && period <= 65536 && period&(period-1) == 0
+}
+
+func NewMortalEraWithPeriod(currentBlockNumber uint64, period uint64) types.ExtrinsicEra {
 	// Adapted from https://substrate.dev/rustdocs/v2.0.1/src/sp_runtime/generic/era.rs.html#66
-	phase := currentBlockNumber % MortalEraPeriod
+	phase := currentBlockNumber % period
 
-	quantizeFactor := MortalEraPeriod >> 12
+	quantizeFactor := period >> 12
 	if quantizeFactor < 1 {
 		quantizeFactor = 1
 	}
 	quantizedPhase := phase / quantizeFactor * quantizeFactor
 
-	encoded := uint16(math.Log2(float64(MortalEraPeriod))-1) | uint16((quantizedPhase/quantizeFactor)<<4)
+	encoded := uint16(math.Log2(float64(period))-1) | uint16((quantizedPhase/quantizeFactor)<<4)
 
 	return types.ExtrinsicEra{
 		IsMortalEra: true,
@@ -23,3 +23,19 @@ func TestMortalEra(t *testing.T) {
 	assert.Equal(t, era.AsMortalEra.First, byte(5))
 	assert.Equal(t, era.AsMortalEra.Second, byte(0))
 }
+
+func TestMortalEraWithPeriod(t *testing.T) {
+	era := parachain.NewMortalEraWithPeriod(1, 64)
+	assert.Equal(t, parachain.NewMortalEra(1), era)
+
+	// Period 4096 with phase 100
+	era = parachain.NewMortalEraWithPeriod(4196, 4096)
+	assert.Equal(t, era.AsMortalEra.First, byte(75))
+	assert.Equal(t, era.AsMortalEra.Second, byte(6))
+
+	assert.True(t, parachain.IsValidMortalEraPeriod(4))
+	assert.True(t, parachain.IsValidMortalEraPeriod(65536))
+	assert.False(t, parachain.IsValidMortalEraPeriod(2))
+	assert.False(t, parachain.IsValidMortalEraPeriod(100))
+	assert.False(t, parachain.IsValidMortalEraPeriod(131072))
+}
@@ -35,7 +35,11 @@ func NewExtrinsicPool(eg *errgroup.Group, conn *Connection, log *logrus.Entry) *
 	return &ep
 }
 
-func (ep *ExtrinsicPool) WaitForSubmitAndWatch(ctx context.Context, nonce uint32, ext *types.Extrinsic, onProcessed func() error) {
+// WaitForSubmitAndWatch submits an extrinsic once a watch slot is free. onProcessed is
+// called with the final status of the extrinsic, which holds the hash of the block it
+// was included in, if any. It's called with nil if the subscription to the extrinsic's
+// status ended before it was processed, e.g. because the connection was re-established.
+func (ep *ExtrinsicPool) WaitForSubmitAndWatch(ctx context.Context, nonce uint32, ext *types.Extrinsic, onProcessed func(status *types.ExtrinsicStatus) error) {
 	select {
 	case ep.watched <- struct{}{}:
 		ep.eg.Go(func() error {
@@ -45,8 +49,13 @@ func (ep *ExtrinsicPool) WaitForSubmitAndWatch(ctx context.Context, nonce uint32
 	}
 }
 
-func (ep *ExtrinsicPool) submitAndWatchLoop(ctx context.Context, nonce uint32, ext *types.Extrinsic, onProcessed func() error) error {
-	sub, err := ep.conn.api.RPC.Author.SubmitAndWatchExtrinsic(*ext)
+// Pending returns the number of submitted extrinsics which haven't been processed yet
+func (ep *ExtrinsicPool) Pending() int {
+	return len(ep.watched)
+}
+
+func (ep *ExtrinsicPool) submitAndWatchLoop(ctx context.Context, nonce uint32, ext *types.Extrinsic, onProcessed func(status *types.ExtrinsicStatus) error) error {
+	sub, err := ep.conn.GetAPI().RPC.Author.SubmitAndWatchExtrinsic(*ext)
 	if err != nil {
 		return err
 	}
@@ -87,7 +96,7 @@ func (ep *ExtrinsicPool) submitAndWatchLoop(ctx context.Context, nonce uint32, e
 					"nonce":  nonce,
 					"status": statusStr,
 				}).Debug("Re-submitting failed extrinsic")
-				newSub, err := ep.conn.api.RPC.Author.SubmitAndWatchExtrinsic(*ext)
+				newSub, err := ep.conn.GetAPI().RPC.Author.SubmitAndWatchExtrinsic(*ext)
 				if err != nil {
 					return err
 				}
@@ -100,16 +109,26 @@ func (ep *ExtrinsicPool) submitAndWatchLoop(ctx context.Context, nonce uint32, e
 				// https://github.com/paritytech/substrate/blob/29aca981db5e8bf8b5538e6c7920ded917013ef3/primitives/transaction-pool/src/pool.rs#L56-L127
 				sub.Unsubscribe()
 				ep.Lock()
-				defer ep.Unlock()
 				if nonce > ep.maxNonce {
 					ep.maxNonce = nonce
 				}
 				<-ep.watched
-				return onProcessed()
+				ep.Unlock()
+				// Callbacks query the chain and may wait to retry, so other watchers aren't held up
+				return onProcessed(&status)
 			}
 
 		case err := <-sub.Err():
-			return err
+			// The subscription doesn't survive reconnecting, in which case the error channel
+			// is closed without an error
+			sub.Unsubscribe()
+			log := ep.log.WithField("nonce", nonce)
+			if err != nil {
+				log = log.WithError(err)
+			}
+			log.Warn("Subscription to extrinsic status ended. Its outcome is unknown")
+			<-ep.watched
+			return onProcessed(nil)
 		}
 	}
 }
@@ -0,0 +1,83 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package chain
+
+import (
+	"sync"
+)
+
+// Closer is a connection which can be shared through a Registry
+type Closer interface {
+	Close()
+}
+
+// Registry hands out connections shared by all workers of the process. Connections
+// are keyed by endpoint, and closed once every worker which acquired them released them.
+type Registry struct {
+	mu      sync.Mutex
+	entries map[string]*registryEntry
+}
+
+type registryEntry struct {
+	conn Closer
+	refs int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		entries: make(map[string]*registryEntry),
+	}
+}
+
+// Acquire returns the connection for key, opening it if it isn't in use yet. Each
+// successful call must be matched by a call to Release.
+func (r *Registry) Acquire(key string, open func() (Closer, error)) (Closer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[key]
+	if !ok {
+		conn, err := open()
+		if err != nil {
+			return nil, err
+		}
+		entry = &registryEntry{conn: conn}
+		r.entries[key] = entry
+	}
+
+	entry.refs++
+	return entry.conn, nil
+}
+
+// Release gives up a reference to the connection for key, closing it if it was the last
+func (r *Registry) Release(key string) {
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+
+	entry.refs--
+	if entry.refs > 0 {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.entries, key)
+	r.mu.Unlock()
+
+	entry.conn.Close()
+}
+
+// Refs returns the number of references to the connection for key
+func (r *Registry) Refs(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[key]
+	if !ok {
+		return 0
+	}
+	return entry.refs
+}
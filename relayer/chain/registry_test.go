@@ -0,0 +1,69 @@
+package chain_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain"
+)
+
+type testConn struct {
+	closed int
+}
+
+func (c *testConn) Close() {
+	c.closed++
+}
+
+func TestRegistry_SharesConnections(t *testing.T) {
+	registry := chain.NewRegistry()
+
+	opened := 0
+	open := func() (chain.Closer, error) {
+		opened++
+		return &testConn{}, nil
+	}
+
+	first, err := registry.Acquire("ws://a", open)
+	assert.Nil(t, err)
+	second, err := registry.Acquire("ws://a", open)
+	assert.Nil(t, err)
+	other, err := registry.Acquire("ws://b", open)
+	assert.Nil(t, err)
+
+	assert.Same(t, first, second)
+	assert.NotSame(t, first, other)
+	assert.Equal(t, 2, opened)
+	assert.Equal(t, 2, registry.Refs("ws://a"))
+
+	registry.Release("ws://a")
+	assert.Equal(t, 0, first.(*testConn).closed)
+
+	registry.Release("ws://a")
+	assert.Equal(t, 1, first.(*testConn).closed)
+	assert.Equal(t, 0, registry.Refs("ws://a"))
+
+	// Released connections aren't handed out again
+	third, err := registry.Acquire("ws://a", open)
+	assert.Nil(t, err)
+	assert.NotSame(t, first, third)
+	assert.Equal(t, 3, opened)
+}
+
+func TestRegistry_OpenFails(t *testing.T) {
+	registry := chain.NewRegistry()
+
+	_, err := registry.Acquire("ws://a", func() (chain.Closer, error) {
+		return nil, errors.New("connection refused")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, registry.Refs("ws://a"))
+
+	conn, err := registry.Acquire("ws://a", func() (chain.Closer, error) {
+		return &testConn{}, nil
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, conn)
+}
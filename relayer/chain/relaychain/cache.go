@@ -0,0 +1,48 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package relaychain
+
+import (
+	"sync"
+)
+
+// fifoCache keeps the most recently added values, evicting the oldest once full. It's meant
+// for data of finalized blocks, which never changes.
+type fifoCache struct {
+	mu     sync.Mutex
+	size   int
+	values map[interface{}]interface{}
+	// Keys in the order they were added, evicted first to last
+	keys []interface{}
+}
+
+func newFIFOCache(size int) *fifoCache {
+	return &fifoCache{
+		size:   size,
+		values: make(map[interface{}]interface{}, size),
+	}
+}
+
+func (c *fifoCache) get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.values[key]
+	return value, ok
+}
+
+func (c *fifoCache) add(key interface{}, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.values[key]; ok {
+		return
+	}
+	if len(c.keys) == c.size {
+		delete(c.values, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+	c.values[key] = value
+	c.keys = append(c.keys, key)
+}
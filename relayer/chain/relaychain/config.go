@@ -1,6 +1,8 @@
 package relaychain
 
 type Config struct {
-	Endpoint   string `mapstructure:"endpoint"`
-	PrivateKey string `mapstructure:"private-key"`
+	Endpoint string `mapstructure:"endpoint"`
+	// Endpoints to fail over to when Endpoint isn't reachable. Optional.
+	Endpoints  []string `mapstructure:"endpoints"`
+	PrivateKey string   `mapstructure:"private-key"`
 }
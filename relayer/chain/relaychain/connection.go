@@ -6,74 +6,91 @@ package relaychain
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 
 	gsrpc "github.com/snowfork/go-substrate-rpc-client/v3"
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/substrate"
 )
 
 type Connection struct {
-	endpoint    string
-	api         *gsrpc.SubstrateAPI
-	metadata    types.Metadata
-	genesisHash types.Hash
-	log         *logrus.Entry
+	endpoints []string
+	// Shared with the other workers connected to the same endpoints
+	client    *substrate.Client
+	closeOnce sync.Once
+	mmrProofs *mmrProofCache
+	// Parachain heads by relay chain block hash
+	paraHeads *fifoCache
+	log       *logrus.Entry
 }
 
-func NewConnection(endpoint string, log *logrus.Entry) *Connection {
+// NewConnection creates a connection to the node at endpoint. The connection fails over
+// to the fallback endpoints when the node isn't reachable. Connections to the same
+// endpoints share a websocket.
+func NewConnection(endpoint string, log *logrus.Entry, fallbacks ...string) *Connection {
 	return &Connection{
-		endpoint: endpoint,
-		log:      log,
+		endpoints: append([]string{endpoint}, fallbacks...),
+		mmrProofs: newMMRProofCache(MMRProofCacheSize),
+		paraHeads: newFIFOCache(ParaHeadsCacheSize),
+		log:       log,
 	}
 }
 
+// GetAPI returns the API of the current connection, which is replaced after reconnecting
 func (co *Connection) GetAPI() *gsrpc.SubstrateAPI {
-	return co.api
+	return co.client.API()
 }
 
 func (co *Connection) GetMetadata() *types.Metadata {
-	return &co.metadata
+	return co.client.Metadata()
 }
 
-func (co *Connection) Connect(_ context.Context) error {
-	// Initialize API
-	api, err := gsrpc.NewSubstrateAPI(co.endpoint)
-	if err != nil {
-		return err
-	}
-	co.api = api
-
-	// Fetch metadata
-	meta, err := api.RPC.State.GetMetadataLatest()
-	if err != nil {
-		return err
+func (co *Connection) Connect(ctx context.Context) error {
+	if co.client == nil {
+		co.client = substrate.AcquireClient(co.endpoints)
 	}
-	co.metadata = *meta
 
-	// Fetch genesis hash
-	genesisHash, err := api.RPC.Chain.GetBlockHash(0)
+	err := co.client.Connect(ctx)
 	if err != nil {
 		return err
 	}
-	co.genesisHash = genesisHash
 
 	co.log.WithFields(logrus.Fields{
-		"endpoint":    co.endpoint,
-		"metaVersion": meta.Version,
+		"endpoint":    co.client.Endpoint(),
+		"metaVersion": co.client.Metadata().Version,
 	}).Info("Connected to chain")
 
 	return nil
 }
 
+// Close gives up the shared connection, which is closed once no other worker uses it
 func (co *Connection) Close() {
-	// TODO: Fix design issue in GSRPC preventing on-demand closing of connections
+	if co.client == nil {
+		return
+	}
+	co.closeOnce.Do(co.client.Release)
 }
 
+// Subscribe creates a subscription which is restored after reconnecting
+func (co *Connection) Subscribe(namespace, subscribeMethod, unsubscribeMethod, notificationMethod string,
+	channel interface{}, args ...interface{}) (*substrate.Subscription, error) {
+	return co.client.Subscribe(namespace, subscribeMethod, unsubscribeMethod, notificationMethod, channel, args...)
+}
+
+// GetMMRLeafForBlock returns the MMR leaf added for the child of block blockNumber, with a proof
+// against the MMR root at blockHash. Proofs are cached, so they're only generated once for each
+// leaf and root.
 func (co *Connection) GetMMRLeafForBlock(
 	blockNumber uint64,
 	blockHash types.Hash,
 ) (types.GenerateMMRProofResponse, error) {
+	if proofResponse, ok := co.mmrProofs.get(blockNumber, blockHash); ok {
+		return proofResponse, nil
+	}
+
 	co.log.WithFields(logrus.Fields{
 		"blockNumber": blockNumber,
 		"blockHash":   blockHash.Hex(),
@@ -101,93 +118,131 @@ func (co *Connection) GetMMRLeafForBlock(
 		"Proof.LeafCount":                 proofResponse.Proof.LeafCount,
 		"Proof.Items":                     proofItemsHex,
 	}).Info("Generated MMR Proof")
+
+	co.mmrProofs.add(blockNumber, blockHash, proofResponse)
 	return proofResponse, nil
 }
 
+// GetAllParaheadsWithOwn returns the heads of all parachains at blockHash ordered by parachain ID,
+// which is the order in which they are committed to in the MMR leaf, together with the position
+// and decoded header of the given parachain. Heads are cached, and must not be modified.
 func (co *Connection) GetAllParaheadsWithOwn(blockHash types.Hash, ownParachainId uint32) (
-	[]types.Bytes, int, types.Header, error) {
-	none := types.NewOptionU32Empty()
-	encoded, err := types.EncodeToBytes(none)
+	[]ParaHead, int, types.Header, error) {
+	paraHeads, err := co.getParaHeads(blockHash)
 	if err != nil {
-		co.log.WithError(err).Error("Error")
 		return nil, 0, types.Header{}, err
 	}
 
-	baseParaHeadsStorageKey, err := types.CreateStorageKey(
-		co.GetMetadata(),
-		"Paras",
-		"Heads", encoded, nil)
+	ownParachainHeaderPos, ownParachainHeader, ok, err := ownParaHead(paraHeads, ownParachainId)
 	if err != nil {
-		co.log.WithError(err).Error("Failed to create parachain header storage key")
+		co.log.WithError(err).Error("Failed to decode Header")
 		return nil, 0, types.Header{}, err
 	}
+	if !ok {
+		return nil, 0, types.Header{}, fmt.Errorf("no head for parachain %d at relay chain block %s", ownParachainId, blockHash.Hex())
+	}
 
-	//TODO fix this manual slice.
-	// The above types.CreateStorageKey does not give the same base key as polkadotjs needs for getKeys.
-	// It has some extra bytes.
-	// maybe from the none u32 in golang being wrong, or maybe slightly off CreateStorageKey call? we slice it
-	// here as a hack.
-	actualBaseParaHeadsStorageKey := baseParaHeadsStorageKey[:32]
-	co.log.WithField("actualBaseParaHeadsStorageKey", actualBaseParaHeadsStorageKey.Hex()).Info("actualBaseParaHeadsStorageKey")
+	co.log.WithField("parachainId", ownParachainId).Info("Decoding header for own parachain")
+	co.log.WithFields(logrus.Fields{
+		"headerBytes":           fmt.Sprintf("%#x", paraHeads[ownParachainHeaderPos].Data),
+		"header.ParentHash":     ownParachainHeader.ParentHash.Hex(),
+		"header.Number":         ownParachainHeader.Number,
+		"header.StateRoot":      ownParachainHeader.StateRoot.Hex(),
+		"header.ExtrinsicsRoot": ownParachainHeader.ExtrinsicsRoot.Hex(),
+		"header.Digest":         ownParachainHeader.Digest,
+		"parachainId":           ownParachainId,
+	}).Info("Decoded header for parachain")
+
+	return paraHeads, ownParachainHeaderPos, ownParachainHeader, nil
+}
 
-	keysResponse, err := co.GetAPI().RPC.State.GetKeys(actualBaseParaHeadsStorageKey, blockHash)
+// getParaHeads returns the heads of all parachains at blockHash ordered by parachain ID. The state
+// of a block never changes, so heads are cached by block hash.
+func (co *Connection) getParaHeads(blockHash types.Hash) ([]ParaHead, error) {
+	if paraHeads, ok := co.paraHeads.get(blockHash); ok {
+		return paraHeads.([]ParaHead), nil
+	}
+
+	heads, err := NewStorageMap(co.GetMetadata(), "Paras", "Heads")
 	if err != nil {
-		co.log.WithError(err).Error("Failed to get all parachain keys")
-		return nil, 0, types.Header{}, err
+		co.log.WithError(err).Error("Failed to look up parachain heads in metadata")
+		return nil, err
 	}
 
-	headersResponse, err := co.GetAPI().RPC.State.QueryStorage(keysResponse, blockHash, blockHash)
+	parachains, err := co.fetchParachainIDs(blockHash)
+	if err != nil {
+		co.log.WithError(err).Error("Failed to get parachain IDs")
+		return nil, err
+	}
+
+	entries, err := heads.Entries(co.GetAPI(), blockHash)
 	if err != nil {
 		co.log.WithError(err).Error("Failed to get all parachain headers")
-		return nil, 0, types.Header{}, err
+		return nil, err
 	}
 
-	co.log.Info("Got all parachain headers")
-	var headers []types.Bytes
-	var ownParachainHeaderPos int
-	for _, headerResponse := range headersResponse {
-		for index, change := range headerResponse.Changes {
-
-			// TODO fix this manual slice with a proper type decode. only the last few bytes are for the ParaId,
-			// not sure what the early ones are for.
-			key := change.StorageKey[40:]
-			var parachainID types.U32
-			if err := types.DecodeFromBytes(key, &parachainID); err != nil {
-				co.log.WithError(err).Error("Failed to decode parachain ID")
-				return nil, 0, types.Header{}, err
-			}
-
-			var headerBytes types.Bytes
-			if err := types.DecodeFromBytes(change.StorageData, &headerBytes); err != nil {
-				co.log.WithError(err).Error("Failed to decode MMREncodableOpaqueLeaf")
-				return nil, 0, types.Header{}, err
-			}
-			headers = append(headers, headerBytes)
-
-			if parachainID == types.U32(ownParachainId) {
-				ownParachainHeaderPos = index
-			}
+	co.log.WithField("blockHash", blockHash.Hex()).Debug("Got all parachain headers")
+	var paraHeads []ParaHead
+	for _, entry := range entries {
+		var paraID types.U32
+		err := heads.DecodeKey(entry.Key, &paraID)
+		if err != nil {
+			co.log.WithError(err).Error("Failed to decode parachain ID")
+			return nil, err
+		}
+		// Parathreads have heads too, but aren't committed to
+		if !parachains[uint32(paraID)] {
+			continue
+		}
 
+		paraHead := ParaHead{ParaID: uint32(paraID)}
+		err = types.DecodeFromBytes(entry.Value, &paraHead.Data)
+		if err != nil {
+			co.log.WithError(err).Error("Failed to decode parachain head")
+			return nil, err
 		}
+		paraHeads = append(paraHeads, paraHead)
 	}
-	var ownParachainHeader types.Header
-	if err := types.DecodeFromBytes(headers[ownParachainHeaderPos], &ownParachainHeader); err != nil {
-		co.log.WithError(err).Error("Failed to decode Header")
-		return nil, 0, types.Header{}, err
+	SortParaHeads(paraHeads)
+
+	co.paraHeads.add(blockHash, paraHeads)
+	return paraHeads, nil
+}
+
+// ownParaHead returns the position and decoded header of a parachain's head, if it has one
+func ownParaHead(paraHeads []ParaHead, paraID uint32) (int, types.Header, bool, error) {
+	for i, paraHead := range paraHeads {
+		if paraHead.ParaID != paraID {
+			continue
+		}
+		var header types.Header
+		err := types.DecodeFromBytes(paraHead.Data, &header)
+		if err != nil {
+			return 0, types.Header{}, false, err
+		}
+		return i, header, true, nil
 	}
+	return 0, types.Header{}, false, nil
+}
 
-	co.log.WithField("parachainId", ownParachainId).Info("Decoding header for own parachain")
-	co.log.WithFields(logrus.Fields{
-		"headerBytes":           fmt.Sprintf("%#x", headers[ownParachainHeaderPos]),
-		"header.ParentHash":     ownParachainHeader.ParentHash.Hex(),
-		"header.Number":         ownParachainHeader.Number,
-		"header.StateRoot":      ownParachainHeader.StateRoot.Hex(),
-		"header.ExtrinsicsRoot": ownParachainHeader.ExtrinsicsRoot.Hex(),
-		"header.Digest":         ownParachainHeader.Digest,
-		"parachainId":           ownParachainId,
-	}).Info("Decoded header for parachain")
+// fetchParachainIDs returns the IDs of the parachains registered at blockHash
+func (co *Connection) fetchParachainIDs(blockHash types.Hash) (map[uint32]bool, error) {
+	key, err := types.CreateStorageKey(co.GetMetadata(), "Paras", "Parachains", nil, nil)
+	if err != nil {
+		return nil, err
+	}
 
-	return headers, ownParachainHeaderPos, ownParachainHeader, nil
+	var ids []types.U32
+	_, err = co.GetAPI().RPC.State.GetStorage(key, &ids, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	parachains := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		parachains[uint32(id)] = true
+	}
+	return parachains, nil
 }
 
 // Fetch the latest block of a parachain that has been finalized at a relay chain block hash
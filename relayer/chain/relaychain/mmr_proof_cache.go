@@ -0,0 +1,39 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package relaychain
+
+import (
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+// MMRProofCacheSize is the number of generated MMR proofs kept by a connection
+const MMRProofCacheSize = 512
+
+type mmrProofKey struct {
+	leafIndex uint64
+	blockHash types.Hash
+}
+
+// mmrProofCache keeps the most recently generated MMR proofs. Proofs for the same leaf against
+// the same MMR root are requested for every commitment relayed with it, and for every bridge
+// parachain whose head is committed to by the leaf.
+type mmrProofCache struct {
+	cache *fifoCache
+}
+
+func newMMRProofCache(size int) *mmrProofCache {
+	return &mmrProofCache{cache: newFIFOCache(size)}
+}
+
+func (c *mmrProofCache) get(leafIndex uint64, blockHash types.Hash) (types.GenerateMMRProofResponse, bool) {
+	proof, ok := c.cache.get(mmrProofKey{leafIndex, blockHash})
+	if !ok {
+		return types.GenerateMMRProofResponse{}, false
+	}
+	return proof.(types.GenerateMMRProofResponse), true
+}
+
+func (c *mmrProofCache) add(leafIndex uint64, blockHash types.Hash, proof types.GenerateMMRProofResponse) {
+	c.cache.add(mmrProofKey{leafIndex, blockHash}, proof)
+}
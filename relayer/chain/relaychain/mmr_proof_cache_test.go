@@ -0,0 +1,98 @@
+package relaychain_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
+)
+
+// testMMR generates proofs for an MMR with one leaf for each block before the block a
+// proof is generated at
+type testMMR struct {
+	blockNumbers map[string]uint64
+	calls        int32
+}
+
+func (m *testMMR) GenerateProof(leafIndex uint64, blockHash string) (map[string]string, error) {
+	atomic.AddInt32(&m.calls, 1)
+
+	leaf, err := types.EncodeToBytes(types.MMRLeaf{
+		ParentNumberAndHash: types.ParentNumberAndHash{ParentNumber: types.U32(leafIndex)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encodedLeaf, err := types.EncodeToHexString(types.NewBytes(leaf))
+	if err != nil {
+		return nil, err
+	}
+	encodedProof, err := types.EncodeToHexString(types.MMRProof{
+		LeafIndex: types.U64(leafIndex),
+		LeafCount: types.U64(m.blockNumbers[blockHash]),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"blockHash": blockHash,
+		"leaf":      encodedLeaf,
+		"proof":     encodedProof,
+	}, nil
+}
+
+func TestGetMMRLeafForBlock(t *testing.T) {
+	encodedMetadata, err := types.EncodeToHexString(newTestMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash100 := types.NewHash([]byte{100})
+	hash200 := types.NewHash([]byte{200})
+	mmr := &testMMR{blockNumbers: map[string]uint64{hash100.Hex(): 100, hash200.Hex(): 200}}
+	endpoint := newTestRelayChain(t, map[string]interface{}{
+		"state": &testState{metadata: encodedMetadata},
+		"mmr":   mmr,
+	})
+
+	conn := relaychain.NewConnection(endpoint, logrus.WithField("test", "relaychain"))
+	err = conn.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		proof, err := conn.GetMMRLeafForBlock(42, hash100)
+		assert.Nil(t, err)
+		assert.Equal(t, types.U32(42), proof.Leaf.ParentNumberAndHash.ParentNumber)
+		assert.Equal(t, types.U64(42), proof.Proof.LeafIndex)
+		assert.Equal(t, types.U64(100), proof.Proof.LeafCount)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&mmr.calls))
+
+	// The same leaf against another MMR root
+	proof, err := conn.GetMMRLeafForBlock(42, hash200)
+	assert.Nil(t, err)
+	assert.Equal(t, types.U64(42), proof.Proof.LeafIndex)
+	assert.Equal(t, types.U64(200), proof.Proof.LeafCount)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&mmr.calls))
+
+	// Older proofs are evicted
+	for leafIndex := uint64(0); leafIndex < relaychain.MMRProofCacheSize; leafIndex++ {
+		_, err := conn.GetMMRLeafForBlock(leafIndex, hash200)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	calls := atomic.LoadInt32(&mmr.calls)
+	_, err = conn.GetMMRLeafForBlock(42, hash100)
+	assert.Nil(t, err)
+	assert.Equal(t, calls+1, atomic.LoadInt32(&mmr.calls))
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package relaychain
+
+import (
+	"sort"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/crypto/merkle"
+)
+
+// ParaHead is the head of a parachain, as stored in Paras.Heads
+type ParaHead struct {
+	ParaID uint32
+	// SCALE-encoded header of the parachain's latest block included on the relay chain
+	Data types.Bytes
+}
+
+// SortParaHeads orders heads by parachain ID, as the relay chain does before committing to them
+func SortParaHeads(heads []ParaHead) {
+	sort.Slice(heads, func(i, j int) bool {
+		return heads[i].ParaID < heads[j].ParaID
+	})
+}
+
+// ParaHeadsLeaves returns the SCALE-encoded (ParaId, HeadData) pairs of heads, which are
+// the leaves of the Merkle tree committed to in MMR leaves. Heads must be sorted by parachain ID.
+func ParaHeadsLeaves(heads []ParaHead) ([][]byte, error) {
+	leaves := make([][]byte, len(heads))
+	for i, head := range heads {
+		leaf, err := types.EncodeToBytes(head)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf
+	}
+	return leaves, nil
+}
+
+// ParaHeadsRoot returns the root of the Merkle tree over heads, which are sorted by parachain ID
+func ParaHeadsRoot(heads []ParaHead) (types.H256, error) {
+	leaves, err := ParaHeadsLeaves(heads)
+	if err != nil {
+		return types.H256{}, err
+	}
+	return types.H256(merkle.Root(leaves)), nil
+}
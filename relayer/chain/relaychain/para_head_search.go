@@ -0,0 +1,133 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package relaychain
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+// ParaHeadsCacheSize is the number of relay chain blocks whose parachain heads are kept by a
+// connection. It covers the blocks visited by many searches over a long relay chain history.
+const ParaHeadsCacheSize = 1024
+
+// IncludedParaHead is the head of a parachain at a relay chain block
+type IncludedParaHead struct {
+	BlockNumber uint64
+	BlockHash   types.Hash
+	// Heads of all parachains ordered by parachain ID, which must not be modified
+	ParaHeads []ParaHead
+	// Position of the parachain's head in ParaHeads
+	Pos    int
+	Header types.Header
+}
+
+// FindParaHead returns the latest relay chain block before the given one at which the head of a
+// parachain is paraBlockNumber. Parachain heads only move forward, so the search steps back from
+// the given block in doubling steps until the head is at most paraBlockNumber, and then binary
+// searches the last step. This takes O(log n) queries of parachain heads for a parachain block
+// included n blocks ago, and only reads the state of blocks back to about 2n blocks ago, which
+// nodes pruning their state still have for recent blocks.
+func (co *Connection) FindParaHead(paraID uint32, paraBlockNumber uint64, before uint64) (IncludedParaHead, error) {
+	notFound := fmt.Errorf("no relay chain block before %d has block %d of parachain %d as head",
+		before, paraBlockNumber, paraID)
+	if before == 0 {
+		return IncludedParaHead{}, notFound
+	}
+
+	// Most searches are for recent parachain blocks, which are often still the head
+	latest, ok, err := co.paraHeadAt(before-1, paraID)
+	if err != nil {
+		return IncludedParaHead{}, err
+	}
+	if !ok || uint64(latest.Header.Number) < paraBlockNumber {
+		return IncludedParaHead{}, notFound
+	}
+	if uint64(latest.Header.Number) == paraBlockNumber {
+		return latest, nil
+	}
+
+	// The head at hi is after paraBlockNumber, and the head at lo is at most paraBlockNumber.
+	// Blocks before the parachain was registered have no head.
+	hi := before - 1
+	queries := 1
+	var lo uint64
+	var head IncludedParaHead
+	var found bool
+	for step := uint64(1); ; step *= 2 {
+		if hi == 0 {
+			return IncludedParaHead{}, notFound
+		}
+		lo = 0
+		if step < hi {
+			lo = hi - step
+		}
+
+		head, found, err = co.paraHeadAt(lo, paraID)
+		if err != nil {
+			return IncludedParaHead{}, err
+		}
+		queries++
+		if !found || uint64(head.Header.Number) <= paraBlockNumber {
+			break
+		}
+		hi = lo
+	}
+
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		midHead, ok, err := co.paraHeadAt(mid, paraID)
+		if err != nil {
+			return IncludedParaHead{}, err
+		}
+		queries++
+		if !ok || uint64(midHead.Header.Number) <= paraBlockNumber {
+			lo, head, found = mid, midHead, ok
+		} else {
+			hi = mid
+		}
+	}
+
+	if !found || uint64(head.Header.Number) != paraBlockNumber {
+		return IncludedParaHead{}, notFound
+	}
+
+	co.log.WithFields(logrus.Fields{
+		"parachainId":           paraID,
+		"paraBlockNumber":       paraBlockNumber,
+		"relayChainBlockNumber": head.BlockNumber,
+		"queries":               queries,
+	}).Debug("Found relay chain block with parachain head")
+
+	return head, nil
+}
+
+// paraHeadAt returns the head of a parachain at a relay chain block, if it has one
+func (co *Connection) paraHeadAt(blockNumber uint64, paraID uint32) (IncludedParaHead, bool, error) {
+	blockHash, err := co.GetAPI().RPC.Chain.GetBlockHash(blockNumber)
+	if err != nil {
+		co.log.WithError(err).WithField("blockNumber", blockNumber).Error("Failed to get block hash")
+		return IncludedParaHead{}, false, err
+	}
+
+	paraHeads, err := co.getParaHeads(blockHash)
+	if err != nil {
+		return IncludedParaHead{}, false, err
+	}
+
+	pos, header, ok, err := ownParaHead(paraHeads, paraID)
+	if err != nil || !ok {
+		return IncludedParaHead{}, false, err
+	}
+
+	return IncludedParaHead{
+		BlockNumber: blockNumber,
+		BlockHash:   blockHash,
+		ParaHeads:   paraHeads,
+		Pos:         pos,
+		Header:      header,
+	}, true, nil
+}
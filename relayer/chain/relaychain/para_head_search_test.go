@@ -0,0 +1,242 @@
+package relaychain_test
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
+)
+
+func blockHashOf(number uint64) types.Hash {
+	var hash types.Hash
+	binary.BigEndian.PutUint64(hash[:], number)
+	return hash
+}
+
+func blockNumberOf(blockHash string) uint64 {
+	return binary.BigEndian.Uint64(types.MustHexDecodeString(blockHash))
+}
+
+type testHistoryChain struct{}
+
+func (testHistoryChain) GetBlockHash(number uint64) string {
+	return blockHashOf(number).Hex()
+}
+
+// testHistory serves the parachain heads of a relay chain at each of its blocks. Parachain 200 is
+// registered at block 10, after which its head moves forward every third block. The head of
+// parachain 300 moves forward every block. Like a pruning node, it has discarded the state of
+// blocks before prunedBefore.
+type testHistory struct {
+	metadata       string
+	heads          *relaychain.StorageMap
+	parachainsKey  string
+	headsKeys      map[uint32]string
+	headsRequested int32
+	prunedBefore   uint64
+}
+
+func newTestHistory(t *testing.T) *testHistory {
+	metadata := newTestMetadata()
+	encodedMetadata, err := types.EncodeToHexString(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heads, err := relaychain.NewStorageMap(metadata, "Paras", "Heads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parachainsKey, err := types.CreateStorageKey(metadata, "Paras", "Parachains", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := &testHistory{
+		metadata:      encodedMetadata,
+		heads:         heads,
+		parachainsKey: parachainsKey.Hex(),
+		headsKeys:     make(map[uint32]string),
+	}
+	for _, paraID := range []uint32{200, 300} {
+		encodedID, err := types.EncodeToBytes(paraID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := heads.Key(encodedID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		history.headsKeys[paraID] = key.Hex()
+	}
+	return history
+}
+
+// paraHeads returns the numbers of the parachain heads at a relay chain block
+func (h *testHistory) paraHeads(blockNumber uint64) map[uint32]uint64 {
+	heads := map[uint32]uint64{300: blockNumber}
+	if blockNumber >= 10 {
+		heads[200] = blockNumber / 3
+	}
+	return heads
+}
+
+func (h *testHistory) checkPruned(blockHash string) error {
+	blockNumber := blockNumberOf(blockHash)
+	if blockNumber < atomic.LoadUint64(&h.prunedBefore) {
+		return fmt.Errorf("State already discarded for block %d", blockNumber)
+	}
+	return nil
+}
+
+func (h *testHistory) GetMetadata() string {
+	return h.metadata
+}
+
+func (h *testHistory) GetKeysPaged(prefix string, _ uint32, startKey *string, blockHash string) ([]string, error) {
+	var keys []string
+	if err := h.checkPruned(blockHash); err != nil {
+		return nil, err
+	}
+	if startKey != nil {
+		return keys, nil
+	}
+	for paraID := range h.paraHeads(blockNumberOf(blockHash)) {
+		if strings.HasPrefix(h.headsKeys[paraID], prefix) {
+			keys = append(keys, h.headsKeys[paraID])
+		}
+	}
+	return keys, nil
+}
+
+func (h *testHistory) GetStorage(key string, blockHash string) (*string, error) {
+	if err := h.checkPruned(blockHash); err != nil {
+		return nil, err
+	}
+	if key != h.parachainsKey {
+		return nil, nil
+	}
+	var ids []types.U32
+	for paraID := range h.paraHeads(blockNumberOf(blockHash)) {
+		ids = append(ids, types.U32(paraID))
+	}
+	value, err := types.EncodeToHexString(ids)
+	if err != nil {
+		panic(err)
+	}
+	return &value, nil
+}
+
+func (h *testHistory) QueryStorage(keys []string, from string, _ string) ([]types.StorageChangeSet, error) {
+	atomic.AddInt32(&h.headsRequested, 1)
+	if err := h.checkPruned(from); err != nil {
+		return nil, err
+	}
+
+	heads := h.paraHeads(blockNumberOf(from))
+	changeSet := types.StorageChangeSet{Block: types.NewHash(types.MustHexDecodeString(from))}
+	for _, key := range keys {
+		for paraID, number := range heads {
+			if h.headsKeys[paraID] != key {
+				continue
+			}
+			header, err := types.EncodeToBytes(types.Header{Number: types.BlockNumber(number)})
+			if err != nil {
+				panic(err)
+			}
+			value, err := types.EncodeToBytes(types.NewBytes(header))
+			if err != nil {
+				panic(err)
+			}
+			changeSet.Changes = append(changeSet.Changes, types.KeyValueOption{
+				StorageKey:     types.MustHexDecodeString(key),
+				HasStorageData: true,
+				StorageData:    value,
+			})
+		}
+	}
+	return []types.StorageChangeSet{changeSet}, nil
+}
+
+func TestFindParaHead(t *testing.T) {
+	history := newTestHistory(t)
+	endpoint := newTestRelayChain(t, map[string]interface{}{
+		"state": history,
+		"chain": testHistoryChain{},
+	})
+
+	conn := relaychain.NewConnection(endpoint, logrus.WithField("test", "relaychain"))
+	err := conn.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Block 20 of parachain 200 is the head at relay chain blocks 60 to 62
+	head, err := conn.FindParaHead(200, 20, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(62), head.BlockNumber)
+	assert.Equal(t, blockHashOf(62), head.BlockHash)
+	assert.Equal(t, types.BlockNumber(20), head.Header.Number)
+	assert.Equal(t, 0, head.Pos)
+	if assert.Len(t, head.ParaHeads, 2) {
+		assert.Equal(t, uint32(200), head.ParaHeads[0].ParaID)
+		assert.Equal(t, uint32(300), head.ParaHeads[1].ParaID)
+	}
+	requested := atomic.LoadInt32(&history.headsRequested)
+	assert.LessOrEqual(t, requested, int32(12))
+
+	// Heads are cached
+	_, err = conn.FindParaHead(200, 20, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, requested, atomic.LoadInt32(&history.headsRequested))
+
+	// The head at the latest block is found with a single query
+	head, err = conn.FindParaHead(200, 40, 122)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(121), head.BlockNumber)
+	assert.Equal(t, requested+1, atomic.LoadInt32(&history.headsRequested))
+
+	// The first head of the parachain
+	head, err = conn.FindParaHead(200, 3, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(11), head.BlockNumber)
+
+	// Before the parachain was registered
+	_, err = conn.FindParaHead(200, 2, 100)
+	assert.Error(t, err)
+
+	// Not included by the given block yet
+	_, err = conn.FindParaHead(200, 34, 100)
+	assert.Error(t, err)
+
+	_, err = conn.FindParaHead(200, 20, 0)
+	assert.Error(t, err)
+
+	// Heads included long ago take logarithmically many queries
+	requested = atomic.LoadInt32(&history.headsRequested)
+	head, err = conn.FindParaHead(300, 123456, 1<<20)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(123456), head.BlockNumber)
+	assert.Equal(t, 1, head.Pos)
+	assert.LessOrEqual(t, atomic.LoadInt32(&history.headsRequested)-requested, int32(40))
+
+	// Recently included heads are found without reading the state of old blocks
+	atomic.StoreUint64(&history.prunedBefore, (1<<20)-256)
+	requested = atomic.LoadInt32(&history.headsRequested)
+	head, err = conn.FindParaHead(300, (1<<20)-100, 1<<20)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64((1<<20)-100), head.BlockNumber)
+	assert.LessOrEqual(t, atomic.LoadInt32(&history.headsRequested)-requested, int32(16))
+
+	// Heads included before the state was discarded can't be found
+	_, err = conn.FindParaHead(300, 1000, 1<<20)
+	assert.Error(t, err)
+}
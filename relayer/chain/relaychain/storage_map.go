@@ -0,0 +1,203 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package relaychain
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	gsrpc "github.com/snowfork/go-substrate-rpc-client/v3"
+	"github.com/snowfork/go-substrate-rpc-client/v3/scale"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/snowfork/go-substrate-rpc-client/v3/xxhash"
+)
+
+// StorageKeysPageSize is the number of keys fetched with each call to state_getKeysPaged
+const StorageKeysPageSize = 256
+
+// StorageMap is a map in the storage of a runtime module. Its keys are hashed with
+// the hashers declared in the metadata, one for each key of (double or N-) maps.
+type StorageMap struct {
+	module   string
+	name     string
+	hashers  []types.StorageHasherV10
+	prefix   types.StorageKey
+	pageSize uint32
+}
+
+// StorageMapEntry is a key of a storage map, together with its SCALE-encoded value
+type StorageMapEntry struct {
+	Key   types.StorageKey
+	Value types.StorageDataRaw
+}
+
+// NewStorageMap looks up the map name of module in the metadata
+func NewStorageMap(meta *types.Metadata, module, name string) (*StorageMap, error) {
+	entry, err := meta.FindStorageEntryMetadata(module, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var hashers []types.StorageHasherV10
+	switch e := entry.(type) {
+	case types.StorageFunctionMetadataV10:
+		switch {
+		case e.Type.IsMap:
+			hashers = []types.StorageHasherV10{e.Type.AsMap.Hasher}
+		case e.Type.IsDoubleMap:
+			hashers = []types.StorageHasherV10{e.Type.AsDoubleMap.Hasher, e.Type.AsDoubleMap.Key2Hasher}
+		}
+	case types.StorageFunctionMetadataV13:
+		switch {
+		case e.Type.IsMap:
+			hashers = []types.StorageHasherV10{e.Type.AsMap.Hasher}
+		case e.Type.IsDoubleMap:
+			hashers = []types.StorageHasherV10{e.Type.AsDoubleMap.Hasher, e.Type.AsDoubleMap.Key2Hasher}
+		case e.Type.IsNMap:
+			hashers = e.Type.AsNMap.Hashers
+		}
+	default:
+		return nil, fmt.Errorf("storage %s.%s: unsupported metadata version", module, name)
+	}
+	if len(hashers) == 0 {
+		return nil, fmt.Errorf("storage %s.%s is not a map", module, name)
+	}
+
+	prefix := append(xxhash.New128([]byte(module)).Sum(nil), xxhash.New128([]byte(name)).Sum(nil)...)
+
+	return &StorageMap{
+		module:   module,
+		name:     name,
+		hashers:  hashers,
+		prefix:   prefix,
+		pageSize: StorageKeysPageSize,
+	}, nil
+}
+
+// Prefix returns the prefix shared by the keys of all entries in the map
+func (m *StorageMap) Prefix() types.StorageKey {
+	return m.prefix
+}
+
+// Key returns the storage key of the entry with the given SCALE-encoded keys
+func (m *StorageMap) Key(keys ...[]byte) (types.StorageKey, error) {
+	if len(keys) != len(m.hashers) {
+		return nil, fmt.Errorf("storage %s.%s: expected %d keys, got %d", m.module, m.name, len(m.hashers), len(keys))
+	}
+
+	key := append(types.StorageKey{}, m.prefix...)
+	for i, hasher := range m.hashers {
+		hashFunc, err := hasher.HashFunc()
+		if err != nil {
+			return nil, err
+		}
+		_, err = hashFunc.Write(keys[i])
+		if err != nil {
+			return nil, err
+		}
+		key = append(key, hashFunc.Sum(nil)...)
+	}
+	return key, nil
+}
+
+// DecodeKey decodes the keys of an entry from its storage key into targets. This is only
+// possible for maps using transparent hashers, such as Twox64Concat or Blake2_128Concat.
+func (m *StorageMap) DecodeKey(key types.StorageKey, targets ...interface{}) error {
+	if len(targets) != len(m.hashers) {
+		return fmt.Errorf("storage %s.%s: expected %d targets, got %d", m.module, m.name, len(m.hashers), len(targets))
+	}
+	if !bytes.HasPrefix(key, m.prefix) {
+		return fmt.Errorf("storage %s.%s: key %s is not in this map", m.module, m.name, key.Hex())
+	}
+
+	reader := bytes.NewReader(key[len(m.prefix):])
+	decoder := scale.NewDecoder(reader)
+	for i, hasher := range m.hashers {
+		var hashLen int
+		switch {
+		case hasher.IsTwox64Concat:
+			hashLen = 8
+		case hasher.IsBlake2_128Concat:
+			hashLen = 16
+		case hasher.IsIdentity:
+			hashLen = 0
+		default:
+			return fmt.Errorf("storage %s.%s: key %d is hashed with an opaque hasher", m.module, m.name, i)
+		}
+
+		_, err := reader.Seek(int64(hashLen), io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		err = decoder.Decode(targets[i])
+		if err != nil {
+			return fmt.Errorf("storage %s.%s: decode key %d: %w", m.module, m.name, i, err)
+		}
+	}
+	if reader.Len() != 0 {
+		return fmt.Errorf("storage %s.%s: %d trailing bytes in key %s", m.module, m.name, reader.Len(), key.Hex())
+	}
+	return nil
+}
+
+// Keys returns the storage keys of all entries in the map at blockHash, paging through
+// them with state_getKeysPaged
+func (m *StorageMap) Keys(api *gsrpc.SubstrateAPI, blockHash types.Hash) ([]types.StorageKey, error) {
+	var keys []types.StorageKey
+	var startKey interface{}
+	for {
+		var page []string
+		err := api.Client.Call(&page, "state_getKeysPaged", m.prefix.Hex(), m.pageSize, startKey, blockHash.Hex())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hex := range page {
+			key, err := types.HexDecodeString(hex)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+
+		if uint32(len(page)) < m.pageSize {
+			return keys, nil
+		}
+		startKey = page[len(page)-1]
+	}
+}
+
+// Entries returns all entries in the map at blockHash. Values are queried one page of keys at a time.
+func (m *StorageMap) Entries(api *gsrpc.SubstrateAPI, blockHash types.Hash) ([]StorageMapEntry, error) {
+	keys, err := m.Keys(api, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []StorageMapEntry
+	for start := 0; start < len(keys); start += int(m.pageSize) {
+		end := start + int(m.pageSize)
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		changeSets, err := api.RPC.State.QueryStorage(keys[start:end], blockHash, blockHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, changeSet := range changeSets {
+			for _, change := range changeSet.Changes {
+				if !change.HasStorageData {
+					continue
+				}
+				entries = append(entries, StorageMapEntry{
+					Key:   change.StorageKey,
+					Value: change.StorageData,
+				})
+			}
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,352 @@
+package relaychain_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	gethrpc "github.com/snowfork/go-substrate-rpc-client/v3/gethrpc"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
+)
+
+// Metadata of a relay chain storing parachain heads like Polkadot does
+func newTestMetadata() *types.Metadata {
+	heads := types.StorageFunctionMetadataV13{
+		Name:     "Heads",
+		Modifier: types.StorageFunctionModifierV0{IsOptional: true},
+		Type: types.StorageFunctionTypeV13{
+			IsMap: true,
+			AsMap: types.MapTypeV10{
+				Hasher: types.StorageHasherV10{IsTwox64Concat: true},
+				Key:    "ParaId",
+				Value:  "HeadData",
+			},
+		},
+	}
+	code := types.StorageFunctionMetadataV13{
+		Name:     "CurrentCode",
+		Modifier: types.StorageFunctionModifierV0{IsOptional: true},
+		Type: types.StorageFunctionTypeV13{
+			IsMap: true,
+			AsMap: types.MapTypeV10{
+				Hasher: types.StorageHasherV10{IsBlake2_256: true},
+				Key:    "ParaId",
+				Value:  "ValidationCode",
+			},
+		},
+	}
+	parachains := types.StorageFunctionMetadataV13{
+		Name:     "Parachains",
+		Modifier: types.StorageFunctionModifierV0{IsDefault: true},
+		Type: types.StorageFunctionTypeV13{
+			IsType: true,
+			AsType: "Vec<ParaId>",
+		},
+	}
+	return &types.Metadata{
+		MagicNumber:   types.MagicNumber,
+		Version:       13,
+		IsMetadataV13: true,
+		AsMetadataV13: types.MetadataV13{
+			Modules: []types.ModuleMetadataV13{
+				{
+					Name:       "Paras",
+					HasStorage: true,
+					Storage: types.StorageMetadataV13{
+						Prefix: "Paras",
+						Items:  []types.StorageFunctionMetadataV13{heads, code, parachains},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestStorageMap_Key(t *testing.T) {
+	heads, err := relaychain.NewStorageMap(newTestMetadata(), "Paras", "Heads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedID, err := types.EncodeToBytes(types.U32(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := heads.Key(encodedID)
+	assert.Nil(t, err)
+	// twox128("Paras") ++ twox128("Heads") ++ twox64(200) ++ 200
+	assert.Equal(t, "0xcd710b30bd2eab0352ddcc26417aa1941b3c252fcb29d88eff4f3de5de4476c30a31c34bd88c539ec8000000", key.Hex())
+	assert.True(t, bytes.HasPrefix(key, heads.Prefix()))
+
+	var paraID types.U32
+	err = heads.DecodeKey(key, &paraID)
+	assert.Nil(t, err)
+	assert.Equal(t, types.U32(200), paraID)
+
+	// Trailing bytes don't belong to a U32
+	err = heads.DecodeKey(append(key, 0), &paraID)
+	assert.Error(t, err)
+
+	code, err := relaychain.NewStorageMap(newTestMetadata(), "Paras", "CurrentCode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = code.Key(encodedID)
+	assert.Nil(t, err)
+	err = code.DecodeKey(key, &paraID)
+	assert.Error(t, err, "keys hashed with Blake2_256 can't be decoded")
+
+	_, err = relaychain.NewStorageMap(newTestMetadata(), "Paras", "Parachains")
+	assert.Error(t, err, "Paras.Parachains isn't a map")
+}
+
+// testState serves the storage of a relay chain from a map of hex-encoded keys and values
+type testState struct {
+	metadata string
+	storage  map[string]string
+	pages    int32
+}
+
+func (s *testState) GetMetadata() string {
+	return s.metadata
+}
+
+// GetKeysPaged returns keys in lexicographic order, like substrate nodes do
+func (s *testState) GetKeysPaged(prefix string, count uint32, startKey *string, _ string) []string {
+	atomic.AddInt32(&s.pages, 1)
+
+	var keys []string
+	for key := range s.storage {
+		if strings.HasPrefix(key, prefix) && (startKey == nil || key > *startKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if uint32(len(keys)) > count {
+		keys = keys[:count]
+	}
+	return keys
+}
+
+func (s *testState) GetStorage(key string, _ string) *string {
+	value, ok := s.storage[key]
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func (s *testState) QueryStorage(keys []string, from string, _ string) []types.StorageChangeSet {
+	changeSet := types.StorageChangeSet{Block: types.NewHash(types.MustHexDecodeString(from))}
+	for _, key := range keys {
+		value, ok := s.storage[key]
+		changeSet.Changes = append(changeSet.Changes, types.KeyValueOption{
+			StorageKey:     types.MustHexDecodeString(key),
+			HasStorageData: ok,
+			StorageData:    types.MustHexDecodeString(value),
+		})
+	}
+	return []types.StorageChangeSet{changeSet}
+}
+
+type testSystem struct{}
+
+func (testSystem) Health() map[string]interface{} {
+	return map[string]interface{}{"peers": 1, "isSyncing": false, "shouldHavePeers": true}
+}
+
+type testChain struct{}
+
+func (testChain) GetBlockHash(_ uint64) string {
+	return types.NewHash([]byte{1}).Hex()
+}
+
+// newTestRelayChain serves the given RPC services, in addition to the system and chain
+// services needed to connect unless given
+func newTestRelayChain(t *testing.T, services map[string]interface{}) string {
+	services["system"] = testSystem{}
+	if _, ok := services["chain"]; !ok {
+		services["chain"] = testChain{}
+	}
+
+	server := gethrpc.NewServer()
+	for name, service := range services {
+		err := server.RegisterName(name, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	http := httptest.NewServer(server.WebsocketHandler([]string{"*"}))
+	t.Cleanup(func() {
+		server.Stop()
+		http.Close()
+	})
+	return "ws" + strings.TrimPrefix(http.URL, "http")
+}
+
+func TestGetAllParaheadsWithOwn(t *testing.T) {
+	metadata := newTestMetadata()
+	encodedMetadata, err := types.EncodeToHexString(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heads, err := relaychain.NewStorageMap(metadata, "Paras", "Heads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More parachains than fit on a page, so their keys are fetched in several pages
+	const paraCount = relaychain.StorageKeysPageSize*2 + 10
+	state := &testState{metadata: encodedMetadata, storage: make(map[string]string)}
+	var parachains []types.U32
+	// The last para is a parathread, whose head isn't committed to
+	for id := uint32(1000); id <= 1000+paraCount; id++ {
+		if id < 1000+paraCount {
+			parachains = append(parachains, types.U32(id))
+		}
+
+		encodedID, err := types.EncodeToBytes(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := heads.Key(encodedID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		header, err := types.EncodeToBytes(types.Header{Number: types.BlockNumber(id)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := types.EncodeToHexString(types.NewBytes(header))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state.storage[key.Hex()] = value
+	}
+
+	parachainsKey, err := types.CreateStorageKey(metadata, "Paras", "Parachains", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedParachains, err := types.EncodeToHexString(parachains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.storage[parachainsKey.Hex()] = encodedParachains
+
+	conn := relaychain.NewConnection(newTestRelayChain(t, map[string]interface{}{"state": state}), logrus.WithField("test", "relaychain"))
+	err = conn.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	blockHash := types.NewHash([]byte{2})
+	allHeads, pos, own, err := conn.GetAllParaheadsWithOwn(blockHash, 1200)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&state.pages))
+	assert.Len(t, allHeads, paraCount)
+	assert.Equal(t, 200, pos)
+	assert.Equal(t, types.BlockNumber(1200), own.Number)
+
+	// Heads are ordered by parachain ID
+	for i, head := range allHeads {
+		assert.Equal(t, uint32(1000+i), head.ParaID)
+
+		var header types.Header
+		err := types.DecodeFromBytes(head.Data, &header)
+		assert.Nil(t, err)
+		assert.Equal(t, types.BlockNumber(1000+i), header.Number)
+	}
+
+	number, err := conn.FetchLatestFinalizedParaBlockNumber(blockHash, 1005)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1005), number)
+
+	_, _, _, err = conn.GetAllParaheadsWithOwn(blockHash, 200)
+	assert.Error(t, err)
+
+	_, _, _, err = conn.GetAllParaheadsWithOwn(blockHash, 1000+paraCount)
+	assert.Error(t, err, "parathreads aren't included")
+}
+
+// paraHeadsFixture holds the relay chain state a parachain heads root is computed from, laid out
+// like the responses of a relay chain node: the raw Paras.Heads and Paras.Parachains storage, and
+// the mmr_generateProof response with the leaf committing to the heads. No relay chain node was
+// reachable to capture it from, so it was constructed instead. The headers, storage and leaf are
+// encoded like Polkadot's, and the root and proof were computed independently of the relayer.
+type paraHeadsFixture struct {
+	BlockHash          string            `json:"blockHash"`
+	Storage            map[string]string `json:"storage"`
+	MMRProof           map[string]string `json:"mmrProof"`
+	OwnParaID          uint32            `json:"ownParaId"`
+	OwnHeadPos         int               `json:"ownHeadPos"`
+	ParachainHeadsRoot string            `json:"parachainHeadsRoot"`
+}
+
+// fixtureMMR returns the fixture's proof response
+type fixtureMMR struct {
+	response map[string]string
+}
+
+func (m fixtureMMR) GenerateProof(_ uint64, _ string) map[string]string {
+	return m.response
+}
+
+func TestGetAllParaheadsWithOwn_Fixture(t *testing.T) {
+	rawData, err := ioutil.ReadFile(filepath.Join("testdata", "paras-heads-fixture.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture paraHeadsFixture
+	err = json.Unmarshal(rawData, &fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedMetadata, err := types.EncodeToHexString(newTestMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &testState{metadata: encodedMetadata, storage: fixture.Storage}
+	conn := relaychain.NewConnection(newTestRelayChain(t, map[string]interface{}{
+		"state": state,
+		"mmr":   fixtureMMR{response: fixture.MMRProof},
+	}), logrus.WithField("test", "relaychain"))
+	err = conn.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	blockHash := types.NewHash(types.MustHexDecodeString(fixture.BlockHash))
+	expectedRoot := types.NewH256(types.MustHexDecodeString(fixture.ParachainHeadsRoot))
+
+	proofResponse, err := conn.GetMMRLeafForBlock(2145911, blockHash)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedRoot, proofResponse.Leaf.ParachainHeads)
+
+	allHeads, pos, own, err := conn.GetAllParaheadsWithOwn(blockHash, fixture.OwnParaID)
+	assert.Nil(t, err)
+	assert.Equal(t, fixture.OwnHeadPos, pos)
+	assert.Equal(t, types.BlockNumber(861024), own.Number)
+	// The parathread's head is left out
+	assert.Len(t, allHeads, 5)
+
+	root, err := relaychain.ParaHeadsRoot(allHeads)
+	assert.Nil(t, err)
+	assert.Equal(t, proofResponse.Leaf.ParachainHeads, root)
+}
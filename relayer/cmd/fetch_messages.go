@@ -7,13 +7,14 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	geth "github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	gethCommon "github.com/ethereum/go-ethereum/common"
 	gethTypes "github.com/ethereum/go-ethereum/core/types"
@@ -21,8 +22,6 @@ import (
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
-	"github.com/snowfork/polkadot-ethereum/relayer/contracts/basic"
-	"github.com/snowfork/polkadot-ethereum/relayer/contracts/incentivized"
 	"github.com/snowfork/polkadot-ethereum/relayer/core"
 )
 
@@ -89,19 +88,14 @@ func getEthContractEventsAndTrie(
 	}
 	defer conn.Close()
 
-	basicOutboundChannel, err := basic.NewBasicOutboundChannel(common.HexToAddress(config.Channels.Basic.Outbound), conn.GetClient())
-	if err != nil {
-		return nil, nil, err
-	}
-
-	incentivizedOutboundChannel, err := incentivized.NewIncentivizedOutboundChannel(common.HexToAddress(config.Channels.Incentivized.Outbound), conn.GetClient())
-	if err != nil {
-		return nil, nil, err
+	for _, channel := range config.Channels {
+		err := channel.Validate()
+		if err != nil {
+			return nil, nil, err
+		}
+		mapping[channel.OutboundAddress()] = channel.Call
 	}
 
-	mapping[common.HexToAddress(config.Channels.Basic.Outbound)] = "BasicInboundChannel.submit"
-	mapping[common.HexToAddress(config.Channels.Incentivized.Outbound)] = "IncentivizedInboundChannel.submit"
-
 	loader := ethereum.DefaultBlockLoader{Conn: conn}
 	block, err := loader.GetBlock(ctx, blockHash)
 	if err != nil {
@@ -120,82 +114,42 @@ func getEthContractEventsAndTrie(
 
 	allEvents := make([]*gethTypes.Log, 0)
 
-	basicEvents, err := getEthBasicMessages(ctx, basicOutboundChannel, block.NumberU64(), index)
-	if err != nil {
-		return nil, nil, err
-	}
-	allEvents = append(allEvents, basicEvents...)
-
-	incentivizedEvents, err := getEthIncentivizedMessages(ctx, incentivizedOutboundChannel, block.NumberU64(), index)
-	if err != nil {
-		return nil, nil, err
+	for i := range config.Channels {
+		events, err := getEthChannelMessages(ctx, conn, &config.Channels[i], block.NumberU64(), index)
+		if err != nil {
+			return nil, nil, err
+		}
+		allEvents = append(allEvents, events...)
 	}
-	allEvents = append(allEvents, incentivizedEvents...)
 
 	return allEvents, trie, nil
 }
 
-func getEthBasicMessages(
+func getEthChannelMessages(
 	ctx context.Context,
-	contract *basic.BasicOutboundChannel,
+	conn *ethereum.Connection,
+	channel *ethereum.ChannelConfig,
 	blockNumber uint64,
 	index uint64,
 ) ([]*gethTypes.Log, error) {
 	events := make([]*gethTypes.Log, 0)
-	filterOps := bind.FilterOpts{Start: blockNumber, End: &blockNumber, Context: ctx}
-
-	iter, err := contract.FilterMessage(&filterOps)
-	if err != nil {
-		return nil, err
+	query := geth.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(blockNumber),
+		ToBlock:   new(big.Int).SetUint64(blockNumber),
+		Addresses: []common.Address{channel.OutboundAddress()},
+		Topics:    [][]common.Hash{{channel.EventTopic()}},
 	}
 
-	for {
-		more := iter.Next()
-		if !more {
-			err = iter.Error()
-			if err != nil {
-				return nil, err
-			}
-			break
-		}
-
-		if uint64(iter.Event.Raw.TxIndex) != index {
-			continue
-		}
-		events = append(events, &iter.Event.Raw)
-	}
-
-	return events, nil
-}
-
-func getEthIncentivizedMessages(
-	ctx context.Context,
-	contract *incentivized.IncentivizedOutboundChannel,
-	blockNumber uint64,
-	index uint64,
-) ([]*gethTypes.Log, error) {
-	events := make([]*gethTypes.Log, 0)
-	filterOps := bind.FilterOpts{Start: blockNumber, End: &blockNumber, Context: ctx}
-
-	iter, err := contract.FilterMessage(&filterOps)
+	logs, err := conn.GetClient().FilterLogs(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	for {
-		more := iter.Next()
-		if !more {
-			err = iter.Error()
-			if err != nil {
-				return nil, err
-			}
-			break
-		}
-
-		if uint64(iter.Event.Raw.TxIndex) != index {
+	for i := range logs {
+		if uint64(logs[i].TxIndex) != index {
 			continue
 		}
-		events = append(events, &iter.Event.Raw)
+		events = append(events, &logs[i])
 	}
 
 	return events, nil
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	_ "expvar"
 	"log"
+	"net/http"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -17,12 +19,24 @@ func runCmd() *cobra.Command {
 		Example: "artemis-relay run",
 		RunE:    RunFn,
 	}
+	cmd.Flags().String("metrics-addr", "", "Serve metrics at http://ADDR/debug/vars")
 	return cmd
 }
 
 func RunFn(cmd *cobra.Command, _ []string) error {
 	setupLogging()
 
+	metricsAddr, err := cmd.Flags().GetString("metrics-addr")
+	if err != nil {
+		return err
+	}
+	if metricsAddr != "" {
+		go func() {
+			err := http.ListenAndServe(metricsAddr, nil)
+			logrus.WithError(err).Error("Metrics server stopped")
+		}()
+	}
+
 	relay := &core.Relay{}
 	return relay.Run()
 }
@@ -14,8 +14,6 @@ import (
 	"github.com/spf13/cobra"
 )
 
-const OUR_PARACHAIN_ID = 200
-
 func subBeefyCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "sub-beefy",
@@ -43,32 +41,37 @@ func subBeefyJustifications(ctx context.Context) error {
 	log := log.WithField("script", "beefy")
 
 	relaychainEndpoint := config.Relaychain.Endpoint
-	relaychainConn := relaychain.NewConnection(relaychainEndpoint, log)
+	relaychainConn := relaychain.NewConnection(relaychainEndpoint, log, config.Relaychain.Endpoints...)
 	err = relaychainConn.Connect(ctx)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
+	defer relaychainConn.Close()
 
 	parachainEndpoint := config.Parachain.Endpoint
-	parachainConn := parachain.NewConnection(parachainEndpoint, nil, log)
+	parachainConn := parachain.NewConnection(parachainEndpoint, nil, log, config.Parachain.Endpoints...)
 	err = parachainConn.Connect(ctx)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
+	defer parachainConn.Close()
 
 	ch := make(chan interface{})
 
 	log.Info("Subscribing to beefy justifications")
-	sub, err := relaychainConn.GetAPI().Client.Subscribe(context.Background(), "beefy", "subscribeJustifications", "unsubscribeJustifications", "justifications", ch)
+	sub, err := relaychainConn.Subscribe("beefy", "subscribeJustifications", "unsubscribeJustifications", "justifications", ch)
 	if err != nil {
-		panic(err)
+		log.Error(err)
+		return err
 	}
 	defer sub.Unsubscribe()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return nil
 		case msg := <-ch:
 
 			signedCommitment := &store.SignedCommitment{}
@@ -91,7 +94,7 @@ func subBeefyJustifications(ctx context.Context) error {
 			}
 			log.WithField("blockHash", nextBlockHash.Hex()).Info("Got blockhash")
 			GetMMRLeafForBlock(uint64(blockNumber), nextBlockHash, relaychainConn)
-			allParaheads, ourParahead := GetAllParaheads(nextBlockHash, relaychainConn, OUR_PARACHAIN_ID)
+			allParaheads, ourParahead := GetAllParaheads(nextBlockHash, relaychainConn, config.Parachain.ParachainID)
 			log.WithFields(logrus.Fields{
 				"allParaheads": allParaheads,
 				"ourParahead":  ourParahead,
@@ -104,77 +107,29 @@ func subBeefyJustifications(ctx context.Context) error {
 }
 
 func GetAllParaheads(blockHash types.Hash, relaychainConn *relaychain.Connection, ourParachainId uint32) ([]types.Header, types.Header) {
-	none := types.NewOptionU32Empty()
-	encoded, err := types.EncodeToBytes(none)
-	if err != nil {
-		log.WithError(err).Error("Error")
-	}
-
-	baseParaHeadsStorageKey, err := types.CreateStorageKey(
-		relaychainConn.GetMetadata(),
-		"Paras",
-		"Heads", encoded, nil)
-	if err != nil {
-		log.WithError(err).Error("Failed to create parachain header storage key")
-	}
-
-	//TODO fix this manual slice.
-	// The above types.CreateStorageKey does not give the same base key as polkadotjs needs for getKeys.
-	// It has some extra bytes.
-	// maybe from the none u32 in golang being wrong, or maybe slightly off CreateStorageKey call? we slice it
-	// here as a hack.
-	actualBaseParaHeadsStorageKey := baseParaHeadsStorageKey[:32]
-	log.WithField("actualBaseParaHeadsStorageKey", actualBaseParaHeadsStorageKey.Hex()).Info("actualBaseParaHeadsStorageKey")
-
-	keysResponse, err := relaychainConn.GetAPI().RPC.State.GetKeys(actualBaseParaHeadsStorageKey, blockHash)
-	if err != nil {
-		log.WithError(err).Error("Failed to get all parachain keys")
-	}
-
-	headersResponse, err := relaychainConn.GetAPI().RPC.State.QueryStorage(keysResponse, blockHash, blockHash)
+	paraHeads, _, ourParachainHeader, err := relaychainConn.GetAllParaheadsWithOwn(blockHash, ourParachainId)
 	if err != nil {
 		log.WithError(err).Error("Failed to get all parachain headers")
+		return nil, types.Header{}
 	}
 
 	log.Info("Got all parachain headers")
 	var headers []types.Header
-	var ourParachainHeader types.Header
-	for _, headerResponse := range headersResponse {
-		for _, change := range headerResponse.Changes {
-
-			// TODO fix this manual slice with a proper type decode. only the last few bytes are for the ParaId,
-			// not sure what the early ones are for.
-			key := change.StorageKey[40:]
-			var parachainID types.U32
-			if err := types.DecodeFromBytes(key, &parachainID); err != nil {
-				log.WithError(err).Error("Failed to decode parachain ID")
-			}
-
-			log.WithField("parachainId", parachainID).Info("Decoding header for parachain")
-			var encodableOpaqueHeader types.Bytes
-			if err := types.DecodeFromBytes(change.StorageData, &encodableOpaqueHeader); err != nil {
-				log.WithError(err).Error("Failed to decode MMREncodableOpaqueLeaf")
-			}
-
-			var header types.Header
-			if err := types.DecodeFromBytes(encodableOpaqueHeader, &header); err != nil {
-				log.WithError(err).Error("Failed to decode Header")
-			}
-			log.WithFields(logrus.Fields{
-				"headerBytes":           fmt.Sprintf("%#x", encodableOpaqueHeader),
-				"header.ParentHash":     header.ParentHash.Hex(),
-				"header.Number":         header.Number,
-				"header.StateRoot":      header.StateRoot.Hex(),
-				"header.ExtrinsicsRoot": header.ExtrinsicsRoot.Hex(),
-				"header.Digest":         header.Digest,
-				"parachainId":           parachainID,
-			}).Info("Decoded header for parachain")
-			headers = append(headers, header)
-
-			if parachainID == types.U32(ourParachainId) {
-				ourParachainHeader = header
-			}
+	for _, paraHead := range paraHeads {
+		var header types.Header
+		if err := types.DecodeFromBytes(paraHead.Data, &header); err != nil {
+			log.WithError(err).Error("Failed to decode Header")
 		}
+		log.WithFields(logrus.Fields{
+			"headerBytes":           fmt.Sprintf("%#x", paraHead.Data),
+			"header.ParentHash":     header.ParentHash.Hex(),
+			"header.Number":         header.Number,
+			"header.StateRoot":      header.StateRoot.Hex(),
+			"header.ExtrinsicsRoot": header.ExtrinsicsRoot.Hex(),
+			"header.Digest":         header.Digest,
+			"parachainId":           paraHead.ParaID,
+		}).Info("Decoded header for parachain")
+		headers = append(headers, header)
 	}
 	return headers, ourParachainHeader
 }
@@ -5,11 +5,14 @@ import (
 	"os"
 	"strings"
 
+	"github.com/sirupsen/logrus"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
 	"github.com/snowfork/polkadot-ethereum/relayer/workers"
 	"github.com/snowfork/polkadot-ethereum/relayer/workers/beefyrelayer/store"
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/parachaincommitmentrelayer"
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/parachaincommitmentrelayer/index"
 	"github.com/spf13/viper"
 )
 
@@ -25,6 +28,34 @@ type Config struct {
 	Relaychain           relaychain.Config `mapstructure:"relaychain"`
 	BeefyRelayerDatabase store.Config      `mapstructure:"database"`
 	Workers              WorkerConfig      `mapstructure:"workers"`
+	// Further parachains whose commitments are relayed alongside those of Parachain
+	Bridges []parachaincommitmentrelayer.BridgeConfig `mapstructure:"bridges"`
+	// Commitments of the bridge parachains, recorded by the parachain commitment relayer
+	CommitmentIndex index.Config `mapstructure:"commitment-index"`
+}
+
+// CommitmentBridges returns all parachains served by the parachain commitment relayer,
+// starting with the one configured through Parachain and Eth.Channels
+func (c *Config) CommitmentBridges() []parachaincommitmentrelayer.BridgeConfig {
+	bridges := []parachaincommitmentrelayer.BridgeConfig{
+		{
+			Parachain: c.Parachain,
+			Channels:  c.Eth.Channels,
+		},
+	}
+	return append(bridges, c.Bridges...)
+}
+
+// defaultParachainID sets the parachain ID of configurations which predate it, and only relay
+// the commitments of a single parachain, to the ID which was used before it could be configured
+func (c *Config) defaultParachainID() {
+	if !c.Workers.ParachainCommitmentRelayer.Enabled || c.Parachain.ParachainID != 0 || len(c.Bridges) > 0 {
+		return
+	}
+
+	logrus.WithField("parachainID", parachaincommitmentrelayer.LegacyParachainID).
+		Warn("parachain.parachain-id is not set. Defaulting to the former ID of the bridge parachain is deprecated, please set it")
+	c.Parachain.ParachainID = parachaincommitmentrelayer.LegacyParachainID
 }
 
 func LoadConfig() (*Config, error) {
@@ -33,6 +64,7 @@ func LoadConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	config.defaultParachainID()
 
 	// Load secrets from environment variables
 	var value string
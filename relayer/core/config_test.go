@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/parachaincommitmentrelayer"
+)
+
+func TestConfig_DefaultParachainID(t *testing.T) {
+	config := Config{}
+	config.Workers.ParachainCommitmentRelayer.Enabled = true
+	config.defaultParachainID()
+	assert.Equal(t, uint32(parachaincommitmentrelayer.LegacyParachainID), config.Parachain.ParachainID)
+
+	// Configured IDs are kept
+	config.Parachain.ParachainID = 1000
+	config.defaultParachainID()
+	assert.Equal(t, uint32(1000), config.Parachain.ParachainID)
+
+	// Configurations with several bridges have to set all IDs
+	config.Parachain.ParachainID = 0
+	config.Bridges = []parachaincommitmentrelayer.BridgeConfig{{}}
+	config.defaultParachainID()
+	assert.Equal(t, uint32(0), config.Parachain.ParachainID)
+}
@@ -49,7 +49,8 @@ func (re *Relay) Run() error {
 	if config.Workers.ParachainCommitmentRelayer.Enabled {
 		parachaincommitmentrelayerFactory := func() (workers.Worker, *workers.WorkerConfig, error) {
 			parachainCommitmentRelayer, err := parachaincommitmentrelayer.NewWorker(
-				&config.Parachain,
+				config.CommitmentBridges(),
+				&config.CommitmentIndex,
 				&config.Relaychain,
 				&config.Eth,
 				logrus.WithField("worker", parachaincommitmentrelayer.Name),
@@ -0,0 +1,116 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+// Package merkle implements the binary Merkle tree which the relay chain uses to commit to
+// BEEFY authority sets and parachain heads in MMR leaves.
+//
+// Leaves are hashed with Keccak256, and pairs of nodes are hashed in order. When a level of
+// the tree has an odd number of nodes, the last one is promoted to the next level as is. Proofs
+// are verified on Ethereum by MerkleProof.computeRootFromProofAtPosition.
+package merkle
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Root returns the root of the tree over leaves. The root of an empty tree is zero.
+func Root(leaves [][]byte) [32]byte {
+	level := hashLeaves(leaves)
+	if len(level) == 0 {
+		return [32]byte{}
+	}
+
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// Proof returns the hashes needed to compute the root from the leaf at pos, ordered from
+// the leaf to the root
+func Proof(leaves [][]byte, pos int) ([][32]byte, error) {
+	if pos < 0 || pos >= len(leaves) {
+		return nil, fmt.Errorf("leaf %d is out of range for a tree of %d leaves", pos, len(leaves))
+	}
+
+	var proof [][32]byte
+	level := hashLeaves(leaves)
+	for len(level) > 1 {
+		sibling := pos ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		level = nextLevel(level)
+		pos /= 2
+	}
+	return proof, nil
+}
+
+// ComputeRoot returns the root of a tree of width leaves, computed from the hash of the leaf
+// at pos and its proof
+func ComputeRoot(leafHash [32]byte, pos int, width int, proof [][32]byte) ([32]byte, error) {
+	if pos < 0 || pos >= width {
+		return [32]byte{}, fmt.Errorf("leaf %d is out of range for a tree of %d leaves", pos, width)
+	}
+
+	hash := leafHash
+	i := 0
+	for ; width > 1; width = (width-1)/2 + 1 {
+		isLeft := pos%2 == 0
+		if isLeft && pos+1 == width {
+			// Promoted without a sibling
+			pos /= 2
+			continue
+		}
+
+		if i >= len(proof) {
+			return [32]byte{}, fmt.Errorf("proof is too short")
+		}
+		if isLeft {
+			hash = hashPair(hash, proof[i])
+		} else {
+			hash = hashPair(proof[i], hash)
+		}
+		pos /= 2
+		i++
+	}
+	if i != len(proof) {
+		return [32]byte{}, fmt.Errorf("proof is too long")
+	}
+	return hash, nil
+}
+
+// HashLeaf returns the hash of a leaf, as used in the tree
+func HashLeaf(leaf []byte) [32]byte {
+	var hash [32]byte
+	copy(hash[:], crypto.Keccak256(leaf))
+	return hash
+}
+
+func hashLeaves(leaves [][]byte) [][32]byte {
+	hashes := make([][32]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = HashLeaf(leaf)
+	}
+	return hashes
+}
+
+func nextLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+		} else {
+			next = append(next, hashPair(level[i], level[i+1]))
+		}
+	}
+	return next
+}
+
+func hashPair(left, right [32]byte) [32]byte {
+	var hash [32]byte
+	copy(hash[:], crypto.Keccak256(left[:], right[:]))
+	return hash
+}
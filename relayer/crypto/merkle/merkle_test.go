@@ -0,0 +1,79 @@
+package merkle_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/crypto/merkle"
+)
+
+// BEEFY authorities of a rococo-local relay chain, as found in ethereum/test/fixtures/full-flow.json
+var (
+	alice = common.FromHex("0xe04cc55ebee1cbce552f250e85c57b70b2e2625b")
+	bob   = common.FromHex("0x25451a4de12dccc2d166922fa938e900fcc4ed24")
+)
+
+func TestRoot(t *testing.T) {
+	assert.Equal(t, [32]byte{}, merkle.Root(nil))
+
+	assert.Equal(t,
+		common.HexToHash("0xaeb47a269393297f4b0a3c9c9cfd00c7a4195255274cf39d83dabc2fcc9ff3d7"),
+		common.Hash(merkle.Root([][]byte{alice})),
+	)
+
+	assert.Equal(t,
+		common.HexToHash("0x697ea2a8fe5b03468548a7a413424a6292ab44a82a6f5cc594c3fa7dda7ce402"),
+		common.Hash(merkle.Root([][]byte{alice, bob})),
+	)
+}
+
+func TestProof(t *testing.T) {
+	// Sibling hashes in the validator proofs of full-flow.json
+	proof, err := merkle.Proof([][]byte{alice, bob}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, [][32]byte{common.HexToHash("0xf68aec7304bf37f340dae2ea20fb5271ee28a3128812b84a615da4789e458bde")}, proof)
+
+	proof, err = merkle.Proof([][]byte{alice, bob}, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][32]byte{common.HexToHash("0xaeb47a269393297f4b0a3c9c9cfd00c7a4195255274cf39d83dabc2fcc9ff3d7")}, proof)
+
+	_, err = merkle.Proof([][]byte{alice, bob}, 2)
+	assert.Error(t, err)
+}
+
+func TestComputeRoot(t *testing.T) {
+	for width := 1; width <= 17; width++ {
+		var leaves [][]byte
+		for i := 0; i < width; i++ {
+			leaves = append(leaves, []byte(fmt.Sprintf("leaf %d", i)))
+		}
+		root := merkle.Root(leaves)
+
+		for pos := range leaves {
+			proof, err := merkle.Proof(leaves, pos)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			computed, err := merkle.ComputeRoot(merkle.HashLeaf(leaves[pos]), pos, width, proof)
+			assert.Nil(t, err)
+			assert.Equal(t, root, computed, "width %d, position %d", width, pos)
+
+			if len(proof) > 0 {
+				_, err = merkle.ComputeRoot(merkle.HashLeaf(leaves[pos]), pos, width, proof[1:])
+				assert.Error(t, err)
+			}
+			_, err = merkle.ComputeRoot(merkle.HashLeaf(leaves[pos]), pos, width, append(proof, root))
+			assert.Error(t, err)
+		}
+	}
+
+	// The last of three leaves is promoted to the root's level
+	leaves := [][]byte{alice, bob, []byte("charlie")}
+	proof, err := merkle.Proof(leaves, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][32]byte{merkle.Root(leaves[:2])}, proof)
+}
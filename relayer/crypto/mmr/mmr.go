@@ -0,0 +1,219 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+// Package mmr verifies inclusion proofs of BEEFY MMR leaves in the same way as the
+// MMRVerification contract, so that proofs can be checked before they are submitted to Ethereum.
+//
+// Nodes are numbered from 1 in the order they were added to the MMR. A proof lists the peaks
+// left of the leaf's mountain, then the siblings on the path from the leaf to its peak, and
+// finally the bagged peaks right of the mountain, if any.
+//
+// Leaves are hashed with Keccak256 over their SCALE encoding, as the relay chain does.
+package mmr
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+)
+
+// EncodeLeaf returns the SCALE encoding of a leaf, which the relay chain hashes into the MMR
+func EncodeLeaf(leaf types.MMRLeaf) ([]byte, error) {
+	return types.EncodeToBytes(leaf)
+}
+
+// HashLeaf returns the hash of a leaf in the MMR
+func HashLeaf(leaf types.MMRLeaf) ([32]byte, error) {
+	encodedLeaf, err := EncodeLeaf(leaf)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return keccak(encodedLeaf), nil
+}
+
+// EncodeOpaqueLeaf returns the encoding of a leaf wrapped in a length-prefixed byte vector, like
+// the opaque leaves returned by mmr_generateProof. This matches BeefyLightClient.encodeMMRLeaf.
+func EncodeOpaqueLeaf(leaf types.MMRLeaf) ([]byte, error) {
+	encodedLeaf, err := EncodeLeaf(leaf)
+	if err != nil {
+		return nil, err
+	}
+	return types.EncodeToBytes(types.NewBytes(encodedLeaf))
+}
+
+// HashOpaqueLeaf returns the hash of an opaque leaf, like BeefyLightClient.hashMMRLeaf. Note that
+// it differs from the hash of the leaf in the MMR.
+func HashOpaqueLeaf(leaf types.MMRLeaf) ([32]byte, error) {
+	encodedLeaf, err := EncodeOpaqueLeaf(leaf)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return keccak(encodedLeaf), nil
+}
+
+// VerifyProof checks that the leaf at leafIndex of an MMR with leafCount leaves has the hash
+// leafHash, given the root of the MMR and a proof for the leaf
+func VerifyProof(root [32]byte, leafHash [32]byte, leafIndex uint64, leafCount uint64, proof [][32]byte) error {
+	computedRoot, err := CalculateRoot(leafHash, leafIndex, leafCount, proof)
+	if err != nil {
+		return err
+	}
+	if computedRoot != root {
+		return fmt.Errorf("leaf %d of %d leaves: computed root %#x does not match %#x",
+			leafIndex, leafCount, computedRoot, root)
+	}
+	return nil
+}
+
+// CalculateRoot returns the root of an MMR with leafCount leaves, computed from the hash of
+// the leaf at leafIndex and its proof. It fails wherever MMRVerification.verifyInclusionProof
+// would return false or revert.
+func CalculateRoot(leafHash [32]byte, leafIndex uint64, leafCount uint64, proof [][32]byte) ([32]byte, error) {
+	leafPos := LeafIndexToPos(leafIndex)
+	if !isLeaf(leafPos) {
+		return [32]byte{}, fmt.Errorf("position %d of leaf %d is not a leaf", leafPos, leafIndex)
+	}
+
+	peaks := PeakPositions(leafCount)
+	if len(peaks) == 0 {
+		return [32]byte{}, fmt.Errorf("an MMR without leaves has no root")
+	}
+
+	var targetPeakPos uint64
+	numLeftPeaks := 0
+	for _, peakPos := range peaks {
+		if peakPos >= leafPos {
+			targetPeakPos = peakPos
+			break
+		}
+		numLeftPeaks++
+	}
+
+	bagger, err := calculatePeakRoot(numLeftPeaks, leafHash, leafPos, targetPeakPos, proof)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	// All peaks right of the leaf's mountain are bagged into the last proof item
+	if targetPeakPos < peaks[len(peaks)-1] {
+		if len(proof) == 0 {
+			return [32]byte{}, fmt.Errorf("proof is missing the right peaks")
+		}
+		bagger = hashPair(proof[len(proof)-1], bagger)
+	}
+
+	for i := numLeftPeaks; i > 0; i-- {
+		if i > len(proof) {
+			return [32]byte{}, fmt.Errorf("proof is missing left peak %d", i-1)
+		}
+		bagger = hashPair(bagger, proof[i-1])
+	}
+
+	return bagger, nil
+}
+
+// calculatePeakRoot hashes the leaf up to the peak of its mountain with the siblings in the
+// proof, which follow the numLeftPeaks left peaks
+func calculatePeakRoot(numLeftPeaks int, leafHash [32]byte, leafPos uint64, peakPos uint64,
+	proof [][32]byte) ([32]byte, error) {
+	if leafPos == peakPos {
+		return leafHash, nil
+	}
+
+	pos := leafPos
+	hash := leafHash
+	height := uint64(1)
+	for next := numLeftPeaks; ; next++ {
+		if next >= len(proof) {
+			return [32]byte{}, fmt.Errorf("proof is missing the sibling at height %d", height)
+		}
+		sibling := proof[next]
+
+		var parentPos uint64
+		if heightAt(pos+1) > height {
+			// Right sibling
+			parentPos = pos + 1
+			hash = hashPair(sibling, hash)
+		} else {
+			// Left sibling
+			parentPos = pos + parentOffset(height-1)
+			hash = hashPair(hash, sibling)
+		}
+
+		if parentPos >= peakPos {
+			return hash, nil
+		}
+		pos = parentPos
+		height++
+	}
+}
+
+// LeafIndexToPos returns the position of the leaf at index
+func LeafIndexToPos(index uint64) uint64 {
+	return leafIndexToMMRSize(index) - uint64(bits.TrailingZeros64(index+1))
+}
+
+// PeakPositions returns the positions of the peaks of an MMR with width leaves, from left to right
+func PeakPositions(width uint64) []uint64 {
+	var peaks []uint64
+	var size uint64
+	for i := 64; i > 0; i-- {
+		if width&(1<<(i-1)) != 0 {
+			size += (1 << i) - 1
+			peaks = append(peaks, size)
+		}
+	}
+	return peaks
+}
+
+// leafIndexToMMRSize returns the number of nodes in the MMR right after the leaf at index was added
+func leafIndexToMMRSize(index uint64) uint64 {
+	leafCount := index + 1
+	return 2*leafCount - uint64(bits.OnesCount64(leafCount))
+}
+
+// mountainHeight returns the height of the highest peak of an MMR with size nodes
+func mountainHeight(size uint64) uint64 {
+	height := uint64(1)
+	for uint64(1)<<height <= size+height {
+		height++
+	}
+	return height - 1
+}
+
+// heightAt returns the height of the node at pos, where leaves have height 1
+func heightAt(pos uint64) uint64 {
+	reducedPos := pos
+	var peakPos, height uint64
+	// Remove the mountains left of pos
+	for reducedPos > peakPos {
+		reducedPos -= (1 << height) - 1
+		height = mountainHeight(reducedPos)
+		peakPos = (1 << height) - 1
+	}
+	// pos is on the right slope of the mountain
+	if peakPos-reducedPos > height {
+		return 0
+	}
+	return height - (peakPos - reducedPos)
+}
+
+func isLeaf(pos uint64) bool {
+	return heightAt(pos) == 1
+}
+
+func parentOffset(height uint64) uint64 {
+	return 2 << height
+}
+
+func hashPair(left, right [32]byte) [32]byte {
+	return keccak(left[:], right[:])
+}
+
+func keccak(data ...[]byte) [32]byte {
+	var hash [32]byte
+	copy(hash[:], crypto.Keccak256(data...))
+	return hash
+}
@@ -0,0 +1,137 @@
+package mmr_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/crypto/mmr"
+)
+
+// Fixtures shared with the tests of the MMRVerification contract
+type fixture struct {
+	Leaves   []common.Hash `json:"leaves"`
+	RootHash common.Hash   `json:"rootHash"`
+	Proofs   []struct {
+		LeafIndex uint64        `json:"leafIndex"`
+		LeafCount uint64        `json:"leafCount"`
+		Items     []common.Hash `json:"items"`
+	} `json:"proofs"`
+}
+
+func readFixture(t *testing.T, filename string) fixture {
+	rawData, err := ioutil.ReadFile(filepath.Join("testdata", filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f fixture
+	err = json.Unmarshal(rawData, &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func toProof(items []common.Hash) [][32]byte {
+	proof := make([][32]byte, len(items))
+	for i, item := range items {
+		proof[i] = item
+	}
+	return proof
+}
+
+func TestVerifyProof_Fixtures(t *testing.T) {
+	for _, filename := range []string{"mmr-fixture-data-7-leaves.json", "mmr-fixture-data-15-leaves.json"} {
+		f := readFixture(t, filename)
+		for i, p := range f.Proofs {
+			proof := toProof(p.Items)
+			err := mmr.VerifyProof(f.RootHash, f.Leaves[i], p.LeafIndex, p.LeafCount, proof)
+			assert.Nil(t, err, "%s: leaf %d", filename, i)
+
+			// The proof of another leaf
+			other := (i + 1) % len(f.Leaves)
+			err = mmr.VerifyProof(f.RootHash, f.Leaves[other], p.LeafIndex, p.LeafCount, proof)
+			assert.Error(t, err, "%s: leaf %d with the proof of leaf %d", filename, other, i)
+
+			if len(proof) > 0 {
+				err = mmr.VerifyProof(f.RootHash, f.Leaves[i], p.LeafIndex, p.LeafCount, proof[1:])
+				assert.Error(t, err, "%s: leaf %d with a truncated proof", filename, i)
+			}
+		}
+	}
+}
+
+func TestVerifyProof_SingleLeaf(t *testing.T) {
+	leaf := common.HexToHash("0xda5e6d0616e05c6a6348605a37ca33493fc1a15ad1e6a405ee05c17843fdafed")
+	assert.Nil(t, mmr.VerifyProof(leaf, leaf, 0, 1, nil))
+	assert.Error(t, mmr.VerifyProof(leaf, leaf, 0, 0, nil))
+}
+
+func TestPositions(t *testing.T) {
+	// Leaves of the 7-leaf MMR pictured in MMRVerification.sol
+	var positions []uint64
+	for i := uint64(0); i < 7; i++ {
+		positions = append(positions, mmr.LeafIndexToPos(i))
+	}
+	assert.Equal(t, []uint64{1, 2, 4, 5, 8, 9, 11}, positions)
+	assert.Equal(t, []uint64{7, 10, 11}, mmr.PeakPositions(7))
+	assert.Equal(t, []uint64{15, 22, 25, 26}, mmr.PeakPositions(15))
+	assert.Empty(t, mmr.PeakPositions(0))
+}
+
+// The latest leaf and MMR root of a rococo-local relay chain, as found in
+// ethereum/test/fixtures/full-flow.json
+var fullFlowLeaf = types.MMRLeaf{
+	ParentNumberAndHash: types.ParentNumberAndHash{
+		ParentNumber: 32,
+		Hash:         types.NewHash(common.FromHex("0xf425d9e0c5fa1511e73a0f88746c458570800d0209caf715da35799181e68dff")),
+	},
+	ParachainHeads: types.NewH256(common.FromHex("0xe45baa2cb0c9464b2b70ceb409ee6c1209c174fae4991d0340af8741190b86bc")),
+	BeefyNextAuthoritySet: types.BeefyNextAuthoritySet{
+		ID:   1,
+		Len:  3,
+		Root: types.NewH256(common.FromHex("0x76aceff742e34d03bedbb6e888b7614ef3373e8f018c8ec3aac6f68260b28d95")),
+	},
+}
+
+func TestEncodeOpaqueLeaf(t *testing.T) {
+	// BeefyLightClient.encodeMMRLeaf
+	encodedLeaf, err := mmr.EncodeOpaqueLeaf(fullFlowLeaf)
+	assert.Nil(t, err)
+	assert.Equal(t, "0xc10120000000f425d9e0c5fa1511e73a0f88746c458570800d0209caf715da35799181e68dffe45baa2cb0c9464b2b70ceb409ee6c1209c174fae4991d0340af8741190b86bc01000000000000000300000076aceff742e34d03bedbb6e888b7614ef3373e8f018c8ec3aac6f68260b28d95",
+		types.HexEncodeToString(encodedLeaf))
+
+	// BeefyLightClient.hashMMRLeaf
+	hash, err := mmr.HashOpaqueLeaf(fullFlowLeaf)
+	assert.Nil(t, err)
+	assert.Equal(t, common.HexToHash("0x80522e3a21bb1c0d6968b789b36d3bc99c22d59c8381e3c6574589e0334c484c"), common.Hash(hash))
+
+	rawLeaf, err := mmr.EncodeLeaf(fullFlowLeaf)
+	assert.Nil(t, err)
+	assert.Equal(t, encodedLeaf[2:], rawLeaf)
+}
+
+func TestVerifyProof_FullFlow(t *testing.T) {
+	// The MMR root signed in the BEEFY commitment for block 33, which includes the leaf for block 32
+	root := common.HexToHash("0x7c25a43172710779634aac5820f39a3bd5f070d1f18e84222403f351f80920d6")
+	proof := toProof([]common.Hash{common.HexToHash("0x2d65ec8227795b62e8e9d097b62eb63eacf26dc747842d909b35ebccd12e9575")})
+
+	hash, err := mmr.HashLeaf(fullFlowLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, mmr.VerifyProof(root, hash, 32, 33, proof))
+	assert.Error(t, mmr.VerifyProof(root, hash, 31, 32, proof))
+
+	opaqueHash, err := mmr.HashOpaqueLeaf(fullFlowLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, mmr.VerifyProof(root, opaqueHash, 32, 33, proof), "the relay chain hashes leaves without a length prefix")
+}
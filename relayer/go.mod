@@ -4,6 +4,7 @@ go 1.14
 
 require (
 	github.com/ChainSafe/go-schnorrkel v0.0.0-20210527232834-58622d036665 // indirect
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/VictoriaMetrics/fastcache v1.6.0 // indirect
 	github.com/allegro/bigcache v1.2.1 // indirect
 	github.com/ethereum/go-ethereum v1.10.3
@@ -0,0 +1,547 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package substrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	gsrpc "github.com/snowfork/go-substrate-rpc-client/v3"
+	gethrpc "github.com/snowfork/go-substrate-rpc-client/v3/gethrpc"
+	"github.com/snowfork/go-substrate-rpc-client/v3/rpc"
+	"github.com/snowfork/go-substrate-rpc-client/v3/rpc/author"
+	rpcchain "github.com/snowfork/go-substrate-rpc-client/v3/rpc/chain"
+	"github.com/snowfork/go-substrate-rpc-client/v3/rpc/mmr"
+	"github.com/snowfork/go-substrate-rpc-client/v3/rpc/offchain"
+	"github.com/snowfork/go-substrate-rpc-client/v3/rpc/state"
+	"github.com/snowfork/go-substrate-rpc-client/v3/rpc/system"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain"
+)
+
+const (
+	DialTimeout         = 10 * time.Second
+	HealthCheckInterval = 30 * time.Second
+	HealthCheckTimeout  = 10 * time.Second
+	MinReconnectDelay   = time.Second
+	MaxReconnectDelay   = time.Minute
+)
+
+var ErrClosed = errors.New("substrate client is closed")
+
+// clients holds the clients shared by the workers of the process
+var clients = chain.NewRegistry()
+
+// rpcClient implements client.Client on top of a gethrpc client which, unlike the
+// client created by gsrpc, can be closed
+type rpcClient struct {
+	*gethrpc.Client
+	url string
+}
+
+func (c *rpcClient) URL() string {
+	return c.url
+}
+
+// connection is a websocket connection to one of the endpoints
+type connection struct {
+	api         *gsrpc.SubstrateAPI
+	rpc         *rpcClient
+	metadata    *types.Metadata
+	genesisHash types.Hash
+}
+
+// Client is a websocket connection to a Substrate node which survives dropped
+// connections. The node is polled for its health, and whenever the connection is
+// found dead, the client reconnects to the first available endpoint, with backoff,
+// and restores the subscriptions made through it.
+type Client struct {
+	endpoints []string
+	// Key in the registry of shared clients, if acquired from it
+	key string
+	log *logrus.Entry
+
+	healthCheckInterval time.Duration
+	minReconnectDelay   time.Duration
+	maxReconnectDelay   time.Duration
+
+	// Serializes connecting, so that users of a shared client can all call Connect
+	connectLock sync.Mutex
+
+	mu   sync.RWMutex
+	conn *connection
+	subs map[*Subscription]struct{}
+
+	// Signalled by subscriptions which ended because the connection died
+	dead      chan struct{}
+	closing   chan struct{}
+	done      chan struct{}
+	started   bool
+	closeOnce sync.Once
+}
+
+// NewClient creates a client for the given endpoints. The first endpoint is preferred,
+// the others are fallbacks.
+func NewClient(endpoints []string, log *logrus.Entry) *Client {
+	return &Client{
+		endpoints:           endpoints,
+		log:                 log,
+		healthCheckInterval: HealthCheckInterval,
+		minReconnectDelay:   MinReconnectDelay,
+		maxReconnectDelay:   MaxReconnectDelay,
+		subs:                make(map[*Subscription]struct{}),
+		dead:                make(chan struct{}, 1),
+		closing:             make(chan struct{}),
+		done:                make(chan struct{}),
+	}
+}
+
+// AcquireClient returns the client shared by all users of the same endpoints. The
+// client must be given back with Release instead of being closed.
+func AcquireClient(endpoints []string) *Client {
+	key := strings.Join(endpoints, ",")
+	conn, _ := clients.Acquire(key, func() (chain.Closer, error) {
+		client := NewClient(endpoints, logrus.WithField("endpoint", endpoints[0]))
+		client.key = key
+		return client, nil
+	})
+	return conn.(*Client)
+}
+
+// Release gives up a client acquired with AcquireClient. The client is closed once
+// all its users released it.
+func (c *Client) Release() {
+	if c.key == "" {
+		c.Close()
+		return
+	}
+	clients.Release(c.key)
+}
+
+// Connect connects to the first available endpoint and starts monitoring the
+// connection. Connecting a client which is already connected does nothing.
+func (c *Client) Connect(ctx context.Context) error {
+	if len(c.endpoints) == 0 {
+		return fmt.Errorf("no endpoints configured")
+	}
+
+	c.connectLock.Lock()
+	defer c.connectLock.Unlock()
+
+	c.mu.RLock()
+	started := c.started
+	c.mu.RUnlock()
+	if started {
+		return nil
+	}
+
+	conn, err := c.dialAny(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.closing:
+		conn.rpc.Close()
+		return ErrClosed
+	default:
+	}
+
+	c.conn = conn
+	c.started = true
+	go c.monitor()
+
+	c.log.WithFields(logrus.Fields{
+		"endpoint":    conn.rpc.url,
+		"metaVersion": conn.metadata.Version,
+	}).Info("Connected to chain")
+
+	return nil
+}
+
+func (c *Client) current() *connection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+// API returns the API of the current connection. It must not be held on to, as it
+// stops working when the connection is replaced.
+func (c *Client) API() *gsrpc.SubstrateAPI {
+	return c.current().api
+}
+
+// Endpoint returns the endpoint of the current connection
+func (c *Client) Endpoint() string {
+	return c.current().rpc.url
+}
+
+// Metadata returns the latest known metadata. The returned metadata isn't modified
+// when the runtime is upgraded, so it stays valid for callers holding on to it.
+func (c *Client) Metadata() *types.Metadata {
+	return c.current().metadata
+}
+
+func (c *Client) GenesisHash() types.Hash {
+	return c.current().genesisHash
+}
+
+// RefreshMetadata fetches the metadata of the latest runtime
+func (c *Client) RefreshMetadata() error {
+	meta, err := c.API().RPC.State.GetMetadataLatest()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	conn := *c.conn
+	conn.metadata = meta
+	c.conn = &conn
+	c.mu.Unlock()
+
+	c.log.WithField("metaVersion", meta.Version).Info("Refreshed metadata")
+	return nil
+}
+
+// Close stops reconnecting, ends all subscriptions and closes the connection
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+
+		c.mu.RLock()
+		started := c.started
+		c.mu.RUnlock()
+		if started {
+			<-c.done
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for sub := range c.subs {
+			sub.end()
+		}
+		c.subs = make(map[*Subscription]struct{})
+
+		if c.conn != nil {
+			c.conn.rpc.Close()
+			c.log.WithField("endpoint", c.conn.rpc.url).Info("Closed connection to chain")
+		}
+	})
+}
+
+// Subscribe creates a subscription which is restored on each new connection.
+// Notifications are sent to the given channel, which is never closed.
+func (c *Client) Subscribe(namespace, subscribeMethod, unsubscribeMethod, notificationMethod string,
+	channel interface{}, args ...interface{}) (*Subscription, error) {
+	sub := &Subscription{
+		client:             c,
+		namespace:          namespace,
+		subscribeMethod:    subscribeMethod,
+		unsubscribeMethod:  unsubscribeMethod,
+		notificationMethod: notificationMethod,
+		channel:            channel,
+		args:               args,
+		err:                make(chan error, 1),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.closing:
+		return nil, ErrClosed
+	default:
+	}
+	if c.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	err := sub.subscribe(c.conn.rpc)
+	if err != nil {
+		return nil, err
+	}
+	c.subs[sub] = struct{}{}
+
+	return sub, nil
+}
+
+func (c *Client) unsubscribe(sub *Subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, sub)
+}
+
+func (c *Client) signalDead() {
+	select {
+	case c.dead <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) monitor() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closing:
+			return
+		case <-c.dead:
+		case <-ticker.C:
+			err := c.checkHealth()
+			if err == nil {
+				continue
+			}
+			c.log.WithError(err).WithField("endpoint", c.Endpoint()).Warn("Health check failed")
+		}
+
+		c.reconnect()
+	}
+}
+
+func (c *Client) checkHealth() error {
+	ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+	defer cancel()
+
+	var health interface{}
+	return c.current().rpc.CallContext(ctx, &health, "system_health")
+}
+
+// reconnect replaces the current connection, retrying with exponential backoff until
+// it succeeds or the client is closed
+func (c *Client) reconnect() {
+	c.log.WithField("endpoint", c.Endpoint()).Warn("Connection to chain lost. Reconnecting")
+
+	delay := c.minReconnectDelay
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.closing:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err := c.replaceConnection(ctx)
+		cancel()
+		if err == nil {
+			// Subscriptions which ended before the connection was replaced are
+			// restored already
+			select {
+			case <-c.dead:
+			default:
+			}
+			return
+		}
+
+		c.log.WithError(err).WithField("retryIn", delay).Error("Failed to reconnect to chain")
+
+		select {
+		case <-c.closing:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > c.maxReconnectDelay {
+			delay = c.maxReconnectDelay
+		}
+	}
+}
+
+func (c *Client) replaceConnection(ctx context.Context) error {
+	conn, err := c.dialAny(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.closing:
+		conn.rpc.Close()
+		return ErrClosed
+	default:
+	}
+
+	if conn.genesisHash != c.conn.genesisHash {
+		conn.rpc.Close()
+		return fmt.Errorf("endpoint %s has genesis hash %s instead of %s",
+			conn.rpc.url, conn.genesisHash.Hex(), c.conn.genesisHash.Hex())
+	}
+
+	for sub := range c.subs {
+		err := sub.subscribe(conn.rpc)
+		if err != nil {
+			conn.rpc.Close()
+			return fmt.Errorf("restore subscription %s_%s: %w", sub.namespace, sub.subscribeMethod, err)
+		}
+	}
+
+	c.conn.rpc.Close()
+	c.conn = conn
+
+	c.log.WithFields(logrus.Fields{
+		"endpoint":      conn.rpc.url,
+		"metaVersion":   conn.metadata.Version,
+		"subscriptions": len(c.subs),
+	}).Info("Reconnected to chain")
+
+	return nil
+}
+
+// dialAny connects to the first available endpoint, in order of preference
+func (c *Client) dialAny(ctx context.Context) (*connection, error) {
+	var errs []error
+	for _, endpoint := range c.endpoints {
+		conn, err := dial(ctx, endpoint)
+		if err == nil {
+			return conn, nil
+		}
+		c.log.WithError(err).WithField("endpoint", endpoint).Warn("Failed to connect to endpoint")
+		errs = append(errs, err)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("failed to connect to any of %d endpoints: %v", len(c.endpoints), errs)
+}
+
+func dial(ctx context.Context, endpoint string) (*connection, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, DialTimeout)
+	defer cancel()
+
+	gc, err := gethrpc.DialContext(dialCtx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	rc := &rpcClient{Client: gc, url: endpoint}
+
+	// Unlike rpc.NewRPC, keeps the metadata it fetches
+	st := state.NewState(rc)
+	meta, err := st.GetMetadataLatest()
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	types.SetSerDeOptions(types.SerDeOptionsFromMetadata(meta))
+
+	r := &rpc.RPC{
+		Author:   author.NewAuthor(rc),
+		Chain:    rpcchain.NewChain(rc),
+		MMR:      mmr.NewMMR(rc),
+		Offchain: offchain.NewOffchain(rc),
+		State:    st,
+		System:   system.NewSystem(rc),
+	}
+
+	genesisHash, err := r.Chain.GetBlockHash(0)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return &connection{
+		api:         &gsrpc.SubstrateAPI{RPC: r, Client: rc},
+		rpc:         rc,
+		metadata:    meta,
+		genesisHash: genesisHash,
+	}, nil
+}
+
+// Subscription is a subscription which follows its client across reconnections
+type Subscription struct {
+	client             *Client
+	namespace          string
+	subscribeMethod    string
+	unsubscribeMethod  string
+	notificationMethod string
+	channel            interface{}
+	args               []interface{}
+
+	mu     sync.Mutex
+	sub    *gethrpc.ClientSubscription
+	err    chan error
+	closed bool
+}
+
+// subscribe (re)creates the subscription on the given connection
+func (s *Subscription) subscribe(rc *rpcClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DialTimeout)
+	defer cancel()
+
+	sub, err := rc.Subscribe(ctx, s.namespace, s.subscribeMethod, s.unsubscribeMethod,
+		s.notificationMethod, s.channel, s.args...)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.sub = sub
+	s.mu.Unlock()
+
+	go s.watch(sub)
+
+	return nil
+}
+
+// watch signals the client when the connection of a subscription dies. Subscriptions
+// ended by closing their connection don't report an error.
+func (s *Subscription) watch(sub *gethrpc.ClientSubscription) {
+	err, ok := <-sub.Err()
+	if !ok || err == nil {
+		return
+	}
+
+	s.client.log.WithError(err).WithField("method", s.namespace+"_"+s.subscribeMethod).Warn("Subscription ended")
+	s.client.signalDead()
+}
+
+// Err returns a channel which receives nil when the client is closed, and which is
+// closed by Unsubscribe. Unlike gsrpc subscriptions, it doesn't report dropped
+// connections, which are recovered from.
+func (s *Subscription) Err() <-chan error {
+	return s.err
+}
+
+// Unsubscribe ends the subscription. It can safely be called more than once.
+func (s *Subscription) Unsubscribe() {
+	s.client.unsubscribe(s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.sub.Unsubscribe()
+	close(s.err)
+}
+
+// end is called by the client on closing
+func (s *Subscription) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err <- nil
+}
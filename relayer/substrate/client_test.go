@@ -0,0 +1,224 @@
+package substrate
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	gethrpc "github.com/snowfork/go-substrate-rpc-client/v3/gethrpc"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type testState struct{}
+
+func (testState) GetMetadata() string {
+	return types.ExamplaryMetadataV12PolkadotString
+}
+
+type testSystem struct{}
+
+func (testSystem) Health() map[string]interface{} {
+	return map[string]interface{}{"peers": 1, "isSyncing": false, "shouldHavePeers": true}
+}
+
+// testChain accepts subscriptions without sending any notifications
+type testChain struct {
+	genesisHash   string
+	subscriptions int32
+}
+
+func (c *testChain) GetBlockHash(_ uint64) string {
+	return c.genesisHash
+}
+
+func (c *testChain) SubscribeFinalizedHeads() string {
+	atomic.AddInt32(&c.subscriptions, 1)
+	return "subscription"
+}
+
+func (c *testChain) UnsubscribeFinalizedHeads(_ string) bool {
+	return true
+}
+
+type testNode struct {
+	chain  *testChain
+	rpc    *gethrpc.Server
+	http   *httptest.Server
+	wsURL  string
+	closed bool
+}
+
+var testGenesisHash = types.NewHash([]byte{1}).Hex()
+
+func newTestNode(t *testing.T) *testNode {
+	return newTestNodeWithGenesisHash(t, testGenesisHash)
+}
+
+func newTestNodeWithGenesisHash(t *testing.T, genesisHash string) *testNode {
+	node := &testNode{chain: &testChain{genesisHash: genesisHash}, rpc: gethrpc.NewServer()}
+	for name, service := range map[string]interface{}{
+		"state":  testState{},
+		"system": testSystem{},
+		"chain":  node.chain,
+	} {
+		err := node.rpc.RegisterName(name, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node.http = httptest.NewServer(node.rpc.WebsocketHandler([]string{"*"}))
+	node.wsURL = "ws" + strings.TrimPrefix(node.http.URL, "http")
+	return node
+}
+
+// stop drops all connections and refuses new ones
+func (n *testNode) stop() {
+	if n.closed {
+		return
+	}
+	n.closed = true
+	n.rpc.Stop()
+	n.http.Close()
+}
+
+func newTestClient(endpoints ...string) *Client {
+	client := NewClient(endpoints, logrus.WithField("test", "substrate"))
+	client.minReconnectDelay = 10 * time.Millisecond
+	client.maxReconnectDelay = 50 * time.Millisecond
+	return client
+}
+
+func TestClient_ConnectsToFallbackEndpoint(t *testing.T) {
+	node := newTestNode(t)
+	defer node.stop()
+
+	// Nothing listens on the discard port
+	client := newTestClient("ws://127.0.0.1:9/", node.wsURL)
+	defer client.Close()
+
+	err := client.Connect(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, node.wsURL, client.Endpoint())
+}
+
+func TestClient_ReconnectsAndRestoresSubscriptions(t *testing.T) {
+	primary := newTestNode(t)
+	defer primary.stop()
+	fallback := newTestNode(t)
+	defer fallback.stop()
+
+	client := newTestClient(primary.wsURL, fallback.wsURL)
+	defer client.Close()
+
+	err := client.Connect(context.Background())
+	assert.Nil(t, err)
+
+	ch := make(chan types.Header)
+	sub, err := client.Subscribe("chain", "subscribeFinalizedHeads", "unsubscribeFinalizedHeads", "finalizedHead", ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&primary.chain.subscriptions))
+
+	primary.stop()
+
+	assert.Eventually(t, func() bool {
+		return client.Endpoint() == fallback.wsURL
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fallback.chain.subscriptions))
+
+	var health interface{}
+	err = client.API().Client.Call(&health, "system_health")
+	assert.Nil(t, err)
+}
+
+func TestClient_RejectsOtherChain(t *testing.T) {
+	primary := newTestNode(t)
+	defer primary.stop()
+	other := newTestNodeWithGenesisHash(t, types.NewHash([]byte{2}).Hex())
+	defer other.stop()
+
+	client := newTestClient(primary.wsURL, other.wsURL)
+	defer client.Close()
+
+	err := client.Connect(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, testGenesisHash, client.GenesisHash().Hex())
+
+	primary.stop()
+
+	<-time.After(200 * time.Millisecond)
+	assert.Equal(t, primary.wsURL, client.Endpoint())
+}
+
+func TestAcquireClient(t *testing.T) {
+	node := newTestNode(t)
+	defer node.stop()
+
+	endpoints := []string{node.wsURL}
+	first := AcquireClient(endpoints)
+	second := AcquireClient(endpoints)
+	assert.Same(t, first, second)
+
+	for _, client := range []*Client{first, second} {
+		err := client.Connect(context.Background())
+		assert.Nil(t, err)
+	}
+	assert.NotNil(t, first.Metadata())
+
+	ch := make(chan types.Header)
+	first.Release()
+	sub, err := second.Subscribe("chain", "subscribeFinalizedHeads", "unsubscribeFinalizedHeads", "finalizedHead", ch)
+	assert.Nil(t, err)
+	sub.Unsubscribe()
+
+	second.Release()
+	_, err = second.Subscribe("chain", "subscribeFinalizedHeads", "unsubscribeFinalizedHeads", "finalizedHead", ch)
+	assert.Equal(t, ErrClosed, err)
+
+	third := AcquireClient(endpoints)
+	defer third.Release()
+	assert.NotSame(t, first, third)
+}
+
+func TestClient_Close(t *testing.T) {
+	node := newTestNode(t)
+	defer node.stop()
+
+	client := newTestClient(node.wsURL)
+	err := client.Connect(context.Background())
+	assert.Nil(t, err)
+
+	ch := make(chan types.Header)
+	sub, err := client.Subscribe("chain", "subscribeFinalizedHeads", "unsubscribeFinalizedHeads", "finalizedHead", ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Close()
+
+	select {
+	case err := <-sub.Err():
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("subscription didn't end")
+	}
+	sub.Unsubscribe()
+
+	var health interface{}
+	err = client.API().Client.Call(&health, "system_health")
+	assert.Error(t, err)
+
+	_, err = client.Subscribe("chain", "subscribeFinalizedHeads", "unsubscribeFinalizedHeads", "finalizedHead", ch)
+	assert.Equal(t, ErrClosed, err)
+
+	// Safe to call more than once
+	client.Close()
+}
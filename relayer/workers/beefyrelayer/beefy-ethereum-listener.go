@@ -23,6 +23,7 @@ type BeefyEthereumListener struct {
 	ethereumConfig   *ethereum.Config
 	ethereumConn     *ethereum.Connection
 	beefyDB          *store.Database
+	stateMachine     *store.StateMachine
 	beefyLightClient *beefylightclient.Contract
 	beefyMessages    chan<- store.BeefyRelayInfo
 	dbMessages       chan<- store.DatabaseCmd
@@ -32,12 +33,13 @@ type BeefyEthereumListener struct {
 }
 
 func NewBeefyEthereumListener(ethereumConfig *ethereum.Config, ethereumConn *ethereum.Connection, beefyDB *store.Database,
-	beefyMessages chan<- store.BeefyRelayInfo, dbMessages chan<- store.DatabaseCmd, headers chan<- chain.Header,
-	log *logrus.Entry) *BeefyEthereumListener {
+	stateMachine *store.StateMachine, beefyMessages chan<- store.BeefyRelayInfo, dbMessages chan<- store.DatabaseCmd,
+	headers chan<- chain.Header, log *logrus.Entry) *BeefyEthereumListener {
 	return &BeefyEthereumListener{
 		ethereumConfig:  ethereumConfig,
 		ethereumConn:    ethereumConn,
 		beefyDB:         beefyDB,
+		stateMachine:    stateMachine,
 		dbMessages:      dbMessages,
 		beefyMessages:   beefyMessages,
 		headers:         headers,
@@ -99,6 +101,7 @@ func (li *BeefyEthereumListener) pollHistoricEventsAndHeaders(ctx context.Contex
 	li.processHistoricalFinalVerificationSuccessfulEvents(ctx, blockNumber, latestBlockNumber)
 	// Send transactions for items in database based on their statuses
 	li.forwardWitnessedBeefyJustifications()
+	li.expireItems(latestBlockNumber)
 	li.forwardReadyToCompleteItems(ctx, blockNumber, descendantsUntilFinal)
 	return nil
 }
@@ -117,6 +120,7 @@ func (li *BeefyEthereumListener) pollEventsAndHeaders(ctx context.Context, desce
 			blockNumber := gethheader.Number.Uint64()
 			li.forwardWitnessedBeefyJustifications()
 			li.processInitialVerificationSuccessfulEvents(ctx, blockNumber)
+			li.expireItems(blockNumber)
 			li.forwardReadyToCompleteItems(ctx, blockNumber, descendantsUntilFinal)
 			li.processFinalVerificationSuccessfulEvents(ctx, blockNumber)
 		}
@@ -180,18 +184,13 @@ func (li *BeefyEthereumListener) processHistoricalInitialVerificationSuccessfulE
 			generatedPayload := li.simulatePayloadGeneration(*item)
 			if generatedPayload == validationData.CommitmentHash {
 				// Update existing database item
-				li.log.Infof(
-					"Updating item %s status from 'CommitmentWitnessed' to 'InitialVerificationTxConfirmed'",
-					event.Id,
-				)
 				instructions := map[string]interface{}{
-					"contract_id":             event.Id.Int64(),
-					"status":                  store.InitialVerificationTxConfirmed,
-					"initial_verification_tx": event.Raw.TxHash.Hex(),
-					"complete_on_block":       event.Raw.BlockNumber + li.blockWaitPeriod,
+					"contract_id":                  event.Id.Int64(),
+					"initial_verification_tx_hash": event.Raw.TxHash,
+					"complete_on_block":            event.Raw.BlockNumber + li.blockWaitPeriod,
 				}
-				updateCmd := store.NewDatabaseCmd(item, store.Update, instructions)
-				li.dbMessages <- updateCmd
+				li.transition(item, store.InitialVerificationTxConfirmed, event.Raw.BlockNumber,
+					"InitialVerificationSuccessful event found while syncing", instructions)
 
 				itemFoundInDatabase = true
 				break
@@ -235,17 +234,12 @@ func (li *BeefyEthereumListener) processInitialVerificationSuccessfulEvents(ctx
 			continue
 		}
 
-		li.log.Infof(
-			"3: Updating item %s status from 'InitialVerificationTxSent' to 'InitialVerificationTxConfirmed'",
-			event.Id,
-		)
 		instructions := map[string]interface{}{
 			"contract_id":       event.Id.Int64(),
-			"status":            store.InitialVerificationTxConfirmed,
 			"complete_on_block": event.Raw.BlockNumber + li.blockWaitPeriod,
 		}
-		updateCmd := store.NewDatabaseCmd(item, store.Update, instructions)
-		li.dbMessages <- updateCmd
+		li.transition(item, store.InitialVerificationTxConfirmed, event.Raw.BlockNumber,
+			"InitialVerificationSuccessful event", instructions)
 	}
 }
 
@@ -292,13 +286,9 @@ func (li *BeefyEthereumListener) processHistoricalFinalVerificationSuccessfulEve
 
 	for _, event := range events {
 		item := li.beefyDB.GetItemByID(event.Id.Int64())
-		if int64(item.ID) == event.Id.Int64() {
-			li.log.Infof(
-				"Deleting finalized item %s from the database",
-				event.Id,
-			)
-			deleteCmd := store.NewDatabaseCmd(item, store.Delete, nil)
-			li.dbMessages <- deleteCmd
+		if item.ID != 0 {
+			li.transition(item, store.Completed, event.Raw.BlockNumber,
+				"FinalVerificationSuccessful event found while syncing", nil)
 		} else {
 			li.log.Error("BEEFY justification data not found in database for FinalVerificationSuccessful event. Ignoring event.")
 		}
@@ -328,14 +318,43 @@ func (li *BeefyEthereumListener) processFinalVerificationSuccessfulEvents(ctx co
 			continue
 		}
 
-		li.log.Infof(
-			"6: Deleting finalized item %s from the database",
-			event.Id,
-		)
-
 		item := li.beefyDB.GetItemByID(event.Id.Int64())
-		deleteCmd := store.NewDatabaseCmd(item, store.Delete, nil)
-		li.dbMessages <- deleteCmd
+		if item.ID == 0 {
+			li.log.WithField("contractID", event.Id).Warn("BEEFY justification data not found in database for FinalVerificationSuccessful event")
+			continue
+		}
+		li.transition(item, store.Completed, event.Raw.BlockNumber, "FinalVerificationSuccessful event", nil)
+	}
+}
+
+// transition passes a change of an item's status to the database
+func (li *BeefyEthereumListener) transition(item *store.BeefyRelayInfo, to store.Status, blockNumber uint64,
+	reason string, instructions map[string]interface{}) {
+	cmd, err := li.stateMachine.Transition(item, to, blockNumber, reason, instructions)
+	if err != nil {
+		li.log.WithError(err).Error("Invalid item status change")
+		return
+	}
+	li.dbMessages <- cmd
+}
+
+// expireItems resends or abandons items which stayed in their status beyond its deadline
+func (li *BeefyEthereumListener) expireItems(blockNumber uint64) {
+	for _, status := range []store.Status{
+		store.InitialVerificationTxSent,
+		store.InitialVerificationTxConfirmed,
+		store.CompleteVerificationTxSent,
+	} {
+		for _, item := range li.beefyDB.GetItemsByStatus(status) {
+			cmd, ok, err := li.stateMachine.Expire(item, blockNumber)
+			if err != nil {
+				li.log.WithError(err).Error("Invalid item status change")
+				continue
+			}
+			if ok {
+				li.dbMessages <- cmd
+			}
+		}
 	}
 }
 
@@ -371,17 +390,20 @@ func (li *BeefyEthereumListener) forwardReadyToCompleteItems(ctx context.Context
 		li.log.Info(fmt.Sprintf("Found %d item(s) in database awaiting completion block", len(initialVerificationItems)))
 	}
 	for _, item := range initialVerificationItems {
+		// Items whose completion window closed are left to expire
+		if blockNumber > item.CompleteOnBlock+li.stateMachine.Deadlines.CompletionWindow {
+			continue
+		}
 		if item.CompleteOnBlock+descendantsUntilFinal <= blockNumber {
 			// Fetch intended completion block's hash
 			block, err := li.ethereumConn.GetClient().BlockByNumber(ctx, big.NewInt(int64(item.CompleteOnBlock)))
 			if err != nil {
 				li.log.WithError(err).Error("Failure fetching inclusion block")
+				continue
 			}
 
-			li.log.Infof(
-				"4: Updating item %v status from 'InitialVerificationTxConfirmed' to 'ReadyToComplete'",
-				item.ID,
-			)
+			// ReadyToComplete is held in memory until the writer sends the completion
+			li.log.WithField("id", item.ID).Info("Item ready to complete")
 			item.Status = store.ReadyToComplete
 			item.RandomSeed = block.Hash()
 			li.beefyMessages <- *item
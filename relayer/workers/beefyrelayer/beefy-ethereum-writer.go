@@ -8,6 +8,7 @@ import (
 
 	"golang.org/x/sync/errgroup"
 
+	geth "github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -22,19 +23,23 @@ type BeefyEthereumWriter struct {
 	ethereumConfig   *ethereum.Config
 	ethereumConn     *ethereum.Connection
 	beefyDB          *store.Database
+	stateMachine     *store.StateMachine
 	beefyLightClient *beefylightclient.Contract
+	lightClient      *beefyLightClient
+	blockWaitPeriod  uint64
 	databaseMessages chan<- store.DatabaseCmd
 	beefyMessages    <-chan store.BeefyRelayInfo
 	log              *logrus.Entry
 }
 
 func NewBeefyEthereumWriter(ethereumConfig *ethereum.Config, ethereumConn *ethereum.Connection, beefyDB *store.Database,
-	databaseMessages chan<- store.DatabaseCmd, beefyMessages <-chan store.BeefyRelayInfo,
+	stateMachine *store.StateMachine, databaseMessages chan<- store.DatabaseCmd, beefyMessages <-chan store.BeefyRelayInfo,
 	log *logrus.Entry) *BeefyEthereumWriter {
 	return &BeefyEthereumWriter{
 		ethereumConfig:   ethereumConfig,
 		ethereumConn:     ethereumConn,
 		beefyDB:          beefyDB,
+		stateMachine:     stateMachine,
 		databaseMessages: databaseMessages,
 		beefyMessages:    beefyMessages,
 		log:              log,
@@ -43,11 +48,18 @@ func NewBeefyEthereumWriter(ethereumConfig *ethereum.Config, ethereumConn *ether
 
 func (wr *BeefyEthereumWriter) Start(ctx context.Context, eg *errgroup.Group) error {
 
-	beefyLightClientContract, err := beefylightclient.NewContract(common.HexToAddress(wr.ethereumConfig.BeefyLightClient), wr.ethereumConn.GetClient())
+	lightClient, err := newBeefyLightClient(common.HexToAddress(wr.ethereumConfig.BeefyLightClient), wr.ethereumConn)
 	if err != nil {
 		return err
 	}
-	wr.beefyLightClient = beefyLightClientContract
+	wr.lightClient = lightClient
+	wr.beefyLightClient = lightClient.contract
+
+	blockWaitPeriod, err := lightClient.contract.BLOCKWAITPERIOD(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return err
+	}
+	wr.blockWaitPeriod = blockWaitPeriod
 
 	eg.Go(func() error {
 		return wr.writeMessagesLoop(ctx)
@@ -136,6 +148,19 @@ func (wr *BeefyEthereumWriter) WriteNewSignatureCommitment(ctx context.Context,
 		GasLimit: 5000000,
 	}
 
+	// Read before sending, so that a failure leaves no transaction unrecorded
+	blockNumber, err := wr.ethereumConn.GetClient().BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+
+	if info.InitialVerificationTxHash != (common.Hash{}) {
+		resend, err := wr.prepareResend(ctx, &info, &options, blockNumber)
+		if err != nil || !resend {
+			return err
+		}
+	}
+
 	tx, err := contract.NewSignatureCommitment(&options, msg.CommitmentHash,
 		msg.ValidatorClaimsBitfield, msg.ValidatorSignatureCommitment,
 		msg.ValidatorPosition, msg.ValidatorPublicKey, msg.ValidatorPublicKeyMerkleProof)
@@ -148,10 +173,88 @@ func (wr *BeefyEthereumWriter) WriteNewSignatureCommitment(ctx context.Context,
 		"txHash": tx.Hash().Hex(),
 	}).Info("New Signature Commitment transaction submitted")
 
-	wr.log.Info("1: Creating item in Database with status 'InitialVerificationTxSent'")
-	info.Status = store.InitialVerificationTxSent
+	// Witnessed commitments are created in the database, while resent ones are updated
 	info.InitialVerificationTxHash = tx.Hash()
-	cmd := store.NewDatabaseCmd(&info, store.Create, nil)
+	info.InitialVerificationTxNonce = tx.Nonce()
+	instructions := map[string]interface{}{
+		"initial_verification_tx_hash":  tx.Hash(),
+		"initial_verification_tx_nonce": tx.Nonce(),
+	}
+	return wr.transition(&info, store.InitialVerificationTxSent, blockNumber,
+		"NewSignatureCommitment transaction submitted", instructions)
+}
+
+// prepareResend checks the last initial verification sent for an item before it's sent again,
+// and returns false if it must not be. An item whose transaction was mined is confirmed instead.
+// While the transaction may still be mined, the new one reuses its nonce to replace it, paying
+// enough more gas for nodes to accept the replacement.
+func (wr *BeefyEthereumWriter) prepareResend(ctx context.Context, info *store.BeefyRelayInfo,
+	options *bind.TransactOpts, blockNumber uint64) (bool, error) {
+	client := wr.ethereumConn.GetClient()
+	log := wr.log.WithFields(logrus.Fields{
+		"id":     info.ID,
+		"txHash": info.InitialVerificationTxHash.Hex(),
+		"nonce":  info.InitialVerificationTxNonce,
+	})
+
+	previous, pending, err := client.TransactionByHash(ctx, info.InitialVerificationTxHash)
+	if err != nil && err != geth.NotFound {
+		return false, err
+	}
+
+	if err == nil && !pending {
+		confirmed, ok, err := store.ConfirmInitialVerification(ctx, wr.lightClient,
+			wr.ethereumConn.GetKP().CommonAddress(), wr.blockWaitPeriod, info)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			log.Info("Previous initial verification was mined")
+			return false, wr.transition(info, store.InitialVerificationTxConfirmed, blockNumber,
+				"previous initial verification was mined", confirmed)
+		}
+		// The transaction failed, using up its nonce
+		return true, nil
+	}
+
+	if err == geth.NotFound {
+		// A dropped transaction may still be mined unless its nonce was used up
+		minedNonce, err := client.NonceAt(ctx, options.From, nil)
+		if err != nil {
+			return false, err
+		}
+		if info.InitialVerificationTxNonce < minedNonce {
+			return true, nil
+		}
+		log.Info("Replacing dropped initial verification")
+		options.Nonce = new(big.Int).SetUint64(info.InitialVerificationTxNonce)
+		return true, nil
+	}
+
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		return false, err
+	}
+	// Nodes only accept replacements paying at least 10% more
+	minGasPrice := new(big.Int).Div(new(big.Int).Mul(previous.GasPrice(), big.NewInt(11)), big.NewInt(10))
+	minGasPrice.Add(minGasPrice, big.NewInt(1))
+	if gasPrice.Cmp(minGasPrice) < 0 {
+		gasPrice = minGasPrice
+	}
+
+	log.WithField("gasPrice", gasPrice).Info("Replacing pending initial verification")
+	options.Nonce = new(big.Int).SetUint64(previous.Nonce())
+	options.GasPrice = gasPrice
+	return true, nil
+}
+
+// transition passes a change of an item's status at an Ethereum block to the database
+func (wr *BeefyEthereumWriter) transition(info *store.BeefyRelayInfo, to store.Status, blockNumber uint64,
+	reason string, instructions map[string]interface{}) error {
+	cmd, err := wr.stateMachine.Transition(info, to, blockNumber, reason, instructions)
+	if err != nil {
+		return err
+	}
 	wr.databaseMessages <- cmd
 
 	return nil
@@ -221,6 +324,12 @@ func (wr *BeefyEthereumWriter) WriteCompleteSignatureCommitment(ctx context.Cont
 		return err
 	}
 
+	// Read before sending, so that a failure leaves no transaction unrecorded
+	blockNumber, err := wr.ethereumConn.GetClient().BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+
 	tx, err := contract.CompleteSignatureCommitment(&options,
 		msg.ID,
 		msg.Commitment,
@@ -237,14 +346,9 @@ func (wr *BeefyEthereumWriter) WriteCompleteSignatureCommitment(ctx context.Cont
 		"txHash": tx.Hash().Hex(),
 	}).Info("Complete Signature Commitment transaction submitted")
 
-	// Update item's status in database
-	wr.log.Info("5: Updating item status from 'ReadyToComplete' to 'CompleteVerificationTxSent'")
 	instructions := map[string]interface{}{
-		"status":                        store.CompleteVerificationTxSent,
 		"complete_verification_tx_hash": tx.Hash(),
 	}
-	updateCmd := store.NewDatabaseCmd(&info, store.Update, instructions)
-	wr.databaseMessages <- updateCmd
-
-	return nil
+	return wr.transition(&info, store.CompleteVerificationTxSent, blockNumber,
+		"CompleteSignatureCommitment transaction submitted", instructions)
 }
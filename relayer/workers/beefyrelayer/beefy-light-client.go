@@ -0,0 +1,99 @@
+package beefyrelayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	gethTypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/contracts/beefylightclient"
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/beefyrelayer/store"
+)
+
+// beefyLightClient reads the state of the BeefyLightClient contract which stored items are
+// reconciled against
+type beefyLightClient struct {
+	address      common.Address
+	ethereumConn *ethereum.Connection
+	contract     *beefylightclient.Contract
+}
+
+func newBeefyLightClient(address common.Address, ethereumConn *ethereum.Connection) (*beefyLightClient, error) {
+	contract, err := beefylightclient.NewContract(address, ethereumConn.GetClient())
+	if err != nil {
+		return nil, err
+	}
+	return &beefyLightClient{
+		address:      address,
+		ethereumConn: ethereumConn,
+		contract:     contract,
+	}, nil
+}
+
+func (lc *beefyLightClient) InitialVerificationID(ctx context.Context, txHash common.Hash) (int64, bool, error) {
+	receipt, err := lc.ethereumConn.GetClient().TransactionReceipt(ctx, txHash)
+	if err == geth.NotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if receipt.Status != gethTypes.ReceiptStatusSuccessful {
+		return 0, false, nil
+	}
+
+	for _, log := range receipt.Logs {
+		if log.Address != lc.address {
+			continue
+		}
+		event, err := lc.contract.ParseInitialVerificationSuccessful(*log)
+		if err != nil {
+			continue
+		}
+		return event.Id.Int64(), true, nil
+	}
+	return 0, false, fmt.Errorf("transaction %s succeeded without an InitialVerificationSuccessful event", txHash.Hex())
+}
+
+func (lc *beefyLightClient) InitialVerificationPending(ctx context.Context, txHash common.Hash) (bool, error) {
+	_, pending, err := lc.ethereumConn.GetClient().TransactionByHash(ctx, txHash)
+	if err == geth.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pending, nil
+}
+
+func (lc *beefyLightClient) ValidationData(ctx context.Context, id int64) (store.ValidationData, error) {
+	// The generated binding indexes its results before checking for errors, so the raw call is used
+	var out []interface{}
+	caller := beefylightclient.ContractCallerRaw{Contract: &lc.contract.ContractCaller}
+	err := caller.Call(&bind.CallOpts{Context: ctx}, &out, "validationData", big.NewInt(id))
+	if err != nil {
+		return store.ValidationData{}, err
+	}
+
+	senderAddress, ok1 := out[0].(common.Address)
+	commitmentHash, ok2 := out[1].([32]byte)
+	blockNumber, ok3 := out[2].(*big.Int)
+	if !ok1 || !ok2 || !ok3 {
+		return store.ValidationData{}, fmt.Errorf("unexpected validation data for ID %d: %v", id, out)
+	}
+
+	return store.ValidationData{
+		SenderAddress:  senderAddress,
+		CommitmentHash: commitmentHash,
+		BlockNumber:    blockNumber.Uint64(),
+	}, nil
+}
+
+func (lc *beefyLightClient) LatestBeefyBlock(ctx context.Context) (uint64, error) {
+	return lc.contract.LatestBeefyBlock(&bind.CallOpts{Context: ctx})
+}
@@ -53,9 +53,10 @@ func (li *BeefyRelaychainListener) onDone(ctx context.Context) error {
 func (li *BeefyRelaychainListener) subBeefyJustifications(ctx context.Context) error {
 	ch := make(chan interface{})
 
-	sub, err := li.relaychainConn.GetAPI().Client.Subscribe(context.Background(), "beefy", "subscribeJustifications", "unsubscribeJustifications", "justifications", ch)
+	sub, err := li.relaychainConn.Subscribe("beefy", "subscribeJustifications", "unsubscribeJustifications", "justifications", ch)
 	if err != nil {
-		panic(err)
+		li.log.WithError(err).Error("Failed to subscribe to BEEFY justifications")
+		return err
 	}
 	defer sub.Unsubscribe()
 
@@ -63,6 +64,8 @@ func (li *BeefyRelaychainListener) subBeefyJustifications(ctx context.Context) e
 		select {
 		case <-ctx.Done():
 			return li.onDone(ctx)
+		case err := <-sub.Err():
+			return err
 		case msg := <-ch:
 
 			signedCommitment := &store.SignedCommitment{}
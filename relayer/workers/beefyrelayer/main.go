@@ -6,6 +6,8 @@ import (
 
 	"golang.org/x/sync/errgroup"
 
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/sirupsen/logrus"
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain"
@@ -25,12 +27,20 @@ type Worker struct {
 	beefyEthereumWriter     *BeefyEthereumWriter
 	log                     *logrus.Entry
 	beefyDB                 *store.Database
+	stateMachine            *store.StateMachine
 	beefyMessages           chan store.BeefyRelayInfo
 	ethHeaders              chan chain.Header
 }
 
 const Name = "beefy-relayer"
 
+const (
+	// Ethereum blocks to wait for a transaction of the relayer to be confirmed before it's sent again
+	TxConfirmationTimeout = 20
+	// Number of initial verifications sent for a commitment before it's abandoned
+	MaxAttempts = 5
+)
+
 func NewWorker(relaychainConfig *relaychain.Config, ethereumConfig *ethereum.Config, dbConfig *store.Config, log *logrus.Entry) (*Worker, error) {
 
 	log.Info("Worker created")
@@ -49,17 +59,23 @@ func NewWorker(relaychainConfig *relaychain.Config, ethereumConfig *ethereum.Con
 		return nil, err
 	}
 
-	relaychainConn := relaychain.NewConnection(relaychainConfig.Endpoint, log)
+	relaychainConn := relaychain.NewConnection(relaychainConfig.Endpoint, log, relaychainConfig.Endpoints...)
 	ethereumConn := ethereum.NewConnection(ethereumConfig.Endpoint, ethereumKeypair, log)
 
 	beefyMessages := make(chan store.BeefyRelayInfo)
 	ethHeaders := make(chan chain.Header)
 
+	// The completion window is set once the light client's parameters are known
+	stateMachine := store.NewStateMachine(store.Deadlines{
+		TxConfirmation: TxConfirmationTimeout,
+		MaxAttempts:    MaxAttempts,
+	})
+
 	beefyEthereumListener := NewBeefyEthereumListener(ethereumConfig,
-		ethereumConn, beefyDB, beefyMessages, dbMessages, ethHeaders, log)
+		ethereumConn, beefyDB, stateMachine, beefyMessages, dbMessages, ethHeaders, log)
 
 	beefyEthereumWriter := NewBeefyEthereumWriter(ethereumConfig, ethereumConn,
-		beefyDB, dbMessages, beefyMessages, log)
+		beefyDB, stateMachine, dbMessages, beefyMessages, log)
 
 	beefyRelaychainListener := NewBeefyRelaychainListener(
 		relaychainConfig,
@@ -78,6 +94,7 @@ func NewWorker(relaychainConfig *relaychain.Config, ethereumConfig *ethereum.Con
 		beefyRelaychainListener: beefyRelaychainListener,
 		log:                     log,
 		beefyDB:                 beefyDB,
+		stateMachine:            stateMachine,
 		beefyMessages:           beefyMessages,
 		ethHeaders:              ethHeaders,
 	}, nil
@@ -86,6 +103,13 @@ func NewWorker(relaychainConfig *relaychain.Config, ethereumConfig *ethereum.Con
 func (worker *Worker) Start(ctx context.Context, eg *errgroup.Group) error {
 	worker.log.Info("Worker started")
 
+	// Clean up after ourselves
+	eg.Go(func() error {
+		<-ctx.Done()
+		worker.Stop()
+		return nil
+	})
+
 	err := worker.beefyDB.Start(ctx, eg)
 	if err != nil {
 		worker.log.WithFields(logrus.Fields{
@@ -117,6 +141,12 @@ func (worker *Worker) Start(ctx context.Context, eg *errgroup.Group) error {
 		return err
 	}
 
+	err = worker.reconcileDatabase(ctx)
+	if err != nil {
+		worker.log.WithError(err).Error("Failed to reconcile database with the light client")
+		return err
+	}
+
 	eg.Go(func() error {
 
 		err = worker.beefyEthereumListener.Start(ctx, eg, uint64(worker.ethereumConfig.DescendantsUntilFinal))
@@ -135,6 +165,42 @@ func (worker *Worker) Start(ctx context.Context, eg *errgroup.Group) error {
 	return nil
 }
 
+// reconcileDatabase updates items which were in flight when the relayer last stopped
+func (worker *Worker) reconcileDatabase(ctx context.Context) error {
+	lightClient, err := newBeefyLightClient(common.HexToAddress(worker.ethereumConfig.BeefyLightClient), worker.ethereumConn)
+	if err != nil {
+		return err
+	}
+
+	blockWaitPeriod, err := lightClient.contract.BLOCKWAITPERIOD(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return err
+	}
+
+	// Completions are sent once the random seed block is final, while the light client can still
+	// read its hash
+	errorAndSafetyBuffer, err := lightClient.contract.ERRORANDSAFETYBUFFER(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return err
+	}
+	completionWindow := store.CompletionWindow(TxConfirmationTimeout, errorAndSafetyBuffer)
+	descendantsUntilFinal := uint64(worker.ethereumConfig.DescendantsUntilFinal)
+	// Completions which aren't confirmed are resent within the window
+	if completionWindow <= descendantsUntilFinal+TxConfirmationTimeout {
+		return fmt.Errorf("completion window of %d blocks is too short to resend completions once final after %d blocks",
+			completionWindow, descendantsUntilFinal)
+	}
+	worker.stateMachine.Deadlines.CompletionWindow = completionWindow
+
+	blockNumber, err := worker.ethereumConn.GetClient().BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+
+	return worker.beefyDB.Reconcile(ctx, lightClient, worker.stateMachine, worker.ethereumConn.GetKP().CommonAddress(),
+		blockWaitPeriod, blockNumber)
+}
+
 func (worker *Worker) Stop() {
 	if worker.relaychainConn != nil {
 		worker.relaychainConn.Close()
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+)
+
+// SchemaMigration records a migration which was applied to the database
+type SchemaMigration struct {
+	Version     int `gorm:"primary_key;auto_increment:false"`
+	Description string
+	AppliedAt   time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// beefyRelayInfoV1 is the beefy_relay_info table as created by the first migration
+type beefyRelayInfoV1 struct {
+	gorm.Model
+	ValidatorAddresses         []byte
+	SignedCommitment           []byte
+	SerializedLatestMMRProof   []byte
+	ContractID                 int64
+	Status                     Status
+	InitialVerificationTxHash  common.Hash
+	CompleteOnBlock            uint64
+	RandomSeed                 common.Hash
+	CompleteVerificationTxHash common.Hash
+}
+
+func (beefyRelayInfoV1) TableName() string {
+	return "beefy_relay_info"
+}
+
+// transitionV3 is the beefy_relay_transitions table as created by the third migration
+type transitionV3 struct {
+	ID          uint `gorm:"primary_key"`
+	ItemID      uint `gorm:"index"`
+	FromStatus  Status
+	ToStatus    Status
+	Reason      string
+	BlockNumber uint64
+	CreatedAt   time.Time
+}
+
+func (transitionV3) TableName() string {
+	return "beefy_relay_transitions"
+}
+
+type migration struct {
+	version     int
+	description string
+	migrate     func(tx *gorm.DB) error
+}
+
+// migrations are applied in order, each at most once. Released migrations must not be changed:
+// schema changes are made by appending a new one. Migrations create tables from the frozen structs
+// above rather than the current models, whose later fields are added by later migrations.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create beefy_relay_info",
+		migrate: func(tx *gorm.DB) error {
+			// Databases created before migrations were introduced already have the table
+			if tx.HasTable(&beefyRelayInfoV1{}) {
+				return nil
+			}
+			return tx.CreateTable(&beefyRelayInfoV1{}).Error
+		},
+	},
+	{
+		version:     2,
+		description: "index beefy_relay_info lookups",
+		migrate: func(tx *gorm.DB) error {
+			model := tx.Model(&beefyRelayInfoV1{})
+			for _, column := range []string{"status", "contract_id", "initial_verification_tx_hash"} {
+				if err := model.AddIndex("idx_beefy_relay_info_"+column, column).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		version:     3,
+		description: "track beefy_relay_info status deadlines and history",
+		migrate: func(tx *gorm.DB) error {
+			for _, column := range []struct{ name, definition string }{
+				{"status_block", "bigint NOT NULL DEFAULT 0"},
+				{"attempts", "integer NOT NULL DEFAULT 0"},
+			} {
+				err := tx.Exec(fmt.Sprintf("ALTER TABLE beefy_relay_info ADD COLUMN %s %s", column.name, column.definition)).Error
+				if err != nil {
+					return err
+				}
+			}
+			return tx.CreateTable(&transitionV3{}).Error
+		},
+	},
+	{
+		version:     4,
+		description: "track beefy_relay_info initial verification nonces",
+		migrate: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE beefy_relay_info ADD COLUMN initial_verification_tx_nonce bigint NOT NULL DEFAULT 0").Error
+		},
+	},
+}
+
+// SchemaVersion returns the version of the last migration applied to the database, or 0 for a new one
+func SchemaVersion(db *gorm.DB) (int, error) {
+	if !db.HasTable(&SchemaMigration{}) {
+		return 0, nil
+	}
+	var applied SchemaMigration
+	err := db.Order("version desc").Take(&applied).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return applied.Version, nil
+}
+
+// migrationLockID identifies the Postgres advisory lock which serializes migrations of relayers
+// sharing a database
+const migrationLockID = 0x62656566 // "beef"
+
+// Migrate brings the database schema up to date, applying each pending migration in its own
+// transaction. Databases written by a later version of the relayer are rejected.
+func Migrate(db *gorm.DB) error {
+	err := inMigrationTransaction(db, func(tx *gorm.DB) error {
+		if tx.HasTable(&SchemaMigration{}) {
+			return nil
+		}
+		return tx.CreateTable(&SchemaMigration{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if version > latest {
+		return fmt.Errorf("database schema version %d is newer than the latest supported version %d", version, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		m := m
+		err := inMigrationTransaction(db, func(tx *gorm.DB) error {
+			// Another relayer may have applied the migration in the meantime
+			current, err := SchemaVersion(tx)
+			if err != nil {
+				return err
+			}
+			if m.version <= current {
+				return nil
+			}
+
+			if err := m.migrate(tx); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+			}
+			applied := SchemaMigration{Version: m.version, Description: m.description, AppliedAt: time.Now().UTC()}
+			return tx.Create(&applied).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inMigrationTransaction runs fn in a transaction which, on Postgres, holds the migration lock.
+// SQLite only allows a single writer, which serializes migrations already.
+func inMigrationTransaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	if tx.Dialect().GetName() == "postgres" {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
@@ -0,0 +1,125 @@
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/beefyrelayer/store"
+)
+
+func tempDatabaseConfig(t *testing.T) *store.Config {
+	dir, err := ioutil.TempDir("", "beefy-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &store.Config{Dialect: "sqlite3", DBPath: filepath.Join(dir, "beefy.db")}
+}
+
+func TestPrepareDatabase_Persistent(t *testing.T) {
+	config := tempDatabaseConfig(t)
+
+	db, err := store.PrepareDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := store.SchemaVersion(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, version)
+
+	item := loadSampleBeefyRelayInfo()
+	item.Status = store.InitialVerificationTxSent
+	assert.Nil(t, db.Create(&item).Error)
+	assert.Nil(t, db.Close())
+
+	// Reopening keeps items and doesn't apply migrations again
+	db, err = store.PrepareDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var items []store.BeefyRelayInfo
+	assert.Nil(t, db.Find(&items).Error)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, store.InitialVerificationTxSent, items[0].Status)
+	}
+
+	var applied []store.SchemaMigration
+	assert.Nil(t, db.Order("version").Find(&applied).Error)
+	if assert.Len(t, applied, 4) {
+		for i, migration := range applied {
+			assert.Equal(t, i+1, migration.Version)
+		}
+	}
+}
+
+func TestPrepareDatabase_Unversioned(t *testing.T) {
+	config := tempDatabaseConfig(t)
+
+	// A database created before migrations were introduced
+	db, err := gorm.Open(config.Dialect, config.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, db.CreateTable(&legacyBeefyRelayInfo{}).Error)
+	sample := loadSampleBeefyRelayInfo()
+	legacy := legacyBeefyRelayInfo{SignedCommitment: sample.SignedCommitment, ContractID: 4}
+	assert.Nil(t, db.Create(&legacy).Error)
+	assert.Nil(t, db.Close())
+
+	db, err = store.PrepareDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	version, err := store.SchemaVersion(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, version)
+
+	var items []store.BeefyRelayInfo
+	assert.Nil(t, db.Find(&items).Error)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, int64(4), items[0].ContractID)
+		assert.Equal(t, 0, items[0].Attempts)
+	}
+}
+
+// legacyBeefyRelayInfo is the table as created before migrations were introduced
+type legacyBeefyRelayInfo struct {
+	gorm.Model
+	ValidatorAddresses         []byte
+	SignedCommitment           []byte
+	SerializedLatestMMRProof   []byte
+	ContractID                 int64
+	Status                     store.Status
+	InitialVerificationTxHash  common.Hash
+	CompleteOnBlock            uint64
+	RandomSeed                 common.Hash
+	CompleteVerificationTxHash common.Hash
+}
+
+func (legacyBeefyRelayInfo) TableName() string {
+	return "beefy_relay_info"
+}
+
+func TestPrepareDatabase_NewerSchema(t *testing.T) {
+	config := tempDatabaseConfig(t)
+
+	db, err := store.PrepareDatabase(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, db.Create(&store.SchemaMigration{Version: 99}).Error)
+	assert.Nil(t, db.Close())
+
+	_, err = store.PrepareDatabase(config)
+	assert.Error(t, err)
+}
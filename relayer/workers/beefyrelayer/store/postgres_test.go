@@ -0,0 +1,213 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/beefyrelayer/store"
+)
+
+// Postgres isn't available to tests, so the statements sent to it are checked against a stand-in
+// driver instead
+
+const migrationLockID = 0x62656566
+
+func newPostgresMock(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("postgres", sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func expectHasTable(mock sqlmock.Sqlmock, table string, exists bool) {
+	count := 0
+	if exists {
+		count = 1
+	}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM INFORMATION_SCHEMA\.tables WHERE table_name = \$1`).
+		WithArgs(table).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
+func expectSchemaVersion(mock sqlmock.Sqlmock, version int) {
+	expectHasTable(mock, "schema_migrations", true)
+	rows := sqlmock.NewRows([]string{"version", "description", "applied_at"})
+	if version > 0 {
+		rows.AddRow(version, "", nil)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "schema_migrations" ORDER BY version desc LIMIT 1`).WillReturnRows(rows)
+}
+
+func expectMigrationLock(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).
+		WithArgs(migrationLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestMigrate_Postgres(t *testing.T) {
+	db, mock := newPostgresMock(t)
+
+	expectMigrationLock(mock)
+	expectHasTable(mock, "schema_migrations", false)
+	mock.ExpectExec(`CREATE TABLE "schema_migrations" \("version" integer,"description" text,"applied_at" timestamp with time zone , PRIMARY KEY \("version"\)\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	expectSchemaVersion(mock, 0)
+
+	expectMigrationLock(mock)
+	expectSchemaVersion(mock, 0)
+	expectHasTable(mock, "beefy_relay_info", false)
+	mock.ExpectExec(`CREATE TABLE "beefy_relay_info" \(.*"initial_verification_tx_hash" bytea,.*"complete_verification_tx_hash" bytea , PRIMARY KEY \("id"\)\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE INDEX idx_beefy_relay_info_deleted_at ON "beefy_relay_info"\(deleted_at\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "schema_migrations" \("version","description","applied_at"\) VALUES \(\$1,\$2,\$3\)`).
+		WithArgs(1, "create beefy_relay_info", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	mock.ExpectCommit()
+
+	expectMigrationLock(mock)
+	expectSchemaVersion(mock, 1)
+	for _, column := range []string{"status", "contract_id", "initial_verification_tx_hash"} {
+		mock.ExpectExec(`CREATE INDEX idx_beefy_relay_info_` + column + ` ON "beefy_relay_info"\("?` + column + `"?\)`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectQuery(`INSERT INTO "schema_migrations"`).
+		WithArgs(2, "index beefy_relay_info lookups", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectCommit()
+
+	expectMigrationLock(mock)
+	expectSchemaVersion(mock, 2)
+	mock.ExpectExec(`ALTER TABLE beefy_relay_info ADD COLUMN status_block bigint NOT NULL DEFAULT 0`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE beefy_relay_info ADD COLUMN attempts integer NOT NULL DEFAULT 0`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE "beefy_relay_transitions" \(.*"from_status" integer,"to_status" integer.*\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE INDEX idx_beefy_relay_transitions_item_id ON "beefy_relay_transitions"\("?item_id"?\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "schema_migrations"`).
+		WithArgs(3, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectCommit()
+
+	expectMigrationLock(mock)
+	expectSchemaVersion(mock, 3)
+	mock.ExpectExec(`ALTER TABLE beefy_relay_info ADD COLUMN initial_verification_tx_nonce bigint NOT NULL DEFAULT 0`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "schema_migrations"`).
+		WithArgs(4, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+	mock.ExpectCommit()
+
+	assert.Nil(t, store.Migrate(db))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrate_PostgresConcurrent(t *testing.T) {
+	db, mock := newPostgresMock(t)
+
+	expectMigrationLock(mock)
+	expectHasTable(mock, "schema_migrations", true)
+	mock.ExpectCommit()
+	expectSchemaVersion(mock, 0)
+
+	// Another relayer applied all migrations while this one waited for the lock
+	for i := 0; i < 4; i++ {
+		expectMigrationLock(mock)
+		expectSchemaVersion(mock, 4)
+		mock.ExpectCommit()
+	}
+
+	assert.Nil(t, store.Migrate(db))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetters_Postgres(t *testing.T) {
+	db, mock := newPostgresMock(t)
+	database := store.NewDatabase(db, nil, logrus.WithField("database", "Beefy"))
+	hash := common.HexToHash("0x01")
+
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "contract_id", "status"}).AddRow(3, 5, store.InitialVerificationTxSent)
+	}
+	where := `SELECT \* FROM "beefy_relay_info" WHERE "beefy_relay_info"\."deleted_at" IS NULL AND `
+
+	mock.ExpectQuery(where + `\(\("beefy_relay_info"\."status" = \$1\)\)`).
+		WithArgs(store.InitialVerificationTxSent).
+		WillReturnRows(rows())
+	mock.ExpectQuery(where + `\(\("beefy_relay_info"\."contract_id" = \$1\)\) LIMIT 1`).
+		WithArgs(5).
+		WillReturnRows(rows())
+	mock.ExpectQuery(where + `\(\("beefy_relay_info"\."initial_verification_tx_hash" = \$1\)\) LIMIT 1`).
+		WithArgs(hash.Bytes()).
+		WillReturnRows(rows())
+	mock.ExpectQuery(where + `\(\("beefy_relay_info"\."complete_verification_tx_hash" = \$1\)\) LIMIT 1`).
+		WithArgs(hash.Bytes()).
+		WillReturnRows(rows())
+	for _, status := range []store.Status{store.InitialVerificationTxSent, store.InitialVerificationTxConfirmed,
+		store.ReadyToComplete, store.CompleteVerificationTxSent} {
+		mock.ExpectQuery(where + `\(\("beefy_relay_info"\."status" = \$1\)\)`).
+			WithArgs(status).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+
+	items := database.GetItemsByStatus(store.InitialVerificationTxSent)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, uint(3), items[0].ID)
+	}
+	assert.Equal(t, int64(5), database.GetItemByID(5).ContractID)
+	assert.Equal(t, uint(3), database.GetItemByInitialVerificationTxHash(hash).ID)
+	assert.Equal(t, uint(3), database.GetItemByCompleteVerificationTxHash(hash).ID)
+	assert.Nil(t, database.Reconcile(context.Background(), nil, store.NewStateMachine(store.Deadlines{}),
+		common.Address{}, 3, 100))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestWriteLoop_PostgresFailedTransition(t *testing.T) {
+	db, mock := newPostgresMock(t)
+	messages := make(chan store.DatabaseCmd)
+	database := store.NewDatabase(db, messages, logrus.WithField("database", "Beefy"))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "beefy_relay_info" SET`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	eg, ctx := errgroup.WithContext(context.Background())
+	assert.Nil(t, database.Start(ctx, eg))
+
+	item := loadSampleBeefyRelayInfo()
+	item.ID = 3
+	item.Status = store.InitialVerificationTxSent
+	cmd, err := store.NewStateMachine(store.Deadlines{}).Transition(&item, store.InitialVerificationTxConfirmed, 105,
+		"confirmed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages <- cmd
+
+	// Failed status changes stop the relayer, like failed creations
+	assert.EqualError(t, eg.Wait(), "connection reset")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestPrepareDatabase_UnsupportedDialect(t *testing.T) {
+	_, err := store.PrepareDatabase(&store.Config{Dialect: "mysql", DBPath: "beefy"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ValidationData is what the BeefyLightClient contract keeps about an initial verification until
+// the commitment is completed
+type ValidationData struct {
+	SenderAddress  common.Address
+	CommitmentHash [32]byte
+	BlockNumber    uint64
+}
+
+// LightClient is the on-chain state stored items are reconciled against
+type LightClient interface {
+	// InitialVerificationID returns the contract ID assigned by a successful initial verification
+	// transaction, and false if the transaction didn't succeed or isn't known to the node
+	InitialVerificationID(ctx context.Context, txHash common.Hash) (int64, bool, error)
+	// InitialVerificationPending reports whether an initial verification transaction is known to
+	// the node without being mined yet
+	InitialVerificationPending(ctx context.Context, txHash common.Hash) (bool, error)
+	// ValidationData returns the validation data of a contract ID, which is zero once the
+	// commitment was completed
+	ValidationData(ctx context.Context, id int64) (ValidationData, error)
+	// LatestBeefyBlock returns the number of the last relay chain block with a completed commitment
+	LatestBeefyBlock(ctx context.Context) (uint64, error)
+}
+
+// CommitmentHash returns the hash under which the commitment is verified by the light client
+func (b *BeefyRelayInfo) CommitmentHash() ([32]byte, error) {
+	var signedCommitment SignedCommitment
+	if err := json.Unmarshal(b.SignedCommitment, &signedCommitment); err != nil {
+		return [32]byte{}, err
+	}
+	return blake2b.Sum256(signedCommitment.Commitment.Bytes()), nil
+}
+
+func (b *BeefyRelayInfo) blockNumber() (uint64, error) {
+	var signedCommitment SignedCommitment
+	if err := json.Unmarshal(b.SignedCommitment, &signedCommitment); err != nil {
+		return 0, err
+	}
+	return uint64(signedCommitment.Commitment.BlockNumber), nil
+}
+
+// Reconcile brings items which were in flight when the relayer stopped up to date with the light
+// client at an Ethereum block, as their transactions may have been mined, dropped or reverted in
+// the meantime:
+//
+//   - Items whose validation data is held by the contract are InitialVerificationTxConfirmed,
+//     so that the completion transaction is sent once the wait period has passed.
+//   - Items for blocks up to the latest completed one leave the database, as the light client no
+//     longer accepts them.
+//   - Items whose initial verification is still pending are left to its confirmation deadline.
+//   - Other items are CommitmentWitnessed again, so that the initial verification is resent.
+//
+// It must run before the listeners and writer are started.
+func (d *Database) Reconcile(ctx context.Context, lightClient LightClient, stateMachine *StateMachine,
+	sender common.Address, blockWaitPeriod, blockNumber uint64) error {
+	var items []*BeefyRelayInfo
+	for _, status := range []Status{
+		InitialVerificationTxSent,
+		InitialVerificationTxConfirmed,
+		ReadyToComplete,
+		CompleteVerificationTxSent,
+	} {
+		var withStatus []*BeefyRelayInfo
+		err := d.DB.Where(map[string]interface{}{"status": status}).Find(&withStatus).Error
+		if err != nil {
+			return err
+		}
+		items = append(items, withStatus...)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	if len(items) == 0 {
+		return nil
+	}
+
+	latestBeefyBlock, err := lightClient.LatestBeefyBlock(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.log.WithField("items", len(items)).Info("Reconciling stored items with the light client")
+
+	for _, item := range items {
+		to, reason, instructions, err := d.reconcileItem(ctx, lightClient, sender, blockWaitPeriod,
+			latestBeefyBlock, item)
+		if err != nil {
+			return err
+		}
+		if to == InitialVerificationTxSent {
+			d.log.WithField("id", item.ID).Info("Leaving pending initial verification to its deadline")
+			continue
+		}
+
+		cmd, err := stateMachine.Transition(item, to, blockNumber, reason, instructions)
+		if err != nil {
+			return err
+		}
+		if err := d.apply(cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcileItem returns the status an item moves to, and the updates of its other fields
+func (d *Database) reconcileItem(ctx context.Context, lightClient LightClient, sender common.Address,
+	blockWaitPeriod, latestBeefyBlock uint64, item *BeefyRelayInfo) (Status, string, map[string]interface{}, error) {
+	blockNumber, err := item.blockNumber()
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if blockNumber <= latestBeefyBlock {
+		reason := fmt.Sprintf("light client completed block %d", latestBeefyBlock)
+		if item.Status == CompleteVerificationTxSent {
+			return Completed, reason, nil, nil
+		}
+		return Abandoned, reason, nil, nil
+	}
+
+	witnessed := map[string]interface{}{
+		"contract_id":       0,
+		"complete_on_block": 0,
+	}
+
+	if item.Status == InitialVerificationTxSent {
+		pending, err := lightClient.InitialVerificationPending(ctx, item.InitialVerificationTxHash)
+		if err != nil {
+			return 0, "", nil, err
+		}
+		if pending {
+			return InitialVerificationTxSent, "initial verification is pending", nil, nil
+		}
+
+		confirmed, ok, err := ConfirmInitialVerification(ctx, lightClient, sender, blockWaitPeriod, item)
+		if err != nil {
+			return 0, "", nil, err
+		}
+		if !ok {
+			return CommitmentWitnessed, "initial verification wasn't confirmed", witnessed, nil
+		}
+		return InitialVerificationTxConfirmed, "light client holds validation data of the commitment", confirmed, nil
+	}
+
+	confirmed, ok, err := validationDataUpdates(ctx, lightClient, sender, blockWaitPeriod, item, item.ContractID)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if !ok {
+		return CommitmentWitnessed, "light client holds no validation data of the commitment", witnessed, nil
+	}
+	return InitialVerificationTxConfirmed, "light client holds validation data of the commitment", confirmed, nil
+}
+
+// ConfirmInitialVerification returns the updates confirming an item whose last initial
+// verification transaction was mined, so that it isn't sent again. It returns false if the
+// transaction wasn't mined or failed, or if the light client no longer holds its validation data.
+func ConfirmInitialVerification(ctx context.Context, lightClient LightClient, sender common.Address,
+	blockWaitPeriod uint64, item *BeefyRelayInfo) (map[string]interface{}, bool, error) {
+	id, ok, err := lightClient.InitialVerificationID(ctx, item.InitialVerificationTxHash)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return validationDataUpdates(ctx, lightClient, sender, blockWaitPeriod, item, id)
+}
+
+// validationDataUpdates returns the updates of an item whose commitment was verified initially
+// under a contract ID, and false if the light client holds no validation data of it
+func validationDataUpdates(ctx context.Context, lightClient LightClient, sender common.Address,
+	blockWaitPeriod uint64, item *BeefyRelayInfo, contractID int64) (map[string]interface{}, bool, error) {
+	data, err := lightClient.ValidationData(ctx, contractID)
+	if err != nil {
+		return nil, false, err
+	}
+	commitmentHash, err := item.CommitmentHash()
+	if err != nil {
+		return nil, false, err
+	}
+	if data.CommitmentHash != commitmentHash || data.SenderAddress != sender {
+		return nil, false, nil
+	}
+	return map[string]interface{}{
+		"contract_id":       contractID,
+		"complete_on_block": data.BlockNumber + blockWaitPeriod,
+	}, true, nil
+}
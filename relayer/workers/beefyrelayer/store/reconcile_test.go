@@ -0,0 +1,207 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/beefyrelayer/store"
+)
+
+type testLightClient struct {
+	initialVerifications map[common.Hash]int64
+	pending              map[common.Hash]bool
+	validationData       map[int64]store.ValidationData
+	latestBeefyBlock     uint64
+}
+
+func (lc *testLightClient) InitialVerificationID(_ context.Context, txHash common.Hash) (int64, bool, error) {
+	id, ok := lc.initialVerifications[txHash]
+	return id, ok, nil
+}
+
+func (lc *testLightClient) InitialVerificationPending(_ context.Context, txHash common.Hash) (bool, error) {
+	return lc.pending[txHash], nil
+}
+
+func (lc *testLightClient) ValidationData(_ context.Context, id int64) (store.ValidationData, error) {
+	return lc.validationData[id], nil
+}
+
+func (lc *testLightClient) LatestBeefyBlock(_ context.Context) (uint64, error) {
+	return lc.latestBeefyBlock, nil
+}
+
+func sampleItemAt(t *testing.T, blockNumber uint32, status store.Status) store.BeefyRelayInfo {
+	item := loadSampleBeefyRelayInfo()
+	var signedCommitment store.SignedCommitment
+	if err := json.Unmarshal(item.SignedCommitment, &signedCommitment); err != nil {
+		t.Fatal(err)
+	}
+	signedCommitment.Commitment.BlockNumber = types.NewU32(blockNumber)
+	data, err := json.Marshal(signedCommitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item.SignedCommitment = data
+	item.Status = status
+	return item
+}
+
+func TestReconcile(t *testing.T) {
+	db, err := store.PrepareDatabase(tempDatabaseConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := store.NewDatabase(db, nil, logrus.WithField("database", "Beefy"))
+	defer database.Stop()
+
+	sender := common.HexToAddress("0xE04CC55ebEE1cBCE552f250e85c57B70B2E2625b")
+	lightClient := &testLightClient{
+		initialVerifications: map[common.Hash]int64{},
+		pending:              map[common.Hash]bool{},
+		validationData:       map[int64]store.ValidationData{},
+		latestBeefyBlock:     900,
+	}
+
+	create := func(item store.BeefyRelayInfo) uint {
+		if err := db.Create(&item).Error; err != nil {
+			t.Fatal(err)
+		}
+		return item.ID
+	}
+	validationData := func(item store.BeefyRelayInfo, blockNumber uint64) store.ValidationData {
+		hash, err := item.CommitmentHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.ValidationData{SenderAddress: sender, CommitmentHash: hash, BlockNumber: blockNumber}
+	}
+
+	// Initial verification which was dropped
+	dropped := sampleItemAt(t, 950, store.InitialVerificationTxSent)
+	dropped.InitialVerificationTxHash = common.HexToHash("0x01")
+	droppedID := create(dropped)
+
+	// Initial verification which is still pending
+	pending := sampleItemAt(t, 955, store.InitialVerificationTxSent)
+	pending.InitialVerificationTxHash = common.HexToHash("0x03")
+	pending.StatusBlock = 120
+	lightClient.pending[pending.InitialVerificationTxHash] = true
+	pendingID := create(pending)
+
+	// Initial verification which was mined while the relayer was down
+	mined := sampleItemAt(t, 960, store.InitialVerificationTxSent)
+	mined.InitialVerificationTxHash = common.HexToHash("0x02")
+	lightClient.initialVerifications[mined.InitialVerificationTxHash] = 7
+	lightClient.validationData[7] = validationData(mined, 100)
+	minedID := create(mined)
+
+	// Completion which didn't go through
+	uncompleted := sampleItemAt(t, 970, store.CompleteVerificationTxSent)
+	uncompleted.ContractID = 8
+	lightClient.validationData[8] = validationData(uncompleted, 110)
+	uncompletedID := create(uncompleted)
+
+	// Completion which went through
+	completed := sampleItemAt(t, 900, store.CompleteVerificationTxSent)
+	completed.ContractID = 5
+	completedID := create(completed)
+
+	// Validation data held for a different commitment
+	mismatched := sampleItemAt(t, 980, store.InitialVerificationTxConfirmed)
+	mismatched.ContractID = 9
+	lightClient.validationData[9] = validationData(sampleItemAt(t, 990, 0), 120)
+	mismatchedID := create(mismatched)
+
+	witnessedID := create(sampleItemAt(t, 800, store.CommitmentWitnessed))
+
+	stateMachine := store.NewStateMachine(store.Deadlines{TxConfirmation: 20, CompletionWindow: 10, MaxAttempts: 3})
+	err = database.Reconcile(context.Background(), lightClient, stateMachine, sender, 3, 150)
+	assert.Nil(t, err)
+
+	get := func(id uint) (store.BeefyRelayInfo, bool) {
+		var item store.BeefyRelayInfo
+		err := db.Take(&item, id).Error
+		return item, err == nil
+	}
+
+	item, ok := get(droppedID)
+	assert.True(t, ok)
+	assert.Equal(t, store.CommitmentWitnessed, item.Status)
+
+	// Pending initial verifications keep their deadline
+	item, ok = get(pendingID)
+	assert.True(t, ok)
+	assert.Equal(t, store.InitialVerificationTxSent, item.Status)
+	assert.Equal(t, uint64(120), item.StatusBlock)
+	assert.Empty(t, database.GetTransitions(pendingID))
+
+	item, ok = get(minedID)
+	assert.True(t, ok)
+	assert.Equal(t, store.InitialVerificationTxConfirmed, item.Status)
+	assert.Equal(t, int64(7), item.ContractID)
+	assert.Equal(t, uint64(103), item.CompleteOnBlock)
+
+	item, ok = get(uncompletedID)
+	assert.True(t, ok)
+	assert.Equal(t, store.InitialVerificationTxConfirmed, item.Status)
+	assert.Equal(t, uint64(113), item.CompleteOnBlock)
+
+	_, ok = get(completedID)
+	assert.False(t, ok)
+	history := database.GetTransitions(completedID)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, store.Completed, history[0].ToStatus)
+		assert.Equal(t, uint64(150), history[0].BlockNumber)
+	}
+
+	item, ok = get(mismatchedID)
+	assert.True(t, ok)
+	assert.Equal(t, store.CommitmentWitnessed, item.Status)
+	assert.Equal(t, int64(0), item.ContractID)
+
+	// Witnessed items are left to the listener, whatever their block
+	item, ok = get(witnessedID)
+	assert.True(t, ok)
+	assert.Equal(t, store.CommitmentWitnessed, item.Status)
+}
+
+func TestConfirmInitialVerification(t *testing.T) {
+	sender := common.HexToAddress("0xE04CC55ebEE1cBCE552f250e85c57B70B2E2625b")
+	item := sampleItemAt(t, 950, store.CommitmentWitnessed)
+	item.InitialVerificationTxHash = common.HexToHash("0x01")
+	commitmentHash, err := item.CommitmentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lightClient := &testLightClient{
+		initialVerifications: map[common.Hash]int64{},
+		validationData:       map[int64]store.ValidationData{},
+	}
+
+	// The transaction wasn't mined, or failed
+	_, ok, err := store.ConfirmInitialVerification(context.Background(), lightClient, sender, 3, &item)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// The transaction was mined after the item was witnessed again
+	lightClient.initialVerifications[item.InitialVerificationTxHash] = 7
+	lightClient.validationData[7] = store.ValidationData{SenderAddress: sender, CommitmentHash: commitmentHash,
+		BlockNumber: 100}
+	confirmed, ok, err := store.ConfirmInitialVerification(context.Background(), lightClient, sender, 3, &item)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"contract_id": int64(7), "complete_on_block": uint64(103)}, confirmed)
+
+	// The light client no longer holds the validation data
+	delete(lightClient.validationData, 7)
+	_, ok, err = store.ConfirmInitialVerification(context.Background(), lightClient, sender, 3, &item)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+var statusNames = map[Status]string{
+	CommitmentWitnessed:            "CommitmentWitnessed",
+	InitialVerificationTxSent:      "InitialVerificationTxSent",
+	InitialVerificationTxConfirmed: "InitialVerificationTxConfirmed",
+	ReadyToComplete:                "ReadyToComplete",
+	CompleteVerificationTxSent:     "CompleteVerificationTxSent",
+	Completed:                      "Completed",
+	Abandoned:                      "Abandoned",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Terminal reports whether items leave the database on reaching the status
+func (s Status) Terminal() bool {
+	return s == Completed || s == Abandoned
+}
+
+// stored returns the status an item has in the database while it has the given status.
+// ReadyToComplete is only held in memory, between the listener and the writer.
+func (s Status) stored() Status {
+	if s == ReadyToComplete {
+		return InitialVerificationTxConfirmed
+	}
+	return s
+}
+
+// transitions lists the statuses an item may move to from each status
+var transitions = map[Status][]Status{
+	// Initial verifications of witnessed commitments may also be found on chain while syncing
+	CommitmentWitnessed: {InitialVerificationTxSent, InitialVerificationTxConfirmed, Abandoned},
+	InitialVerificationTxSent: {InitialVerificationTxConfirmed, CommitmentWitnessed, Completed,
+		Abandoned},
+	InitialVerificationTxConfirmed: {ReadyToComplete, CompleteVerificationTxSent, CommitmentWitnessed,
+		Completed, Abandoned},
+	ReadyToComplete: {CompleteVerificationTxSent, CommitmentWitnessed, Completed, Abandoned},
+	CompleteVerificationTxSent: {InitialVerificationTxConfirmed, CommitmentWitnessed, Completed,
+		Abandoned},
+}
+
+// Transition records a change of the status of an item
+type Transition struct {
+	ID         uint `gorm:"primary_key"`
+	ItemID     uint `gorm:"index"`
+	FromStatus Status
+	ToStatus   Status
+	Reason     string
+	// Ethereum block at which the change was made
+	BlockNumber uint64
+	CreatedAt   time.Time
+}
+
+func (Transition) TableName() string {
+	return "beefy_relay_transitions"
+}
+
+// BlockhashWindow is the number of most recent blocks whose hashes contracts can read
+const BlockhashWindow = 256
+
+// CompletionWindow returns the number of blocks after CompleteOnBlock during which completions
+// are sent. The light client takes the hash of CompleteOnBlock as random seed, which it can only
+// read for BlockhashWindow blocks, so completions sent at the end of the window still have
+// txConfirmation blocks, and a safety buffer, to be included. It's 0 if there's no such window.
+func CompletionWindow(txConfirmation, safetyBuffer uint64) uint64 {
+	if txConfirmation+safetyBuffer >= BlockhashWindow {
+		return 0
+	}
+	return BlockhashWindow - txConfirmation - safetyBuffer
+}
+
+// Deadlines bound the number of Ethereum blocks items spend in a status
+type Deadlines struct {
+	// Blocks to wait for a transaction of the relayer to be confirmed before it's sent again
+	TxConfirmation uint64
+	// Blocks after CompleteOnBlock during which the completion transaction is still sent
+	CompletionWindow uint64
+	// Number of initial verifications after which an item is abandoned
+	MaxAttempts int
+}
+
+// StateMachine moves items through the statuses of a relay, checking that transitions are
+// allowed and that items don't stay in a status beyond its deadline
+type StateMachine struct {
+	Deadlines Deadlines
+}
+
+func NewStateMachine(deadlines Deadlines) *StateMachine {
+	return &StateMachine{Deadlines: deadlines}
+}
+
+// CanTransition reports whether items may move between the statuses. Items may also be updated
+// without changing their status.
+func (sm *StateMachine) CanTransition(from, to Status) bool {
+	if from == to {
+		return !from.Terminal()
+	}
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition returns the command moving an item to a status at an Ethereum block. Instructions
+// update other fields of stored items, while new items are created from their fields. Items
+// reaching a terminal status are deleted.
+func (sm *StateMachine) Transition(item *BeefyRelayInfo, to Status, blockNumber uint64, reason string,
+	instructions map[string]interface{}) (DatabaseCmd, error) {
+	if !sm.CanTransition(item.Status, to) {
+		return DatabaseCmd{}, fmt.Errorf("item %d can't move from %s to %s", item.ID, item.Status, to)
+	}
+
+	transition := &Transition{
+		ItemID:      item.ID,
+		FromStatus:  item.Status,
+		ToStatus:    to,
+		Reason:      reason,
+		BlockNumber: blockNumber,
+	}
+
+	attempts := item.Attempts
+	if to == InitialVerificationTxSent {
+		attempts++
+	}
+
+	if item.ID == 0 {
+		if to.Terminal() {
+			return DatabaseCmd{}, fmt.Errorf("item can't be created as %s", to)
+		}
+		item.Status = to
+		item.StatusBlock = blockNumber
+		item.Attempts = attempts
+		return DatabaseCmd{Info: item, Type: Create, Transition: transition}, nil
+	}
+
+	updates := map[string]interface{}{}
+	for column, value := range instructions {
+		updates[column] = value
+	}
+	updates["status"] = to.stored()
+	updates["status_block"] = blockNumber
+	updates["attempts"] = attempts
+
+	cmdType := Update
+	if to.Terminal() {
+		cmdType = Delete
+	}
+	return DatabaseCmd{Info: item, Type: cmdType, Instructions: updates, Transition: transition}, nil
+}
+
+// Expire returns the command for an item which stayed in its status beyond the deadline, if any.
+// Unconfirmed completions are resent while the completion window is open. Otherwise, the item
+// starts over with a new initial verification, unless its attempts are used up, in which case
+// it's abandoned.
+func (sm *StateMachine) Expire(item *BeefyRelayInfo, blockNumber uint64) (DatabaseCmd, bool, error) {
+	deadlines := sm.Deadlines
+	windowEnd := item.CompleteOnBlock + deadlines.CompletionWindow
+
+	var reason string
+	switch item.Status {
+	case InitialVerificationTxSent:
+		if blockNumber <= item.StatusBlock+deadlines.TxConfirmation {
+			return DatabaseCmd{}, false, nil
+		}
+		reason = fmt.Sprintf("initial verification not confirmed within %d blocks", deadlines.TxConfirmation)
+	case InitialVerificationTxConfirmed, ReadyToComplete:
+		if blockNumber <= windowEnd {
+			return DatabaseCmd{}, false, nil
+		}
+		reason = fmt.Sprintf("completion window closed at block %d", windowEnd)
+	case CompleteVerificationTxSent:
+		if blockNumber <= item.StatusBlock+deadlines.TxConfirmation {
+			return DatabaseCmd{}, false, nil
+		}
+		reason = fmt.Sprintf("completion not confirmed within %d blocks", deadlines.TxConfirmation)
+		if blockNumber <= windowEnd {
+			cmd, err := sm.Transition(item, InitialVerificationTxConfirmed, blockNumber, reason, nil)
+			return cmd, err == nil, err
+		}
+		reason = fmt.Sprintf("%s and completion window closed at block %d", reason, windowEnd)
+	default:
+		return DatabaseCmd{}, false, nil
+	}
+
+	if item.Attempts >= deadlines.MaxAttempts {
+		reason = fmt.Sprintf("%s after %d attempts", reason, item.Attempts)
+		cmd, err := sm.Transition(item, Abandoned, blockNumber, reason, nil)
+		return cmd, err == nil, err
+	}
+	cmd, err := sm.Transition(item, CommitmentWitnessed, blockNumber, reason, map[string]interface{}{
+		"contract_id":       0,
+		"complete_on_block": 0,
+	})
+	return cmd, err == nil, err
+}
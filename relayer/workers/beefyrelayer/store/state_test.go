@@ -0,0 +1,214 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/beefyrelayer/store"
+)
+
+func newTestStateMachine() *store.StateMachine {
+	return store.NewStateMachine(store.Deadlines{
+		TxConfirmation:   20,
+		CompletionWindow: store.CompletionWindow(20, 10),
+		MaxAttempts:      2,
+	})
+}
+
+func TestCompletionWindow(t *testing.T) {
+	// The hash of the random seed block can be read for 256 blocks
+	assert.Equal(t, uint64(226), store.CompletionWindow(20, 10))
+	assert.Equal(t, uint64(0), store.CompletionWindow(200, 56))
+}
+
+func TestStateMachine_CanTransition(t *testing.T) {
+	sm := newTestStateMachine()
+
+	assert.True(t, sm.CanTransition(store.CommitmentWitnessed, store.InitialVerificationTxSent))
+	assert.True(t, sm.CanTransition(store.InitialVerificationTxSent, store.InitialVerificationTxConfirmed))
+	assert.True(t, sm.CanTransition(store.ReadyToComplete, store.CompleteVerificationTxSent))
+	assert.True(t, sm.CanTransition(store.CompleteVerificationTxSent, store.Completed))
+	assert.True(t, sm.CanTransition(store.InitialVerificationTxConfirmed, store.InitialVerificationTxConfirmed))
+
+	assert.False(t, sm.CanTransition(store.CommitmentWitnessed, store.CompleteVerificationTxSent))
+	assert.False(t, sm.CanTransition(store.CommitmentWitnessed, store.Completed))
+	assert.False(t, sm.CanTransition(store.Completed, store.CommitmentWitnessed))
+	assert.False(t, sm.CanTransition(store.Abandoned, store.Abandoned))
+
+	item := loadSampleBeefyRelayInfo()
+	_, err := sm.Transition(&item, store.ReadyToComplete, 1, "", nil)
+	assert.Error(t, err)
+}
+
+func TestStateMachine_Transition(t *testing.T) {
+	sm := newTestStateMachine()
+
+	// Witnessed commitments are created on their first initial verification
+	item := loadSampleBeefyRelayInfo()
+	cmd, err := sm.Transition(&item, store.InitialVerificationTxSent, 100, "sent", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, store.Create, cmd.Type)
+	assert.Equal(t, store.InitialVerificationTxSent, cmd.Info.Status)
+	assert.Equal(t, uint64(100), cmd.Info.StatusBlock)
+	assert.Equal(t, 1, cmd.Info.Attempts)
+	assert.Equal(t, store.CommitmentWitnessed, cmd.Transition.FromStatus)
+
+	// Stored items are updated
+	item.ID = 1
+	item.Status = store.ReadyToComplete
+	cmd, err = sm.Transition(&item, store.CompleteVerificationTxSent, 130, "sent",
+		map[string]interface{}{"complete_verification_tx_hash": common.Hash{1}})
+	assert.Nil(t, err)
+	assert.Equal(t, store.Update, cmd.Type)
+	assert.Equal(t, store.CompleteVerificationTxSent, cmd.Instructions["status"])
+	assert.Equal(t, uint64(130), cmd.Instructions["status_block"])
+	assert.Equal(t, common.Hash{1}, cmd.Instructions["complete_verification_tx_hash"])
+
+	// and deleted on reaching a terminal status
+	item.Status = store.CompleteVerificationTxSent
+	cmd, err = sm.Transition(&item, store.Completed, 140, "completed", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, store.Delete, cmd.Type)
+}
+
+func TestStateMachine_Expire(t *testing.T) {
+	sm := newTestStateMachine()
+
+	item := func(status store.Status, statusBlock, completeOnBlock uint64, attempts int) *store.BeefyRelayInfo {
+		info := loadSampleBeefyRelayInfo()
+		info.ID = 1
+		info.Status = status
+		info.StatusBlock = statusBlock
+		info.CompleteOnBlock = completeOnBlock
+		info.Attempts = attempts
+		return &info
+	}
+	expire := func(info *store.BeefyRelayInfo, blockNumber uint64) (store.DatabaseCmd, bool) {
+		cmd, ok, err := sm.Expire(info, blockNumber)
+		assert.Nil(t, err)
+		return cmd, ok
+	}
+
+	// Unconfirmed initial verifications are resent, until the attempts are used up
+	sent := item(store.InitialVerificationTxSent, 100, 0, 1)
+	_, ok := expire(sent, 120)
+	assert.False(t, ok)
+	cmd, ok := expire(sent, 121)
+	if assert.True(t, ok) {
+		assert.Equal(t, store.CommitmentWitnessed, cmd.Transition.ToStatus)
+		assert.Equal(t, "initial verification not confirmed within 20 blocks", cmd.Transition.Reason)
+	}
+	cmd, ok = expire(item(store.InitialVerificationTxSent, 100, 0, 2), 121)
+	if assert.True(t, ok) {
+		assert.Equal(t, store.Abandoned, cmd.Transition.ToStatus)
+		assert.Equal(t, store.Delete, cmd.Type)
+	}
+
+	// Completions have to be sent within the completion window
+	confirmed := item(store.InitialVerificationTxConfirmed, 100, 103, 1)
+	_, ok = expire(confirmed, 329)
+	assert.False(t, ok)
+	cmd, ok = expire(confirmed, 330)
+	if assert.True(t, ok) {
+		assert.Equal(t, store.CommitmentWitnessed, cmd.Transition.ToStatus)
+		assert.Equal(t, 0, cmd.Instructions["contract_id"])
+	}
+
+	// Unconfirmed completions are resent while the window is open
+	completing := item(store.CompleteVerificationTxSent, 116, 103, 1)
+	_, ok = expire(completing, 136)
+	assert.False(t, ok)
+	cmd, ok = expire(completing, 137)
+	if assert.True(t, ok) {
+		assert.Equal(t, store.InitialVerificationTxConfirmed, cmd.Transition.ToStatus)
+		assert.Equal(t, "completion not confirmed within 20 blocks", cmd.Transition.Reason)
+	}
+	// and start over once it's closed
+	cmd, ok = expire(item(store.CompleteVerificationTxSent, 320, 103, 1), 341)
+	if assert.True(t, ok) {
+		assert.Equal(t, store.CommitmentWitnessed, cmd.Transition.ToStatus)
+	}
+
+	_, ok = expire(item(store.CommitmentWitnessed, 0, 0, 0), 1000)
+	assert.False(t, ok)
+}
+
+func TestStateMachine_History(t *testing.T) {
+	db, err := store.PrepareDatabase(tempDatabaseConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan store.DatabaseCmd)
+	database := store.NewDatabase(db, messages, logrus.WithField("database", "Beefy"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+	assert.Nil(t, database.Start(ctx, eg))
+	defer func() {
+		cancel()
+		eg.Wait()
+		database.Stop()
+	}()
+
+	sm := newTestStateMachine()
+	send := func(item *store.BeefyRelayInfo, to store.Status, blockNumber uint64, reason string) {
+		cmd, err := sm.Transition(item, to, blockNumber, reason, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages <- cmd
+	}
+	load := func(id uint) *store.BeefyRelayInfo {
+		// Commands are applied asynchronously
+		time.Sleep(100 * time.Millisecond)
+		var item store.BeefyRelayInfo
+		if err := db.Unscoped().Take(&item, id).Error; err != nil {
+			t.Fatal(err)
+		}
+		return &item
+	}
+
+	item := loadSampleBeefyRelayInfo()
+	send(&item, store.InitialVerificationTxSent, 100, "sent")
+	stored := load(1)
+	assert.Equal(t, store.InitialVerificationTxSent, stored.Status)
+
+	stale := *stored
+	send(stored, store.InitialVerificationTxConfirmed, 105, "confirmed")
+	// Built from the item before it was confirmed
+	send(&stale, store.CommitmentWitnessed, 121, "expired")
+	stored = load(1)
+	assert.Equal(t, store.InitialVerificationTxConfirmed, stored.Status)
+	assert.Equal(t, uint64(105), stored.StatusBlock)
+
+	stored.Status = store.ReadyToComplete
+	send(stored, store.CompleteVerificationTxSent, 110, "completing")
+	stored = load(1)
+	assert.Equal(t, store.CompleteVerificationTxSent, stored.Status)
+	send(stored, store.Completed, 112, "completed")
+	stored = load(1)
+	assert.Equal(t, store.Completed, stored.Status)
+	assert.NotNil(t, stored.DeletedAt)
+	assert.Len(t, database.GetItemsByStatus(store.Completed), 0)
+
+	var steps []store.Status
+	for _, transition := range database.GetTransitions(1) {
+		steps = append(steps, transition.ToStatus)
+	}
+	assert.Equal(t, []store.Status{
+		store.InitialVerificationTxSent,
+		store.InitialVerificationTxConfirmed,
+		store.CompleteVerificationTxSent,
+		store.Completed,
+	}, steps)
+	history := database.GetTransitions(1)
+	assert.Equal(t, store.ReadyToComplete, history[2].FromStatus)
+	assert.Equal(t, "completing", history[2].Reason)
+	assert.Equal(t, uint64(110), history[2].BlockNumber)
+}
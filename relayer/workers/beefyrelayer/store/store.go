@@ -4,11 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
@@ -22,6 +22,8 @@ const (
 	InitialVerificationTxConfirmed Status = iota // 2
 	ReadyToComplete                Status = iota // 3
 	CompleteVerificationTxSent     Status = iota // 4
+	Completed                      Status = iota // 5
+	Abandoned                      Status = iota // 6
 )
 
 type BeefyRelayInfo struct {
@@ -35,6 +37,12 @@ type BeefyRelayInfo struct {
 	CompleteOnBlock            uint64
 	RandomSeed                 common.Hash
 	CompleteVerificationTxHash common.Hash
+	// Ethereum block at which the item reached its status
+	StatusBlock uint64
+	// Number of initial verifications sent for the commitment
+	Attempts int
+	// Account nonce of the last initial verification transaction, which replacements reuse
+	InitialVerificationTxNonce uint64
 }
 
 func NewBeefyRelayInfo(validatorAddresses, signedCommitment []byte, contractId int64, status Status,
@@ -85,6 +93,8 @@ type DatabaseCmd struct {
 	Info         *BeefyRelayInfo
 	Type         CmdType
 	Instructions map[string]interface{}
+	// Set for commands built by the StateMachine, which are recorded in the item's history
+	Transition *Transition
 }
 
 func NewDatabaseCmd(info *BeefyRelayInfo, cmdType CmdType, instructions map[string]interface{}) DatabaseCmd {
@@ -109,33 +119,41 @@ func NewDatabase(db *gorm.DB, messages <-chan DatabaseCmd, log *logrus.Entry) *D
 	}
 }
 
+// PrepareDatabase opens the configured database and applies pending schema migrations. Items are
+// kept across restarts of the relayer. With the sqlite3 dialect, DBPath is the path of the database
+// file, which is created if needed. With the postgres dialect, it's the connection string of a
+// database which may be shared by several relayers.
 func PrepareDatabase(config *Config) (*gorm.DB, error) {
 	if len(config.DBPath) == 0 {
 		return nil, fmt.Errorf("invalid database path: %s", config.DBPath)
 	}
-	tmpDBFile, err := ioutil.TempFile("", config.DBPath)
+	dialect := config.Dialect
+	if dialect == "" {
+		dialect = "sqlite3"
+	}
+	if dialect != "sqlite3" && dialect != "postgres" {
+		return nil, fmt.Errorf("unsupported database dialect: %s", dialect)
+	}
+
+	db, err := gorm.Open(dialect, config.DBPath)
 	if err != nil {
 		return nil, err
 	}
+	if dialect == "sqlite3" {
+		// The write loop and the listeners share the database, which SQLite doesn't allow
+		// concurrent writers for
+		db.DB().SetMaxOpenConns(1)
+	}
 
-	db, err := gorm.Open(config.Dialect, tmpDBFile.Name())
+	err = Migrate(db)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	InitTables(db)
-
 	return db, nil
 }
 
-func InitTables(db *gorm.DB) {
-	var beefyRelayInfo BeefyRelayInfo
-	if !db.HasTable(&beefyRelayInfo) {
-		db.CreateTable(&beefyRelayInfo)
-		db.Model(&beefyRelayInfo)
-	}
-}
-
 func (d *Database) onDone(ctx context.Context) error {
 	d.log.Info("Shutting down database...")
 	return ctx.Err()
@@ -151,7 +169,10 @@ func (d *Database) Start(ctx context.Context, eg *errgroup.Group) error {
 
 // Stop is used to handle shut down logic
 func (d *Database) Stop() {
-	// Should automatically close. The database.close() method was removed in gorm 1.20.
+	err := d.DB.Close()
+	if err != nil {
+		d.log.WithError(err).Error("Failed to close database")
+	}
 }
 
 func (d *Database) writeLoop(ctx context.Context) error {
@@ -163,6 +184,14 @@ func (d *Database) writeLoop(ctx context.Context) error {
 			return d.onDone(ctx)
 		case cmd := <-d.messages:
 			mutex.Lock()
+			if cmd.Transition != nil {
+				err := d.apply(cmd)
+				mutex.Unlock()
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			switch cmd.Type {
 			case Create:
 				d.log.Info("Creating item in database...")
@@ -184,36 +213,115 @@ func (d *Database) writeLoop(ctx context.Context) error {
 				}
 			case Update:
 				d.log.Info("Updating item in database...")
-				d.DB.Model(&cmd.Info).Updates(cmd.Instructions)
+				if err := d.DB.Model(&cmd.Info).Updates(cmd.Instructions).Error; err != nil {
+					d.log.Error(err)
+					return err
+				}
 			case Delete:
 				d.log.Info("Deleting item from database...")
-				d.DB.Delete(&cmd.Info, cmd.Info.ID)
+				if err := d.DB.Delete(&cmd.Info, cmd.Info.ID).Error; err != nil {
+					d.log.Error(err)
+					return err
+				}
 			}
 			mutex.Unlock()
 		}
 	}
 }
 
+// apply carries out a command of the StateMachine together with recording its transition. Stored
+// items are only changed if their status is still the one the transition was made from, as the
+// command may have been built from a stale copy of the item.
+func (d *Database) apply(cmd DatabaseCmd) error {
+	transition := *cmd.Transition
+	log := d.log.WithFields(logrus.Fields{
+		"from":        transition.FromStatus,
+		"to":          transition.ToStatus,
+		"reason":      transition.Reason,
+		"blockNumber": transition.BlockNumber,
+	})
+
+	tx := d.DB.Begin()
+	if err := tx.Error; err != nil {
+		log.WithError(err).Error("Failed to change item status")
+		return err
+	}
+
+	if cmd.Type == Create {
+		if err := tx.Create(cmd.Info).Error; err != nil {
+			tx.Rollback()
+			log.WithError(err).Error("Failed to create item")
+			return err
+		}
+	} else {
+		result := tx.Model(cmd.Info).
+			Where(map[string]interface{}{"status": transition.FromStatus.stored()}).
+			Updates(cmd.Instructions)
+		if err := result.Error; err != nil {
+			tx.Rollback()
+			log.WithError(err).WithField("id", cmd.Info.ID).Error("Failed to change item status")
+			return err
+		}
+		if result.RowsAffected == 0 {
+			tx.Rollback()
+			log.WithField("id", cmd.Info.ID).Warn("Skipping status change of item which changed in the meantime")
+			return nil
+		}
+		if cmd.Type == Delete {
+			if err := tx.Delete(cmd.Info).Error; err != nil {
+				tx.Rollback()
+				log.WithError(err).WithField("id", cmd.Info.ID).Error("Failed to delete item")
+				return err
+			}
+		}
+	}
+
+	transition.ItemID = cmd.Info.ID
+	if err := tx.Create(&transition).Error; err != nil {
+		tx.Rollback()
+		log.WithError(err).WithField("id", cmd.Info.ID).Error("Failed to record item status change")
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.WithError(err).WithField("id", cmd.Info.ID).Error("Failed to change item status")
+		return err
+	}
+
+	log.WithField("id", cmd.Info.ID).Info("Changed item status")
+	return nil
+}
+
+// GetTransitions returns the history of an item's status, oldest first
+func (d *Database) GetTransitions(itemID uint) []*Transition {
+	history := make([]*Transition, 0)
+	d.DB.Where(map[string]interface{}{"item_id": itemID}).Order("id").Find(&history)
+	return history
+}
+
+// Conditions are given as maps, so that column names are quoted and placeholders are numbered
+// as the dialect of the database requires
+
 func (d *Database) GetItemsByStatus(status Status) []*BeefyRelayInfo {
 	items := make([]*BeefyRelayInfo, 0)
-	d.DB.Where("status = ?", status).Find(&items)
+	d.DB.Where(map[string]interface{}{"status": status}).Find(&items)
 	return items
 }
 
 func (d *Database) GetItemByID(id int64) *BeefyRelayInfo {
 	var item BeefyRelayInfo
-	d.DB.Take(&item, "contract_id = ?", id)
+	d.DB.Take(&item, map[string]interface{}{"contract_id": id})
 	return &item
 }
 
 func (d *Database) GetItemByInitialVerificationTxHash(txHash common.Hash) *BeefyRelayInfo {
 	var item BeefyRelayInfo
-	d.DB.Take(&item, "initial_verification_tx_hash = ?", txHash)
+	d.DB.Take(&item, map[string]interface{}{"initial_verification_tx_hash": txHash})
 	return &item
 }
 
 func (d *Database) GetItemByCompleteVerificationTxHash(txHash common.Hash) *BeefyRelayInfo {
 	var item BeefyRelayInfo
-	d.DB.Take(&item, "complete_verification_tx_hash = ?", txHash)
+	d.DB.Take(&item, map[string]interface{}{"complete_verification_tx_hash": txHash})
 	return &item
 }
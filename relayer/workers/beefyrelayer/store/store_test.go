@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
@@ -24,6 +26,7 @@ type StoreTestSuite struct {
 	database *store.Database
 	messages chan store.DatabaseCmd
 	ctx      context.Context
+	dir      string
 }
 
 func TestStoreTestSuite(t *testing.T) {
@@ -31,9 +34,15 @@ func TestStoreTestSuite(t *testing.T) {
 }
 
 func (suite *StoreTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "beefy-store")
+	if err != nil {
+		suite.Fail(err.Error())
+	}
+	suite.dir = dir
+
 	config := store.Config{
 		Dialect: "sqlite3",
-		DBPath:  "tmp.db",
+		DBPath:  filepath.Join(dir, "tmp.db"),
 	}
 
 	db, err := store.PrepareDatabase(&config)
@@ -73,6 +82,11 @@ func (suite *StoreTestSuite) SetupTest() {
 	}
 }
 
+func (suite *StoreTestSuite) TearDownTest() {
+	suite.database.DB.Close()
+	os.RemoveAll(suite.dir)
+}
+
 func (suite *StoreTestSuite) TestGetItemsByStatus() {
 	item := loadSampleBeefyRelayInfo()
 
@@ -0,0 +1,461 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethrelayer
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	gethCommon "github.com/ethereum/go-ethereum/common"
+	gethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/ethashproof"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+)
+
+// Number of consecutive blocks handled by a single backfill job
+const backfillChunkSize = 32
+
+// Number of blocks in an ethash epoch, which share an ethashproof cache
+const ethashEpochLength = 30000
+
+// Number of times a chunk is fetched again when it doesn't continue the chain of the chunk
+// before it, before giving up
+const backfillRefetchAttempts = 3
+
+// Interval between backfill progress reports in the logs
+const backfillProgressInterval = 30 * time.Second
+
+// Backfill metrics, published through expvar
+var (
+	backfillTargetBlock    = expvar.NewInt("ethrelayer_backfill_target_block")
+	backfillLatestBlock    = expvar.NewInt("ethrelayer_backfill_latest_block")
+	backfillBlocksPerSec   = expvar.NewFloat("ethrelayer_backfill_blocks_per_second")
+	backfillInFlightChunks = expvar.NewInt("ethrelayer_backfill_inflight_chunks")
+)
+
+// backfillChunk holds the data fetched for the inclusive block range [from, to]
+type backfillChunk struct {
+	from     uint64
+	to       uint64
+	headers  []*chain.Header
+	messages map[uint64][]*chain.EthereumOutboundMessage
+	// Hashes of the parent of the first block and of the last block, which link consecutive
+	// chunks
+	parentHash gethCommon.Hash
+	hash       gethCommon.Hash
+}
+
+// backfill quickly catches up with the latest finalized block when the parachain lags
+// far behind. Blocks are fetched in chunks by parallel jobs and their payloads are
+// forwarded in order. Returns the height from which regular syncing should resume.
+func (li *EthereumListener) backfill(
+	ctx context.Context,
+	initBlockHeight uint64,
+	descendantsUntilFinal uint64,
+	hcs *ethereum.HeaderCacheState,
+) (uint64, error) {
+	if li.config.BackfillConcurrency == 0 {
+		return initBlockHeight, nil
+	}
+
+	latest, err := li.conn.GetClient().BlockNumber(ctx)
+	if err != nil {
+		li.log.WithError(err).Error("Failed to retrieve latest block number")
+		return 0, err
+	}
+	if latest < descendantsUntilFinal || latest-descendantsUntilFinal < initBlockHeight {
+		return initBlockHeight, nil
+	}
+	target := latest - descendantsUntilFinal
+	if target-initBlockHeight+1 < li.config.BackfillThreshold {
+		return initBlockHeight, nil
+	}
+
+	// The payload for header N carries the messages of block N - descendantsUntilFinal,
+	// so start early enough to collect messages for the first headers
+	start := initBlockHeight
+	if start > descendantsUntilFinal {
+		start = start - descendantsUntilFinal
+	} else {
+		start = 0
+	}
+
+	li.log.WithFields(logrus.Fields{
+		"fromBlock":   initBlockHeight,
+		"toBlock":     target,
+		"concurrency": li.config.BackfillConcurrency,
+	}).Info("Backfilling finalized blocks")
+
+	backfillTargetBlock.Set(int64(target))
+
+	var lastMessageBlock uint64
+	if target > descendantsUntilFinal {
+		lastMessageBlock = target - descendantsUntilFinal
+	}
+
+	caches := newBackfillCaches(hcs)
+	fetch := func(ctx context.Context, from uint64, to uint64) (*backfillChunk, error) {
+		return li.fetchBackfillChunk(ctx, from, to, initBlockHeight, lastMessageBlock, hcs, caches)
+	}
+
+	// Messages awaiting the header they are forwarded with
+	messages := make(map[uint64][]*chain.EthereumOutboundMessage)
+	progress := newBackfillProgress(start, target, li.log)
+
+	emit := func(chunk *backfillChunk) error {
+		for number := chunk.from; number <= chunk.to; number++ {
+			if len(chunk.messages[number]) > 0 {
+				messages[number] = chunk.messages[number]
+			}
+
+			header := chunk.headers[number-chunk.from]
+			if header == nil {
+				continue
+			}
+
+			payload := ParachainPayload{Header: header}
+			// Don't attempt to forward events prior to genesis block
+			if number >= descendantsUntilFinal {
+				payload.Messages = messages[number-descendantsUntilFinal]
+				delete(messages, number-descendantsUntilFinal)
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case li.payloads <- payload:
+			}
+		}
+
+		progress.update(chunk.to)
+		return nil
+	}
+
+	err = runBackfill(ctx, start, target, li.config.BackfillConcurrency, fetch, emit)
+	if err != nil {
+		li.log.WithError(err).Error("Failed to backfill blocks")
+		return 0, err
+	}
+
+	li.log.WithField("blockNumber", target).Info("Done backfilling finalized blocks")
+
+	return target + 1, nil
+}
+
+// backfillCaches hands out the ethashproof caches of the epochs being backfilled. The
+// header cache state only holds the caches of two consecutive epochs, so jobs on both
+// sides of an epoch boundary would otherwise keep switching them.
+type backfillCaches struct {
+	sync.Mutex
+	hcs    *ethereum.HeaderCacheState
+	caches map[uint64]*ethashproof.DatasetMerkleTreeCache
+}
+
+func newBackfillCaches(hcs *ethereum.HeaderCacheState) *backfillCaches {
+	return &backfillCaches{
+		hcs:    hcs,
+		caches: make(map[uint64]*ethashproof.DatasetMerkleTreeCache),
+	}
+}
+
+// get returns the cache for the epoch of a block. The lock is only held while looking up
+// the cache, so that jobs generate their proofs in parallel.
+func (c *backfillCaches) get(number uint64) (*ethashproof.DatasetMerkleTreeCache, error) {
+	epoch := number / ethashEpochLength
+
+	c.Lock()
+	defer c.Unlock()
+
+	cache, ok := c.caches[epoch]
+	if ok {
+		return cache, nil
+	}
+
+	cache, err := c.hcs.GetEthashproofCache(number)
+	if err != nil {
+		return nil, err
+	}
+	c.caches[epoch] = cache
+
+	// Jobs work through the epochs in order, so only the previous epoch may still be needed
+	for e := range c.caches {
+		if e+1 < epoch {
+			delete(c.caches, e)
+		}
+	}
+
+	return cache, nil
+}
+
+// backfillChunkEnd returns the last block of the chunk starting at `from`. Chunks don't
+// span epoch boundaries, so that each job needs a single ethashproof cache.
+func backfillChunkEnd(from uint64, target uint64) uint64 {
+	to := from + backfillChunkSize - 1
+	if epochEnd := (from/ethashEpochLength+1)*ethashEpochLength - 1; to > epochEnd {
+		to = epochEnd
+	}
+	if to > target {
+		to = target
+	}
+	return to
+}
+
+// runBackfill fetches the blocks in [start, target] in chunks using `concurrency` parallel
+// jobs and passes the chunks to `emit` in order. The number of chunks that are being
+// fetched or waiting to be emitted is bounded to limit memory usage. Chunks whose first block
+// isn't a child of the last block of the chunk before, as blocks were replaced in between
+// fetching them, are fetched again.
+func runBackfill(
+	ctx context.Context,
+	start uint64,
+	target uint64,
+	concurrency uint,
+	fetch func(ctx context.Context, from uint64, to uint64) (*backfillChunk, error),
+	emit func(chunk *backfillChunk) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer backfillInFlightChunks.Set(0)
+	eg, ctx := errgroup.WithContext(ctx)
+
+	jobs := make(chan uint64)
+	results := make(chan *backfillChunk, concurrency)
+	window := make(chan struct{}, 2*concurrency)
+
+	eg.Go(func() error {
+		defer close(jobs)
+		for from := start; from <= target; from = backfillChunkEnd(from, target) + 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case window <- struct{}{}:
+			}
+			backfillInFlightChunks.Add(1)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case jobs <- from:
+			}
+		}
+		return nil
+	})
+
+	for i := uint(0); i < concurrency; i++ {
+		eg.Go(func() error {
+			for from := range jobs {
+				chunk, err := fetch(ctx, from, backfillChunkEnd(from, target))
+				if err != nil {
+					return err
+				}
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case results <- chunk:
+				}
+			}
+			return nil
+		})
+	}
+
+	pending := make(map[uint64]*backfillChunk)
+	next := start
+	var lastHash gethCommon.Hash
+	for next <= target {
+		select {
+		case <-ctx.Done():
+			err := eg.Wait()
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		case chunk := <-results:
+			pending[chunk.from] = chunk
+		}
+
+		for {
+			chunk, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+
+			for attempt := 0; next > start && chunk.parentHash != lastHash; attempt++ {
+				if attempt == backfillRefetchAttempts {
+					cancel()
+					eg.Wait()
+					return fmt.Errorf("block %d is not a child of block %d after fetching it %d more times",
+						chunk.from, chunk.from-1, attempt)
+				}
+				refetched, err := fetch(ctx, chunk.from, chunk.to)
+				if err != nil {
+					cancel()
+					eg.Wait()
+					return err
+				}
+				chunk = refetched
+			}
+
+			err := emit(chunk)
+			if err != nil {
+				cancel()
+				eg.Wait()
+				return err
+			}
+
+			backfillInFlightChunks.Add(-1)
+			<-window
+			next = chunk.to + 1
+			lastHash = chunk.hash
+		}
+	}
+
+	return eg.Wait()
+}
+
+func (li *EthereumListener) fetchBackfillChunk(
+	ctx context.Context,
+	from uint64,
+	to uint64,
+	initBlockHeight uint64,
+	lastMessageBlock uint64,
+	hcs *ethereum.HeaderCacheState,
+	caches *backfillCaches,
+) (*backfillChunk, error) {
+	gethheaders := make([]*gethTypes.Header, to-from+1)
+	for number := from; number <= to; number++ {
+		gethheader, err := li.conn.GetClient().HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			li.log.WithField("blockNumber", number).WithError(err).Error("Failed to retrieve finalized header")
+			return nil, err
+		}
+		gethheaders[number-from] = gethheader
+	}
+
+	chunk := backfillChunk{
+		from:       from,
+		to:         to,
+		headers:    make([]*chain.Header, to-from+1),
+		messages:   make(map[uint64][]*chain.EthereumOutboundMessage),
+		parentHash: gethheaders[0].ParentHash,
+		hash:       gethheaders[len(gethheaders)-1].Hash(),
+	}
+
+	for number := from; number <= to; number++ {
+		if number < initBlockHeight {
+			continue
+		}
+
+		gethheader := gethheaders[number-from]
+		if number > from && gethheader.ParentHash != gethheaders[number-from-1].Hash() {
+			return nil, fmt.Errorf("header %d is not a child of header %d", number, number-1)
+		}
+
+		cache, err := caches.get(number)
+		if err != nil {
+			li.log.WithFields(logrus.Fields{
+				"blockHash":   gethheader.Hash().Hex(),
+				"blockNumber": gethheader.Number,
+			}).WithError(err).Error("Failed to get ethashproof cache for header")
+			return nil, err
+		}
+
+		header, err := li.makeHeaderWithCache(gethheader, cache)
+		if err != nil {
+			return nil, err
+		}
+		chunk.headers[number-from] = header
+	}
+
+	// Only query logs for the span of blocks which may contain messages
+	var matchFrom, matchTo uint64
+	matched := false
+	for number := from; number <= to && number <= lastMessageBlock; number++ {
+		if li.logFetcher.BloomMatches(gethheaders[number-from].Bloom) {
+			if !matched {
+				matchFrom = number
+				matched = true
+			}
+			matchTo = number
+		}
+	}
+	if !matched {
+		return &chunk, nil
+	}
+
+	logs, err := li.logFetcher.FetchLogRange(ctx, matchFrom, matchTo)
+	if err != nil {
+		li.log.WithError(err).WithFields(logrus.Fields{
+			"fromBlock": matchFrom,
+			"toBlock":   matchTo,
+		}).Error("Failure fetching event logs")
+		return nil, err
+	}
+
+	for number, events := range logs {
+		if events[0].BlockHash != gethheaders[number-from].Hash() {
+			return nil, fmt.Errorf("logs for block %d belong to a different block than its header", number)
+		}
+
+		messages, err := li.makeOutgoingMessages(ctx, hcs, events)
+		if err != nil {
+			return nil, err
+		}
+		chunk.messages[number] = messages
+	}
+
+	return &chunk, nil
+}
+
+type backfillProgress struct {
+	start      uint64
+	target     uint64
+	startTime  time.Time
+	lastReport time.Time
+	log        *logrus.Entry
+}
+
+func newBackfillProgress(start uint64, target uint64, log *logrus.Entry) *backfillProgress {
+	now := time.Now()
+	return &backfillProgress{
+		start:      start,
+		target:     target,
+		startTime:  now,
+		lastReport: now,
+		log:        log,
+	}
+}
+
+func (p *backfillProgress) update(latest uint64) {
+	now := time.Now()
+	blocksPerSec := float64(latest-p.start+1) / now.Sub(p.startTime).Seconds()
+
+	backfillLatestBlock.Set(int64(latest))
+	backfillBlocksPerSec.Set(blocksPerSec)
+
+	if now.Sub(p.lastReport) < backfillProgressInterval && latest < p.target {
+		return
+	}
+	p.lastReport = now
+
+	fields := logrus.Fields{
+		"blockNumber":  latest,
+		"target":       p.target,
+		"remaining":    p.target - latest,
+		"blocksPerSec": fmt.Sprintf("%.2f", blocksPerSec),
+	}
+	if blocksPerSec > 0 {
+		fields["eta"] = (time.Duration(float64(p.target-latest)/blocksPerSec) * time.Second).String()
+	}
+	p.log.WithFields(fields).Info("Backfill progress")
+}
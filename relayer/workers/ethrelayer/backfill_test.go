@@ -0,0 +1,139 @@
+package ethrelayer
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunBackfill_EmitsChunksInOrder(t *testing.T) {
+	fetch := func(_ context.Context, from uint64, to uint64) (*backfillChunk, error) {
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		return &backfillChunk{from: from, to: to}, nil
+	}
+
+	var emitted []*backfillChunk
+	emit := func(chunk *backfillChunk) error {
+		emitted = append(emitted, chunk)
+		return nil
+	}
+
+	err := runBackfill(context.Background(), 10, 1000, 8, fetch, emit)
+	assert.Nil(t, err)
+
+	next := uint64(10)
+	for _, chunk := range emitted {
+		assert.Equal(t, next, chunk.from)
+		assert.True(t, chunk.to-chunk.from < backfillChunkSize)
+		next = chunk.to + 1
+	}
+	assert.Equal(t, uint64(1001), next)
+}
+
+func TestRunBackfill_FetchError(t *testing.T) {
+	fetchErr := errors.New("fetch failed")
+	fetch := func(_ context.Context, from uint64, to uint64) (*backfillChunk, error) {
+		if from > 500 {
+			return nil, fetchErr
+		}
+		return &backfillChunk{from: from, to: to}, nil
+	}
+
+	var lastEmitted uint64
+	emit := func(chunk *backfillChunk) error {
+		lastEmitted = chunk.to
+		return nil
+	}
+
+	err := runBackfill(context.Background(), 0, 1000, 4, fetch, emit)
+	assert.Equal(t, fetchErr, err)
+	assert.True(t, lastEmitted < 512)
+}
+
+func TestRunBackfill_EmitError(t *testing.T) {
+	emitErr := errors.New("emit failed")
+	fetch := func(_ context.Context, from uint64, to uint64) (*backfillChunk, error) {
+		return &backfillChunk{from: from, to: to}, nil
+	}
+	emit := func(chunk *backfillChunk) error {
+		return emitErr
+	}
+
+	err := runBackfill(context.Background(), 0, 1000, 4, fetch, emit)
+	assert.Equal(t, emitErr, err)
+}
+
+func TestRunBackfill_ChunksWithinEpoch(t *testing.T) {
+	fetch := func(_ context.Context, from uint64, to uint64) (*backfillChunk, error) {
+		return &backfillChunk{from: from, to: to}, nil
+	}
+
+	var emitted []*backfillChunk
+	emit := func(chunk *backfillChunk) error {
+		emitted = append(emitted, chunk)
+		return nil
+	}
+
+	err := runBackfill(context.Background(), 29990, 30100, 4, fetch, emit)
+	assert.Nil(t, err)
+
+	next := uint64(29990)
+	for _, chunk := range emitted {
+		assert.Equal(t, next, chunk.from)
+		assert.Equal(t, chunk.from/ethashEpochLength, chunk.to/ethashEpochLength)
+		next = chunk.to + 1
+	}
+	assert.Equal(t, uint64(30101), next)
+	assert.Equal(t, uint64(29999), emitted[0].to)
+}
+
+func TestRunBackfill_RefetchesReplacedBlocks(t *testing.T) {
+	// Block hashes are their numbers. The chunk from block 64 is first fetched on top of a block 63
+	// which was replaced since the chunk before was fetched
+	fetches := make(map[uint64]int)
+	var mutex sync.Mutex
+	fetch := func(_ context.Context, from uint64, to uint64) (*backfillChunk, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetches[from]++
+
+		chunk := &backfillChunk{
+			from:       from,
+			to:         to,
+			parentHash: common.BigToHash(new(big.Int).SetUint64(from - 1)),
+			hash:       common.BigToHash(new(big.Int).SetUint64(to)),
+		}
+		if from == 64 && fetches[from] == 1 {
+			chunk.parentHash = common.HexToHash("0x01")
+		}
+		return chunk, nil
+	}
+
+	var emitted []*backfillChunk
+	emit := func(chunk *backfillChunk) error {
+		emitted = append(emitted, chunk)
+		return nil
+	}
+
+	err := runBackfill(context.Background(), 0, 200, 4, fetch, emit)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, fetches[64])
+	assert.Equal(t, 1, fetches[96])
+	for i := 1; i < len(emitted); i++ {
+		assert.Equal(t, emitted[i-1].hash, emitted[i].parentHash)
+	}
+
+	// Chunks which keep failing to continue the chain stop the backfill
+	fetch = func(_ context.Context, from uint64, to uint64) (*backfillChunk, error) {
+		return &backfillChunk{from: from, to: to, parentHash: common.BigToHash(new(big.Int).SetUint64(from))}, nil
+	}
+	err = runBackfill(context.Background(), 0, 200, 4, fetch, emit)
+	assert.Error(t, err)
+}
@@ -0,0 +1,401 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethrelayer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	etypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// inboundChannel holds back the messages of a parachain inbound channel which can't be
+// submitted yet. Channels only accept messages in nonce order, so once a message is held
+// back, later messages of the channel are held back as well.
+type inboundChannel struct {
+	// Messages waiting for their headers to be finalized or for the channel to recover, in
+	// nonce order
+	held []messageCall
+	// Parts of failed messages which are resubmitted one at a time, lowest nonces first,
+	// to find the failing message
+	parts [][]messageCall
+	// Set while the first part is awaiting its result
+	partSubmitted bool
+	// Nonce of the message the channel waits for after a message failed on its own. Later
+	// messages are bound to fail until it's delivered, so they're held back meanwhile.
+	blockedAt uint64
+	// Set if the message the channel waits for was quarantined, rather than missing
+	quarantined bool
+}
+
+func (ch *inboundChannel) idle() bool {
+	return len(ch.held) == 0 && len(ch.parts) == 0 && ch.blockedAt == 0
+}
+
+func (ch *inboundChannel) contains(nonce uint64) bool {
+	for _, m := range ch.held {
+		if m.nonce == nonce {
+			return true
+		}
+	}
+	for _, part := range ch.parts {
+		for _, m := range part {
+			if m.nonce == nonce {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dropDelivered removes messages which the parachain has received already
+func (ch *inboundChannel) dropDelivered(delivered uint64) {
+	ch.held = undelivered(ch.held, delivered)
+
+	var parts [][]messageCall
+	for i, part := range ch.parts {
+		part = undelivered(part, delivered)
+		if len(part) > 0 {
+			parts = append(parts, part)
+		} else if i == 0 {
+			ch.partSubmitted = false
+		}
+	}
+	ch.parts = parts
+}
+
+func undelivered(messages []messageCall, delivered uint64) []messageCall {
+	var result []messageCall
+	for _, m := range messages {
+		if m.nonce > delivered {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// channelConfig returns the configuration of the channel whose messages are submitted with
+// the given call
+func (wr *ParachainWriter) channelConfig(call string) (*ethereum.ChannelConfig, error) {
+	channel, ok := wr.channelConfigs[call]
+	if !ok {
+		return nil, fmt.Errorf("no channel is configured with call %q", call)
+	}
+	return channel, nil
+}
+
+// messageNonce returns the nonce of a message, as emitted in the event of its outbound channel
+func (wr *ParachainWriter) messageNonce(msg *chain.EthereumOutboundMessage) (uint64, error) {
+	channel, err := wr.channelConfig(msg.Call)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, arg := range msg.Args {
+		message, ok := arg.(parachain.Message)
+		if !ok {
+			continue
+		}
+
+		var log etypes.Log
+		err := rlp.DecodeBytes(message.Data, &log)
+		if err != nil {
+			return 0, fmt.Errorf("decode log: %w", err)
+		}
+		return channel.MessageNonce(&log)
+	}
+
+	return 0, fmt.Errorf("message has no event")
+}
+
+// queryChannelNonce returns the nonce of the last message received by the inbound channel
+// with the given call, and remembers it
+func (wr *ParachainWriter) queryChannelNonce(call string) (uint64, error) {
+	channel, err := wr.channelConfig(call)
+	if err != nil {
+		return 0, err
+	}
+	module, item, err := channel.NonceStorageItem()
+	if err != nil {
+		return 0, err
+	}
+	key, err := types.CreateStorageKey(wr.conn.GetMetadata(), module, item, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var nonce types.U64
+	_, err = wr.conn.GetAPI().RPC.State.GetStorageLatest(key, &nonce)
+	if err != nil {
+		return 0, err
+	}
+
+	wr.deliveredNonces[call] = uint64(nonce)
+	return uint64(nonce), nil
+}
+
+// deliveredNonce returns the nonce of the last message received by an inbound channel, as
+// far as known. Nonces are queried on first use and refreshed when the listener rewinds.
+func (wr *ParachainWriter) deliveredNonce(call string) (uint64, error) {
+	if nonce, ok := wr.deliveredNonces[call]; ok {
+		return nonce, nil
+	}
+
+	return wr.queryChannelNonce(call)
+}
+
+// refreshChannels queries the nonces of the inbound channels again, dropping the held
+// and failed messages which the parachain has received since
+func (wr *ParachainWriter) refreshChannels() error {
+	for call := range wr.deliveredNonces {
+		nonce, err := wr.queryChannelNonce(call)
+		if err != nil {
+			return err
+		}
+
+		ch, ok := wr.channels[call]
+		if !ok {
+			continue
+		}
+		ch.dropDelivered(nonce)
+		if ch.idle() {
+			delete(wr.channels, call)
+		}
+
+		wr.log.WithFields(logrus.Fields{
+			"call":      call,
+			"delivered": nonce,
+			"heldCount": len(ch.held),
+		}).Info("Refreshed channel after resync")
+	}
+	return nil
+}
+
+func (wr *ParachainWriter) channel(call string) *inboundChannel {
+	ch, ok := wr.channels[call]
+	if !ok {
+		ch = &inboundChannel{}
+		wr.channels[call] = ch
+	}
+	return ch
+}
+
+// isHeld reports whether messages of the channel with the given call are held back
+func (wr *ParachainWriter) isHeld(call string) bool {
+	ch, ok := wr.channels[call]
+	return ok && !ch.idle()
+}
+
+// holdMessage holds back a message in nonce order. Messages which are held back already are
+// ignored, as they may come back from failed extrinsics more than once.
+func (wr *ParachainWriter) holdMessage(m messageCall) {
+	ch := wr.channel(m.message.Call)
+	if ch.contains(m.nonce) || (ch.quarantined && m.nonce == ch.blockedAt) {
+		return
+	}
+
+	i := sort.Search(len(ch.held), func(i int) bool { return ch.held[i].nonce > m.nonce })
+	ch.held = append(ch.held, messageCall{})
+	copy(ch.held[i+1:], ch.held[i:])
+	ch.held[i] = m
+
+	log := wr.log.WithFields(logrus.Fields{
+		"call":        m.message.Call,
+		"nonce":       m.nonce,
+		"blockNumber": m.blockNumber,
+		"heldCount":   len(ch.held),
+	})
+	switch {
+	case ch.blockedAt != 0:
+		log.WithField("blockedAt", ch.blockedAt).Error("Holding back message of blocked channel")
+	case len(ch.parts) > 0:
+		log.Info("Holding back message while recovering its channel")
+	default:
+		log.Info("Holding back message until its header is finalized")
+	}
+}
+
+// admitMessage reports whether a message can be submitted now, or holds it back. Messages
+// submitted without their header must have a finalized block.
+func (wr *ParachainWriter) admitMessage(m messageCall, withHeader bool) (bool, error) {
+	if wr.isHeld(m.message.Call) {
+		wr.holdMessage(m)
+		return false, nil
+	}
+	if withHeader {
+		return true, nil
+	}
+
+	finalized, err := wr.isMessageHeaderFinalized(m.message)
+	if err != nil {
+		return false, err
+	}
+	if !finalized {
+		wr.holdMessage(m)
+		return false, nil
+	}
+	return true, nil
+}
+
+// submitMessages submits messages whose headers have already been imported, leaving out
+// those the parachain has received already and holding back those which can't be
+// submitted yet
+func (wr *ParachainWriter) submitMessages(ctx context.Context, messages []messageCall) error {
+	delivered := make(map[string]uint64)
+	for _, m := range messages {
+		call := m.message.Call
+		if _, ok := delivered[call]; ok {
+			continue
+		}
+		nonce, err := wr.queryChannelNonce(call)
+		if err != nil {
+			return err
+		}
+		delivered[call] = nonce
+	}
+
+	var submittable []messageCall
+	for _, m := range messages {
+		if m.nonce <= delivered[m.message.Call] {
+			continue
+		}
+
+		ok, err := wr.admitMessage(m, false)
+		if err != nil {
+			return err
+		}
+		if ok {
+			submittable = append(submittable, m)
+		}
+	}
+
+	if len(submittable) == 0 {
+		return nil
+	}
+	return wr.writeMessages(ctx, submittable, wr.onMessagesProcessed(ctx, submittable))
+}
+
+// writeChannels makes progress on the channels with messages held back. Blocked channels
+// are released once the parachain received the message they wait for. Recovering channels
+// resubmit their next part of failed messages. Otherwise, the held messages whose blocks
+// have been finalized since are submitted. Messages whose blocks lost out to another block
+// at the same height can't be delivered anymore and are dropped.
+func (wr *ParachainWriter) writeChannels(ctx context.Context) error {
+	if len(wr.channels) == 0 {
+		return nil
+	}
+
+	finalized, err := wr.lightClient.FinalizedBlock()
+	if err != nil {
+		return err
+	}
+	wr.finalizedBlockNumber = uint64(finalized.Number)
+
+	var messages []messageCall
+	for call, ch := range wr.channels {
+		if ch.blockedAt != 0 {
+			released, err := wr.releaseChannel(call, ch)
+			if err != nil {
+				return err
+			}
+			if !released {
+				continue
+			}
+		}
+
+		if len(ch.parts) > 0 {
+			if !ch.partSubmitted {
+				err := wr.writeNextPart(ctx, call, ch)
+				if err != nil {
+					return err
+				}
+			}
+			if len(ch.parts) > 0 {
+				continue
+			}
+		}
+
+		for len(ch.held) > 0 {
+			m := ch.held[0]
+			ok, err := wr.isMessageHeaderFinalized(m.message)
+			if err != nil {
+				return err
+			}
+			if !ok && m.blockNumber > wr.finalizedBlockNumber {
+				break
+			}
+
+			if ok {
+				messages = append(messages, m)
+			} else {
+				reason := fmt.Sprintf("light client finalized another block at height %d", m.blockNumber)
+				err := wr.dropMessage(m, reason)
+				if err != nil {
+					return err
+				}
+			}
+			ch.held = ch.held[1:]
+		}
+
+		if ch.idle() {
+			delete(wr.channels, call)
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+	return wr.writeMessages(ctx, messages, wr.onMessagesProcessed(ctx, messages))
+}
+
+// releaseChannel reports whether a blocked channel received the message it waits for,
+// e.g. from another relayer, and releases it if so. A missing message may also turn up
+// among the held messages, having failed in a later extrinsic than the messages following
+// it, in which case the channel's messages are recovered from there.
+func (wr *ParachainWriter) releaseChannel(call string, ch *inboundChannel) (bool, error) {
+	if !ch.quarantined && ch.contains(ch.blockedAt) {
+		wr.log.WithFields(logrus.Fields{
+			"call":      call,
+			"blockedAt": ch.blockedAt,
+		}).Info("Blocked channel holds the message it was waiting for. Resuming recovery")
+
+		messages := ch.held
+		for _, part := range ch.parts {
+			messages = append(messages, part...)
+		}
+		sort.Slice(messages, func(i, j int) bool { return messages[i].nonce < messages[j].nonce })
+
+		ch.parts = [][]messageCall{messages}
+		ch.held = nil
+		ch.blockedAt = 0
+		return true, nil
+	}
+
+	delivered, err := wr.queryChannelNonce(call)
+	if err != nil {
+		return false, err
+	}
+	if delivered < ch.blockedAt {
+		return false, nil
+	}
+
+	wr.log.WithFields(logrus.Fields{
+		"call":      call,
+		"blockedAt": ch.blockedAt,
+		"delivered": delivered,
+	}).Info("Blocked channel received the message it was waiting for. Resuming submission")
+
+	ch.blockedAt = 0
+	ch.quarantined = false
+	ch.dropDelivered(delivered)
+	return true, nil
+}
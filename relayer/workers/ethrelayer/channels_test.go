@@ -0,0 +1,214 @@
+package ethrelayer
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	etypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// testChannels have events which don't follow the layout of the built-in channels
+var testChannels = ethereum.ChannelsConfig{
+	{
+		Name:         "basic",
+		Event:        "Message(address source,uint64 nonce,bytes payload)",
+		Call:         "BasicInboundModule.submit",
+		NonceStorage: "BasicInboundModule.Nonce",
+	},
+	{
+		Name:         "incentivized",
+		Event:        "Message(uint256 fee,bytes payload,uint32 nonce)",
+		Call:         "IncentivizedInboundModule.submit",
+		NonceStorage: "IncentivizedInboundModule.LastNonce",
+	},
+}
+
+func makeChannelMessage(t *testing.T, call string, nonce uint64) messageCall {
+	var channel *ethereum.ChannelConfig
+	for i := range testChannels {
+		if testChannels[i].Call == call {
+			channel = &testChannels[i]
+		}
+	}
+	event, err := channel.EventABI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []interface{}
+	for _, input := range event.Inputs {
+		switch input.Name {
+		case "source":
+			values = append(values, common.HexToAddress("0x01"))
+		case "nonce":
+			values = append(values, reflect.ValueOf(nonce).Convert(input.Type.GetType()).Interface())
+		case "fee":
+			values = append(values, big.NewInt(5))
+		case "payload":
+			values = append(values, []byte{1, 2, 3})
+		}
+	}
+	data, err := event.Inputs.Pack(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := rlp.EncodeToBytes(&etypes.Log{
+		Address: common.HexToAddress("0x02"),
+		Topics:  []common.Hash{event.ID},
+		Data:    data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return messageCall{
+		message: &chain.EthereumOutboundMessage{
+			Call: call,
+			Args: []interface{}{parachain.Message{Data: encoded}},
+		},
+		nonce: nonce,
+	}
+}
+
+func newTestWriter() *ParachainWriter {
+	logger, _ := test.NewNullLogger()
+	channelConfigs := make(map[string]*ethereum.ChannelConfig)
+	for i := range testChannels {
+		channelConfigs[testChannels[i].Call] = &testChannels[i]
+	}
+	return &ParachainWriter{
+		config:          &parachain.Config{},
+		channelConfigs:  channelConfigs,
+		log:             logger.WithField("chain", "Parachain"),
+		channels:        make(map[string]*inboundChannel),
+		deliveredNonces: make(map[string]uint64),
+	}
+}
+
+func partNonces(ch *inboundChannel) [][]uint64 {
+	var nonces [][]uint64
+	for _, part := range ch.parts {
+		var p []uint64
+		for _, m := range part {
+			p = append(p, m.nonce)
+		}
+		nonces = append(nonces, p)
+	}
+	return nonces
+}
+
+func TestMessageNonce(t *testing.T) {
+	wr := newTestWriter()
+
+	for _, call := range []string{"BasicInboundModule.submit", "IncentivizedInboundModule.submit"} {
+		m := makeChannelMessage(t, call, 42)
+		nonce, err := wr.messageNonce(m.message)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(42), nonce)
+	}
+
+	_, err := wr.messageNonce(&chain.EthereumOutboundMessage{Call: "BasicInboundModule.submit"})
+	assert.Error(t, err)
+
+	m := makeChannelMessage(t, "BasicInboundModule.submit", 42)
+	m.message.Call = "UnknownModule.submit"
+	_, err = wr.messageNonce(m.message)
+	assert.Error(t, err)
+}
+
+func TestRecoverMessages(t *testing.T) {
+	wr := newTestWriter()
+	basic, incentivized := "BasicInboundModule.submit", "IncentivizedInboundModule.submit"
+	failure := &parachain.ExtrinsicResult{Error: &parachain.DispatchError{IsOther: true}}
+
+	err := wr.recoverMessages([]messageCall{
+		makeChannelMessage(t, basic, 7),
+		makeChannelMessage(t, incentivized, 3),
+		makeChannelMessage(t, basic, 5),
+		makeChannelMessage(t, basic, 6),
+	}, failure)
+	assert.Nil(t, err)
+
+	// Each channel's messages are resubmitted on their own first
+	assert.Equal(t, [][]uint64{{5, 6, 7}}, partNonces(wr.channels[basic]))
+	assert.Equal(t, [][]uint64{{3}}, partNonces(wr.channels[incentivized]))
+
+	// Later messages are held back while recovering, once each
+	assert.False(t, wr.isHeld("Other.submit"))
+	for _, nonce := range []uint64{9, 8, 9} {
+		ok, err := wr.admitMessage(makeChannelMessage(t, basic, nonce), true)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+	ch := wr.channels[basic]
+	if assert.Len(t, ch.held, 2) {
+		assert.Equal(t, uint64(8), ch.held[0].nonce)
+		assert.Equal(t, uint64(9), ch.held[1].nonce)
+	}
+
+	// The lower half is resubmitted before the upper half
+	ch.partSubmitted = true
+	assert.Nil(t, wr.onPartProcessed(&partResult{call: basic, result: failure}))
+	assert.Equal(t, [][]uint64{{5}, {6, 7}}, partNonces(ch))
+	assert.False(t, ch.partSubmitted)
+
+	// Parts whose outcome is unknown are resubmitted
+	ch.partSubmitted = true
+	assert.Nil(t, wr.onPartProcessed(&partResult{call: basic}))
+	assert.Equal(t, [][]uint64{{5}, {6, 7}}, partNonces(ch))
+	assert.False(t, ch.partSubmitted)
+
+	assert.Nil(t, wr.onPartProcessed(&partResult{call: basic, result: &parachain.ExtrinsicResult{}}))
+	assert.Equal(t, [][]uint64{{6, 7}}, partNonces(ch))
+
+	// Messages failing again after the channel's earlier failure are held back
+	err = wr.recoverMessages([]messageCall{makeChannelMessage(t, basic, 10)}, failure)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]uint64{{6, 7}}, partNonces(ch))
+	assert.Len(t, ch.held, 3)
+}
+
+func TestReleaseChannel_HeldMessage(t *testing.T) {
+	wr := newTestWriter()
+	call := "BasicInboundModule.submit"
+	ch := wr.channel(call)
+	ch.parts = [][]messageCall{{makeChannelMessage(t, call, 6)}}
+	ch.held = []messageCall{makeChannelMessage(t, call, 5), makeChannelMessage(t, call, 7)}
+	ch.blockedAt = 5
+
+	released, err := wr.releaseChannel(call, ch)
+	assert.Nil(t, err)
+	assert.True(t, released)
+	assert.Equal(t, [][]uint64{{5, 6, 7}}, partNonces(ch))
+	assert.Empty(t, ch.held)
+	assert.Equal(t, uint64(0), ch.blockedAt)
+}
+
+func TestInboundChannel_DropDelivered(t *testing.T) {
+	call := "BasicInboundModule.submit"
+	ch := &inboundChannel{
+		parts: [][]messageCall{
+			{makeChannelMessage(t, call, 4), makeChannelMessage(t, call, 5)},
+			{makeChannelMessage(t, call, 6), makeChannelMessage(t, call, 7)},
+		},
+		held:          []messageCall{makeChannelMessage(t, call, 8)},
+		partSubmitted: true,
+	}
+
+	ch.dropDelivered(6)
+	assert.Equal(t, [][]uint64{{7}}, partNonces(ch))
+	assert.False(t, ch.partSubmitted)
+	assert.Len(t, ch.held, 1)
+
+	ch.dropDelivered(8)
+	assert.True(t, ch.idle())
+}
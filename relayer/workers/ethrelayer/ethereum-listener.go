@@ -5,49 +5,60 @@ package ethrelayer
 
 import (
 	"context"
+	"math/big"
+	"time"
 
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	etypes "github.com/ethereum/go-ethereum/core/types"
 
 	gethTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/sirupsen/logrus"
+	"github.com/snowfork/ethashproof"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/snowfork/polkadot-ethereum/relayer/chain"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum/syncer"
-	"github.com/snowfork/polkadot-ethereum/relayer/contracts/basic"
-	"github.com/snowfork/polkadot-ethereum/relayer/contracts/incentivized"
 )
 
+// Interval at which the listener compares its progress with the parachain's light client
+const lightClientCheckInterval = time.Minute
+
 // EthereumListener streams the Ethereum blockchain for application events
 type EthereumListener struct {
-	config                      *ethereum.Config
-	conn                        *ethereum.Connection
-	basicOutboundChannel        *basic.BasicOutboundChannel
-	incentivizedOutboundChannel *incentivized.IncentivizedOutboundChannel
-	mapping                     map[common.Address]string
-	payloads                    chan<- ParachainPayload
-	headerSyncer                *syncer.Syncer
-	log                         *logrus.Entry
+	config      *ethereum.Config
+	conn        *ethereum.Connection
+	lightClient *LightClient
+	mapping     map[common.Address]string
+	payloads    chan<- ParachainPayload
+	resyncs     <-chan struct{}
+	logFetcher  *ethereum.LogFetcher
+	// Recently received headers, used to find the header of each finalized block
+	recentHeaders map[common.Hash]*gethTypes.Header
+	// Height of the next header to be forwarded
+	nextHeight uint64
+	log        *logrus.Entry
 }
 
 func NewEthereumListener(
 	config *ethereum.Config,
 	conn *ethereum.Connection,
+	lightClient *LightClient,
 	payloads chan<- ParachainPayload,
+	resyncs <-chan struct{},
 	log *logrus.Entry,
 ) *EthereumListener {
 	return &EthereumListener{
-		config:                      config,
-		conn:                        conn,
-		basicOutboundChannel:        nil,
-		incentivizedOutboundChannel: nil,
-		mapping:                     make(map[common.Address]string),
-		payloads:                    payloads,
-		headerSyncer:                nil,
-		log:                         log,
+		config:        config,
+		conn:          conn,
+		lightClient:   lightClient,
+		mapping:       make(map[common.Address]string),
+		payloads:      payloads,
+		resyncs:       resyncs,
+		logFetcher:    nil,
+		recentHeaders: make(map[common.Hash]*gethTypes.Header),
+		log:           log,
 	}
 }
 
@@ -68,41 +79,23 @@ func (li *EthereumListener) Start(cxt context.Context, eg *errgroup.Group, initB
 		return closeWithError(err)
 	}
 
-	basicOutboundChannel, err := basic.NewBasicOutboundChannel(common.HexToAddress(li.config.Channels.Basic.Outbound), li.conn.GetClient())
-	if err != nil {
-		return closeWithError(err)
-	}
-	li.basicOutboundChannel = basicOutboundChannel
-
-	incentivizedOutboundChannel, err := incentivized.NewIncentivizedOutboundChannel(common.HexToAddress(li.config.Channels.Incentivized.Outbound), li.conn.GetClient())
-	if err != nil {
-		return closeWithError(err)
+	for _, channel := range li.config.Channels {
+		err := channel.Validate()
+		if err != nil {
+			return closeWithError(err)
+		}
+		li.mapping[channel.OutboundAddress()] = channel.Call
 	}
-	li.incentivizedOutboundChannel = incentivizedOutboundChannel
-
-	li.mapping[common.HexToAddress(li.config.Channels.Basic.Outbound)] = "BasicInboundChannel.submit"
-	li.mapping[common.HexToAddress(li.config.Channels.Incentivized.Outbound)] = "IncentivizedInboundChannel.submit"
 
-	headersIn := make(chan *gethTypes.Header, 5)
-	li.headerSyncer = syncer.NewSyncer(
-		descendantsUntilFinal,
-		syncer.NewHeaderLoader(li.conn.GetClient()),
-		headersIn,
-		li.log,
-	)
+	li.logFetcher = ethereum.NewLogFetcher(li.conn.GetClient(), li.config.Channels, descendantsUntilFinal, li.log)
 
 	eg.Go(func() error {
-		err := li.processEventsAndHeaders(cxt, initBlockHeight, descendantsUntilFinal, headersIn, hcs)
-
-		// Ensures the context is canceled so that the channel below is
-		// closed by the syncer
-		eg.Go(func() error { return err })
-
-		// Avoid deadlock if the syncer is still trying to send a header
-		for range headersIn {
-			li.log.Debug("Discarded header")
+		syncFrom, err := li.backfill(cxt, initBlockHeight, descendantsUntilFinal, hcs)
+		if err != nil {
+			return closeWithError(err)
 		}
 
+		err = li.processEventsAndHeaders(cxt, syncFrom, descendantsUntilFinal, hcs)
 		return closeWithError(err)
 	})
 
@@ -113,111 +106,228 @@ func (li *EthereumListener) processEventsAndHeaders(
 	ctx context.Context,
 	initBlockHeight uint64,
 	descendantsUntilFinal uint64,
-	headers <-chan *gethTypes.Header,
 	hcs *ethereum.HeaderCacheState,
 ) error {
-	headerEg, headerCtx := errgroup.WithContext(ctx)
+	height := initBlockHeight
+	for {
+		resyncHeight, err := li.syncHeaders(ctx, height, descendantsUntilFinal, hcs)
+		if err != nil || resyncHeight == 0 {
+			return err
+		}
+		height = resyncHeight
+	}
+}
+
+// syncHeaders forwards headers and their events starting at the given height. It
+// returns the height to resync from if the parachain's light client has diverged from
+// our progress, or zero if the header stream ended.
+func (li *EthereumListener) syncHeaders(
+	ctx context.Context,
+	initBlockHeight uint64,
+	descendantsUntilFinal uint64,
+	hcs *ethereum.HeaderCacheState,
+) (uint64, error) {
+	syncCtx, cancelSync := context.WithCancel(ctx)
+	defer cancelSync()
+	headerEg, headerCtx := errgroup.WithContext(syncCtx)
+
+	headers := make(chan *gethTypes.Header, 5)
+	headerSyncer := syncer.NewSyncer(
+		descendantsUntilFinal,
+		syncer.NewHeaderLoader(li.conn.GetClient()),
+		headers,
+		li.log,
+	)
 
-	li.log.Info("Syncing headers starting...")
-	err := li.headerSyncer.StartSync(headerCtx, headerEg, initBlockHeight-1)
+	// stopSync returns the error which stopped the syncer, if any
+	stopSync := func() error {
+		cancelSync()
+		// Avoid deadlock if the syncer is still trying to send a header
+		for range headers {
+			li.log.Debug("Discarded header")
+		}
+		return headerEg.Wait()
+	}
+
+	li.nextHeight = initBlockHeight
+	li.log.WithField("blockNumber", initBlockHeight).Info("Syncing headers starting...")
+	err := headerSyncer.StartSync(headerCtx, headerEg, initBlockHeight-1)
 	if err != nil {
 		li.log.WithError(err).Error("Failed to start header sync")
-		return err
+		stopSync()
+		return 0, err
 	}
 
+	ticker := time.NewTicker(lightClientCheckInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			stopSync()
+			return 0, ctx.Err()
 		case <-headerCtx.Done():
-			return headerCtx.Err()
+			err := stopSync()
+			if err == nil {
+				err = headerCtx.Err()
+			}
+			return 0, err
+		case <-ticker.C:
+			resyncHeight, err := li.checkLightClient(ctx, false)
+			if err != nil {
+				stopSync()
+				return 0, err
+			}
+			if resyncHeight != 0 {
+				stopSync()
+				return resyncHeight, nil
+			}
+		case <-li.resyncs:
+			resyncHeight, err := li.checkLightClient(ctx, true)
+			if err != nil {
+				stopSync()
+				return 0, err
+			}
+			stopSync()
+			return resyncHeight, nil
 		case gethheader, ok := <-headers:
 			if !ok {
-				return nil
+				stopSync()
+				return 0, nil
 			}
 
-			header, err := li.makeOutgoingHeader(hcs, gethheader)
+			err := li.forwardHeader(ctx, hcs, gethheader, descendantsUntilFinal)
 			if err != nil {
-				return err
+				stopSync()
+				return 0, err
 			}
 
-			// Don't attempt to forward events prior to genesis block
-			if descendantsUntilFinal > gethheader.Number.Uint64() {
-				li.payloads <- ParachainPayload{Header: header}
-				continue
+			if gethheader.Number.Uint64() >= li.nextHeight {
+				li.nextHeight = gethheader.Number.Uint64() + 1
 			}
+		}
+	}
+}
 
-			finalizedBlockNumber := gethheader.Number.Uint64() - descendantsUntilFinal
-			var events []*etypes.Log
+func (li *EthereumListener) forwardHeader(
+	ctx context.Context,
+	hcs *ethereum.HeaderCacheState,
+	gethheader *gethTypes.Header,
+	descendantsUntilFinal uint64,
+) error {
+	header, err := li.makeOutgoingHeader(hcs, gethheader)
+	if err != nil {
+		return err
+	}
 
-			filterOptions := bind.FilterOpts{Start: finalizedBlockNumber, End: &finalizedBlockNumber, Context: ctx}
+	// Don't attempt to forward events prior to genesis block
+	if descendantsUntilFinal > gethheader.Number.Uint64() {
+		li.payloads <- ParachainPayload{Header: header}
+		return nil
+	}
 
-			basicEvents, err := li.queryBasicEvents(li.basicOutboundChannel, &filterOptions)
-			if err != nil {
-				li.log.WithError(err).Error("Failure fetching event logs")
-				return err
-			}
-			events = append(events, basicEvents...)
+	li.addRecentHeader(gethheader, descendantsUntilFinal)
 
-			incentivizedEvents, err := li.queryIncentivizedEvents(li.incentivizedOutboundChannel, &filterOptions)
-			if err != nil {
-				li.log.WithError(err).Error("Failure fetching event logs")
-				return err
-			}
-			events = append(events, incentivizedEvents...)
+	finalizedBlockNumber := gethheader.Number.Uint64() - descendantsUntilFinal
+	finalizedHeader := li.findAncestor(gethheader, descendantsUntilFinal)
 
-			messages, err := li.makeOutgoingMessages(ctx, hcs, events)
-			if err != nil {
-				return err
-			}
+	events, err := li.logFetcher.FetchLogs(ctx, finalizedBlockNumber, finalizedHeader)
+	if err != nil {
+		li.log.WithError(err).WithField("blockNumber", finalizedBlockNumber).Error("Failure fetching event logs")
+		return err
+	}
 
-			li.payloads <- ParachainPayload{Header: header, Messages: messages}
-		}
+	messages, err := li.makeOutgoingMessages(ctx, hcs, events)
+	if err != nil {
+		return err
 	}
+
+	li.payloads <- ParachainPayload{Header: header, Messages: messages}
+	return nil
 }
 
-func (li *EthereumListener) queryBasicEvents(contract *basic.BasicOutboundChannel, options *bind.FilterOpts) ([]*etypes.Log, error) {
-	var events []*etypes.Log
+// checkLightClient compares our progress with the parachain's light client. It returns
+// the height to resync from, or zero if no resync is needed. If `rejected` is set, one
+// of our headers was rejected and we always resync from the best common ancestor.
+func (li *EthereumListener) checkLightClient(ctx context.Context, rejected bool) (uint64, error) {
+	finalized, err := li.lightClient.FinalizedBlock()
+	if err != nil {
+		li.log.WithError(err).Error("Failed to query finalized block of light client")
+		return 0, err
+	}
+
+	if !rejected && uint64(finalized.Number) < li.nextHeight {
+		return 0, nil
+	}
 
-	iter, err := contract.FilterMessage(options)
+	best, err := li.lightClient.BestBlock()
 	if err != nil {
-		return nil, err
+		li.log.WithError(err).Error("Failed to query best block of light client")
+		return 0, err
 	}
 
-	for {
-		more := iter.Next()
-		if !more {
-			err = iter.Error()
-			if err != nil {
-				return nil, err
-			}
-			break
+	ancestor, err := li.findCommonAncestor(ctx, finalized, best)
+	if err != nil {
+		return 0, err
+	}
+
+	li.log.WithFields(logrus.Fields{
+		"finalizedBlock": finalized.Number,
+		"bestBlock":      best.Number,
+		"commonAncestor": ancestor,
+		"nextHeight":     li.nextHeight,
+		"rejected":       rejected,
+	}).Info("Resyncing with light client")
+
+	return ancestor + 1, nil
+}
+
+// findCommonAncestor returns the number of the highest block in our canonical chain
+// that the light client has imported. The finalized block is always a common ancestor.
+func (li *EthereumListener) findCommonAncestor(ctx context.Context, finalized *ethereum.HeaderID, best *ethereum.HeaderID) (uint64, error) {
+	for number := uint64(best.Number); number > uint64(finalized.Number); number-- {
+		header, err := li.conn.GetClient().HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			li.log.WithField("blockNumber", number).WithError(err).Error("Failed to retrieve header")
+			return 0, err
+		}
+
+		imported, err := li.lightClient.HeaderExists(types.NewH256(header.Hash().Bytes()))
+		if err != nil {
+			return 0, err
+		}
+		if imported {
+			return number, nil
 		}
-		events = append(events, &iter.Event.Raw)
 	}
-	return events, nil
+
+	return uint64(finalized.Number), nil
 }
 
-func (li *EthereumListener) queryIncentivizedEvents(contract *incentivized.IncentivizedOutboundChannel, options *bind.FilterOpts) ([]*etypes.Log, error) {
-	var events []*etypes.Log
+func (li *EthereumListener) addRecentHeader(header *gethTypes.Header, descendantsUntilFinal uint64) {
+	li.recentHeaders[header.Hash()] = header
 
-	iter, err := contract.FilterMessage(options)
-	if err != nil {
-		return nil, err
+	// Keep a few more headers than needed to tolerate short reorgs
+	number := header.Number.Uint64()
+	for hash, h := range li.recentHeaders {
+		if h.Number.Uint64()+2*descendantsUntilFinal+1 < number {
+			delete(li.recentHeaders, hash)
+		}
 	}
+}
 
-	for {
-		more := iter.Next()
-		if !more {
-			err = iter.Error()
-			if err != nil {
-				return nil, err
-			}
-			break
+// findAncestor walks back from a header through recently received headers. Returns
+// nil if the ancestor isn't known, e.g. shortly after starting up.
+func (li *EthereumListener) findAncestor(header *gethTypes.Header, depth uint64) *gethTypes.Header {
+	ancestor := header
+	for i := uint64(0); i < depth; i++ {
+		parent, ok := li.recentHeaders[ancestor.ParentHash]
+		if !ok {
+			return nil
 		}
-		events = append(events, &iter.Event.Raw)
+		ancestor = parent
 	}
-	return events, nil
+	return ancestor
 }
 
 func (li *EthereumListener) makeOutgoingMessages(
@@ -268,6 +378,13 @@ func (li *EthereumListener) makeOutgoingHeader(
 		return nil, err
 	}
 
+	return li.makeHeaderWithCache(gethheader, cache)
+}
+
+func (li *EthereumListener) makeHeaderWithCache(
+	gethheader *gethTypes.Header,
+	cache *ethashproof.DatasetMerkleTreeCache,
+) (*chain.Header, error) {
 	header, err := ethereum.MakeHeaderFromEthHeader(gethheader, cache, li.log)
 	if err != nil {
 		li.log.WithFields(logrus.Fields{
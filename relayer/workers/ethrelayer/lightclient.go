@@ -0,0 +1,86 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethrelayer
+
+import (
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// LightClient reads the state of the parachain's VerifierLightclient pallet
+type LightClient struct {
+	conn *parachain.Connection
+}
+
+func NewLightClient(conn *parachain.Connection) *LightClient {
+	return &LightClient{conn: conn}
+}
+
+// Value of the `BestBlock` storage item
+type bestBlock struct {
+	ID              ethereum.HeaderID
+	TotalDifficulty types.U256
+}
+
+func (lc *LightClient) FinalizedBlock() (*ethereum.HeaderID, error) {
+	key, err := types.CreateStorageKey(lc.conn.GetMetadata(), "VerifierLightclient", "FinalizedBlock", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var finalized ethereum.HeaderID
+	_, err = lc.conn.GetAPI().RPC.State.GetStorageLatest(key, &finalized)
+	if err != nil {
+		return nil, err
+	}
+
+	return &finalized, nil
+}
+
+func (lc *LightClient) BestBlock() (*ethereum.HeaderID, error) {
+	key, err := types.CreateStorageKey(lc.conn.GetMetadata(), "VerifierLightclient", "BestBlock", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var best bestBlock
+	_, err = lc.conn.GetAPI().RPC.State.GetStorageLatest(key, &best)
+	if err != nil {
+		return nil, err
+	}
+
+	return &best.ID, nil
+}
+
+// Header returns the imported header with the given hash
+func (lc *LightClient) Header(hash types.H256) (*ethereum.StoredHeader, bool, error) {
+	key, err := types.CreateStorageKey(lc.conn.GetMetadata(), "VerifierLightclient", "Headers", hash[:], nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var storedHeader ethereum.StoredHeader
+	ok, err := lc.conn.GetAPI().RPC.State.GetStorageLatest(key, &storedHeader)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &storedHeader, ok, nil
+}
+
+func (lc *LightClient) HeaderExists(hash types.H256) (bool, error) {
+	key, err := types.CreateStorageKey(lc.conn.GetMetadata(), "VerifierLightclient", "Headers", hash[:], nil)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := lc.conn.GetAPI().RPC.State.GetStorageRawLatest(key)
+	if err != nil {
+		return false, err
+	}
+
+	return data != nil && len(*data) > 0, nil
+}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/snowfork/go-substrate-rpc-client/v3/types"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
 	"github.com/snowfork/polkadot-ethereum/relayer/crypto/sr25519"
@@ -53,23 +52,34 @@ func (w *Worker) Start(ctx context.Context, eg *errgroup.Group) error {
 
 	// channel for payloads from ethereum
 	payloads := make(chan ParachainPayload, 1)
+	// channel for the writer to request a resync with the light client
+	resyncs := make(chan struct{}, 1)
+
+	lightClient := NewLightClient(w.paraconn)
 
 	listener := NewEthereumListener(
 		w.ethconfig,
 		w.ethconn,
+		lightClient,
 		payloads,
+		resyncs,
 		w.log,
 	)
 	writer := NewParachainWriter(
+		w.paraconfig,
+		w.ethconfig.Channels,
 		w.paraconn,
+		lightClient,
 		payloads,
+		resyncs,
 		w.log,
 	)
 
-	finalizedBlockNumber, err := w.queryFinalizedBlockNumber()
+	finalized, err := lightClient.FinalizedBlock()
 	if err != nil {
 		return err
 	}
+	finalizedBlockNumber := uint64(finalized.Number)
 	w.log.WithField("blockNumber", finalizedBlockNumber).Debug("Retrieved finalized block number from parachain")
 
 	err = listener.Start(ctx, eg, finalizedBlockNumber+1, uint64(w.ethconfig.DescendantsUntilFinal))
@@ -85,21 +95,6 @@ func (w *Worker) Start(ctx context.Context, eg *errgroup.Group) error {
 	return nil
 }
 
-func (w *Worker) queryFinalizedBlockNumber() (uint64, error) {
-	storageKey, err := types.CreateStorageKey(w.paraconn.Metadata(), "VerifierLightclient", "FinalizedBlock", nil, nil)
-	if err != nil {
-		return 0, err
-	}
-
-	var finalizedHeader ethereum.HeaderID
-	_, err = w.paraconn.Api().RPC.State.GetStorageLatest(storageKey, &finalizedHeader)
-	if err != nil {
-		return 0, err
-	}
-
-	return uint64(finalizedHeader.Number), nil
-}
-
 func (w *Worker) connect(ctx context.Context) error {
 	kpForPara, err := sr25519.NewKeypairFromSeed(w.paraconfig.PrivateKey, 42)
 	if err != nil {
@@ -107,7 +102,7 @@ func (w *Worker) connect(ctx context.Context) error {
 	}
 
 	w.ethconn = ethereum.NewConnection(w.ethconfig.Endpoint, nil, w.log)
-	w.paraconn = parachain.NewConnection(w.paraconfig.Endpoint, kpForPara.AsKeyringPair(), w.log)
+	w.paraconn = parachain.NewConnection(w.paraconfig.Endpoint, kpForPara.AsKeyringPair(), w.log, w.paraconfig.Endpoints...)
 
 	err = w.ethconn.Connect(ctx)
 	if err != nil {
@@ -1,9 +1,12 @@
 package ethrelayer
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"math/big"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/sirupsen/logrus"
 
 	"golang.org/x/sync/errgroup"
@@ -19,24 +22,69 @@ type ParachainPayload struct {
 	Messages []*chain.EthereumOutboundMessage
 }
 
+// Number of recently written headers whose receipts roots are kept in memory
+// for verifying message proofs
+const receiptsRootCacheSize = 64
+
+// Upper bound for the size of the signature and signed extensions, which aren't
+// included when measuring the length of an unsigned extrinsic
+const signedExtrinsicOverhead = 256
+
 type ParachainWriter struct {
+	config      *parachain.Config
 	conn        *parachain.Connection
+	lightClient *LightClient
 	payloads    <-chan ParachainPayload
+	resyncs     chan<- struct{}
 	log         *logrus.Entry
 	nonce       uint32
 	pool        *parachain.ExtrinsicPool
-	genesisHash types.Hash
+	builder     *parachain.ExtrinsicBuilder
+	limits      *parachain.BlockLimits
+	retries     chan retryBatch
+	eg          *errgroup.Group
+	// Set once the node turns out not to support dry runs
+	dryRunUnsupported bool
+	// Latest known finalized block of the light client
+	finalizedBlockNumber uint64
+	receiptsRoots        map[types.H256]types.H256
+	receiptsRootOrder    []types.H256
+	// Configuration of the channels whose messages are submitted, by call
+	channelConfigs map[string]*ethereum.ChannelConfig
+	// Inbound channels with messages which can't be submitted yet, by call
+	channels map[string]*inboundChannel
+	// Nonce of the last message received by each inbound channel, as far as known
+	deliveredNonces map[string]uint64
+	// Number of the header of the last payload received from the listener
+	lastPayloadBlock uint64
 }
 
 func NewParachainWriter(
+	config *parachain.Config,
+	channels ethereum.ChannelsConfig,
 	conn *parachain.Connection,
+	lightClient *LightClient,
 	payloads <-chan ParachainPayload,
+	resyncs chan<- struct{},
 	log *logrus.Entry,
 ) *ParachainWriter {
+	channelConfigs := make(map[string]*ethereum.ChannelConfig, len(channels))
+	for i := range channels {
+		channelConfigs[channels[i].Call] = &channels[i]
+	}
+
 	return &ParachainWriter{
-		conn:     conn,
-		payloads: payloads,
-		log:      log,
+		config:          config,
+		conn:            conn,
+		lightClient:     lightClient,
+		payloads:        payloads,
+		resyncs:         resyncs,
+		retries:         make(chan retryBatch, parachain.MaxWatchedExtrinsics),
+		log:             log,
+		receiptsRoots:   make(map[types.H256]types.H256, receiptsRootCacheSize),
+		channelConfigs:  channelConfigs,
+		channels:        make(map[string]*inboundChannel),
+		deliveredNonces: make(map[string]uint64),
 	}
 }
 
@@ -54,19 +102,45 @@ func (wr *ParachainWriter) Start(ctx context.Context, eg *errgroup.Group) error
 		return err
 	}
 
+	switch wr.config.DryRun {
+	case parachain.DryRunDisabled, parachain.DryRunWarn, parachain.DryRunRefuse:
+	default:
+		return cancelWithError(fmt.Errorf("invalid dry-run mode %q", wr.config.DryRun))
+	}
+
 	nonce, err := wr.queryAccountNonce()
 	if err != nil {
 		return cancelWithError(err)
 	}
 	wr.nonce = nonce
 
-	genesisHash, err := wr.conn.GetAPI().RPC.Chain.GetBlockHash(0)
+	builder, err := parachain.NewExtrinsicBuilder(wr.conn, wr.config, wr.log)
+	if err != nil {
+		return cancelWithError(err)
+	}
+	err = builder.Start(ctx, eg)
+	if err != nil {
+		return cancelWithError(err)
+	}
+	wr.builder = builder
+
+	finalized, err := wr.lightClient.FinalizedBlock()
 	if err != nil {
 		return cancelWithError(err)
 	}
-	wr.genesisHash = genesisHash
+	wr.finalizedBlockNumber = uint64(finalized.Number)
+
+	if wr.config.MaxHeadersPerBatch > 1 {
+		limits, err := parachain.ReadBlockLimits(wr.conn.GetMetadata())
+		if err != nil {
+			// Batching can't be bounded without knowing the limits
+			wr.log.WithError(err).Warn("Failed to read block limits. Submitting one header per extrinsic")
+		}
+		wr.limits = limits
+	}
 
 	wr.pool = parachain.NewExtrinsicPool(eg, wr.conn, wr.log)
+	wr.eg = eg
 
 	eg.Go(func() error {
 		err := wr.writeLoop(ctx)
@@ -97,131 +171,692 @@ func (wr *ParachainWriter) queryAccountNonce() (uint32, error) {
 	return uint32(accountInfo.Nonce), nil
 }
 
-func (wr *ParachainWriter) queryImportedHeaderExists(hash types.H256) (bool, error) {
-	key, err := types.CreateStorageKey(wr.conn.GetMetadata(), "VerifierLightclient", "Headers", hash[:], nil)
-	if err != nil {
-		return false, err
+func (wr *ParachainWriter) cacheReceiptsRoot(header *ethereum.Header) {
+	hash := header.ID().Hash
+	if _, exists := wr.receiptsRoots[hash]; exists {
+		return
+	}
+
+	if len(wr.receiptsRootOrder) == receiptsRootCacheSize {
+		delete(wr.receiptsRoots, wr.receiptsRootOrder[0])
+		wr.receiptsRootOrder = wr.receiptsRootOrder[1:]
 	}
 
-	var headerOption types.OptionBytes
-	ok, err := wr.conn.GetAPI().RPC.State.GetStorageLatest(key, &headerOption)
+	wr.receiptsRoots[hash] = header.Fields.ReceiptsRoot
+	wr.receiptsRootOrder = append(wr.receiptsRootOrder, hash)
+}
+
+// lookupReceiptsRoot returns the receipts root of the header with the given hash,
+// falling back to the parachain's light client if we haven't written the header ourselves
+func (wr *ParachainWriter) lookupReceiptsRoot(hash types.H256) (types.H256, error) {
+	root, exists := wr.receiptsRoots[hash]
+	if exists {
+		return root, nil
+	}
+
+	storedHeader, ok, err := wr.lightClient.Header(hash)
 	if err != nil {
-		return false, err
+		return types.H256{}, err
 	}
 	if !ok {
-		return false, fmt.Errorf("Unable to query header for hash %s", hash.Hex())
+		return types.H256{}, fmt.Errorf("Header %s is unknown", hash.Hex())
 	}
 
-	return headerOption.IsSome(), nil
+	return storedHeader.Header.Fields.ReceiptsRoot, nil
+}
+
+// verifyMessage checks a message the same way the parachain will, so that
+// we don't pay fees for submitting messages which are bound to fail. Messages
+// with invalid proofs are dropped.
+func (wr *ParachainWriter) verifyMessage(m messageCall) (bool, error) {
+	for _, arg := range m.message.Args {
+		message, ok := arg.(parachain.Message)
+		if !ok {
+			continue
+		}
+
+		root, err := wr.lookupReceiptsRoot(message.Proof.BlockHash)
+		if err != nil {
+			return false, err
+		}
+
+		err = ethereum.VerifyMessage(common.Hash(root), &message)
+		if err != nil {
+			return false, wr.dropMessage(m, fmt.Sprintf("proof verification failed: %s", err))
+		}
+	}
+
+	return true, nil
 }
 
 func (wr *ParachainWriter) writeLoop(ctx context.Context) error {
+	var next *payloadCalls
+
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case payload, ok := <-wr.payloads:
-			if !ok {
-				return nil
-			}
+		var batch []*payloadCalls
 
-			header := payload.Header.HeaderData.(ethereum.Header)
-			err := wr.WritePayload(ctx, &payload)
+		if next != nil {
+			batch = append(batch, next)
+			next = nil
+		} else {
+			err := wr.writeChannels(ctx)
 			if err != nil {
-				wr.log.WithError(err).WithFields(logrus.Fields{
-					"blockNumber":  header.Fields.Number,
-					"messageCount": len(payload.Messages),
-				}).Error("Failure submitting header and messages to Substrate")
 				return err
 			}
 
-			wr.log.WithFields(logrus.Fields{
-				"blockNumber":  header.Fields.Number,
-				"messageCount": len(payload.Messages),
-			}).Info("Submitted header and messages to Substrate")
+			// Retries take precedence over new payloads
+			select {
+			case retry := <-wr.retries:
+				err := wr.retry(ctx, retry)
+				if err != nil {
+					return err
+				}
+				continue
+			default:
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case retry := <-wr.retries:
+				err := wr.retry(ctx, retry)
+				if err != nil {
+					return err
+				}
+				continue
+			case payload, ok := <-wr.payloads:
+				if !ok {
+					return nil
+				}
+
+				calls, err := wr.receivePayload(ctx, &payload)
+				if err != nil {
+					return err
+				}
+				batch = append(batch, calls)
+			}
+		}
+
+		// Merge payloads which are already queued, e.g. while catching up
+		closed := false
+	merge:
+		for uint(len(batch)) < wr.maxHeadersPerBatch() {
+			select {
+			case payload, ok := <-wr.payloads:
+				if !ok {
+					closed = true
+					break merge
+				}
+
+				calls, err := wr.receivePayload(ctx, &payload)
+				if err != nil {
+					return err
+				}
+
+				fits, err := wr.fitsInExtrinsic(flattenCalls(append(batch[:len(batch):len(batch)], calls)))
+				if err != nil {
+					return err
+				}
+				if !fits {
+					next = calls
+					break merge
+				}
+
+				batch = append(batch, calls)
+			default:
+				break merge
+			}
+		}
+
+		err := wr.writeBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		if closed && next == nil {
+			return nil
 		}
 	}
 }
 
-// Write submits a transaction to the chain
-func (wr *ParachainWriter) write(ctx context.Context, c types.Call, onProcessed func() error) error {
-	ext := types.NewExtrinsic(c)
+// receivePayload creates the calls for a payload forwarded by the listener. If the listener
+// rewound while resyncing with the light client, the channels are brought up to date first,
+// as messages which were forwarded before may have been delivered since.
+func (wr *ParachainWriter) receivePayload(ctx context.Context, payload *ParachainPayload) (*payloadCalls, error) {
+	if header, ok := payload.Header.HeaderData.(ethereum.Header); ok {
+		blockNumber := uint64(header.Fields.Number)
+		if blockNumber <= wr.lastPayloadBlock {
+			err := wr.refreshChannels()
+			if err != nil {
+				return nil, err
+			}
+		}
+		wr.lastPayloadBlock = blockNumber
+	}
+
+	return wr.makePayloadCalls(ctx, payload)
+}
+
+// retryBatch holds the calls of a failed extrinsic which are resubmitted
+type retryBatch struct {
+	// Payloads whose calls are rebuilt before resubmitting
+	payloads []*ParachainPayload
+	// Messages which are resubmitted on their own
+	messages []messageCall
+	// Set if the messages failed on their own, so that their channels are recovered
+	failure *parachain.ExtrinsicResult
+	// Set for the outcome of resubmitting part of a recovering channel's messages
+	part *partResult
+}
+
+// retry resubmits the calls of an extrinsic which failed to dispatch. Headers which have
+// been imported by another relayer or can no longer be imported are skipped, so that
+// their messages are submitted on their own.
+func (wr *ParachainWriter) retry(ctx context.Context, retry retryBatch) error {
+	switch {
+	case retry.part != nil:
+		return wr.onPartProcessed(retry.part)
+	case retry.failure != nil:
+		return wr.recoverMessages(retry.messages, retry.failure)
+	case len(retry.payloads) == 0:
+		return wr.submitMessages(ctx, retry.messages)
+	}
 
-	latestHash, err := wr.conn.GetAPI().RPC.Chain.GetFinalizedHead()
+	finalized, err := wr.lightClient.FinalizedBlock()
 	if err != nil {
 		return err
 	}
+	wr.finalizedBlockNumber = uint64(finalized.Number)
+
+	var batch []*payloadCalls
+	for _, payload := range retry.payloads {
+		calls, err := wr.makePayloadCalls(ctx, payload)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, calls)
+	}
+
+	return wr.writeBatch(ctx, batch)
+}
+
+func (wr *ParachainWriter) maxHeadersPerBatch() uint {
+	if wr.limits == nil || wr.config.MaxHeadersPerBatch < 1 {
+		return 1
+	}
+	return wr.config.MaxHeadersPerBatch
+}
 
-	latestBlock, err := wr.conn.GetAPI().RPC.Chain.GetBlock(latestHash)
+// fitsInExtrinsic checks whether a batch of calls stays within the parachain's
+// length and weight limits for a single extrinsic. The weight is estimated by the
+// parachain, so if that fails only the length is checked.
+func (wr *ParachainWriter) fitsInExtrinsic(calls []types.Call) (bool, error) {
+	call, err := types.NewCall(wr.conn.GetMetadata(), "Utility.batch_all", calls)
 	if err != nil {
-		return err
+		return false, err
 	}
+	ext := types.NewExtrinsic(call)
 
-	era := parachain.NewMortalEra(uint64(latestBlock.Block.Header.Number))
+	encoded, err := types.EncodeToBytes(ext)
+	if err != nil {
+		return false, err
+	}
+	if uint64(len(encoded))+signedExtrinsicOverhead > uint64(wr.limits.MaxExtrinsicLength) {
+		return false, nil
+	}
 
-	rv, err := wr.conn.GetAPI().RPC.State.GetRuntimeVersionLatest()
+	info, err := wr.conn.QueryInfo(&ext)
 	if err != nil {
-		return err
+		wr.log.WithError(err).WithField("callCount", len(calls)).Warn(
+			"Failed to estimate weight of batch. Checking its length only")
+		return true, nil
 	}
 
-	o := types.SignatureOptions{
-		BlockHash:          latestHash,
-		Era:                era,
-		GenesisHash:        wr.genesisHash,
-		Nonce:              types.NewUCompactFromUInt(uint64(wr.nonce)),
-		SpecVersion:        rv.SpecVersion,
-		Tip:                types.NewUCompactFromUInt(0),
-		TransactionVersion: rv.TransactionVersion,
+	return info.Weight <= wr.limits.MaxExtrinsicWeight, nil
+}
+
+// requestResync asks the listener to resume syncing from the light client's state
+func (wr *ParachainWriter) requestResync() {
+	select {
+	case wr.resyncs <- struct{}{}:
+	default:
+		// A resync is already pending
 	}
+}
 
-	extI := ext
-	err = extI.Sign(*wr.conn.GetKeypair(), o)
+// Write submits a transaction to the chain. onProcessed is called with the dispatch
+// result of the extrinsic, or nil if it couldn't be determined.
+func (wr *ParachainWriter) write(
+	ctx context.Context,
+	c types.Call,
+	fields logrus.Fields,
+	onProcessed func(result *parachain.ExtrinsicResult) error,
+) error {
+	ext, err := wr.builder.Sign(c, wr.nonce)
 	if err != nil {
 		return err
 	}
 
-	wr.pool.WaitForSubmitAndWatch(ctx, wr.nonce, &extI, onProcessed)
+	wr.logFee(ext, fields)
+
+	dispatchErr := wr.dryRun(ext, fields)
+	if dispatchErr != nil && wr.config.DryRun == parachain.DryRunRefuse {
+		wr.log.WithFields(fields).WithField("error", dispatchErr.Error()).Warn("Refusing to submit extrinsic which fails to dispatch")
+		// Handled like a failed extrinsic, but without consuming the nonce
+		wr.eg.Go(func() error {
+			return onProcessed(&parachain.ExtrinsicResult{Error: dispatchErr, DryRun: true})
+		})
+		return nil
+	}
+
+	wr.pool.WaitForSubmitAndWatch(ctx, wr.nonce, ext, func(status *types.ExtrinsicStatus) error {
+		return onProcessed(wr.fetchExtrinsicResult(ext, status))
+	})
 
 	wr.nonce = wr.nonce + 1
 
 	return nil
 }
 
+// logFee logs the estimated fee for an extrinsic, and the average fee per header and message
+func (wr *ParachainWriter) logFee(ext *types.Extrinsic, fields logrus.Fields) {
+	info, err := wr.conn.QueryInfo(ext)
+	if err != nil {
+		wr.log.WithError(err).WithFields(fields).Warn("Failed to estimate fee for extrinsic")
+		return
+	}
+
+	fee, ok := new(big.Int).SetString(info.PartialFee.String(), 10)
+	if !ok {
+		wr.log.WithFields(fields).WithField("fee", info.PartialFee).Warn("Failed to parse estimated fee")
+		return
+	}
+
+	callCount := 0
+	for _, key := range []string{"headerCount", "messageCount"} {
+		if count, ok := fields[key].(int); ok {
+			callCount += count
+		}
+	}
+
+	log := wr.log.WithFields(fields).WithFields(logrus.Fields{
+		"fee":    fee.String(),
+		"weight": info.Weight,
+	})
+	if callCount > 0 {
+		log = log.WithField("feePerCall", new(big.Int).Div(fee, big.NewInt(int64(callCount))).String())
+	}
+	log.Info("Estimated fee for extrinsic")
+}
+
+// dryRun returns the error an extrinsic would fail to dispatch with. Since the dry run is
+// applied on top of the best block, it's skipped while any of our previous extrinsics are
+// pending, as the extrinsic might depend on them.
+func (wr *ParachainWriter) dryRun(ext *types.Extrinsic, fields logrus.Fields) *parachain.DispatchError {
+	if wr.config.DryRun == parachain.DryRunDisabled || wr.dryRunUnsupported {
+		return nil
+	}
+	if wr.pool.Pending() > 0 {
+		wr.log.WithFields(fields).Debug("Skipping dry run while previous extrinsics are pending")
+		return nil
+	}
+
+	result, err := wr.conn.DryRun(ext)
+	if err != nil {
+		if parachain.IsMethodNotFound(err) {
+			wr.log.WithError(err).Warn("Node doesn't support dry runs. Disabling them")
+			wr.dryRunUnsupported = true
+			return nil
+		}
+		wr.log.WithError(err).WithFields(fields).Warn("Failed to dry run extrinsic. Skipping the dry run")
+		return nil
+	}
+
+	if result.ValidityError != nil {
+		// The extrinsic pool handles invalid extrinsics on submission
+		wr.log.WithFields(fields).WithField("error", result.ValidityError.Error()).Warn("Dry run found extrinsic to be invalid")
+		return nil
+	}
+	if result.DispatchError != nil {
+		wr.log.WithFields(fields).WithField("error", result.DispatchError.Error()).Warn("Dry run found extrinsic to fail dispatch")
+	}
+
+	return result.DispatchError
+}
+
+// fetchExtrinsicResult returns the dispatch result of a processed extrinsic, or nil if its
+// status is unknown, the extrinsic wasn't included in a block or its events can't be decoded
+func (wr *ParachainWriter) fetchExtrinsicResult(ext *types.Extrinsic, status *types.ExtrinsicStatus) *parachain.ExtrinsicResult {
+	var blockHash types.Hash
+	switch {
+	case status == nil:
+		return nil
+	case status.IsInBlock:
+		blockHash = status.AsInBlock
+	case status.IsFinalized:
+		blockHash = status.AsFinalized
+	default:
+		wr.log.WithField("status", status).Debug("Extrinsic was processed without being included in a block")
+		return nil
+	}
+
+	result, err := wr.conn.FetchExtrinsicResult(blockHash, ext)
+	if err != nil {
+		wr.log.WithError(err).WithField("blockHash", blockHash.Hex()).Warn("Failed to fetch dispatch result of extrinsic")
+		return nil
+	}
+
+	return result
+}
+
 func (wr *ParachainWriter) WritePayload(ctx context.Context, payload *ParachainPayload) error {
+	calls, err := wr.makePayloadCalls(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	return wr.writeBatch(ctx, []*payloadCalls{calls})
+}
+
+// payloadCalls holds the calls for importing a payload's header and submitting its messages
+type payloadCalls struct {
+	payload *ParachainPayload
+	// Nil if the header doesn't need to be imported
+	headerCall   *types.Call
+	messageCalls []messageCall
+}
+
+type messageCall struct {
+	message *chain.EthereumOutboundMessage
+	call    types.Call
+	// Number of the header whose payload carried the message. The message's own
+	// block is an ancestor of it.
+	blockNumber uint64
+	nonce       uint64
+}
+
+func flattenCalls(batch []*payloadCalls) []types.Call {
 	var calls []types.Call
+	for _, p := range batch {
+		if p.headerCall != nil {
+			calls = append(calls, *p.headerCall)
+		}
+		for _, m := range p.messageCalls {
+			calls = append(calls, m.call)
+		}
+	}
+	return calls
+}
+
+// makePayloadCalls creates the calls for importing a payload's header and submitting its
+// messages. If the header is already known to the light client, or too old to be imported,
+// only the messages are submitted.
+func (wr *ParachainWriter) makePayloadCalls(ctx context.Context, payload *ParachainPayload) (*payloadCalls, error) {
 	call, err := wr.makeHeaderImportCall(ctx, payload.Header)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	calls := payloadCalls{payload: payload, headerCall: &call}
+
+	var blockNumber uint64
+	if header, ok := payload.Header.HeaderData.(ethereum.Header); ok {
+		blockNumber = uint64(header.Fields.Number)
+		wr.cacheReceiptsRoot(&header)
+
+		needed, err := wr.isHeaderImportNeeded(&header)
+		if err != nil {
+			return nil, err
+		}
+		if !needed {
+			wr.log.WithField("blockNumber", header.Fields.Number).Debug("Skipping import of known or finalized header")
+			calls.headerCall = nil
+		}
 	}
-	calls = append(calls, call)
 
 	for _, msg := range payload.Messages {
 		call, err := wr.makeMessageSubmitCall(ctx, msg)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		m := messageCall{message: msg, call: call, blockNumber: blockNumber}
+		m.nonce, err = wr.messageNonce(msg)
+		if err != nil {
+			err = wr.dropMessage(m, fmt.Sprintf("decode nonce: %s", err))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		delivered, err := wr.deliveredNonce(msg.Call)
+		if err != nil {
+			return nil, err
+		}
+		if m.nonce <= delivered {
+			wr.log.WithFields(logrus.Fields{
+				"call":  msg.Call,
+				"nonce": m.nonce,
+			}).Debug("Skipping message which was delivered already")
+			continue
+		}
+
+		// The message is relayed again after a restart if the lookup fails
+		valid, err := wr.verifyMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			continue
+		}
+
+		submittable, err := wr.admitMessage(m, calls.headerCall != nil)
+		if err != nil {
+			return nil, err
+		}
+		if !submittable {
+			continue
+		}
+
+		calls.messageCalls = append(calls.messageCalls, m)
+	}
+
+	return &calls, nil
+}
+
+func (wr *ParachainWriter) isHeaderImportNeeded(header *ethereum.Header) (bool, error) {
+	if uint64(header.Fields.Number) <= wr.finalizedBlockNumber {
+		return false, nil
+	}
+
+	// Another relayer might have imported the header already
+	imported, err := wr.lightClient.HeaderExists(header.ID().Hash)
+	if err != nil {
+		return false, err
+	}
+	return !imported, nil
+}
+
+// isMessageHeaderFinalized reports whether the light client has finalized the block of a message
+func (wr *ParachainWriter) isMessageHeaderFinalized(msg *chain.EthereumOutboundMessage) (bool, error) {
+	for _, arg := range msg.Args {
+		message, ok := arg.(parachain.Message)
+		if !ok {
+			continue
+		}
+
+		storedHeader, ok, err := wr.lightClient.Header(message.Proof.BlockHash)
+		if err != nil {
+			return false, err
 		}
-		calls = append(calls, call)
+		if !ok || !storedHeader.Finalized {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// writeBatch submits the calls for one or more payloads in a single extrinsic. Headers
+// are imported in the order of the payloads.
+func (wr *ParachainWriter) writeBatch(ctx context.Context, batch []*payloadCalls) error {
+	calls := flattenCalls(batch)
+	if len(calls) == 0 {
+		// All headers are known and there are no messages
+		return nil
+	}
+
+	headerCount, messageCount := 0, countMessageCalls(batch)
+	for _, p := range batch {
+		if p.headerCall != nil {
+			headerCount++
+		}
+	}
+	fields := logrus.Fields{
+		"fromBlock":    batch[0].payload.Header.HeaderData.(ethereum.Header).Fields.Number,
+		"toBlock":      batch[len(batch)-1].payload.Header.HeaderData.(ethereum.Header).Fields.Number,
+		"headerCount":  headerCount,
+		"messageCount": messageCount,
+	}
+
+	call, err := types.NewCall(wr.conn.GetMetadata(), "Utility.batch_all", calls)
+	if err != nil {
+		wr.log.WithError(err).WithFields(fields).Error("Failure submitting headers and messages to Substrate")
+		return err
 	}
 
-	call, err = types.NewCall(wr.conn.GetMetadata(), "Utility.batch_all", calls)
+	onProcessed := func(result *parachain.ExtrinsicResult) error {
+		return wr.checkBatchProcessed(ctx, batch, result)
+	}
+	err = wr.write(ctx, call, fields, onProcessed)
 	if err != nil {
+		wr.log.WithError(err).WithFields(fields).Error("Failure submitting headers and messages to Substrate")
 		return err
 	}
 
-	onProcessed := func() error {
-		// Confirm that the header import was successful
-		header := payload.Header.HeaderData.(ethereum.Header)
+	wr.log.WithFields(fields).Info("Submitted headers and messages to Substrate")
+	return nil
+}
+
+// checkBatchProcessed confirms that the header imports in a processed batch were successful.
+// Since the batch is atomic, any failing call reverts all other calls. If the batch contains
+// messages and is known to have failed, it's split up to isolate the failing call. If a
+// header import failed for a benign reason, such as another relayer importing the same
+// header, the batch is retried. Otherwise our view of the chain has diverged from the light
+// client's and we request a resync.
+func (wr *ParachainWriter) checkBatchProcessed(ctx context.Context, batch []*payloadCalls, result *parachain.ExtrinsicResult) error {
+	if result != nil {
+		if result.Success() {
+			wr.confirmDelivery(batchMessages(batch), result)
+			return nil
+		}
+		if countMessageCalls(batch) > 0 {
+			return wr.recoverBatch(ctx, batch, result)
+		}
+	}
+
+	failed := false
+	benign := false
+
+	for _, p := range batch {
+		if p.headerCall == nil {
+			continue
+		}
+
+		header := p.payload.Header.HeaderData.(ethereum.Header)
 		hash := header.ID().Hash
-		imported, err := wr.queryImportedHeaderExists(hash)
+		storedHeader, ok, err := wr.lightClient.Header(hash)
 		if err != nil {
 			return err
 		}
-		if !imported {
-			return fmt.Errorf("Header import failed for header %s", hash.Hex())
+		if ok && wr.isOwnSubmission(storedHeader) {
+			continue
+		}
+
+		failed = true
+		if ok {
+			wr.log.WithFields(logrus.Fields{
+				"blockNumber": header.Fields.Number,
+				"blockHash":   hash.Hex(),
+			}).Info("Header was imported by another relayer")
+			benign = true
 		}
+	}
+
+	if !failed {
 		return nil
 	}
-	return wr.write(ctx, call, onProcessed)
+
+	if !benign {
+		finalized, err := wr.lightClient.FinalizedBlock()
+		if err != nil {
+			return err
+		}
+		for _, p := range batch {
+			header := p.payload.Header.HeaderData.(ethereum.Header)
+			if p.headerCall != nil && header.Fields.Number <= finalized.Number {
+				// The light client has finalized past this header
+				benign = true
+			}
+		}
+	}
+
+	if !benign {
+		wr.log.WithFields(logrus.Fields{
+			"fromBlock": batch[0].payload.Header.HeaderData.(ethereum.Header).Fields.Number,
+			"toBlock":   batch[len(batch)-1].payload.Header.HeaderData.(ethereum.Header).Fields.Number,
+		}).Warn("Headers were rejected by the parachain. Requesting resync")
+		wr.requestResync()
+		return nil
+	}
+
+	payloads := make([]*ParachainPayload, len(batch))
+	for i, p := range batch {
+		payloads[i] = p.payload
+	}
+
+	err := wr.enqueueRetry(ctx, retryBatch{payloads: payloads})
+	if err != nil {
+		return err
+	}
+	wr.log.WithField("payloadCount", len(payloads)).Info("Retrying payloads of failed batch")
+	return nil
+}
+
+func (wr *ParachainWriter) enqueueRetry(ctx context.Context, retry retryBatch) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case wr.retries <- retry:
+		return nil
+	}
+}
+
+// batchMessages returns the message submitted by each call of a batch, or nil for header imports
+func batchMessages(batch []*payloadCalls) []*chain.EthereumOutboundMessage {
+	var messages []*chain.EthereumOutboundMessage
+	for _, p := range batch {
+		if p.headerCall != nil {
+			messages = append(messages, nil)
+		}
+		for _, m := range p.messageCalls {
+			messages = append(messages, m.message)
+		}
+	}
+	return messages
+}
+
+func countMessageCalls(batch []*payloadCalls) int {
+	count := 0
+	for _, p := range batch {
+		count += len(p.messageCalls)
+	}
+	return count
+}
+
+func (wr *ParachainWriter) isOwnSubmission(storedHeader *ethereum.StoredHeader) bool {
+	ok, submitter := storedHeader.Submitter.Unwrap()
+	return ok && bytes.Equal(submitter[:], wr.conn.GetKeypair().PublicKey)
 }
 
 func (wr *ParachainWriter) makeMessageSubmitCall(ctx context.Context, msg *chain.EthereumOutboundMessage) (types.Call, error) {
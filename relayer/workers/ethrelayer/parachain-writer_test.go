@@ -13,6 +13,7 @@ import (
 
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
 	"github.com/snowfork/polkadot-ethereum/relayer/crypto/sr25519"
 	"github.com/snowfork/polkadot-ethereum/relayer/workers/ethrelayer"
@@ -29,7 +30,10 @@ func TestWrite(t *testing.T) {
 	eg, ctx := errgroup.WithContext(ctx)
 	defer cancel()
 
-	writer := ethrelayer.NewParachainWriter(conn, payloads, log)
+	channels := ethereum.ChannelsConfig{
+		{Name: ethereum.BasicChannel, Event: "Message(address,uint64,bytes)", Call: "BasicInboundChannel.submit"},
+	}
+	writer := ethrelayer.NewParachainWriter(&parachain.Config{}, channels, conn, ethrelayer.NewLightClient(conn), payloads, make(chan struct{}, 1), log)
 
 	err := conn.Connect(ctx)
 	if err != nil {
@@ -0,0 +1,424 @@
+// Copyright 2021 Snowfork
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package ethrelayer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// recoverBatch splits up a batch which failed to dispatch. The headers are resubmitted on
+// their own, followed by the messages, which are recovered if they fail again.
+func (wr *ParachainWriter) recoverBatch(ctx context.Context, batch []*payloadCalls, result *parachain.ExtrinsicResult) error {
+	var headers []*ParachainPayload
+	var messages []messageCall
+	for _, p := range batch {
+		if p.headerCall != nil {
+			headers = append(headers, &ParachainPayload{Header: p.payload.Header})
+		}
+		messages = append(messages, p.messageCalls...)
+	}
+
+	wr.log.WithFields(logrus.Fields{
+		"blockHash":    result.BlockHash.Hex(),
+		"dryRun":       result.DryRun,
+		"error":        result.Error.Error(),
+		"headerCount":  len(headers),
+		"messageCount": len(messages),
+	}).Warn("Batch failed to dispatch. Resubmitting headers and messages separately")
+
+	if len(headers) > 0 {
+		err := wr.enqueueRetry(ctx, retryBatch{payloads: headers})
+		if err != nil {
+			return err
+		}
+		// The headers might be at fault, so the messages are tried as a whole first
+		return wr.enqueueRetry(ctx, retryBatch{messages: messages})
+	}
+
+	return wr.enqueueRetry(ctx, retryBatch{messages: messages, failure: result})
+}
+
+// writeMessages submits messages whose headers have already been imported
+func (wr *ParachainWriter) writeMessages(
+	ctx context.Context,
+	messages []messageCall,
+	onProcessed func(result *parachain.ExtrinsicResult) error,
+) error {
+	calls := make([]types.Call, len(messages))
+	for i, m := range messages {
+		calls[i] = m.call
+	}
+
+	call, err := types.NewCall(wr.conn.GetMetadata(), "Utility.batch_all", calls)
+	if err != nil {
+		return err
+	}
+
+	fields := logrus.Fields{"messageCount": len(messages)}
+	err = wr.write(ctx, call, fields, onProcessed)
+	if err != nil {
+		wr.log.WithError(err).WithFields(fields).Error("Failure submitting messages to Substrate")
+		return err
+	}
+
+	wr.log.WithFields(fields).Info("Submitted messages to Substrate")
+	return nil
+}
+
+func (wr *ParachainWriter) onMessagesProcessed(ctx context.Context, messages []messageCall) func(result *parachain.ExtrinsicResult) error {
+	return func(result *parachain.ExtrinsicResult) error {
+		return wr.checkMessagesProcessed(ctx, messages, result)
+	}
+}
+
+// checkMessagesProcessed recovers messages which failed to dispatch. Messages whose outcome
+// is unknown are resubmitted, unless the parachain received them in the meantime.
+func (wr *ParachainWriter) checkMessagesProcessed(ctx context.Context, messages []messageCall, result *parachain.ExtrinsicResult) error {
+	if result == nil {
+		wr.log.WithField("messageCount", len(messages)).Warn("Outcome of submitting messages is unknown. Resubmitting them")
+		return wr.enqueueRetry(ctx, retryBatch{messages: messages})
+	}
+	if result.Success() {
+		wr.confirmDelivery(callMessages(messages), result)
+		return nil
+	}
+
+	// Messages are bound to fail if their header isn't finalized, e.g. because
+	// the header import preceding them failed. Retrying holds them back until
+	// the header is finalized.
+	for _, m := range messages {
+		finalized, err := wr.isMessageHeaderFinalized(m.message)
+		if err != nil {
+			return err
+		}
+		if !finalized {
+			return wr.enqueueRetry(ctx, retryBatch{messages: messages})
+		}
+	}
+
+	return wr.enqueueRetry(ctx, retryBatch{messages: messages, failure: result})
+}
+
+func callMessages(messages []messageCall) []*chain.EthereumOutboundMessage {
+	result := make([]*chain.EthereumOutboundMessage, len(messages))
+	for i, m := range messages {
+		result[i] = m.message
+	}
+	return result
+}
+
+// partResult is the outcome of resubmitting a part of the failed messages of a channel
+type partResult struct {
+	call string
+	// Nil if the outcome is unknown
+	result *parachain.ExtrinsicResult
+}
+
+// recoverMessages finds the failing message among messages which failed to dispatch, one
+// channel at a time. Each channel's messages are resubmitted on their own, and bisected if
+// they fail again. Messages of channels which are recovering or blocked already are held
+// back, as they failed following the earlier failure.
+func (wr *ParachainWriter) recoverMessages(messages []messageCall, result *parachain.ExtrinsicResult) error {
+	var calls []string
+	byCall := make(map[string][]messageCall)
+	for _, m := range messages {
+		call := m.message.Call
+		if _, ok := byCall[call]; !ok {
+			calls = append(calls, call)
+		}
+		byCall[call] = append(byCall[call], m)
+	}
+
+	for _, call := range calls {
+		group := byCall[call]
+		if wr.isHeld(call) {
+			for _, m := range group {
+				wr.holdMessage(m)
+			}
+			continue
+		}
+
+		sort.Slice(group, func(i, j int) bool { return group[i].nonce < group[j].nonce })
+		ch := wr.channel(call)
+		ch.parts = [][]messageCall{group}
+
+		wr.log.WithFields(logrus.Fields{
+			"call":         call,
+			"fromNonce":    group[0].nonce,
+			"toNonce":      group[len(group)-1].nonce,
+			"messageCount": len(group),
+		}).Warn("Messages failed to dispatch. Recovering their channel")
+
+		if len(calls) == 1 {
+			// The channel's messages failed on their own already
+			err := wr.failPart(call, ch, result)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeNextPart resubmits the first part of a recovering channel's failed messages, leaving
+// out those the parachain has received since
+func (wr *ParachainWriter) writeNextPart(ctx context.Context, call string, ch *inboundChannel) error {
+	delivered, err := wr.queryChannelNonce(call)
+	if err != nil {
+		return err
+	}
+	ch.dropDelivered(delivered)
+
+	if len(ch.parts) == 0 {
+		wr.log.WithField("call", call).Info("Recovered channel")
+		return nil
+	}
+
+	part := ch.parts[0]
+	ch.partSubmitted = true
+	return wr.writeMessages(ctx, part, func(result *parachain.ExtrinsicResult) error {
+		if result != nil && result.Success() {
+			wr.confirmDelivery(callMessages(part), result)
+		}
+		return wr.enqueueRetry(ctx, retryBatch{part: &partResult{call: call, result: result}})
+	})
+}
+
+// onPartProcessed moves on to the next part of a recovering channel once a part was
+// delivered, or bisects it if it failed. Parts whose outcome is unknown are resubmitted.
+func (wr *ParachainWriter) onPartProcessed(part *partResult) error {
+	ch, ok := wr.channels[part.call]
+	if !ok || len(ch.parts) == 0 {
+		return nil
+	}
+	ch.partSubmitted = false
+
+	switch {
+	case part.result == nil:
+		return nil
+	case part.result.Success():
+		ch.parts = ch.parts[1:]
+		if len(ch.parts) == 0 {
+			wr.log.WithField("call", part.call).Info("Recovered channel")
+		}
+		return nil
+	default:
+		return wr.failPart(part.call, ch, part.result)
+	}
+}
+
+// failPart bisects the first part of a recovering channel, which failed to dispatch. A
+// single failing message is quarantined if it's the channel's next message, and the channel
+// is blocked until that message is delivered by other means. If an earlier message is
+// missing instead, the channel is blocked until it's delivered.
+func (wr *ParachainWriter) failPart(call string, ch *inboundChannel, result *parachain.ExtrinsicResult) error {
+	part := ch.parts[0]
+	if len(part) > 1 {
+		mid := len(part) / 2
+		ch.parts = append([][]messageCall{part[:mid], part[mid:]}, ch.parts[1:]...)
+		wr.log.WithFields(logrus.Fields{
+			"call":      call,
+			"fromNonce": part[0].nonce,
+			"toNonce":   part[len(part)-1].nonce,
+		}).Info("Bisecting failed messages")
+		return nil
+	}
+
+	delivered, err := wr.queryChannelNonce(call)
+	if err != nil {
+		return err
+	}
+
+	m := part[0]
+	switch {
+	case m.nonce <= delivered:
+		wr.log.WithFields(logrus.Fields{
+			"call":  call,
+			"nonce": m.nonce,
+		}).Info("Failed message was delivered already")
+		ch.parts = ch.parts[1:]
+		return nil
+	case m.nonce == delivered+1:
+		ch.parts = ch.parts[1:]
+		ch.blockedAt = m.nonce
+		ch.quarantined = true
+		err := wr.quarantineMessage(m, result)
+		if err != nil {
+			return err
+		}
+	default:
+		ch.blockedAt = delivered + 1
+	}
+
+	wr.log.WithFields(logrus.Fields{
+		"call":       call,
+		"blockedAt":  ch.blockedAt,
+		"failedAt":   m.nonce,
+		"heldCount":  len(ch.held),
+		"deadLetter": wr.config.DeadLetterFile,
+	}).Error("Channel is blocked. Its later messages are held back until the parachain receives the message it waits for")
+	return nil
+}
+
+// confirmDelivery logs the outcome of dispatching each message delivered by a processed
+// extrinsic. messages holds the message submitted by each call of the extrinsic, or nil
+// for calls which don't submit a message.
+func (wr *ParachainWriter) confirmDelivery(messages []*chain.EthereumOutboundMessage, result *parachain.ExtrinsicResult) {
+	calls := result.CallResults(len(messages))
+	dispatches := result.MessageDispatches()
+
+	for i, message := range messages {
+		if message == nil || !calls[i].Success {
+			continue
+		}
+		if len(dispatches) == 0 {
+			wr.log.WithFields(logrus.Fields{
+				"call":      message.Call,
+				"blockHash": result.BlockHash.Hex(),
+			}).Warn("No dispatch event found for delivered message")
+			return
+		}
+
+		dispatch := dispatches[0]
+		dispatches = dispatches[1:]
+
+		log := wr.log.WithFields(logrus.Fields{
+			"call":      message.Call,
+			"channelID": dispatch.ID.ChannelID,
+			"nonce":     dispatch.ID.Nonce,
+			"blockHash": result.BlockHash.Hex(),
+		})
+		switch {
+		case dispatch.Error != nil:
+			log.WithField("error", dispatch.Error.Error()).Warn("Message was delivered but its call failed")
+		case dispatch.Rejected:
+			log.Warn("Message was delivered but rejected by the dispatch pallet")
+		case dispatch.DecodeFailed:
+			log.Warn("Message was delivered but its payload couldn't be decoded")
+		default:
+			log.Info("Message was delivered and dispatched")
+		}
+	}
+}
+
+// deadLetter is a message which was removed from submission because it failed to dispatch
+// or can't be delivered
+type deadLetter struct {
+	Time time.Time `json:"time"`
+	Call string    `json:"call"`
+	// Nonce of the message in its channel
+	Nonce uint64 `json:"nonce"`
+	// SCALE-encoded call, as submitted
+	Data string `json:"data"`
+	// Ethereum block and transaction which emitted the message
+	BlockHash string `json:"blockHash,omitempty"`
+	TxIndex   uint32 `json:"txIndex,omitempty"`
+	// Parachain block in which the message failed to dispatch, unless the
+	// failure was found by a dry run
+	ParachainBlockHash string `json:"parachainBlockHash,omitempty"`
+	DryRun             bool   `json:"dryRun,omitempty"`
+	Error              string `json:"error"`
+}
+
+// quarantineMessage removes a message which failed to dispatch on its own from submission
+func (wr *ParachainWriter) quarantineMessage(m messageCall, result *parachain.ExtrinsicResult) error {
+	letter, err := newDeadLetter(m, result.Error.Error())
+	if err != nil {
+		return err
+	}
+	letter.DryRun = result.DryRun
+	if !result.DryRun {
+		letter.ParachainBlockHash = result.BlockHash.Hex()
+	}
+
+	wr.log.WithFields(logrus.Fields{
+		"call":      letter.Call,
+		"blockHash": letter.BlockHash,
+		"nonce":     letter.Nonce,
+		"txIndex":   letter.TxIndex,
+		"error":     letter.Error,
+	}).Error("Quarantined message which failed to dispatch")
+
+	return wr.recordDeadLetter(letter)
+}
+
+// dropMessage removes a message which can't be delivered from submission
+func (wr *ParachainWriter) dropMessage(m messageCall, reason string) error {
+	letter, err := newDeadLetter(m, reason)
+	if err != nil {
+		return err
+	}
+
+	wr.log.WithFields(logrus.Fields{
+		"call":        letter.Call,
+		"blockHash":   letter.BlockHash,
+		"blockNumber": m.blockNumber,
+		"nonce":       letter.Nonce,
+		"txIndex":     letter.TxIndex,
+		"error":       letter.Error,
+	}).Error("Dropped message which can't be delivered")
+
+	return wr.recordDeadLetter(letter)
+}
+
+func newDeadLetter(m messageCall, reason string) (*deadLetter, error) {
+	data, err := types.EncodeToHexString(m.call)
+	if err != nil {
+		return nil, err
+	}
+
+	letter := deadLetter{
+		Time:  time.Now().UTC(),
+		Call:  m.message.Call,
+		Nonce: m.nonce,
+		Data:  data,
+		Error: reason,
+	}
+	for _, arg := range m.message.Args {
+		if message, ok := arg.(parachain.Message); ok {
+			letter.BlockHash = message.Proof.BlockHash.Hex()
+			letter.TxIndex = uint32(message.Proof.TxIndex)
+		}
+	}
+	return &letter, nil
+}
+
+func (wr *ParachainWriter) recordDeadLetter(letter *deadLetter) error {
+	if wr.config.DeadLetterFile == "" {
+		return nil
+	}
+	return appendDeadLetter(wr.config.DeadLetterFile, letter)
+}
+
+// appendDeadLetter writes a dead letter as a line of JSON to the given file
+func appendDeadLetter(path string, letter *deadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
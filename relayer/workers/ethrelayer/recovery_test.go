@@ -0,0 +1,70 @@
+package ethrelayer
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead-letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead-letters.jsonl")
+
+	for _, call := range []string{"BasicInboundModule.submit", "IncentivizedInboundModule.submit"} {
+		err := appendDeadLetter(path, &deadLetter{Call: call, Error: "Module { index: 10, error: 1 }"})
+		assert.Nil(t, err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter deadLetter
+		err := json.Unmarshal(scanner.Bytes(), &letter)
+		assert.Nil(t, err)
+		letters = append(letters, letter)
+	}
+
+	assert.Len(t, letters, 2)
+	assert.Equal(t, "BasicInboundModule.submit", letters[0].Call)
+	assert.Equal(t, "IncentivizedInboundModule.submit", letters[1].Call)
+}
+
+func TestDropMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead-letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wr := newTestWriter()
+	wr.config.DeadLetterFile = filepath.Join(dir, "dead-letters.jsonl")
+
+	m := makeChannelMessage(t, "BasicInboundModule.submit", 12)
+	err = wr.dropMessage(m, "light client finalized another block at height 40")
+	assert.Nil(t, err)
+
+	data, err := ioutil.ReadFile(wr.config.DeadLetterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letter deadLetter
+	assert.Nil(t, json.Unmarshal(data, &letter))
+	assert.Equal(t, "BasicInboundModule.submit", letter.Call)
+	assert.Equal(t, uint64(12), letter.Nonce)
+	assert.Equal(t, "light client finalized another block at height 40", letter.Error)
+}
@@ -8,41 +8,38 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	gethTypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
-	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
 	"github.com/snowfork/polkadot-ethereum/relayer/contracts/beefylightclient"
 )
 
-//TODO - put in config
-const OUR_PARACHAIN_ID = 200
-
 type BeefyListener struct {
-	ethereumConfig      *ethereum.Config
-	ethereumConn        *ethereum.Connection
-	beefyLightClient    *beefylightclient.Contract
-	relaychainConn      *relaychain.Connection
-	parachainConnection *parachain.Connection
-	messages            chan<- MessagePackage
-	log                 *logrus.Entry
+	ethereumConfig   *ethereum.Config
+	ethereumConn     *ethereum.Connection
+	beefyLightClient *beefylightclient.Contract
+	relaychainConn   *relaychain.Connection
+	parachains       []*ParachainListener
+	messages         chan<- MessagePackage
+	log              *logrus.Entry
 }
 
 func NewBeefyListener(
 	ethereumConfig *ethereum.Config,
 	ethereumConn *ethereum.Connection,
 	relaychainConn *relaychain.Connection,
-	parachainConnection *parachain.Connection,
+	parachains []*ParachainListener,
 	messages chan<- MessagePackage,
 	log *logrus.Entry) *BeefyListener {
 	return &BeefyListener{
-		ethereumConfig:      ethereumConfig,
-		ethereumConn:        ethereumConn,
-		relaychainConn:      relaychainConn,
-		parachainConnection: parachainConnection,
-		messages:            messages,
-		log:                 log,
+		ethereumConfig: ethereumConfig,
+		ethereumConn:   ethereumConn,
+		relaychainConn: relaychainConn,
+		parachains:     parachains,
+		messages:       messages,
+		log:            log,
 	}
 }
 
@@ -63,27 +60,11 @@ func (li *BeefyListener) Start(ctx context.Context, eg *errgroup.Group) error {
 			return err
 		}
 
-		verifiedParaBlockNumber, err := li.relaychainConn.FetchLatestFinalizedParaBlockNumber(
-			verifiedBeefyBlockHash, OUR_PARACHAIN_ID)
-		if err != nil {
-			li.log.WithError(err).Error("Failed to get latest finalized para block number from relay chain")
-			return err
-		}
-
-		verifiedParaBlockHash, err := li.parachainConnection.GetAPI().RPC.Chain.GetBlockHash(verifiedParaBlockNumber)
+		err = li.relayParachains(ctx, verifiedBeefyBlockNumber, verifiedBeefyBlockHash)
 		if err != nil {
-			li.log.WithError(err).Error("Failed to get latest finalized para block hash")
 			return err
 		}
 
-		messagePackages, err := li.buildMissedMessagePackages(ctx, verifiedBeefyBlockNumber, verifiedParaBlockNumber, verifiedParaBlockHash)
-		if err != nil {
-			li.log.WithError(err).Error("Failed to build missed message package")
-			return err
-		}
-
-		li.emitMessagePackages(messagePackages)
-
 		err = li.subBeefyJustifications(ctx)
 		return err
 	})
@@ -155,26 +136,23 @@ func (li *BeefyListener) processBeefyLightClientEvents(ctx context.Context, even
 		}
 		li.log.WithField("relayBlockHash", relayBlockHash.Hex()).Info("Got relay chain blockhash")
 
-		verifiedParaBlockNumber, err := li.relaychainConn.FetchLatestFinalizedParaBlockNumber(
-			relayBlockHash, OUR_PARACHAIN_ID)
+		err = li.relayParachains(ctx, uint64(beefyBlockNumber), relayBlockHash)
 		if err != nil {
-			li.log.WithError(err).Error("Failed to get latest finalized para block number from relay chain")
-			return err
-		}
-		verifiedParaBlockHash, err := li.parachainConnection.GetAPI().RPC.Chain.GetBlockHash(verifiedParaBlockNumber)
-		if err != nil {
-			li.log.WithError(err).Error("Failed to get latest finalized para block hash")
 			return err
 		}
+	}
+	return nil
+}
 
-		messagePackages, err := li.buildMissedMessagePackages(ctx, beefyBlockNumber, verifiedParaBlockNumber, verifiedParaBlockHash)
+// relayParachains emits the commitments of every bridge parachain which are finalized by the
+// given relay chain block, but haven't been delivered to Ethereum yet
+func (li *BeefyListener) relayParachains(ctx context.Context, relayBlockNumber uint64, relayBlockHash types.Hash) error {
+	for _, listener := range li.parachains {
+		messagePackages, err := listener.buildMessagePackages(ctx, relayBlockNumber, relayBlockHash)
 		if err != nil {
-			li.log.WithError(err).Error("Failed to build missed message packages")
 			return err
 		}
-
 		li.emitMessagePackages(messagePackages)
-
 	}
 	return nil
 }
@@ -182,6 +160,7 @@ func (li *BeefyListener) processBeefyLightClientEvents(ctx context.Context, even
 func (li *BeefyListener) emitMessagePackages(packages []MessagePackage) {
 	for _, messagePackage := range packages {
 		li.log.WithFields(logrus.Fields{
+			"parachainID":           messagePackage.paraID,
 			"channelID":             messagePackage.channelID,
 			"commitmentHash":        messagePackage.commitmentHash,
 			"commitmentData":        messagePackage.commitmentData,
@@ -2,34 +2,42 @@ package parachaincommitmentrelayer
 
 import (
 	"context"
+	"fmt"
+	"sort"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/sirupsen/logrus"
 	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
 	"github.com/snowfork/polkadot-ethereum/relayer/contracts/basic"
 	"github.com/snowfork/polkadot-ethereum/relayer/contracts/incentivized"
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/parachaincommitmentrelayer/index"
 )
 
 // Catches up by searching for and relaying all missed commitments before the given para block
 // This method implicitly assumes that relaychainBlock or some earlier relay chain block has
-// already finalized the given para block
-func (li *BeefyListener) buildMissedMessagePackages(
-	ctx context.Context, relaychainBlock uint64, paraBlock uint64, paraHash types.Hash) (
+// already finalized the given para block. MMR proofs are generated against the MMR root at
+// relaychainBlock, which must be the latest BEEFY block verified on Ethereum.
+func (li *ParachainListener) buildMissedMessagePackages(
+	ctx context.Context, relaychainBlock uint64, relaychainHash types.Hash, paraBlock uint64, paraHash types.Hash) (
 	[]MessagePackage, error) {
-	basicContract, err := basic.NewBasicInboundChannel(common.HexToAddress(
-		li.ethereumConfig.Channels.Basic.Inbound),
-		li.ethereumConn.GetClient(),
-	)
+	basicChannel, err := li.channels.Get(ethereum.BasicChannel)
 	if err != nil {
 		return nil, err
 	}
 
-	incentivizedContract, err := incentivized.NewIncentivizedInboundChannel(common.HexToAddress(
-		li.ethereumConfig.Channels.Incentivized.Inbound),
-		li.ethereumConn.GetClient(),
-	)
+	basicContract, err := basic.NewBasicInboundChannel(basicChannel.InboundAddress(), li.ethereumConn.GetClient())
+	if err != nil {
+		return nil, err
+	}
+
+	incentivizedChannel, err := li.channels.Get(ethereum.IncentivizedChannel)
+	if err != nil {
+		return nil, err
+	}
+
+	incentivizedContract, err := incentivized.NewIncentivizedInboundChannel(incentivizedChannel.InboundAddress(), li.ethereumConn.GetClient())
 	if err != nil {
 		return nil, err
 	}
@@ -95,20 +103,35 @@ func (li *BeefyListener) buildMissedMessagePackages(
 		return nil, nil
 	}
 
-	li.log.Info("Nonces are not all up to date - searching for lost commitments")
-
-	paraBlocks, err := li.searchForLostCommitments(paraBlock, ethBasicNonce, ethIncentivizedNonce)
-	if err != nil {
-		return nil, err
+	var paraBlocks []ParaBlockWithDigest
+	indexed := false
+	if li.index != nil {
+		paraBlocks, indexed, err = li.lookUpLostCommitments(paraBlock, ethBasicNonce, ethIncentivizedNonce)
+		if err != nil {
+			li.log.WithError(err).Error("Failed to look up lost commitments in index")
+			return nil, err
+		}
+		if !indexed {
+			li.log.Info("Commitment index doesn't hold all lost commitments yet")
+		}
 	}
 
-	li.log.Info("Stopped searching for lost commitments")
+	if !indexed {
+		li.log.Info("Nonces are not all up to date - searching for lost commitments")
+
+		paraBlocks, err = li.searchForLostCommitments(paraBlock, ethBasicNonce, ethIncentivizedNonce)
+		if err != nil {
+			return nil, err
+		}
+
+		li.log.Info("Stopped searching for lost commitments")
+	}
 
 	li.log.WithFields(logrus.Fields{
 		"blocks": paraBlocks,
 	}).Info("Found these blocks and commitments")
 
-	blocksWithProofs, err := li.parablocksWithProofs(paraBlocks, relaychainBlock)
+	blocksWithProofs, err := li.parablocksWithProofs(paraBlocks, relaychainBlock, relaychainHash)
 	if err != nil {
 		li.log.Error(err)
 		return nil, err
@@ -123,7 +146,7 @@ func (li *BeefyListener) buildMissedMessagePackages(
 		"blocks": blocksWithProofs,
 	}).Info("Packaging these blocks and proofs")
 
-	messagePackages, err := CreateMessagePackages(blocksWithProofs)
+	messagePackages, err := CreateMessagePackages(li.paraID, blocksWithProofs)
 	if err != nil {
 		li.log.WithError(err).Error("Failed to create message packages")
 		return nil, err
@@ -135,44 +158,41 @@ func (li *BeefyListener) buildMissedMessagePackages(
 }
 
 // Takes a slice of parachain blocks and augments them with their respective
-// header, header proof and MMR proof
-func (li *BeefyListener) parablocksWithProofs(blocks []ParaBlockWithDigest, latestRelayChainBlockNumber uint64) (
-	[]ParaBlockWithProofs, error) {
-	relayChainBlockNumber := latestRelayChainBlockNumber
-	var relayBlockHash types.Hash
-	var err error
+// header, header proof and MMR proof. MMR proofs are against the MMR root at the given
+// latest relay chain block.
+func (li *ParachainListener) parablocksWithProofs(blocks []ParaBlockWithDigest, latestRelayChainBlockNumber uint64,
+	latestRelayChainBlockHash types.Hash) ([]ParaBlockWithProofs, error) {
 	var blocksWithProof []ParaBlockWithProofs
 	for _, block := range blocks {
-		var allParaHeads []types.Bytes
-		var ownParaHeadProofPos int
-		var ownParaHead types.Header
-		// Loop back over relay chain blocks to find the one that finalized the given parachain block
-		for ownParaHead.Number != types.BlockNumber(block.BlockNumber) {
-			li.log.WithField("relayChainBlockNumber", relayChainBlockNumber).Info("Getting hash for relay chain block")
-			relayBlockHash, err = li.relaychainConn.GetAPI().RPC.Chain.GetBlockHash(uint64(relayChainBlockNumber))
-			if err != nil {
-				li.log.WithError(err).Error("Failed to get block hash")
-				return nil, err
-			}
-			li.log.WithField("relayBlockHash", relayBlockHash.Hex()).Info("Got relay chain blockhash")
-			allParaHeads, ownParaHeadProofPos, ownParaHead, err = li.relaychainConn.GetAllParaheadsWithOwn(relayBlockHash, OUR_PARACHAIN_ID)
-			if err != nil {
-				li.log.WithError(err).Error("Failed to get paraheads")
-				return nil, err
-			}
-			relayChainBlockNumber--
+		// Find the latest relay chain block at which the parachain block is the head. The MMR leaf
+		// committing to the parachain heads of a relay chain block is only added in its child, so
+		// the search ends at the parent of the latest relay chain block.
+		included, err := li.relaychainConn.FindParaHead(li.paraID, block.BlockNumber, latestRelayChainBlockNumber)
+		if err != nil {
+			li.log.WithError(err).Error("Failed to find relay chain block with parachain head")
+			return nil, err
 		}
-
-		// Note - relayChainBlockNumber will be one less than the block number discovered
-		// due to the decrement at the end of the loop, but the mmr leaves are 0 indexed whereas
-		// block numbers start from 1, so we actually do want to query for the leaf at
-		// one less than the block number
-		mmrProof, err := li.relaychainConn.GetMMRLeafForBlock(uint64(relayChainBlockNumber), relayBlockHash)
+		relayChainBlockNumber := included.BlockNumber
+		li.log.WithFields(logrus.Fields{
+			"paraBlockNumber":       block.BlockNumber,
+			"relayChainBlockNumber": relayChainBlockNumber,
+		}).Info("Found relay chain block with parachain head")
+
+		// MMR leaves are 0 indexed whereas block numbers start from 1, so the leaf committing to the
+		// parachain heads of relayChainBlockNumber has its number as index. It is proven against
+		// the latest MMR root, which is the one the BEEFY light client verifies it against.
+		mmrProof, err := li.relaychainConn.GetMMRLeafForBlock(relayChainBlockNumber, latestRelayChainBlockHash)
 		if err != nil {
 			li.log.WithError(err).Error("Failed to get mmr leaf")
 			return nil, err
 		}
-		parasRoot, ownParaHeadProof, err := parachain.CreateParachainHeaderProof(allParaHeads, ownParaHead, mmrProof.Leaf.ParachainHeads)
+		if uint64(mmrProof.Leaf.ParentNumberAndHash.ParentNumber) != relayChainBlockNumber {
+			return nil, fmt.Errorf("MMR leaf %d is for relay chain block %d instead of %d",
+				relayChainBlockNumber, mmrProof.Leaf.ParentNumberAndHash.ParentNumber, relayChainBlockNumber)
+		}
+
+		// Only relay parachain heads committed to by the MMR leaf
+		ownParaHeadProof, err := parachain.CreateParachainHeaderProof(included.ParaHeads, li.paraID, mmrProof.Leaf.ParachainHeads)
 		if err != nil {
 			li.log.WithError(err).Error("Failed to create parachain header proof")
 			return nil, err
@@ -181,20 +201,66 @@ func (li *BeefyListener) parablocksWithProofs(blocks []ParaBlockWithDigest, late
 		blockWithProof := ParaBlockWithProofs{
 			Block:            block,
 			MMRProofResponse: mmrProof,
-			Header:           ownParaHead,
-			HeaderProof:      ownParaHeadProof,
-			HeaderProofPos:   ownParaHeadProofPos,
-			HeaderProofWidth: len(allParaHeads),
-			HeaderProofRoot:  parasRoot,
+			Header:           included.Header,
+			HeaderProof:      ownParaHeadProof.Proof,
+			HeaderProofPos:   ownParaHeadProof.Pos,
+			HeaderProofWidth: ownParaHeadProof.Width,
+			HeaderProofRoot:  ownParaHeadProof.Root[:],
 		}
 		blocksWithProof = append(blocksWithProof, blockWithProof)
 	}
 	return blocksWithProof, nil
 }
 
+// Looks up all lost commitments on each channel up to the given parachain block in the commitment
+// index. Blocks are returned in descending order, like those found by searchForLostCommitments.
+// Reports false if the index doesn't hold all of them.
+func (li *ParachainListener) lookUpLostCommitments(
+	lastParaBlockNumber uint64,
+	basicNonce uint64,
+	incentivizedNonce uint64) ([]ParaBlockWithDigest, bool, error) {
+	var commitments []index.Commitment
+	for _, channel := range []struct {
+		name  string
+		nonce uint64
+	}{
+		{ethereum.BasicChannel, basicNonce},
+		{ethereum.IncentivizedChannel, incentivizedNonce},
+	} {
+		channelCommitments, ok, err := li.index.Undelivered(li.paraID, channel.name, channel.nonce, lastParaBlockNumber)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		commitments = append(commitments, channelCommitments...)
+	}
+
+	sort.Slice(commitments, func(i, j int) bool {
+		if commitments[i].BlockNumber != commitments[j].BlockNumber {
+			return commitments[i].BlockNumber > commitments[j].BlockNumber
+		}
+		return commitments[i].ItemIndex < commitments[j].ItemIndex
+	})
+
+	var blocks []ParaBlockWithDigest
+	for _, commitment := range commitments {
+		digestItem, err := commitment.DigestItem()
+		if err != nil {
+			return nil, false, err
+		}
+		item := DigestItemWithData{digestItem, commitment.Data}
+
+		if len(blocks) == 0 || blocks[len(blocks)-1].BlockNumber != commitment.BlockNumber {
+			blocks = append(blocks, ParaBlockWithDigest{BlockNumber: commitment.BlockNumber})
+		}
+		last := &blocks[len(blocks)-1]
+		last.DigestItemsWithData = append(last.DigestItemsWithData, item)
+	}
+	return blocks, true, nil
+}
+
 // Searches for all lost commitments on each channel from the given parachain block number backwards
 // until it finds the given basic and incentivized nonce
-func (li *BeefyListener) searchForLostCommitments(
+func (li *ParachainListener) searchForLostCommitments(
 	lastParaBlockNumber uint64,
 	basicNonceToFind uint64,
 	incentivizedNonceToFind uint64) ([]ParaBlockWithDigest, error) {
@@ -228,10 +294,7 @@ func (li *BeefyListener) searchForLostCommitments(
 			return nil, err
 		}
 
-		digestItems, err := parachain.ExtractAuxiliaryDigestItems(header.Digest)
-		if err != nil {
-			return nil, err
-		}
+		digestItems := parachain.ExtractAuxiliaryDigestItems(header.Digest, li.log)
 
 		var digestItemsWithData []DigestItemWithData
 
@@ -239,7 +302,7 @@ func (li *BeefyListener) searchForLostCommitments(
 			if digestItem.IsCommitment {
 				channelID := digestItem.AsCommitment.ChannelID
 				if channelID.IsBasic && !basicNonceFound {
-					isRelayed, messageData, err := li.checkBasicMessageNonces(&digestItem, basicNonceToFind)
+					isRelayed, messageData, err := li.checkBasicMessageNonces(&digestItem, blockHash, basicNonceToFind)
 					if err != nil {
 						return nil, err
 					}
@@ -251,7 +314,7 @@ func (li *BeefyListener) searchForLostCommitments(
 					}
 				}
 				if channelID.IsIncentivized && !incentivizedNonceFound {
-					isRelayed, messageData, err := li.checkIncentivizedMessageNonces(&digestItem, incentivizedNonceToFind)
+					isRelayed, messageData, err := li.checkIncentivizedMessageNonces(&digestItem, blockHash, incentivizedNonceToFind)
 					if err != nil {
 						return nil, err
 					}
@@ -277,11 +340,12 @@ func (li *BeefyListener) searchForLostCommitments(
 	return blocks, nil
 }
 
-func (li *BeefyListener) checkBasicMessageNonces(
+func (li *ParachainListener) checkBasicMessageNonces(
 	digestItem *parachain.AuxiliaryDigestItem,
+	blockHash types.Hash,
 	nonceToFind uint64,
 ) (bool, types.StorageDataRaw, error) {
-	messages, data, err := li.parachainConnection.GetBasicOutboundMessages(*digestItem)
+	messages, data, err := li.parachainConnection.GetBasicOutboundMessages(*digestItem, blockHash)
 	if err != nil {
 		return false, nil, err
 	}
@@ -294,12 +358,13 @@ func (li *BeefyListener) checkBasicMessageNonces(
 	return false, data, nil
 }
 
-func (li *BeefyListener) checkIncentivizedMessageNonces(
+func (li *ParachainListener) checkIncentivizedMessageNonces(
 	digestItem *parachain.AuxiliaryDigestItem,
+	blockHash types.Hash,
 	nonceToFind uint64,
 ) (bool, types.StorageDataRaw, error) {
 
-	messages, data, err := li.parachainConnection.GetIncentivizedOutboundMessages(*digestItem)
+	messages, data, err := li.parachainConnection.GetIncentivizedOutboundMessages(*digestItem, blockHash)
 	if err != nil {
 		return false, nil, err
 	}
@@ -0,0 +1,77 @@
+package parachaincommitmentrelayer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/parachaincommitmentrelayer/index"
+)
+
+func TestLookUpLostCommitments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commitment-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	commitmentIndex, err := index.Open(&index.Config{DBPath: filepath.Join(dir, "index.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer commitmentIndex.Close()
+
+	commitment := func(channel string, itemIndex int, nonce uint64) index.Commitment {
+		return index.Commitment{
+			ItemIndex:  itemIndex,
+			Channel:    channel,
+			Hash:       common.BigToHash(common.Big1),
+			FirstNonce: nonce,
+			LastNonce:  nonce,
+			Data:       []byte{byte(nonce)},
+		}
+	}
+	commitments := map[uint64][]index.Commitment{
+		2: {commitment(ethereum.BasicChannel, 0, 1)},
+		3: {commitment(ethereum.IncentivizedChannel, 0, 1), commitment(ethereum.BasicChannel, 1, 2)},
+		5: {commitment(ethereum.BasicChannel, 0, 3)},
+		6: {commitment(ethereum.IncentivizedChannel, 0, 2)},
+	}
+	for blockNumber := uint64(1); blockNumber <= 10; blockNumber++ {
+		err := commitmentIndex.AddBlock(200, blockNumber, common.Hash{}, commitments[blockNumber])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	li := NewParachainListener(200, nil, nil, nil, nil, commitmentIndex, logrus.WithField("test", "catchup"))
+
+	blocks, ok, err := li.lookUpLostCommitments(6, 1, 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	var numbers []uint64
+	for _, block := range blocks {
+		numbers = append(numbers, block.BlockNumber)
+	}
+	assert.Equal(t, []uint64{6, 5, 3}, numbers)
+	if assert.Len(t, blocks, 3) {
+		items := blocks[2].DigestItemsWithData
+		if assert.Len(t, items, 2) {
+			assert.True(t, items[0].DigestItem.AsCommitment.ChannelID.IsIncentivized)
+			assert.True(t, items[1].DigestItem.AsCommitment.ChannelID.IsBasic)
+			assert.Equal(t, []byte{2}, []byte(items[1].Data))
+		}
+	}
+
+	// Block 11 isn't indexed yet
+	_, ok, err = li.lookUpLostCommitments(11, 1, 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
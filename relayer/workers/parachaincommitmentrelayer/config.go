@@ -0,0 +1,49 @@
+package parachaincommitmentrelayer
+
+import (
+	"fmt"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// BridgeConfig describes a bridge parachain whose commitments are relayed to Ethereum
+type BridgeConfig struct {
+	Parachain parachain.Config `mapstructure:"parachain"`
+	// Inbound channel contracts on Ethereum which receive the parachain's commitments
+	Channels ethereum.ChannelsConfig `mapstructure:"channels"`
+}
+
+func (c *BridgeConfig) Validate() error {
+	if c.Parachain.ParachainID == 0 {
+		return fmt.Errorf("parachain at %s: parachain-id is not set", c.Parachain.Endpoint)
+	}
+	for _, name := range []string{ethereum.BasicChannel, ethereum.IncentivizedChannel} {
+		_, err := c.Channels.Get(name)
+		if err != nil {
+			return fmt.Errorf("parachain %d: %w", c.Parachain.ParachainID, err)
+		}
+	}
+	return nil
+}
+
+// validateBridges checks that each parachain is bridged at most once
+func validateBridges(bridges []BridgeConfig) error {
+	if len(bridges) == 0 {
+		return fmt.Errorf("no bridge parachains configured")
+	}
+
+	seen := make(map[uint32]bool)
+	for i := range bridges {
+		err := bridges[i].Validate()
+		if err != nil {
+			return err
+		}
+		id := bridges[i].Parachain.ParachainID
+		if seen[id] {
+			return fmt.Errorf("parachain %d is configured more than once", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package parachaincommitmentrelayer
+
+import (
+	"testing"
+
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+func newTestBridge(paraID uint32) BridgeConfig {
+	return BridgeConfig{
+		Parachain: parachain.Config{
+			Endpoint:    "ws://127.0.0.1:11144/",
+			ParachainID: paraID,
+		},
+		Channels: ethereum.ChannelsConfig{
+			{Name: ethereum.BasicChannel, Inbound: "0x992B9df075935E522EC7950F37eC8557e86f6fdb"},
+			{Name: ethereum.IncentivizedChannel, Inbound: "0xFc97A6197dc90bef6bbEFD672742Ed75E9768553"},
+		},
+	}
+}
+
+func TestValidateBridges(t *testing.T) {
+	assert.Nil(t, validateBridges([]BridgeConfig{newTestBridge(200), newTestBridge(201)}))
+
+	assert.Error(t, validateBridges(nil))
+	assert.Error(t, validateBridges([]BridgeConfig{newTestBridge(0)}))
+	assert.Error(t, validateBridges([]BridgeConfig{newTestBridge(200), newTestBridge(200)}))
+
+	withoutIncentivized := newTestBridge(201)
+	withoutIncentivized.Channels = withoutIncentivized.Channels[:1]
+	assert.Error(t, validateBridges([]BridgeConfig{newTestBridge(200), withoutIncentivized}))
+}
+
+func TestCreateMessagePackages(t *testing.T) {
+	basic := parachain.AuxiliaryDigestItem{
+		IsCommitment: true,
+		AsCommitment: parachain.Commitment{
+			ChannelID: parachain.ChannelID{IsBasic: true},
+			Hash:      types.NewH256([]byte{1}),
+		},
+	}
+	blocks := []ParaBlockWithProofs{
+		{
+			Block: ParaBlockWithDigest{
+				BlockNumber:         5,
+				DigestItemsWithData: []DigestItemWithData{{basic, types.StorageDataRaw{2}}},
+			},
+			Header:         types.Header{Number: 5},
+			HeaderProofPos: 1,
+		},
+	}
+
+	packages, err := CreateMessagePackages(201, blocks)
+	assert.Nil(t, err)
+	assert.Len(t, packages, 1)
+	assert.Equal(t, uint32(201), packages[0].paraID)
+	assert.Equal(t, basic.AsCommitment.Hash, packages[0].commitmentHash)
+	assert.Equal(t, types.BlockNumber(5), packages[0].paraHead.Number)
+}
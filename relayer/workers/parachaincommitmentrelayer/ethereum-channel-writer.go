@@ -2,6 +2,7 @@ package parachaincommitmentrelayer
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 
 	"golang.org/x/sync/errgroup"
@@ -14,48 +15,60 @@ import (
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
 	"github.com/snowfork/polkadot-ethereum/relayer/contracts/basic"
+	"github.com/snowfork/polkadot-ethereum/relayer/contracts/beefylightclient"
 	"github.com/snowfork/polkadot-ethereum/relayer/contracts/incentivized"
+	"github.com/snowfork/polkadot-ethereum/relayer/crypto/mmr"
 
 	gsrpcTypes "github.com/snowfork/go-substrate-rpc-client/v3/types"
 )
 
 type EthereumChannelWriter struct {
-	config                     *ethereum.Config
-	conn                       *ethereum.Connection
-	basicInboundChannel        *basic.BasicInboundChannel
-	incentivizedInboundChannel *incentivized.IncentivizedInboundChannel
-	messagePackages            <-chan MessagePackage
-	log                        *logrus.Entry
+	bridges          []BridgeConfig
+	config           *ethereum.Config
+	conn             *ethereum.Connection
+	beefyLightClient *beefylightclient.Contract
+	inboundChannels  map[uint32]*inboundChannels
+	messagePackages  <-chan MessagePackage
+	log              *logrus.Entry
+}
+
+// inboundChannels are the channel contracts receiving the commitments of a bridge parachain
+type inboundChannels struct {
+	basic        *basic.BasicInboundChannel
+	incentivized *incentivized.IncentivizedInboundChannel
 }
 
 func NewEthereumChannelWriter(
+	bridges []BridgeConfig,
 	config *ethereum.Config,
 	conn *ethereum.Connection,
 	messagePackages <-chan MessagePackage,
 	log *logrus.Entry,
 ) (*EthereumChannelWriter, error) {
 	return &EthereumChannelWriter{
-		config:                     config,
-		conn:                       conn,
-		basicInboundChannel:        nil,
-		incentivizedInboundChannel: nil,
-		messagePackages:            messagePackages,
-		log:                        log,
+		bridges:         bridges,
+		config:          config,
+		conn:            conn,
+		inboundChannels: make(map[uint32]*inboundChannels),
+		messagePackages: messagePackages,
+		log:             log,
 	}, nil
 }
 
 func (wr *EthereumChannelWriter) Start(ctx context.Context, eg *errgroup.Group) error {
-	basic, err := basic.NewBasicInboundChannel(common.HexToAddress(wr.config.Channels.Basic.Inbound), wr.conn.GetClient())
+	beefyLightClient, err := beefylightclient.NewContract(common.HexToAddress(wr.config.BeefyLightClient), wr.conn.GetClient())
 	if err != nil {
 		return err
 	}
-	wr.basicInboundChannel = basic
+	wr.beefyLightClient = beefyLightClient
 
-	incentivized, err := incentivized.NewIncentivizedInboundChannel(common.HexToAddress(wr.config.Channels.Incentivized.Inbound), wr.conn.GetClient())
-	if err != nil {
-		return err
+	for _, bridge := range wr.bridges {
+		channels, err := wr.newInboundChannels(&bridge.Channels)
+		if err != nil {
+			return err
+		}
+		wr.inboundChannels[bridge.Parachain.ParachainID] = channels
 	}
-	wr.incentivizedInboundChannel = incentivized
 
 	eg.Go(func() error {
 		return wr.writeMessagesLoop(ctx)
@@ -64,6 +77,30 @@ func (wr *EthereumChannelWriter) Start(ctx context.Context, eg *errgroup.Group)
 	return nil
 }
 
+func (wr *EthereumChannelWriter) newInboundChannels(config *ethereum.ChannelsConfig) (*inboundChannels, error) {
+	basicChannel, err := config.Get(ethereum.BasicChannel)
+	if err != nil {
+		return nil, err
+	}
+
+	basic, err := basic.NewBasicInboundChannel(basicChannel.InboundAddress(), wr.conn.GetClient())
+	if err != nil {
+		return nil, err
+	}
+
+	incentivizedChannel, err := config.Get(ethereum.IncentivizedChannel)
+	if err != nil {
+		return nil, err
+	}
+
+	incentivized, err := incentivized.NewIncentivizedInboundChannel(incentivizedChannel.InboundAddress(), wr.conn.GetClient())
+	if err != nil {
+		return nil, err
+	}
+
+	return &inboundChannels{basic: basic, incentivized: incentivized}, nil
+}
+
 func (wr *EthereumChannelWriter) onDone(ctx context.Context) error {
 	wr.log.Info("Shutting down writer...")
 	// Avoid deadlock if a listener is still trying to send to a channel
@@ -106,6 +143,7 @@ func (wr *EthereumChannelWriter) signerFn(_ common.Address, tx *types.Transactio
 // Submit sends a SCALE-encoded message to an application deployed on the Ethereum network
 func (wr *EthereumChannelWriter) WriteBasicChannel(
 	options *bind.TransactOpts,
+	channel *basic.BasicInboundChannel,
 	msgPackage *MessagePackage,
 	msgs []parachain.BasicOutboundChannelMessage,
 ) error {
@@ -133,10 +171,9 @@ func (wr *EthereumChannelWriter) WriteBasicChannel(
 		NextAuthoritySetLen:  uint32(msgPackage.mmrProof.Leaf.BeefyNextAuthoritySet.Len),
 		NextAuthoritySetRoot: msgPackage.mmrProof.Leaf.BeefyNextAuthoritySet.Root,
 	}
-	// TODO: assess this - We assume no pruning, so one leaf for each block
-	beefyLeafCount := int64(msgPackage.mmrProof.Leaf.ParentNumberAndHash.ParentNumber)
-	// TODO: assess this - We assume we are relaying the newest leaf
-	beefyMMRLeafIndex := beefyLeafCount - 1
+	// The leaf may be older than the MMR root it is proven against
+	beefyMMRLeafIndex := int64(msgPackage.mmrProof.Proof.LeafIndex)
+	beefyLeafCount := int64(msgPackage.mmrProof.Proof.LeafCount)
 	var beefyMMRProof [][32]byte
 	for _, item := range msgPackage.mmrProof.Proof.Items {
 		beefyMMRProof = append(beefyMMRProof, [32]byte(item))
@@ -154,7 +191,7 @@ func (wr *EthereumChannelWriter) WriteBasicChannel(
 		return err
 	}
 
-	tx, err := wr.basicInboundChannel.Submit(options, messages, paraheadPartial,
+	tx, err := channel.Submit(options, messages, paraheadPartial,
 		paraHeadProof, beefyMMRLeafPartial,
 		big.NewInt(beefyMMRLeafIndex), big.NewInt(beefyLeafCount), beefyMMRProof)
 	if err != nil {
@@ -163,8 +200,9 @@ func (wr *EthereumChannelWriter) WriteBasicChannel(
 	}
 
 	wr.log.WithFields(logrus.Fields{
-		"txHash":  tx.Hash().Hex(),
-		"channel": "Basic",
+		"txHash":      tx.Hash().Hex(),
+		"channel":     "Basic",
+		"parachainID": msgPackage.paraID,
 	}).Info("Transaction submitted")
 
 	return nil
@@ -172,6 +210,7 @@ func (wr *EthereumChannelWriter) WriteBasicChannel(
 
 func (wr *EthereumChannelWriter) WriteIncentivizedChannel(
 	options *bind.TransactOpts,
+	channel *incentivized.IncentivizedInboundChannel,
 	msgPackage *MessagePackage,
 	msgs []parachain.IncentivizedOutboundChannelMessage,
 ) error {
@@ -200,10 +239,9 @@ func (wr *EthereumChannelWriter) WriteIncentivizedChannel(
 		NextAuthoritySetLen:  uint32(msgPackage.mmrProof.Leaf.BeefyNextAuthoritySet.Len),
 		NextAuthoritySetRoot: msgPackage.mmrProof.Leaf.BeefyNextAuthoritySet.Root,
 	}
-	// TODO: assess this - We assume no pruning, so one leaf for each block
-	beefyLeafCount := int64(msgPackage.mmrProof.Leaf.ParentNumberAndHash.ParentNumber)
-	// TODO: assess this - We assume we are relaying the newest leaf
-	beefyMMRLeafIndex := beefyLeafCount - 1
+	// The leaf may be older than the MMR root it is proven against
+	beefyMMRLeafIndex := int64(msgPackage.mmrProof.Proof.LeafIndex)
+	beefyLeafCount := int64(msgPackage.mmrProof.Proof.LeafCount)
 	var beefyMMRProof [][32]byte
 	for _, item := range msgPackage.mmrProof.Proof.Items {
 		beefyMMRProof = append(beefyMMRProof, [32]byte(item))
@@ -221,7 +259,7 @@ func (wr *EthereumChannelWriter) WriteIncentivizedChannel(
 		return err
 	}
 
-	tx, err := wr.incentivizedInboundChannel.Submit(options, messages,
+	tx, err := channel.Submit(options, messages,
 		paraheadPartial,
 		paraHeadProof, beefyMMRLeafPartial,
 		big.NewInt(beefyMMRLeafIndex), big.NewInt(beefyLeafCount), beefyMMRProof)
@@ -231,8 +269,9 @@ func (wr *EthereumChannelWriter) WriteIncentivizedChannel(
 	}
 
 	wr.log.WithFields(logrus.Fields{
-		"txHash":  tx.Hash().Hex(),
-		"channel": "Incentivized",
+		"txHash":      tx.Hash().Hex(),
+		"channel":     "Incentivized",
+		"parachainID": msgPackage.paraID,
 	}).Info("Transaction submitted")
 
 	return nil
@@ -242,6 +281,25 @@ func (wr *EthereumChannelWriter) WriteChannel(
 	options *bind.TransactOpts,
 	msg *MessagePackage,
 ) error {
+	channels, ok := wr.inboundChannels[msg.paraID]
+	if !ok {
+		return fmt.Errorf("no inbound channels configured for parachain %d", msg.paraID)
+	}
+
+	verified, err := wr.verifyMMRProof(options.Context, msg)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		// Undelivered commitments are relayed again with proofs against the next MMR root
+		wr.log.WithFields(logrus.Fields{
+			"parachainID":    msg.paraID,
+			"channelID":      msg.channelID,
+			"commitmentHash": msg.commitmentHash,
+		}).Info("Dropped message package. It will be proved again on the next new MMR root")
+		return nil
+	}
+
 	if msg.channelID.IsBasic {
 		var outboundMessages []parachain.BasicOutboundChannelMessage
 		err := gsrpcTypes.DecodeFromBytes(msg.commitmentData, &outboundMessages)
@@ -249,7 +307,7 @@ func (wr *EthereumChannelWriter) WriteChannel(
 			wr.log.WithError(err).Error("Failed to decode commitment messages")
 			return err
 		}
-		err = wr.WriteBasicChannel(options, msg, outboundMessages)
+		err = wr.WriteBasicChannel(options, channels.basic, msg, outboundMessages)
 		if err != nil {
 			wr.log.WithError(err).Error("Failed to write basic channel")
 			return err
@@ -263,7 +321,7 @@ func (wr *EthereumChannelWriter) WriteChannel(
 			wr.log.WithError(err).Error("Failed to decode commitment messages")
 			return err
 		}
-		err = wr.WriteIncentivizedChannel(options, msg, outboundMessages)
+		err = wr.WriteIncentivizedChannel(options, channels.incentivized, msg, outboundMessages)
 		if err != nil {
 			wr.log.WithError(err).Error("Failed to write incentivized channel")
 			return err
@@ -272,3 +330,39 @@ func (wr *EthereumChannelWriter) WriteChannel(
 
 	return nil
 }
+
+// verifyMMRProof checks the MMR proof of a message package against the latest MMR root verified
+// by the BEEFY light client, so that packages which can't be verified on Ethereum aren't submitted.
+// The root may have moved on since the package was built, in which case false is returned.
+func (wr *EthereumChannelWriter) verifyMMRProof(ctx context.Context, msg *MessagePackage) (bool, error) {
+	root, err := wr.beefyLightClient.LatestMMRRoot(&bind.CallOpts{
+		Pending: false,
+		Context: ctx,
+	})
+	if err != nil {
+		wr.log.WithError(err).Error("Failed to get latest MMR root from ethereum")
+		return false, err
+	}
+
+	leafHash, err := mmr.HashLeaf(msg.mmrProof.Leaf)
+	if err != nil {
+		return false, err
+	}
+	var proof [][32]byte
+	for _, item := range msg.mmrProof.Proof.Items {
+		proof = append(proof, [32]byte(item))
+	}
+
+	err = mmr.VerifyProof(root, leafHash, uint64(msg.mmrProof.Proof.LeafIndex), uint64(msg.mmrProof.Proof.LeafCount), proof)
+	if err != nil {
+		wr.log.WithError(err).WithFields(logrus.Fields{
+			"parachainID":     msg.paraID,
+			"mmrRoot":         gsrpcTypes.NewH256(root[:]).Hex(),
+			"mmrProofAtBlock": msg.mmrProof.BlockHash.Hex(),
+			"leafIndex":       msg.mmrProof.Proof.LeafIndex,
+			"leafCount":       msg.mmrProof.Proof.LeafCount,
+		}).Warn("MMR proof does not match the latest MMR root verified on ethereum")
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,14 @@
+package index
+
+type Config struct {
+	Dialect string `mapstructure:"dialect"`
+	// Database file of the index. The index is disabled when not set.
+	DBPath string `mapstructure:"dbpath"`
+	// First parachain block indexed when the database is empty. Commitments in earlier
+	// blocks are found by searching the parachain, as without the index.
+	StartBlock uint64 `mapstructure:"start-block"`
+}
+
+func (c *Config) Enabled() bool {
+	return c.DBPath != ""
+}
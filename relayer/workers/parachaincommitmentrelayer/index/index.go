@@ -0,0 +1,251 @@
+// Package index records the commitments of bridge parachains in a local database, so that
+// commitments which haven't been delivered to Ethereum yet are found without searching
+// the parachain block by block.
+package index
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// Commitment is a commitment in the digest of a finalized parachain block
+type Commitment struct {
+	ID          uint   `gorm:"primary_key"`
+	ParaID      uint32 `gorm:"unique_index:idx_commitment_item"`
+	BlockNumber uint64 `gorm:"unique_index:idx_commitment_item"`
+	// Position of the commitment among the auxiliary digest items of the block
+	ItemIndex int `gorm:"unique_index:idx_commitment_item"`
+	BlockHash common.Hash
+	// Name of the channel, as in the channels configuration
+	Channel string
+	Hash    common.Hash
+	// Nonces of the first and last committed messages
+	FirstNonce uint64
+	LastNonce  uint64
+	// SCALE-encoded messages
+	Data []byte
+	// Set if the messages couldn't be found when indexing, e.g. because the node pruned them.
+	// The nonces of missing commitments are unknown.
+	Missing bool
+}
+
+func (Commitment) TableName() string {
+	return "commitments"
+}
+
+// DigestItem returns the digest item the commitment was recorded from
+func (c *Commitment) DigestItem() (parachain.AuxiliaryDigestItem, error) {
+	channelID, err := parachain.ChannelIDByName(c.Channel)
+	if err != nil {
+		return parachain.AuxiliaryDigestItem{}, err
+	}
+	return parachain.AuxiliaryDigestItem{
+		IsCommitment: true,
+		AsCommitment: parachain.Commitment{ChannelID: channelID, Hash: types.H256(c.Hash)},
+	}, nil
+}
+
+// Progress is the range of blocks of a parachain which were indexed
+type Progress struct {
+	ParaID     uint32 `gorm:"primary_key;auto_increment:false"`
+	FirstBlock uint64
+	LastBlock  uint64
+	// Hash of LastBlock
+	LastBlockHash common.Hash
+}
+
+func (Progress) TableName() string {
+	return "commitment_index_progress"
+}
+
+type Index struct {
+	db *gorm.DB
+}
+
+// Open opens the index database, creating its tables if needed
+func Open(config *Config) (*Index, error) {
+	if len(config.DBPath) == 0 {
+		return nil, fmt.Errorf("invalid database path: %s", config.DBPath)
+	}
+	dialect := config.Dialect
+	if dialect == "" {
+		dialect = "sqlite3"
+	}
+
+	db, err := gorm.Open(dialect, config.DBPath)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == "sqlite3" {
+		// Writes of the indexers of all bridge parachains go through a single connection,
+		// as SQLite doesn't allow concurrent writers
+		db.DB().SetMaxOpenConns(1)
+	}
+
+	err = InitTables(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return New(db), nil
+}
+
+// InitTables creates the tables of the index, and adds the columns of later versions to
+// existing tables
+func InitTables(db *gorm.DB) error {
+	return db.AutoMigrate(&Commitment{}, &Progress{}).Error
+}
+
+func New(db *gorm.DB) *Index {
+	return &Index{db: db}
+}
+
+func (ix *Index) Close() error {
+	return ix.db.Close()
+}
+
+// Progress returns the blocks of the parachain which were indexed, if any
+func (ix *Index) Progress(paraID uint32) (Progress, bool, error) {
+	var progress Progress
+	err := ix.db.Take(&progress, "para_id = ?", paraID).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return Progress{}, false, nil
+	}
+	if err != nil {
+		return Progress{}, false, err
+	}
+	return progress, true, nil
+}
+
+// AddBlock records the commitments of a parachain block, which must follow the last indexed one
+func (ix *Index) AddBlock(paraID uint32, blockNumber uint64, blockHash common.Hash, commitments []Commitment) error {
+	progress, ok, err := ix.Progress(paraID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		progress = Progress{ParaID: paraID, FirstBlock: blockNumber}
+	} else if blockNumber != progress.LastBlock+1 {
+		return fmt.Errorf("parachain %d: block %d doesn't follow last indexed block %d",
+			paraID, blockNumber, progress.LastBlock)
+	}
+	progress.LastBlock = blockNumber
+	progress.LastBlockHash = blockHash
+
+	tx := ix.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	for i := range commitments {
+		commitment := commitments[i]
+		commitment.ParaID = paraID
+		commitment.BlockNumber = blockNumber
+		commitment.BlockHash = blockHash
+		if err := tx.Create(&commitment).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Save(&progress).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// Undelivered returns the commitments of a channel in blocks up to maxBlock whose messages have
+// nonces after the given nonce, in the order they were committed. The returned flag reports
+// whether these are known to be all of them, which isn't the case when maxBlock isn't indexed
+// yet, when some of them may be in blocks from before the index was started, or when some of
+// them may be missing.
+func (ix *Index) Undelivered(paraID uint32, channel string, nonce uint64, maxBlock uint64) (
+	[]Commitment, bool, error) {
+	progress, ok, err := ix.Progress(paraID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok || progress.LastBlock < maxBlock {
+		return nil, false, nil
+	}
+
+	var commitments []Commitment
+	err = ix.db.
+		Where("para_id = ? AND channel = ? AND first_nonce > ? AND block_number <= ?",
+			paraID, channel, nonce, maxBlock).
+		Order("block_number, item_index").
+		Find(&commitments).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	missing, err := ix.missingAfter(paraID, channel, nonce, maxBlock)
+	if err != nil {
+		return nil, false, err
+	}
+	if missing {
+		return commitments, false, nil
+	}
+
+	// Committed nonces are consecutive
+	next := nonce + 1
+	for _, commitment := range commitments {
+		if commitment.FirstNonce != next {
+			return commitments, false, nil
+		}
+		next = commitment.LastNonce + 1
+	}
+
+	// Without the commitment holding the given nonce, earlier commitments may be missing
+	// unless the parachain was indexed from its start
+	if progress.FirstBlock <= 1 {
+		return commitments, true, nil
+	}
+	if nonce == 0 {
+		return commitments, len(commitments) > 0, nil
+	}
+	var count int
+	err = ix.db.Model(&Commitment{}).
+		Where("para_id = ? AND channel = ? AND first_nonce <= ? AND last_nonce >= ?",
+			paraID, channel, nonce, nonce).
+		Count(&count).Error
+	if err != nil {
+		return nil, false, err
+	}
+	return commitments, count > 0, nil
+}
+
+// missingAfter reports whether a channel has missing commitments in blocks up to maxBlock which
+// may hold messages after the given nonce, i.e. after the block of the commitment holding it
+func (ix *Index) missingAfter(paraID uint32, channel string, nonce uint64, maxBlock uint64) (bool, error) {
+	var afterBlock uint64
+	var delivered Commitment
+	err := ix.db.
+		Where("para_id = ? AND channel = ? AND NOT missing AND first_nonce <= ? AND last_nonce >= ?",
+			paraID, channel, nonce, nonce).
+		Take(&delivered).Error
+	if err == nil {
+		afterBlock = delivered.BlockNumber
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return false, err
+	}
+
+	var count int
+	err = ix.db.Model(&Commitment{}).
+		Where("para_id = ? AND channel = ? AND missing AND block_number > ? AND block_number <= ?",
+			paraID, channel, afterBlock, maxBlock).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,238 @@
+package index_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/parachaincommitmentrelayer/index"
+)
+
+const paraID = 200
+
+func openTestIndex(t *testing.T) (*index.Index, string) {
+	dir, err := ioutil.TempDir("", "commitment-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	config := index.Config{Dialect: "sqlite3", DBPath: filepath.Join(dir, "index.db")}
+	ix, err := index.Open(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ix.Close() })
+	return ix, config.DBPath
+}
+
+func basicCommitment(firstNonce, lastNonce uint64) index.Commitment {
+	return index.Commitment{
+		Channel:    ethereum.BasicChannel,
+		Hash:       common.BigToHash(common.Big1),
+		FirstNonce: firstNonce,
+		LastNonce:  lastNonce,
+		Data:       []byte{byte(firstNonce)},
+	}
+}
+
+// addBlocks indexes the blocks from firstBlock to lastBlock, with the given commitments
+func addBlocks(t *testing.T, ix *index.Index, firstBlock, lastBlock uint64, commitments map[uint64][]index.Commitment) {
+	for blockNumber := firstBlock; blockNumber <= lastBlock; blockNumber++ {
+		err := ix.AddBlock(paraID, blockNumber, common.BigToHash(common.Big2), commitments[blockNumber])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func nonces(commitments []index.Commitment) [][2]uint64 {
+	var result [][2]uint64
+	for _, c := range commitments {
+		result = append(result, [2]uint64{c.FirstNonce, c.LastNonce})
+	}
+	return result
+}
+
+func TestUndelivered(t *testing.T) {
+	ix, _ := openTestIndex(t)
+
+	incentivized := basicCommitment(1, 1)
+	incentivized.Channel = ethereum.IncentivizedChannel
+	incentivized.ItemIndex = 1
+	addBlocks(t, ix, 0, 20, map[uint64][]index.Commitment{
+		3:  {basicCommitment(1, 2), incentivized},
+		7:  {basicCommitment(3, 3)},
+		15: {basicCommitment(4, 6)},
+	})
+
+	commitments, ok, err := ix.Undelivered(paraID, ethereum.BasicChannel, 2, 20)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, [][2]uint64{{3, 3}, {4, 6}}, nonces(commitments))
+	assert.Equal(t, uint64(7), commitments[0].BlockNumber)
+	assert.Equal(t, []byte{3}, commitments[0].Data)
+
+	// Commitments after the given block aren't finalized yet
+	commitments, ok, err = ix.Undelivered(paraID, ethereum.BasicChannel, 0, 10)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, [][2]uint64{{1, 2}, {3, 3}}, nonces(commitments))
+
+	commitments, ok, err = ix.Undelivered(paraID, ethereum.IncentivizedChannel, 1, 20)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, commitments)
+
+	// Blocks which aren't indexed yet
+	_, ok, err = ix.Undelivered(paraID, ethereum.BasicChannel, 2, 21)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = ix.Undelivered(201, ethereum.BasicChannel, 2, 20)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestUndelivered_StartedLate(t *testing.T) {
+	ix, _ := openTestIndex(t)
+
+	// Nonces 1 to 4 were committed before block 10
+	addBlocks(t, ix, 10, 20, map[uint64][]index.Commitment{
+		12: {basicCommitment(5, 6)},
+		18: {basicCommitment(7, 7)},
+	})
+
+	commitments, ok, err := ix.Undelivered(paraID, ethereum.BasicChannel, 6, 20)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, [][2]uint64{{7, 7}}, nonces(commitments))
+
+	// The commitment with nonce 4 wasn't indexed
+	_, ok, err = ix.Undelivered(paraID, ethereum.BasicChannel, 4, 20)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = ix.Undelivered(paraID, ethereum.BasicChannel, 2, 20)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = ix.Undelivered(paraID, ethereum.BasicChannel, 0, 20)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+// Commitments as recorded before missing ones were
+type commitmentV1 struct {
+	ID          uint   `gorm:"primary_key"`
+	ParaID      uint32 `gorm:"unique_index:idx_commitment_item"`
+	BlockNumber uint64 `gorm:"unique_index:idx_commitment_item"`
+	ItemIndex   int    `gorm:"unique_index:idx_commitment_item"`
+	BlockHash   common.Hash
+	Channel     string
+	Hash        common.Hash
+	FirstNonce  uint64
+	LastNonce   uint64
+	Data        []byte
+}
+
+func (commitmentV1) TableName() string {
+	return "commitments"
+}
+
+func TestOpen_MissingCommitments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commitment-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "index.db")
+
+	db, err := gorm.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateTable(&commitmentV1{}).Error
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := index.Open(&index.Config{DBPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	missing := index.Commitment{Channel: ethereum.BasicChannel, Hash: common.BigToHash(common.Big3), Missing: true}
+	addBlocks(t, ix, 0, 20, map[uint64][]index.Commitment{
+		3:  {basicCommitment(1, 2)},
+		7:  {missing},
+		15: {basicCommitment(4, 6)},
+	})
+
+	// Nonce 3 may be in the missing commitment
+	commitments, ok, err := ix.Undelivered(paraID, ethereum.BasicChannel, 2, 20)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, [][2]uint64{{4, 6}}, nonces(commitments))
+
+	// Commitments before the missing one are all known
+	commitments, ok, err = ix.Undelivered(paraID, ethereum.BasicChannel, 0, 6)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, [][2]uint64{{1, 2}}, nonces(commitments))
+
+	commitments, ok, err = ix.Undelivered(paraID, ethereum.BasicChannel, 4, 20)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, commitments)
+}
+
+func TestAddBlock(t *testing.T) {
+	ix, path := openTestIndex(t)
+
+	addBlocks(t, ix, 5, 8, nil)
+	err := ix.AddBlock(paraID, 10, common.Hash{}, nil)
+	assert.Error(t, err)
+	err = ix.AddBlock(paraID, 8, common.Hash{}, nil)
+	assert.Error(t, err)
+
+	// Progress survives reopening the index
+	ix.Close()
+	ix, err = index.Open(&index.Config{DBPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	progress, ok, err := ix.Progress(paraID)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), progress.FirstBlock)
+	assert.Equal(t, uint64(8), progress.LastBlock)
+	assert.Equal(t, common.BigToHash(common.Big2), progress.LastBlockHash)
+
+	_, ok, err = ix.Progress(201)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestCommitmentDigestItem(t *testing.T) {
+	commitment := basicCommitment(1, 1)
+	item, err := commitment.DigestItem()
+	assert.Nil(t, err)
+	assert.True(t, item.IsCommitment)
+	assert.True(t, item.AsCommitment.ChannelID.IsBasic)
+	assert.Equal(t, commitment.Hash[:], item.AsCommitment.Hash[:])
+
+	commitment.Channel = "unknown"
+	_, err = commitment.DigestItem()
+	assert.Error(t, err)
+}
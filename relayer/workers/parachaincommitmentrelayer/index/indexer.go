@@ -0,0 +1,229 @@
+package index
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// Delays between attempts to index a block, which double from the first to the maximum delay
+var (
+	retryDelay    = time.Second
+	maxRetryDelay = time.Minute
+)
+
+// Indexer follows the finalized heads of a parachain, and records the commitments in their
+// digests together with the nonces of the committed messages
+type Indexer struct {
+	paraID     uint32
+	conn       *parachain.Connection
+	index      *Index
+	startBlock uint64
+	log        *logrus.Entry
+}
+
+func NewIndexer(paraID uint32, conn *parachain.Connection, index *Index, startBlock uint64,
+	log *logrus.Entry) *Indexer {
+	return &Indexer{
+		paraID:     paraID,
+		conn:       conn,
+		index:      index,
+		startBlock: startBlock,
+		log:        log.WithField("parachainID", paraID),
+	}
+}
+
+func (ix *Indexer) Start(ctx context.Context, eg *errgroup.Group) error {
+	heads := make(chan types.Header)
+	sub, err := ix.conn.Subscribe("chain", "subscribeFinalizedHeads", "unsubscribeFinalizedHeads",
+		"finalizedHead", heads)
+	if err != nil {
+		return err
+	}
+
+	eg.Go(func() error {
+		defer sub.Unsubscribe()
+
+		header, err := ix.conn.GetFinalizedHeader()
+		if err != nil {
+			return err
+		}
+		err = ix.indexTo(ctx, uint64(header.Number))
+		if err != nil {
+			return err
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-sub.Err():
+				return err
+			case header := <-heads:
+				err := ix.indexTo(ctx, uint64(header.Number))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// indexTo indexes all blocks after the last indexed one up to the given finalized block.
+// Finalized heads may skip blocks, which are indexed all the same.
+func (ix *Indexer) indexTo(ctx context.Context, finalizedBlock uint64) error {
+	next := ix.startBlock
+	progress, ok, err := ix.index.Progress(ix.paraID)
+	if err != nil {
+		return err
+	}
+	if ok {
+		next = progress.LastBlock + 1
+	}
+	if next > finalizedBlock {
+		return nil
+	}
+
+	if finalizedBlock > next {
+		ix.log.WithFields(logrus.Fields{
+			"from": next,
+			"to":   finalizedBlock,
+		}).Info("Indexing commitments")
+	}
+
+	for blockNumber := next; blockNumber <= finalizedBlock; blockNumber++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		ix.indexBlockWithRetry(ctx, blockNumber)
+	}
+	return nil
+}
+
+// indexBlockWithRetry indexes a block, retrying until it succeeds or the context is canceled.
+// Errors are mostly due to the connection to the parachain node, which recovers on its own.
+func (ix *Indexer) indexBlockWithRetry(ctx context.Context, blockNumber uint64) {
+	delay := retryDelay
+	for {
+		err := ix.indexBlock(blockNumber)
+		if err == nil {
+			return
+		}
+		ix.log.WithError(err).WithFields(logrus.Fields{
+			"blockNumber": blockNumber,
+			"retryDelay":  delay,
+		}).Warn("Failed to index block. Retrying")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func (ix *Indexer) indexBlock(blockNumber uint64) error {
+	blockHash, err := ix.conn.GetAPI().RPC.Chain.GetBlockHash(blockNumber)
+	if err != nil {
+		return err
+	}
+
+	header, err := ix.conn.GetAPI().RPC.Chain.GetHeader(blockHash)
+	if err != nil {
+		return err
+	}
+
+	digestItems := parachain.ExtractAuxiliaryDigestItems(header.Digest, ix.log)
+
+	var commitments []Commitment
+	for i, digestItem := range digestItems {
+		if !digestItem.IsCommitment {
+			continue
+		}
+		channelID := digestItem.AsCommitment.ChannelID
+		if !channelID.IsBasic && !channelID.IsIncentivized {
+			ix.log.WithFields(logrus.Fields{
+				"blockNumber":    blockNumber,
+				"channel":        channelID.Name(),
+				"commitmentHash": digestItem.AsCommitment.Hash.Hex(),
+			}).Warn("Skipping commitment of unsupported channel")
+			continue
+		}
+		commitment, err := ix.newCommitment(digestItem, blockHash)
+		if err != nil {
+			return err
+		}
+		commitment.ItemIndex = i
+		commitments = append(commitments, commitment)
+
+		ix.log.WithFields(logrus.Fields{
+			"blockNumber":    blockNumber,
+			"channel":        commitment.Channel,
+			"commitmentHash": commitment.Hash.Hex(),
+			"firstNonce":     commitment.FirstNonce,
+			"lastNonce":      commitment.LastNonce,
+			"missing":        commitment.Missing,
+		}).Info("Indexed commitment")
+	}
+
+	return ix.index.AddBlock(ix.paraID, blockNumber, common.Hash(blockHash), commitments)
+}
+
+// newCommitment reads the messages committed to by a digest item to find their nonces. If the
+// messages can't be found, e.g. because they were pruned, the commitment is recorded as missing,
+// so that catching up searches the parachain for it instead.
+func (ix *Indexer) newCommitment(digestItem parachain.AuxiliaryDigestItem, blockHash types.Hash) (
+	Commitment, error) {
+	commitment := Commitment{Hash: common.Hash(digestItem.AsCommitment.Hash)}
+
+	var nonces []uint64
+	var err error
+	switch channelID := digestItem.AsCommitment.ChannelID; {
+	case channelID.IsBasic:
+		commitment.Channel = ethereum.BasicChannel
+		var messages []parachain.BasicOutboundChannelMessage
+		messages, commitment.Data, err = ix.conn.GetBasicOutboundMessages(digestItem, blockHash)
+		for _, message := range messages {
+			nonces = append(nonces, message.Nonce)
+		}
+	case channelID.IsIncentivized:
+		commitment.Channel = ethereum.IncentivizedChannel
+		var messages []parachain.IncentivizedOutboundChannelMessage
+		messages, commitment.Data, err = ix.conn.GetIncentivizedOutboundMessages(digestItem, blockHash)
+		for _, message := range messages {
+			nonces = append(nonces, message.Nonce)
+		}
+	}
+	if parachain.IsCommitmentNotFound(err) {
+		ix.log.WithFields(logrus.Fields{
+			"blockHash":      blockHash.Hex(),
+			"channel":        commitment.Channel,
+			"commitmentHash": commitment.Hash.Hex(),
+		}).Warn("Messages of commitment not found. Recording it as missing")
+		commitment.Missing = true
+		return commitment, nil
+	}
+	if err != nil {
+		return Commitment{}, err
+	}
+
+	if len(nonces) > 0 {
+		commitment.FirstNonce = nonces[0]
+		commitment.LastNonce = nonces[len(nonces)-1]
+	}
+	return commitment, nil
+}
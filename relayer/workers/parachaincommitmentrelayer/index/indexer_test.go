@@ -0,0 +1,286 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	gethrpc "github.com/snowfork/go-substrate-rpc-client/v3/gethrpc"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+)
+
+// testParachain serves blocks whose digests hold commitments, and the committed messages
+// from its offchain storage
+type testParachain struct {
+	metadata string
+	// Encoded digest items by block number
+	digests map[uint64][]string
+	// Committed messages by offchain storage key
+	offchain map[string]string
+	// Number of times to fail getting the header of a block
+	failures map[uint64]int
+}
+
+func (p *testParachain) GetMetadata() string {
+	return p.metadata
+}
+
+func (p *testParachain) Health() map[string]interface{} {
+	return map[string]interface{}{"peers": 1, "isSyncing": false, "shouldHavePeers": true}
+}
+
+func (p *testParachain) GetBlockHash(number uint64) string {
+	return types.NewHash([]byte{byte(number)}).Hex()
+}
+
+func (p *testParachain) GetHeader(hash string) (map[string]interface{}, error) {
+	number := types.MustHexDecodeString(hash)[0]
+	if p.failures[uint64(number)] > 0 {
+		p.failures[uint64(number)]--
+		return nil, fmt.Errorf("header of block %d is unavailable", number)
+	}
+	logs := p.digests[uint64(number)]
+	if logs == nil {
+		logs = []string{}
+	}
+	return map[string]interface{}{
+		"parentHash":     types.Hash{}.Hex(),
+		"number":         fmt.Sprintf("%#x", number),
+		"stateRoot":      types.Hash{}.Hex(),
+		"extrinsicsRoot": types.Hash{}.Hex(),
+		"digest":         map[string]interface{}{"logs": logs},
+	}, nil
+}
+
+func (p *testParachain) LocalStorageGet(_ string, key string) *string {
+	value, ok := p.offchain[key]
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+// commit adds a commitment to the given messages to the digest of a block
+func (p *testParachain) commit(t *testing.T, blockNumber uint64, channelID parachain.ChannelID, messages interface{}) {
+	data, err := types.EncodeToBytes(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := parachain.CommitmentHash(channelID, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := parachain.MakeStorageKey(channelID, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.offchain[types.HexEncodeToString(key)] = types.HexEncodeToString(data)
+
+	channel, err := types.EncodeToBytes(channelID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Commitment variant of the auxiliary digest item
+	item := append([]byte{0}, channel...)
+	item = append(item, hash[:]...)
+	log, err := types.EncodeToHexString(types.DigestItem{IsOther: true, AsOther: item})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.digests[blockNumber] = append(p.digests[blockNumber], log)
+}
+
+func newTestIndexer(t *testing.T, p *testParachain) (*Indexer, *Index) {
+	server := gethrpc.NewServer()
+	for _, name := range []string{"state", "offchain", "system", "chain"} {
+		err := server.RegisterName(name, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	http := httptest.NewServer(server.WebsocketHandler([]string{"*"}))
+	t.Cleanup(func() {
+		server.Stop()
+		http.Close()
+	})
+
+	log := logrus.WithField("test", "indexer")
+	conn := parachain.NewConnection("ws"+strings.TrimPrefix(http.URL, "http"), nil, log)
+	err := conn.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+
+	dir, err := ioutil.TempDir("", "commitment-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	index, err := Open(&Config{DBPath: filepath.Join(dir, "index.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+
+	return NewIndexer(200, conn, index, 1, log), index
+}
+
+func TestIndexer(t *testing.T) {
+	metadata, err := types.EncodeToHexString(&types.Metadata{
+		MagicNumber:   types.MagicNumber,
+		Version:       13,
+		IsMetadataV13: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &testParachain{
+		metadata: metadata,
+		digests:  make(map[uint64][]string),
+		offchain: make(map[string]string),
+	}
+	target := common.HexToAddress("0xdaf13fa1997b9649b2bcc553732c67887a68022c")
+	basic := parachain.ChannelID{IsBasic: true}
+	incentivized := parachain.ChannelID{IsIncentivized: true}
+	node.commit(t, 2, basic, []parachain.BasicOutboundChannelMessage{
+		{Target: target, Nonce: 1, Payload: []byte{1}},
+		{Target: target, Nonce: 2, Payload: []byte{2}},
+	})
+	node.commit(t, 4, incentivized, []parachain.IncentivizedOutboundChannelMessage{
+		{Target: target, Nonce: 1, Fee: types.NewU256(*common.Big1), Payload: []byte{1}},
+	})
+	node.commit(t, 4, basic, []parachain.BasicOutboundChannelMessage{
+		{Target: target, Nonce: 3, Payload: []byte{3}},
+	})
+	node.commit(t, 7, basic, []parachain.BasicOutboundChannelMessage{
+		{Target: target, Nonce: 4, Payload: []byte{4}},
+	})
+
+	indexer, index := newTestIndexer(t, node)
+	ctx := context.Background()
+
+	err = indexer.indexTo(ctx, 5)
+	assert.Nil(t, err)
+
+	commitments, ok, err := index.Undelivered(200, ethereum.BasicChannel, 0, 5)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	if assert.Len(t, commitments, 2) {
+		assert.Equal(t, uint64(2), commitments[0].BlockNumber)
+		assert.Equal(t, [2]uint64{1, 2}, [2]uint64{commitments[0].FirstNonce, commitments[0].LastNonce})
+		assert.Equal(t, uint64(4), commitments[1].BlockNumber)
+		assert.Equal(t, 1, commitments[1].ItemIndex)
+		assert.Equal(t, [2]uint64{3, 3}, [2]uint64{commitments[1].FirstNonce, commitments[1].LastNonce})
+
+		var messages []parachain.BasicOutboundChannelMessage
+		err = types.DecodeFromBytes(commitments[1].Data, &messages)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{3}, messages[0].Payload)
+	}
+
+	commitments, ok, err = index.Undelivered(200, ethereum.IncentivizedChannel, 0, 5)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	if assert.Len(t, commitments, 1) {
+		assert.Equal(t, 0, commitments[0].ItemIndex)
+	}
+
+	// Block 7 isn't finalized yet
+	_, ok, err = index.Undelivered(200, ethereum.BasicChannel, 3, 7)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// Indexing continues after the last indexed block
+	err = indexer.indexTo(ctx, 8)
+	assert.Nil(t, err)
+	commitments, ok, err = index.Undelivered(200, ethereum.BasicChannel, 3, 8)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Len(t, commitments, 1)
+
+	progress, _, err := index.Progress(200)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), progress.FirstBlock)
+	assert.Equal(t, uint64(8), progress.LastBlock)
+	assert.Equal(t, types.NewHash([]byte{8}).Hex(), progress.LastBlockHash.Hex())
+}
+
+func TestIndexer_MissingCommitment(t *testing.T) {
+	metadata, err := types.EncodeToHexString(&types.Metadata{
+		MagicNumber:   types.MagicNumber,
+		Version:       13,
+		IsMetadataV13: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &testParachain{
+		metadata: metadata,
+		digests:  make(map[uint64][]string),
+		offchain: make(map[string]string),
+		failures: map[uint64]int{3: 2},
+	}
+	target := common.HexToAddress("0xdaf13fa1997b9649b2bcc553732c67887a68022c")
+	basic := parachain.ChannelID{IsBasic: true}
+	node.commit(t, 2, basic, []parachain.BasicOutboundChannelMessage{{Target: target, Nonce: 1, Payload: []byte{1}}})
+	node.commit(t, 4, basic, []parachain.BasicOutboundChannelMessage{{Target: target, Nonce: 2, Payload: []byte{2}}})
+	node.commit(t, 6, basic, []parachain.BasicOutboundChannelMessage{{Target: target, Nonce: 3, Payload: []byte{3}}})
+	// The node pruned the messages committed to in block 4
+	for key := range node.offchain {
+		var messages []parachain.BasicOutboundChannelMessage
+		err := types.DecodeFromHexString(node.offchain[key], &messages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messages[0].Nonce == 2 {
+			delete(node.offchain, key)
+		}
+	}
+
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = time.Second }()
+
+	indexer, index := newTestIndexer(t, node)
+	indexer.conn.SetCommitmentSources(parachain.NewOffchainStorageSource(indexer.conn))
+
+	// Failing to get the header of block 3 is retried
+	err = indexer.indexTo(context.Background(), 6)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, node.failures[3])
+	progress, _, err := index.Progress(200)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(6), progress.LastBlock)
+
+	// Undelivered messages may be in the missing commitment
+	for _, nonce := range []uint64{0, 1} {
+		_, ok, err := index.Undelivered(200, ethereum.BasicChannel, nonce, 6)
+		assert.Nil(t, err)
+		assert.False(t, ok, "nonce %d", nonce)
+	}
+
+	// Not once the messages after it were delivered
+	commitments, ok, err := index.Undelivered(200, ethereum.BasicChannel, 2, 6)
+	assert.Nil(t, err)
+	assert.False(t, ok, "the commitment holding nonce 2 is missing")
+	assert.Len(t, commitments, 1)
+
+	commitments, ok, err = index.Undelivered(200, ethereum.BasicChannel, 3, 6)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, commitments)
+}
@@ -11,46 +11,111 @@ import (
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
 	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
 	"github.com/snowfork/polkadot-ethereum/relayer/crypto/secp256k1"
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/parachaincommitmentrelayer/index"
 )
 
 type Worker struct {
-	parachainConfig       *parachain.Config
+	bridges               []BridgeConfig
 	relaychainConfig      *relaychain.Config
 	ethereumConfig        *ethereum.Config
-	parachainConn         *parachain.Connection
+	parachainConns        []*parachain.Connection
+	offchainSources       []*parachain.RemoteOffchainStorageSource
 	relaychainConn        *relaychain.Connection
 	ethereumConn          *ethereum.Connection
 	ethereumChannelWriter *EthereumChannelWriter
 	beefyListener         *BeefyListener
+	index                 *index.Index
+	indexers              []*index.Indexer
 	log                   *logrus.Entry
 }
 
 const Name = "parachain-commitment-relayer"
 
-func NewWorker(parachainConfig *parachain.Config,
+// LegacyParachainID is the ID of the bridge parachain from before it could be configured
+const LegacyParachainID = 200
+
+// NewWorker creates a worker relaying the commitments of the given bridge parachains. All
+// bridges share a single relay chain connection and BEEFY light client, and the commitment
+// index if one is configured.
+func NewWorker(bridges []BridgeConfig, indexConfig *index.Config,
 	relaychainConfig *relaychain.Config, ethereumConfig *ethereum.Config, log *logrus.Entry) (*Worker, error) {
 
 	log.Info("Creating worker")
 
+	err := validateBridges(bridges)
+	if err != nil {
+		return nil, err
+	}
+
 	ethereumKp, err := secp256k1.NewKeypairFromString(ethereumConfig.ParachainCommitmentsPrivateKey)
 	if err != nil {
 		return nil, err
 	}
 
-	parachainConn := parachain.NewConnection(parachainConfig.Endpoint, nil, log)
-	relaychainConn := relaychain.NewConnection(relaychainConfig.Endpoint, log)
+	var commitmentIndex *index.Index
+	if indexConfig.Enabled() {
+		commitmentIndex, err = index.Open(indexConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	relaychainConn := relaychain.NewConnection(relaychainConfig.Endpoint, log, relaychainConfig.Endpoints...)
 	ethereumConn := ethereum.NewConnection(ethereumConfig.Endpoint, ethereumKp, log)
 
+	var parachainConns []*parachain.Connection
+	var offchainSources []*parachain.RemoteOffchainStorageSource
+	var parachainListeners []*ParachainListener
+	var indexers []*index.Indexer
+	for i := range bridges {
+		config := &bridges[i]
+		parachainLog := log.WithField("parachainID", config.Parachain.ParachainID)
+		parachainConn := parachain.NewConnection(config.Parachain.Endpoint, nil,
+			parachainLog, config.Parachain.Endpoints...)
+		parachainConns = append(parachainConns, parachainConn)
+
+		// Committed messages are read from offchain-enabled nodes first, then from the
+		// parachain node itself. Offchain nodes are connected to when first needed, and
+		// skipped while they are down.
+		var commitmentSources []parachain.CommitmentSource
+		for _, endpoint := range config.Parachain.OffchainEndpoints {
+			offchainConn := parachain.NewConnection(endpoint, nil, parachainLog.WithField("offchainEndpoint", endpoint))
+			offchainSource := parachain.NewRemoteOffchainStorageSource(offchainConn)
+			offchainSources = append(offchainSources, offchainSource)
+			commitmentSources = append(commitmentSources, offchainSource)
+		}
+		commitmentSources = append(commitmentSources,
+			parachain.NewOffchainStorageSource(parachainConn), parachain.NewMessageQueueSource(parachainConn))
+		parachainConn.SetCommitmentSources(commitmentSources...)
+		parachainListeners = append(parachainListeners, NewParachainListener(
+			config.Parachain.ParachainID,
+			&config.Channels,
+			ethereumConn,
+			relaychainConn,
+			parachainConn,
+			commitmentIndex,
+			log,
+		))
+		if commitmentIndex != nil {
+			indexers = append(indexers, index.NewIndexer(config.Parachain.ParachainID, parachainConn,
+				commitmentIndex, indexConfig.StartBlock, log))
+		}
+	}
+
 	// channel for messages from beefy listener to ethereum writer
 	var messagePackages = make(chan MessagePackage, 1)
 
 	ethereumChannelWriter, err := NewEthereumChannelWriter(
+		bridges,
 		ethereumConfig,
 		ethereumConn,
 		messagePackages,
 		log,
 	)
 	if err != nil {
+		if commitmentIndex != nil {
+			commitmentIndex.Close()
+		}
 		return nil, err
 	}
 
@@ -58,20 +123,23 @@ func NewWorker(parachainConfig *parachain.Config,
 		ethereumConfig,
 		ethereumConn,
 		relaychainConn,
-		parachainConn,
+		parachainListeners,
 		messagePackages,
 		log,
 	)
 
 	return &Worker{
-		parachainConfig:       parachainConfig,
+		bridges:               bridges,
 		relaychainConfig:      relaychainConfig,
 		ethereumConfig:        ethereumConfig,
-		parachainConn:         parachainConn,
+		parachainConns:        parachainConns,
+		offchainSources:       offchainSources,
 		relaychainConn:        relaychainConn,
 		ethereumConn:          ethereumConn,
 		ethereumChannelWriter: ethereumChannelWriter,
 		beefyListener:         beefyListener,
+		index:                 commitmentIndex,
+		indexers:              indexers,
 		log:                   log,
 	}, nil
 }
@@ -79,16 +147,25 @@ func NewWorker(parachainConfig *parachain.Config,
 func (worker *Worker) Start(ctx context.Context, eg *errgroup.Group) error {
 	worker.log.Info("Starting worker")
 
+	// Clean up after ourselves
+	eg.Go(func() error {
+		<-ctx.Done()
+		worker.Stop()
+		return nil
+	})
+
 	if worker.beefyListener == nil || worker.ethereumChannelWriter == nil {
 		return fmt.Errorf("Sender and/or receiver need to be set before starting chain")
 	}
 
-	err := worker.parachainConn.Connect(ctx)
-	if err != nil {
-		return err
+	for _, parachainConn := range worker.parachainConns {
+		err := parachainConn.Connect(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = worker.ethereumConn.Connect(ctx)
+	err := worker.ethereumConn.Connect(ctx)
 	if err != nil {
 		return err
 	}
@@ -98,6 +175,13 @@ func (worker *Worker) Start(ctx context.Context, eg *errgroup.Group) error {
 		return err
 	}
 
+	for _, indexer := range worker.indexers {
+		err = indexer.Start(ctx, eg)
+		if err != nil {
+			return err
+		}
+	}
+
 	eg.Go(func() error {
 		if worker.ethereumChannelWriter != nil {
 			worker.log.Info("Starting Writer")
@@ -124,8 +208,11 @@ func (worker *Worker) Start(ctx context.Context, eg *errgroup.Group) error {
 }
 
 func (worker *Worker) Stop() {
-	if worker.parachainConn != nil {
-		worker.parachainConn.Close()
+	for _, parachainConn := range worker.parachainConns {
+		parachainConn.Close()
+	}
+	for _, offchainSource := range worker.offchainSources {
+		offchainSource.Close()
 	}
 	if worker.relaychainConn != nil {
 		worker.relaychainConn.Close()
@@ -133,6 +220,9 @@ func (worker *Worker) Stop() {
 	if worker.ethereumConn != nil {
 		worker.ethereumConn.Close()
 	}
+	if worker.index != nil {
+		worker.index.Close()
+	}
 }
 
 func (ch *Worker) Name() string {
@@ -0,0 +1,71 @@
+package parachaincommitmentrelayer
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/snowfork/go-substrate-rpc-client/v3/types"
+
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/ethereum"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/parachain"
+	"github.com/snowfork/polkadot-ethereum/relayer/chain/relaychain"
+	"github.com/snowfork/polkadot-ethereum/relayer/workers/parachaincommitmentrelayer/index"
+)
+
+// ParachainListener builds message packages for the commitments of a single bridge parachain
+type ParachainListener struct {
+	paraID              uint32
+	channels            *ethereum.ChannelsConfig
+	ethereumConn        *ethereum.Connection
+	relaychainConn      *relaychain.Connection
+	parachainConnection *parachain.Connection
+	// Commitment index of the parachain. Optional.
+	index *index.Index
+	log   *logrus.Entry
+}
+
+func NewParachainListener(
+	paraID uint32,
+	channels *ethereum.ChannelsConfig,
+	ethereumConn *ethereum.Connection,
+	relaychainConn *relaychain.Connection,
+	parachainConnection *parachain.Connection,
+	index *index.Index,
+	log *logrus.Entry) *ParachainListener {
+	return &ParachainListener{
+		paraID:              paraID,
+		channels:            channels,
+		ethereumConn:        ethereumConn,
+		relaychainConn:      relaychainConn,
+		parachainConnection: parachainConnection,
+		index:               index,
+		log:                 log.WithField("parachainID", paraID),
+	}
+}
+
+// buildMessagePackages builds packages for all commitments of the parachain which haven't been
+// delivered to Ethereum yet, and are finalized by the given relay chain block
+func (li *ParachainListener) buildMessagePackages(
+	ctx context.Context, relayBlockNumber uint64, relayBlockHash types.Hash) ([]MessagePackage, error) {
+	verifiedParaBlockNumber, err := li.relaychainConn.FetchLatestFinalizedParaBlockNumber(
+		relayBlockHash, li.paraID)
+	if err != nil {
+		li.log.WithError(err).Error("Failed to get latest finalized para block number from relay chain")
+		return nil, err
+	}
+
+	verifiedParaBlockHash, err := li.parachainConnection.GetAPI().RPC.Chain.GetBlockHash(verifiedParaBlockNumber)
+	if err != nil {
+		li.log.WithError(err).Error("Failed to get latest finalized para block hash")
+		return nil, err
+	}
+
+	messagePackages, err := li.buildMissedMessagePackages(ctx, relayBlockNumber, relayBlockHash,
+		verifiedParaBlockNumber, verifiedParaBlockHash)
+	if err != nil {
+		li.log.WithError(err).Error("Failed to build missed message packages")
+		return nil, err
+	}
+
+	return messagePackages, nil
+}
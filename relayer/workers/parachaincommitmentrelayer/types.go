@@ -26,6 +26,7 @@ type DigestItemWithData struct {
 }
 
 type MessagePackage struct {
+	paraID             uint32
 	channelID          parachain.ChannelID
 	commitmentHash     types.H256
 	commitmentData     types.StorageDataRaw
@@ -37,7 +38,7 @@ type MessagePackage struct {
 	mmrProof           types.GenerateMMRProofResponse
 }
 
-func CreateMessagePackages(paraBlocks []ParaBlockWithProofs) ([]MessagePackage, error) {
+func CreateMessagePackages(paraID uint32, paraBlocks []ParaBlockWithProofs) ([]MessagePackage, error) {
 	var messagePackages []MessagePackage
 
 	for _, block := range paraBlocks {
@@ -45,6 +46,7 @@ func CreateMessagePackages(paraBlocks []ParaBlockWithProofs) ([]MessagePackage,
 			commitmentHash := item.DigestItem.AsCommitment.Hash
 			commitmentData := item.Data
 			messagePackage := MessagePackage{
+				paraID,
 				item.DigestItem.AsCommitment.ChannelID,
 				commitmentHash,
 				commitmentData,
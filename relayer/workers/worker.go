@@ -29,6 +29,11 @@ var WorkerDeadlocked = errors.New("Worker deadlocked")
 type DeadlockHandler func() error
 
 func (wp WorkerPool) runWorker(ctx context.Context, worker Worker) error {
+	// Ensures the worker's goroutines are signaled to clean up when
+	// it fails to start
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	childEg, childCtx := errgroup.WithContext(ctx)
 	err := worker.Start(childCtx, childEg)
 	if err != nil {
@@ -65,6 +65,22 @@ func (w *TerminatingWorker) Start(ctx context.Context, eg *errgroup.Group) error
 	return nil
 }
 
+// FailingWorker fails to start after starting a goroutine which cleans up on shutdown
+type FailingWorker struct {
+	cleanedUp chan struct{}
+}
+
+func (w *FailingWorker) Name() string { return "FailingWorker" }
+
+func (w *FailingWorker) Start(ctx context.Context, eg *errgroup.Group) error {
+	eg.Go(func() error {
+		<-ctx.Done()
+		close(w.cleanedUp)
+		return nil
+	})
+	return errors.New("failed to start")
+}
+
 func testConfig() *workers.WorkerConfig {
 	return &workers.WorkerConfig{
 		Enabled:      true,
@@ -157,3 +173,30 @@ func TestRestartsTerminatedWorker(t *testing.T) {
 	assert.Equal(t, hook.AllEntries()[2].Message, "Starting worker")
 	assert.Equal(t, hook.AllEntries()[2].Data["restarts"], 1)
 }
+
+func TestCleansUpWorkerFailingToStart(t *testing.T) {
+	worker := &FailingWorker{cleanedUp: make(chan struct{})}
+	started := false
+	factory := func() (workers.Worker, *workers.WorkerConfig, error) {
+		if started {
+			return nil, nil, errors.New("not restarted")
+		}
+		started = true
+		return worker, testConfig(), nil
+	}
+	pool := workers.WorkerPool{factory}
+
+	log, _ := testLogger()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		pool.RunWithContext(ctx, nil, log)
+	}()
+
+	select {
+	case <-worker.cleanedUp:
+	case <-time.After(time.Second):
+		t.Fatal("worker wasn't cleaned up")
+	}
+}